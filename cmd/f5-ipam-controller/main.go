@@ -3,21 +3,33 @@ package main
 import (
 	"fmt"
 	"golang.org/x/crypto/ssh/terminal"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	flag "github.com/spf13/pflag"
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
 	"github.com/subbuv26/f5-ipam-controller/pkg/controller"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
 	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/metrics"
 	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	"github.com/subbuv26/f5-ipam-controller/pkg/poolconfig"
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 	clog "github.com/subbuv26/f5-ipam-controller/pkg/vlogger/console"
+	"github.com/subbuv26/f5-ipam-controller/pkg/vlogger/file"
+	"github.com/subbuv26/f5-ipam-controller/pkg/vlogger/jsonlog"
+	"github.com/subbuv26/f5-ipam-controller/pkg/vlogger/klogbridge"
+	slog "github.com/subbuv26/f5-ipam-controller/pkg/vlogger/syslog"
 )
 
 const (
 	DefaultProvider = manager.F5IPAMProvider
+	// DefaultShutdownTimeout leaves time for the work to drain within the usual grace period of a pod
+	DefaultShutdownTimeout = 25 * time.Second
 )
 
 var (
@@ -27,12 +39,43 @@ var (
 	providerFlags *flag.FlagSet
 
 	// Global
-	logLevel *string
-	orch     *string
-	provider *string
+	logLevel                *string
+	logFormat               *string
+	logFile                 *string
+	logFileMaxSize          *int
+	logFileMaxBackups       *int
+	syslogAddress           *string
+	syslogFacility          *string
+	syslogLevel             *string
+	orch                    *string
+	provider                *string
+	namespaceMaxAllocations *int
+	f5ipamMaxAllocations    *int
+	metricsAddress          *string
+	adminAddress            *string
+	adminTokenFile          *string
+	workers                 *int
+	queueSize               *int
+	hostConflictPolicy      *string
+	reservationTimeout      *time.Duration
+	shutdownTimeout         *time.Duration
+	namespaces              *[]string
+	fileDirectory           *string
+	fileInterval            *time.Duration
+	httpAddress             *string
+	httpTokenFile           *string
+	httpStateFile           *string
+	httpTLSCert             *string
+	httpTLSKey              *string
 
 	// Provider
-	iprange *string
+	iprange         *string
+	strategy        *string
+	releaseCooldown *time.Duration
+	storePath       *string
+	poolConfig      *string
+	shrinkPolicy    *string
+	auditFile       *string
 )
 
 func init() {
@@ -53,13 +96,88 @@ func init() {
 
 	// Global flags
 	logLevel = globalFlags.String("log-level", "INFO", "Optional, logging level.")
+	logFormat = globalFlags.String("log-format", "text",
+		"Optional, format of the log messages: text, or json for one object with the fields of the message per line")
+	logFile = globalFlags.String("log-file", "",
+		"Optional, file to write the log messages to instead of stderr, it is rotated by size")
+	logFileMaxSize = globalFlags.Int("log-file-max-size", 100,
+		"Optional, size in megabytes the log file is rotated at, 0 to never rotate it")
+	logFileMaxBackups = globalFlags.Int("log-file-max-backups", 5,
+		"Optional, number of rotated log files to keep")
+	syslogAddress = globalFlags.String("syslog-address", "",
+		"Optional, syslog daemon to send the log messages from --syslog-level up to instead of stderr "+
+			"or the log file: local, udp://host:port or tcp://host:port")
+	syslogFacility = globalFlags.String("syslog-facility", "local0",
+		"Optional, syslog facility of the log messages, like daemon or local0")
+	syslogLevel = globalFlags.String("syslog-level", "DEBUG",
+		"Optional, lowest logging level of the messages sent to syslog, "+
+			"the lower ones are written to stderr or the log file")
 	orch = globalFlags.String("orchestration", "",
-		"Required, orchestration that the controller is running in.")
+		"Required, orchestration that the controller is running in, one of: "+
+			strings.Join(orchestration.Names(), ", "))
+	namespaces = globalFlags.StringSlice("namespace", nil,
+		"Optional, namespace whose F5IPAMs the kubernetes orchestration watches, can be given more than once, "+
+			"all the namespaces are watched when not provided")
+	fileDirectory = globalFlags.String("file-directory", "",
+		"Optional, directory of F5IPAM documents in YAML or JSON for the file orchestration, "+
+			"the status of each document is written next to it")
+	fileInterval = globalFlags.Duration("file-interval", orchestration.DefaultFileInterval,
+		"Optional, interval to check the directory of the file orchestration for changes")
+	httpAddress = globalFlags.String("http-address", orchestration.DefaultHTTPAddress,
+		"Optional, address the REST API of the http orchestration listens on")
+	httpTokenFile = globalFlags.String("http-token-file", "",
+		"Required with --orchestration=http, file with the bearer tokens accepted by the http orchestration, one per line")
+	httpStateFile = globalFlags.String("http-state-file", "",
+		"Required with --orchestration=http, file to persist the allocations of the http orchestration across restarts")
+	httpTLSCert = globalFlags.String("http-tls-cert", "",
+		"Optional, certificate to serve the REST API of the http orchestration over TLS")
+	httpTLSKey = globalFlags.String("http-tls-key", "",
+		"Optional, key of the certificate of the http orchestration")
 	provider = globalFlags.String("ip-provider", DefaultProvider,
 		"Required, the IPAM system that the controller will interface with.")
+	namespaceMaxAllocations = globalFlags.Int("namespace-max-allocations", 0,
+		"Optional, maximum IP Addresses allocated to a namespace without a quota of its own, unlimited when 0")
+	f5ipamMaxAllocations = globalFlags.Int("f5ipam-max-allocations", 0,
+		"Optional, maximum IP Addresses allocated to an F5IPAM without a quota of its namespace, unlimited when 0")
+	workers = globalFlags.Int("workers", controller.DefaultWorkers,
+		"Optional, number of workers processing the requests of different F5IPAM resources in parallel")
+	queueSize = globalFlags.Int("request-queue-size", controller.DefaultQueueSize,
+		"Optional, number of requests waiting for processing, new requests are held back while it is full")
+	hostConflictPolicy = globalFlags.String("host-conflict-policy", controller.RejectHostConflicts,
+		"Optional, handling of a host in a pool that is claimed by more than one F5IPAM: "+
+			"reject fails the claims after the first one, share gives all of them the same IP Address")
+	reservationTimeout = globalFlags.Duration("reservation-timeout", controller.DefaultReservationTimeout,
+		"Optional, time a new allocation waits to be published in the status of its F5IPAM before it is rolled back")
+	shutdownTimeout = globalFlags.Duration("shutdown-timeout", DefaultShutdownTimeout,
+		"Optional, time to finish the accepted requests and publish their results on shutdown, "+
+			"the controller exits with a failure when they are not done in time")
+	metricsAddress = globalFlags.String("metrics-address", "",
+		"Optional, address to serve metrics on at /metrics, like :8080. Metrics are not served when not provided")
+	adminAddress = globalFlags.String("admin-address", "",
+		"Optional, address to serve the admin API for f5-ipam-ctl on, like 127.0.0.1:8081. "+
+			"The admin API is not served when not provided")
+	adminTokenFile = globalFlags.String("admin-token-file", "",
+		"Required with --admin-address, file with the bearer tokens accepted by the admin API, one per line")
 
 	iprange = providerFlags.String("ip-range", "",
 		"Optional, the Default Provider needs iprange to build pools of IP Addresses")
+	strategy = providerFlags.String("allocation-strategy", "",
+		"Optional, strategy to allocate IP Addresses: sequential (default), random or least-recently-released. "+
+			"Strategies of individual CIDRs are given as a comma separated list of <cidr>=<strategy>")
+	releaseCooldown = providerFlags.Duration("release-cooldown", 0,
+		"Optional, duration for which a released IP Address is not allocated again")
+	storePath = providerFlags.String("store-path", "",
+		"Optional, database file to persist allocations across restarts, allocations are kept in memory when not provided")
+	poolConfig = providerFlags.String("pool-config", "",
+		"Optional, file with the pool definitions, used instead of ip-range. "+
+			"The file is reloaded when it changes or on SIGHUP, it can be a mounted ConfigMap")
+	shrinkPolicy = providerFlags.String("pool-shrink-policy", "drain",
+		"Optional, handling of pool shrinks that leave allocated IP Addresses out of the pool on reload: "+
+			"drain keeps them until released, refuse keeps the previous pool definition")
+
+	auditFile = providerFlags.String("audit-file", "",
+		"Optional, file to stream the audit log of the allocation decisions to as lines of JSON. "+
+			"The audit log is always kept in the store")
 
 	globalFlags.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "  Global:\n%s\n", globalFlags.FlagUsagesWrapped(width))
@@ -78,12 +196,71 @@ func init() {
 	}
 }
 
+// setupLogging registers the loggers of the log format and destinations
+func setupLogging() error {
+	var logger log.Logger
+	switch *logFormat {
+	case "text":
+		if len(*logFile) == 0 {
+			logger = clog.NewConsoleLogger()
+			break
+		}
+		fl, err := file.NewFileLogger(*logFile, int64(*logFileMaxSize)<<20, *logFileMaxBackups)
+		if err != nil {
+			return fmt.Errorf("Unable to open the log file: %v", err)
+		}
+		logger = fl
+	case "json":
+		if len(*logFile) == 0 {
+			logger = jsonlog.NewJSONLogger()
+			break
+		}
+		out, err := file.NewRotatingWriter(*logFile, int64(*logFileMaxSize)<<20, *logFileMaxBackups)
+		if err != nil {
+			return fmt.Errorf("Unable to open the log file: %v", err)
+		}
+		logger = jsonlog.NewJSONLoggerExt(out)
+	default:
+		log.RegisterLogger(
+			log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, clog.NewConsoleLogger())
+		return fmt.Errorf("Unknown log format requested: %v\n"+
+			"    Valid log formats are: text, json", *logFormat)
+	}
+	log.RegisterLogger(log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, logger)
+
+	if len(*syslogAddress) == 0 {
+		return nil
+	}
+	minLevel := log.NewLogLevel(*syslogLevel)
+	if minLevel == nil {
+		return fmt.Errorf("Unknown syslog level requested: %v\n"+
+			"    Valid log levels are: DEBUG, INFO, WARNING, ERROR, CRITICAL", *syslogLevel)
+	}
+	network, raddr, err := slog.ParseAddress(*syslogAddress)
+	if err != nil {
+		return err
+	}
+	facility, err := slog.ParseFacility(*syslogFacility)
+	if err != nil {
+		return err
+	}
+	sl, err := slog.NewSyslogLoggerExt(network, raddr, facility, "f5-ipam-controller")
+	if err != nil {
+		return fmt.Errorf("Unable to connect to syslog: %v", err)
+	}
+	log.RegisterLogger(*minLevel, log.LL_MAX_LEVEL, sl)
+	return nil
+}
+
 func verifyArgs() error {
-	log.RegisterLogger(
-		log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, clog.NewConsoleLogger())
+	if err := setupLogging(); err != nil {
+		return err
+	}
 
 	if ll := log.NewLogLevel(*logLevel); nil != ll {
 		log.SetLogLevel(*ll)
+		// client-go logs through klog, its messages follow the format and level of ours
+		klogbridge.Install()
 	} else {
 		return fmt.Errorf("Unknown log level requested: %v\n"+
 			"    Valid log levels are: DEBUG, INFO, WARNING, ERROR, CRITICAL", logLevel)
@@ -93,11 +270,28 @@ func verifyArgs() error {
 		return fmt.Errorf("orchestration is required")
 	}
 
+	if *workers <= 0 || *queueSize <= 0 {
+		return fmt.Errorf("workers and request-queue-size must be positive")
+	}
+	if *reservationTimeout <= 0 || *shutdownTimeout <= 0 {
+		return fmt.Errorf("reservation-timeout and shutdown-timeout must be positive")
+	}
+	if !controller.IsValidHostConflictPolicy(*hostConflictPolicy) {
+		return fmt.Errorf("Unknown host conflict policy: %v", *hostConflictPolicy)
+	}
+	if *namespaceMaxAllocations < 0 || *f5ipamMaxAllocations < 0 {
+		return fmt.Errorf("Maximum allocations can not be negative")
+	}
+
 	*orch = strings.ToLower(*orch)
 	*provider = strings.ToLower(*provider)
 
-	if len(*iprange) == 0 && *provider == DefaultProvider {
-		return fmt.Errorf("IP Range not provider for Provider: %v", DefaultProvider)
+	if len(*iprange) != 0 && len(*poolConfig) != 0 {
+		return fmt.Errorf("Only one of ip-range and pool-config can be provided")
+	}
+	if len(*iprange) == 0 && len(*poolConfig) == 0 && *provider == DefaultProvider {
+		log.Warningf("IP Range not provided for Provider: %v, pools are expected from F5IPAMPool resources",
+			DefaultProvider)
 	}
 	*iprange = strings.Trim(*iprange, "\"")
 	*iprange = strings.Trim(*iprange, "'")
@@ -117,33 +311,154 @@ func main() {
 		os.Exit(1)
 	}
 
-	orcr := orchestration.NewOrchestrator()
-	if orcr == nil {
-		log.Error("Unable to create IPAM Client")
+	orcr, err := orchestration.NewOrchestrator(*orch, orchestration.Params{
+		Namespaces: *namespaces,
+
+		Directory: *fileDirectory,
+		Interval:  *fileInterval,
+
+		Address:     *httpAddress,
+		TokenFile:   *httpTokenFile,
+		StateFile:   *httpStateFile,
+		TLSCertFile: *httpTLSCert,
+		TLSKeyFile:  *httpTLSKey,
+	})
+	if err != nil {
+		log.Errorf("Unable to create IPAM Client: %v", err)
 		os.Exit(1)
 	}
+	var defs poolconfig.Definitions
+	if len(*poolConfig) != 0 {
+		defs, err = poolconfig.Load(*poolConfig)
+		if err != nil {
+			log.Errorf("Unable to load pool configuration: %v", err)
+			os.Exit(1)
+		}
+	}
 	mgrParams := manager.Params{
-		Provider:          *provider,
-		IPAMManagerParams: manager.IPAMManagerParams{Range: *iprange},
+		Provider: *provider,
+		IPAMManagerParams: manager.IPAMManagerParams{
+			Range:           *iprange,
+			Pools:           defs.Pools,
+			Strategy:        *strategy,
+			ReleaseCooldown: *releaseCooldown,
+			StorePath:       *storePath,
+			ShrinkPolicy:    *shrinkPolicy,
+			AuditFile:       *auditFile,
+		},
 	}
 	mgrParams.Range = *iprange
-	mgr := manager.NewManager(mgrParams)
+	mgr, err := manager.NewManager(mgrParams)
+	if err != nil {
+		log.Errorf("Unable to create Manager: %v", err)
+		os.Exit(1)
+	}
 	stopCh := make(chan struct{})
+	// F5IPAMPools define the pools of the kubernetes orchestration besides the configuration
+	var poolSources []string
+	if *orch == orchestration.KubernetesOrchestration {
+		poolSources = []string{orchestration.PoolSource}
+	}
 
 	ctlr := controller.NewController(
 		controller.Spec{
 			Orchestrator: orcr,
 			Manager:      mgr,
 			StopCh:       stopCh,
+			Workers:      *workers,
+			QueueSize:    *queueSize,
+			PoolSources:  poolSources,
+
+			HostConflictPolicy: *hostConflictPolicy,
+			ReservationTimeout: *reservationTimeout,
+
+			NamespacePolicies: defs.Policies,
+			DefaultPolicy: ipamspec.NamespacePolicy{
+				MaxAllocations:            *namespaceMaxAllocations,
+				MaxAllocationsPerResource: *f5ipamMaxAllocations,
+			},
 		},
 	)
 	ctlr.Start()
 
+	if len(*metricsAddress) != 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			log.Infof("Serving metrics on %v/metrics", *metricsAddress)
+			if err := http.ListenAndServe(*metricsAddress, mux); err != nil {
+				log.Errorf("Unable to serve metrics: %v", err)
+			}
+		}()
+	}
+
+	if len(*adminAddress) != 0 {
+		serveAdmin(ctlr)
+	}
+
+	var watcher *poolconfig.Watcher
+	if len(*poolConfig) != 0 {
+		watcher = poolconfig.NewWatcher(*poolConfig, poolconfig.DefaultInterval,
+			func(defs poolconfig.Definitions) {
+				ctlr.ReconcilePools(controller.ConfigPoolSource, defs.Pools)
+				ctlr.ReconcileNamespacePolicies(controller.ConfigPolicySource, defs.Policies)
+			})
+		go watcher.Run(stopCh)
+	}
+
+	// SIGUSR1 logs debug messages until SIGUSR2 sets the log level back to the configured one
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
 	sig := <-signals
+	for ; sig == syscall.SIGHUP || sig == syscall.SIGUSR1 || sig == syscall.SIGUSR2; sig = <-signals {
+		switch sig {
+		case syscall.SIGHUP:
+			if watcher != nil {
+				watcher.Reload()
+			}
+		case syscall.SIGUSR1:
+			log.SetLogLevel(log.LL_DEBUG)
+			log.Infof("Log level set to %v - signal %v", log.GetLogLevel(), sig)
+		case syscall.SIGUSR2:
+			log.SetLogLevel(*log.NewLogLevel(*logLevel))
+			log.Infof("Log level set to %v - signal %v", log.GetLogLevel(), sig)
+		}
+	}
+
+	log.Infof("Stopping - signal %v", sig)
+	// Stop closes the stop channel, which stops the watcher of the pool configuration as well
+	drained := ctlr.Stop(*shutdownTimeout)
+	if !drained {
+		log.Error("Exiting without draining all requests")
+		log.Close()
+		os.Exit(1)
+	}
+	log.Info("Exiting")
+	log.Close()
+}
 
-	ctlr.Stop()
-	log.Infof("Exiting - signal %v\n", sig)
-	close(stopCh)
+// serveAdmin serves the admin API of the controller, the controller runs on without it when
+// it can not be served
+func serveAdmin(ctlr *controller.Controller) {
+	backend, ok := ctlr.AdminBackend()
+	if !ok {
+		log.Errorf("The admin API is not available with Provider: %v", *provider)
+		return
+	}
+	// The admin API changes allocations, it is never served without authentication
+	if len(*adminTokenFile) == 0 {
+		log.Errorf("The admin API is not served without --admin-token-file")
+		return
+	}
+	tokens, err := admin.ReadTokens(*adminTokenFile)
+	if err != nil {
+		log.Errorf("Unable to read the tokens of the admin API: %v", err)
+		return
+	}
+	go func() {
+		log.Infof("Serving the admin API on %v%v", *adminAddress, admin.APIPrefix)
+		if err := http.ListenAndServe(*adminAddress, admin.NewHandler(backend, tokens)); err != nil {
+			log.Errorf("Unable to serve the admin API: %v", err)
+		}
+	}()
 }
@@ -0,0 +1,480 @@
+// f5-ipam-ctl inspects and repairs the allocations of the F5 IPAM Controller, through the admin
+// API of a running controller or directly on the store file of a stopped one.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+
+	// exitIssues is the exit code of a consistency check that finds issues, or of an import
+	// that conflicts
+	exitIssues = 2
+)
+
+const usage = `Usage: f5-ipam-ctl (--server <url> | --store <path>) [flags] <command> [command flags]
+
+Commands:
+  pools        list the pools and their utilization
+  allocations  list and search the allocations by host, IP Address or owner
+  release      force-release IP Addresses and delete their allocations
+  reserve      force-reserve an IP Address for a host
+  check        check the consistency of the allocations, exits with 2 when issues are found
+  export       export a snapshot of the allocations as JSON
+  import       import a snapshot, exits with 2 when it conflicts with the configured pools
+  audit        list the audit log of the allocation decisions by IP Address or host
+  log-level    show or change the log level of a running controller, until it is restarted
+
+The store file must only be repaired while the controller is stopped, a running controller is
+repaired through its admin API. Force-released allocations are not removed from the resources
+that hold them.
+
+Flags:
+`
+
+// cli holds the backend and the output of a command
+type cli struct {
+	backend admin.Backend
+	output  string
+	out     io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("f5-ipam-ctl", flag.ContinueOnError)
+	flags.SetInterspersed(false)
+	flags.SetOutput(stderr)
+	server := flags.String("server", "", "URL of the admin API of a running controller, like http://127.0.0.1:8081")
+	tokenFile := flags.String("token-file", "", "File with the bearer token of the admin API")
+	storePath := flags.String("store", "", "Store file of a stopped controller")
+	output := flags.StringP("output", "o", tableOutput, "Output format: table or json")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	if *output != tableOutput && *output != jsonOutput {
+		fmt.Fprintf(stderr, "Unknown output format: %v\n", *output)
+		return 1
+	}
+
+	c := &cli{output: *output, out: stdout}
+	switch {
+	case *server != "" && *storePath != "":
+		fmt.Fprintln(stderr, "Only one of --server and --store can be given")
+		return 1
+	case *server != "":
+		client := &admin.Client{URL: *server}
+		if *tokenFile != "" {
+			data, err := ioutil.ReadFile(*tokenFile)
+			if err != nil {
+				fmt.Fprintf(stderr, "Unable to read the token: %v\n", err)
+				return 1
+			}
+			client.Token = strings.TrimSpace(string(data))
+		}
+		c.backend = client
+	case *storePath != "":
+		// The store is created when it does not exist, which is never what is meant here
+		if _, err := os.Stat(*storePath); err != nil {
+			fmt.Fprintf(stderr, "Unable to open the store: %v\n", err)
+			return 1
+		}
+		store := sqlite.NewStore(*storePath)
+		if store == nil {
+			fmt.Fprintf(stderr, "Unable to open the store: %v\n", *storePath)
+			return 1
+		}
+		defer store.Close()
+		c.backend = admin.NewStoreBackend(store)
+	default:
+		fmt.Fprintln(stderr, "One of --server and --store is required")
+		flags.Usage()
+		return 1
+	}
+
+	command, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	var err error
+	code := 0
+	switch command {
+	case "pools":
+		err = c.pools(cmdArgs, stderr)
+	case "allocations":
+		err = c.allocations(cmdArgs, stderr)
+	case "release":
+		err = c.release(cmdArgs, stderr)
+	case "reserve":
+		err = c.reserve(cmdArgs, stderr)
+	case "check":
+		code, err = c.check(cmdArgs, stderr)
+	case "export":
+		err = c.export(cmdArgs, stderr)
+	case "import":
+		code, err = c.importSnapshot(cmdArgs, stderr)
+	case "audit":
+		err = c.audit(cmdArgs, stderr)
+	case "log-level":
+		err = c.logLevel(cmdArgs, stderr)
+	default:
+		fmt.Fprintf(stderr, "Unknown command: %v\n", command)
+		flags.Usage()
+		return 1
+	}
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(stderr, "%v: %v\n", command, err)
+		}
+		return 1
+	}
+	return code
+}
+
+func commandFlags(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+func (c *cli) pools(args []string, stderr io.Writer) error {
+	if err := commandFlags("pools", stderr).Parse(args); err != nil {
+		return err
+	}
+	pools, err := c.backend.Pools()
+	if err != nil {
+		return err
+	}
+	if c.output == jsonOutput {
+		return c.writeJSON(admin.PoolList{Pools: pools})
+	}
+	return c.writeTable([]string{"CIDR", "TOTAL", "ALLOCATED", "FREE", "RETAINED", "UTILIZATION"},
+		len(pools), func(i int) []interface{} {
+			pool := pools[i]
+			return []interface{}{pool.CIDR, pool.Total, pool.Allocated, pool.Free, pool.Retained,
+				utilization(pool)}
+		})
+}
+
+func utilization(pool ipamspec.PoolStats) string {
+	if pool.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(pool.Allocated)*100/float64(pool.Total))
+}
+
+func (c *cli) allocations(args []string, stderr io.Writer) error {
+	flags := commandFlags("allocations", stderr)
+	filter := admin.Filter{}
+	flags.StringVar(&filter.HostName, "host", "", "Host of the allocations")
+	flags.StringVar(&filter.IPAddr, "ip", "", "IP Address of the allocations")
+	flags.StringVar(&filter.CIDR, "cidr", "", "CIDR of the pool of the allocations")
+	owner := flags.String("owner", "", "Owner of the allocations as <namespace>/<name>, or <namespace>")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *owner != "" {
+		parts := strings.SplitN(*owner, "/", 2)
+		filter.Namespace = parts[0]
+		if len(parts) == 2 {
+			filter.Name = parts[1]
+		}
+	}
+	allocs, err := c.backend.Allocations(filter)
+	if err != nil {
+		return err
+	}
+	return c.writeAllocations(allocs)
+}
+
+func (c *cli) writeAllocations(allocs []admin.Allocation) error {
+	if c.output == jsonOutput {
+		if allocs == nil {
+			allocs = []admin.Allocation{}
+		}
+		return c.writeJSON(admin.AllocationList{Allocations: allocs})
+	}
+	return c.writeTable([]string{"IP", "HOST", "CIDR", "OWNER", "KEY", "RESERVED UNTIL"},
+		len(allocs), func(i int) []interface{} {
+			alloc := allocs[i]
+			reservedUntil := "-"
+			if alloc.ReservedUntil != 0 {
+				reservedUntil = time.Unix(alloc.ReservedUntil, 0).UTC().Format(time.RFC3339)
+			}
+			return []interface{}{alloc.IPAddr, alloc.HostName, alloc.CIDR,
+				alloc.Namespace + "/" + alloc.Name, orNone(alloc.Key), reservedUntil}
+		})
+}
+
+func (c *cli) release(args []string, stderr io.Writer) error {
+	flags := commandFlags("release", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("the IP Addresses to release are required")
+	}
+	var released []admin.Allocation
+	for _, ipAddr := range flags.Args() {
+		allocs, err := c.backend.Release(ipAddr)
+		released = append(released, allocs...)
+		if err != nil {
+			return err
+		}
+	}
+	return c.writeAllocations(released)
+}
+
+func (c *cli) reserve(args []string, stderr io.Writer) error {
+	flags := commandFlags("reserve", stderr)
+	alloc := admin.Allocation{}
+	flags.StringVar(&alloc.IPAddr, "ip", "", "IP Address to reserve")
+	flags.StringVar(&alloc.CIDR, "cidr", "", "CIDR of the pool of the IP Address")
+	flags.StringVar(&alloc.HostName, "host", "", "Host the IP Address is reserved for, required unless a key is given")
+	flags.StringVar(&alloc.Key, "key", "", "Key of a shared allocation")
+	owner := flags.String("owner", "", "Owner of the allocation as <namespace>/<name>")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if parts := strings.SplitN(*owner, "/", 2); len(parts) == 2 {
+		alloc.Namespace, alloc.Name = parts[0], parts[1]
+	}
+	if err := alloc.Validate(); err != nil {
+		return err
+	}
+	if err := c.backend.Reserve(alloc); err != nil {
+		return err
+	}
+	return c.writeAllocations([]admin.Allocation{alloc})
+}
+
+func (c *cli) check(args []string, stderr io.Writer) (int, error) {
+	if err := commandFlags("check", stderr).Parse(args); err != nil {
+		return 1, err
+	}
+	issues, err := c.backend.Check()
+	if err != nil {
+		return 1, err
+	}
+	if c.output == jsonOutput {
+		if issues == nil {
+			issues = []admin.Issue{}
+		}
+		err = c.writeJSON(admin.IssueList{Issues: issues})
+	} else if len(issues) == 0 {
+		_, err = fmt.Fprintln(c.out, "No issues found")
+	} else {
+		err = c.writeTable([]string{"KIND", "IP", "CIDR", "DETAIL"}, len(issues), func(i int) []interface{} {
+			issue := issues[i]
+			return []interface{}{issue.Kind, issue.IPAddr, orNone(issue.CIDR), issue.Detail}
+		})
+	}
+	if err == nil && len(issues) != 0 {
+		return exitIssues, nil
+	}
+	return 0, err
+}
+
+func (c *cli) export(args []string, stderr io.Writer) error {
+	flags := commandFlags("export", stderr)
+	file := flags.StringP("file", "f", "", "File to write the snapshot to, stdout when not given")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	snapshot, err := c.backend.Export()
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		return c.writeJSON(snapshot)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(*file, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	if c.output == tableOutput {
+		_, err = fmt.Fprintf(c.out, "Exported %v pools, %v allocations and %v retained IP Addresses to %v\n",
+			len(snapshot.Pools), len(snapshot.Allocations), len(snapshot.Retained), *file)
+	}
+	return err
+}
+
+func (c *cli) importSnapshot(args []string, stderr io.Writer) (int, error) {
+	flags := commandFlags("import", stderr)
+	options := admin.ImportOptions{}
+	flags.StringVar(&options.Mode, "mode", admin.MergeMode,
+		"merge adds the snapshot to the allocations, replace replaces them with it")
+	flags.BoolVar(&options.ValidateOnly, "validate-only", false, "Report the conflicts without importing")
+	if err := flags.Parse(args); err != nil {
+		return 1, err
+	}
+	if flags.NArg() != 1 {
+		return 1, fmt.Errorf("the snapshot file is required")
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return 1, err
+	}
+	snapshot := admin.Snapshot{}
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return 1, fmt.Errorf("invalid snapshot: %v", err)
+	}
+	report, err := c.backend.Import(snapshot, options)
+	if err != nil {
+		return 1, err
+	}
+
+	if c.output == jsonOutput {
+		err = c.writeJSON(report)
+	} else if len(report.Conflicts) != 0 {
+		err = c.writeTable([]string{"IP", "CIDR", "CONFLICT"}, len(report.Conflicts), func(i int) []interface{} {
+			conflict := report.Conflicts[i]
+			return []interface{}{conflict.IPAddr, orNone(conflict.CIDR), conflict.Reason}
+		})
+	} else {
+		verb := "Imported"
+		if !report.Applied {
+			verb = "Validated"
+		}
+		_, err = fmt.Fprintf(c.out, "%v %v IP Addresses, %v allocations and %v retained IP Addresses in %v mode\n",
+			verb, report.Addresses, report.Allocations, report.Retained, report.Mode)
+	}
+	if err == nil && len(report.Conflicts) != 0 {
+		return exitIssues, nil
+	}
+	return 0, err
+}
+
+func (c *cli) audit(args []string, stderr io.Writer) error {
+	flags := commandFlags("audit", stderr)
+	query := sqlite.AuditQuery{}
+	flags.StringVar(&query.IPAddr, "ip", "", "IP Address of the events")
+	flags.StringVar(&query.HostName, "host", "", "Host of the events")
+	since := flags.String("since", "", "Start of the time window, as RFC 3339 time or as duration before now like 24h")
+	until := flags.String("until", "", "End of the time window, as RFC 3339 time or as duration before now")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var err error
+	if query.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if query.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+	events, err := c.backend.Audit(query)
+	if err != nil {
+		return err
+	}
+	if c.output == jsonOutput {
+		if events == nil {
+			events = []sqlite.AuditEvent{}
+		}
+		return c.writeJSON(admin.AuditList{Events: events})
+	}
+	return c.writeTable([]string{"TIME", "OPERATION", "IP", "HOST", "CIDR", "OWNER", "RESULT", "REASON"},
+		len(events), func(i int) []interface{} {
+			event := events[i]
+			owner := "-"
+			if event.Namespace != "" {
+				owner = event.Namespace + "/" + event.Name
+			}
+			return []interface{}{event.Time.Format(time.RFC3339), event.Operation, orNone(event.IPAddr),
+				orNone(event.HostName), orNone(event.CIDR), owner, event.Result, orNone(event.Reason)}
+		})
+}
+
+func (c *cli) logLevel(args []string, stderr io.Writer) error {
+	flags := commandFlags("log-level", stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	client, ok := c.backend.(*admin.Client)
+	if !ok {
+		return fmt.Errorf("the log level is only available with --server")
+	}
+	var level string
+	var err error
+	switch flags.NArg() {
+	case 0:
+		level, err = client.LogLevel()
+	case 1:
+		level, err = client.SetLogLevel(flags.Arg(0))
+	default:
+		return fmt.Errorf("only one log level can be given")
+	}
+	if err != nil {
+		return err
+	}
+	if c.output == jsonOutput {
+		return c.writeJSON(admin.LogLevelSetting{Level: level})
+	}
+	_, err = fmt.Fprintln(c.out, level)
+	return err
+}
+
+// parseTime parses an RFC 3339 time, or a duration before now. Empty is the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %v", value)
+	}
+	return t, nil
+}
+
+func (c *cli) writeJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *cli) writeTable(header []string, rows int, row func(int) []interface{}) error {
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for i := 0; i < rows; i++ {
+		var cells []string
+		for _, cell := range row(i) {
+			cells = append(cells, fmt.Sprint(cell))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -3,6 +3,7 @@ module github.com/subbuv26/f5-ipam-controller
 go 1.15
 
 require (
+	github.com/go-logr/logr v0.1.0
 	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mattn/goveralls v0.0.7 // indirect
 	github.com/onsi/ginkgo v1.14.2 // indirect
@@ -12,8 +13,12 @@ require (
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324 // indirect
 	golang.org/x/tools v0.0.0-20210104081019-d8d6ddbec6ee // indirect
+	k8s.io/api v0.16.14
 	k8s.io/apiextensions-apiserver v0.16.14
 	k8s.io/apimachinery v0.16.14
 	k8s.io/client-go v0.16.14
+	k8s.io/klog v1.0.0
+	k8s.io/klog/v2 v2.0.0
 	k8s.io/utils v0.0.0-20201110183641-67b214c5f920 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
@@ -0,0 +1,361 @@
+// Package admin inspects and repairs the allocations of the controller, either through the
+// admin API of a running controller or directly on its store file.
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+// Kinds of the issues found by the consistency check
+const (
+	// UnreferencedIP is an allocated IP Address that no allocation refers to and that is not retained
+	UnreferencedIP = "UnreferencedIP"
+	// DanglingAllocation refers to an IP Address that is not allocated, or not in the store
+	DanglingAllocation = "DanglingAllocation"
+	// PoolMismatch is an allocation in another pool than the one of its IP Address
+	PoolMismatch = "PoolMismatch"
+	// ExpiredReservation is a reservation that is not rolled back yet
+	ExpiredReservation = "ExpiredReservation"
+	// ReleasedRetention retains an IP Address that is already released
+	ReleasedRetention = "ReleasedRetention"
+	// SharedIPConflict is an IP Address referred to by allocations that do not share a key
+	SharedIPConflict = "SharedIPConflict"
+)
+
+// AuditReason of the repairs in the audit log
+const AuditReason = "forced by admin"
+
+// Allocation is an IP Address allocated to a host in a pool by its owner
+type Allocation struct {
+	IPAddr    string `json:"ip"`
+	HostName  string `json:"host"`
+	CIDR      string `json:"cidr"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Key       string `json:"key,omitempty"`
+	// ReservedUntil of an allocation that is not committed yet, in unix seconds
+	ReservedUntil int64 `json:"reservedUntil,omitempty"`
+}
+
+// Filter selects allocations, the fields that are given have to match
+type Filter struct {
+	HostName  string
+	IPAddr    string
+	CIDR      string
+	Namespace string
+	Name      string
+}
+
+// Issue is an inconsistency of the allocations
+type Issue struct {
+	Kind   string `json:"kind"`
+	IPAddr string `json:"ip"`
+	CIDR   string `json:"cidr,omitempty"`
+	Detail string `json:"detail"`
+}
+
+// Backend inspects and repairs the allocations
+type Backend interface {
+	// Pools returns the utilization of the pools
+	Pools() ([]ipamspec.PoolStats, error)
+	// Allocations returns the allocations that match the filter
+	Allocations(filter Filter) ([]Allocation, error)
+	// Release deletes the allocations of the IP Address and releases it, whatever its
+	// reclaim policy and retention. It returns the deleted allocations.
+	Release(ipAddr string) ([]Allocation, error)
+	// Reserve allocates the IP Address to the allocation, bypassing the policies and quotas
+	Reserve(alloc Allocation) error
+	// Check reports the inconsistencies of the allocations
+	Check() ([]Issue, error)
+	// Export returns a snapshot of the state of the allocations
+	Export() (Snapshot, error)
+	// Import applies a snapshot, all of it or nothing when it has conflicts
+	Import(snapshot Snapshot, options ImportOptions) (ImportReport, error)
+	// Audit returns the events of the audit log that match the query
+	Audit(query sqlite.AuditQuery) ([]sqlite.AuditEvent, error)
+}
+
+// Matches checks whether the allocation matches the filter
+func (filter Filter) Matches(alloc Allocation) bool {
+	return (filter.HostName == "" || filter.HostName == alloc.HostName) &&
+		(filter.IPAddr == "" || filter.IPAddr == alloc.IPAddr) &&
+		(filter.CIDR == "" || filter.CIDR == alloc.CIDR) &&
+		(filter.Namespace == "" || filter.Namespace == alloc.Namespace) &&
+		(filter.Name == "" || filter.Name == alloc.Name)
+}
+
+// AllocationKey returns the key of the allocation
+func (alloc Allocation) AllocationKey() ipamspec.AllocationKey {
+	return ipamspec.AllocationKey{
+		Namespace: alloc.Namespace,
+		Name:      alloc.Name,
+		HostName:  alloc.HostName,
+		CIDR:      alloc.CIDR,
+		Key:       alloc.Key,
+	}
+}
+
+// Validate checks that the allocation can be reserved
+func (alloc Allocation) Validate() error {
+	if (alloc.HostName == "" && alloc.Key == "") || alloc.CIDR == "" || alloc.Namespace == "" || alloc.Name == "" {
+		return fmt.Errorf("an allocation needs a host or a key, a cidr and an owner namespace and name")
+	}
+	_, ipNet, err := net.ParseCIDR(alloc.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid cidr: %v", alloc.CIDR)
+	}
+	ip := net.ParseIP(alloc.IPAddr)
+	if ip == nil {
+		return fmt.Errorf("invalid ip: %v", alloc.IPAddr)
+	}
+	if !ipNet.Contains(ip) {
+		return fmt.Errorf("ip %v is not in cidr %v", alloc.IPAddr, alloc.CIDR)
+	}
+	return nil
+}
+
+// StoreBackend works on the store of the allocations. The store of a running controller is
+// only repaired through its admin API, which keeps the controller in line with the store.
+type StoreBackend struct {
+	store *sqlite.DBStore
+}
+
+// NewStoreBackend creates the backend on the store
+func NewStoreBackend(store *sqlite.DBStore) *StoreBackend {
+	return &StoreBackend{store: store}
+}
+
+// Pools returns the utilization of every CIDR in the store, retired IP Addresses are not counted
+func (backend *StoreBackend) Pools() ([]ipamspec.PoolStats, error) {
+	cidrs := backend.store.GetCIDRs()
+	sort.Strings(cidrs)
+	var stats []ipamspec.PoolStats
+	for _, cidr := range cidrs {
+		poolStats := ipamspec.PoolStats{CIDR: cidr}
+		for _, record := range backend.store.GetIPRecords(cidr) {
+			if record.Retired {
+				continue
+			}
+			poolStats.Total++
+			if record.Status == sqlite.ALLOCATED {
+				poolStats.Allocated++
+			}
+		}
+		poolStats.Free = poolStats.Total - poolStats.Allocated
+		poolStats.Retained = len(backend.store.GetRetainedIPs(cidr))
+		stats = append(stats, poolStats)
+	}
+	return stats, nil
+}
+
+// Allocations returns the allocations of the store that match the filter
+func (backend *StoreBackend) Allocations(filter Filter) ([]Allocation, error) {
+	var allocs []Allocation
+	for _, record := range backend.store.GetAllARecords() {
+		if alloc := allocationOf(record); filter.Matches(alloc) {
+			allocs = append(allocs, alloc)
+		}
+	}
+	return allocs, nil
+}
+
+// Release deletes the allocations of the IP Address and releases it
+func (backend *StoreBackend) Release(ipAddr string) ([]Allocation, error) {
+	ipRecord, ok := backend.ipRecords()[ipAddr]
+	if !ok {
+		return nil, fmt.Errorf("IP Address %v is not in the store", ipAddr)
+	}
+	var released []Allocation
+	for _, record := range backend.store.GetARecordsOfIP(ipAddr) {
+		ok := backend.store.DeleteARecord(record)
+		backend.audit(sqlite.AuditDeleteRecord, record, ok)
+		if !ok {
+			return released, fmt.Errorf("unable to delete the allocation of %v to %v", ipAddr, record.HostName)
+		}
+		released = append(released, allocationOf(record))
+	}
+	backend.store.ReleaseIP(ipAddr)
+	backend.audit(sqlite.AuditRelease, sqlite.ARecord{IPAddr: ipAddr, CIDR: ipRecord.CIDR}, true)
+	return released, nil
+}
+
+// Reserve allocates the IP Address to the allocation, the release cooldown does not apply
+func (backend *StoreBackend) Reserve(alloc Allocation) error {
+	if err := alloc.Validate(); err != nil {
+		return err
+	}
+	record := sqlite.ARecord{
+		IPAddr:    alloc.IPAddr,
+		HostName:  alloc.HostName,
+		CIDR:      alloc.CIDR,
+		Namespace: alloc.Namespace,
+		Name:      alloc.Name,
+		Key:       alloc.Key,
+	}
+	ok := backend.store.MarkIPAsAllocated(alloc.CIDR, alloc.IPAddr, 0)
+	backend.audit(sqlite.AuditAllocateSpecific, record, ok)
+	if !ok {
+		return fmt.Errorf("IP Address %v is not available in pool %v", alloc.IPAddr, alloc.CIDR)
+	}
+	ok = backend.store.CreateARecord(record)
+	backend.audit(sqlite.AuditCreateRecord, record, ok)
+	if !ok {
+		backend.store.ReleaseIP(alloc.IPAddr)
+		return fmt.Errorf("unable to create the allocation of %v to %v", alloc.IPAddr, alloc.HostName)
+	}
+	return nil
+}
+
+// Check reports the inconsistencies between the IP Addresses, the allocations and the retentions
+func (backend *StoreBackend) Check() ([]Issue, error) {
+	var issues []Issue
+	ipRecords := backend.ipRecords()
+	records := backend.store.GetAllARecords()
+	now := time.Now().Unix()
+
+	byIP := make(map[string][]sqlite.ARecord)
+	for _, record := range records {
+		byIP[record.IPAddr] = append(byIP[record.IPAddr], record)
+
+		ipRecord, ok := ipRecords[record.IPAddr]
+		switch {
+		case !ok:
+			issues = append(issues, Issue{DanglingAllocation, record.IPAddr, record.CIDR,
+				fmt.Sprintf("allocated to %v of %v/%v, but not in the store", record.HostName,
+					record.Namespace, record.Name)})
+		case ipRecord.Status != sqlite.ALLOCATED:
+			issues = append(issues, Issue{DanglingAllocation, record.IPAddr, record.CIDR,
+				fmt.Sprintf("allocated to %v of %v/%v, but available in the pool", record.HostName,
+					record.Namespace, record.Name)})
+		case record.CIDR != "" && ipRecord.CIDR != record.CIDR:
+			issues = append(issues, Issue{PoolMismatch, record.IPAddr, record.CIDR,
+				fmt.Sprintf("allocated to %v in pool %v, but the IP Address is in pool %v", record.HostName,
+					record.CIDR, ipRecord.CIDR)})
+		}
+		if record.ReservedUntil > 0 && record.ReservedUntil < now {
+			issues = append(issues, Issue{ExpiredReservation, record.IPAddr, record.CIDR,
+				fmt.Sprintf("reservation of %v expired at %v", record.HostName,
+					time.Unix(record.ReservedUntil, 0).UTC().Format(time.RFC3339))})
+		}
+	}
+
+	retained := make(map[string]bool)
+	for _, cidr := range backend.store.GetCIDRs() {
+		for _, ip := range backend.store.GetRetainedIPs(cidr) {
+			retained[ip.IPAddr] = true
+			if ipRecord, ok := ipRecords[ip.IPAddr]; !ok || ipRecord.Status != sqlite.ALLOCATED {
+				issues = append(issues, Issue{ReleasedRetention, ip.IPAddr, cidr,
+					fmt.Sprintf("retained for %v of %v, but not allocated", ip.HostName, ip.Namespace)})
+			}
+		}
+	}
+
+	for _, ipRecord := range ipRecords {
+		if ipRecord.Status == sqlite.ALLOCATED && len(byIP[ipRecord.IPAddr]) == 0 && !retained[ipRecord.IPAddr] {
+			issues = append(issues, Issue{UnreferencedIP, ipRecord.IPAddr, ipRecord.CIDR,
+				"allocated, but no allocation refers to it"})
+		}
+	}
+
+	for ipAddr, shared := range byIP {
+		for i, record := range shared[1:] {
+			if other, ok := conflictingRecord(record, shared[:i+1]); ok {
+				issues = append(issues, Issue{SharedIPConflict, ipAddr, other.CIDR,
+					fmt.Sprintf("allocated to %v of %v/%v and to %v of %v/%v without a shared key",
+						other.HostName, other.Namespace, other.Name, record.HostName, record.Namespace,
+						record.Name)})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].IPAddr != issues[j].IPAddr {
+			return issues[i].IPAddr < issues[j].IPAddr
+		}
+		return issues[i].Kind < issues[j].Kind
+	})
+	return issues, nil
+}
+
+// Audit returns the events of the audit log that match the query
+func (backend *StoreBackend) Audit(query sqlite.AuditQuery) ([]sqlite.AuditEvent, error) {
+	return backend.store.GetAuditEvents(query), nil
+}
+
+// audit records a repair of the allocation of the record in the audit log
+func (backend *StoreBackend) audit(operation string, record sqlite.ARecord, ok bool) {
+	result := sqlite.AuditSuccess
+	if !ok {
+		result = sqlite.AuditFailure
+	}
+	backend.store.RecordAudit(sqlite.AuditEvent{
+		Operation: operation,
+		IPAddr:    record.IPAddr,
+		CIDR:      record.CIDR,
+		Namespace: record.Namespace,
+		Name:      record.Name,
+		HostName:  record.HostName,
+		Key:       record.Key,
+		Result:    result,
+		Reason:    AuditReason,
+	})
+}
+
+// ipRecords returns the IP Addresses of the store by address
+func (backend *StoreBackend) ipRecords() map[string]sqlite.IPRecord {
+	ipRecords := make(map[string]sqlite.IPRecord)
+	for _, cidr := range backend.store.GetCIDRs() {
+		for _, record := range backend.store.GetIPRecords(cidr) {
+			ipRecords[record.IPAddr] = record
+		}
+	}
+	return ipRecords
+}
+
+func allocationOf(record sqlite.ARecord) Allocation {
+	return Allocation{
+		IPAddr:        record.IPAddr,
+		HostName:      record.HostName,
+		CIDR:          record.CIDR,
+		Namespace:     record.Namespace,
+		Name:          record.Name,
+		Key:           record.Key,
+		ReservedUntil: record.ReservedUntil,
+	}
+}
+
+// ReadTokens reads the bearer tokens of the file, one per line. Empty lines and lines starting
+// with # are skipped.
+func ReadTokens(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tokens = append(tokens, []byte(line))
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("No bearer tokens in %v", path)
+	}
+	return tokens, nil
+}
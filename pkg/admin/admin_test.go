@@ -0,0 +1,423 @@
+package admin_test
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+const testCIDR = "10.30.0.0/24"
+
+func newStore(t *testing.T) *sqlite.DBStore {
+	store := sqlite.NewStore(filepath.Join(t.TempDir(), "store.db"))
+	if store == nil {
+		t.Fatal("Unable to create store")
+	}
+	t.Cleanup(store.Close)
+	store.InsertIP([]string{"10.30.0.1", "10.30.0.2", "10.30.0.3", "10.30.0.4"}, testCIDR)
+	return store
+}
+
+func record(ipAddr, host string) sqlite.ARecord {
+	return sqlite.ARecord{IPAddr: ipAddr, HostName: host, CIDR: testCIDR, Namespace: "default", Name: "web"}
+}
+
+func allocation(ipAddr, host string) admin.Allocation {
+	return admin.Allocation{IPAddr: ipAddr, HostName: host, CIDR: testCIDR, Namespace: "default", Name: "web"}
+}
+
+func TestCheck(t *testing.T) {
+	store := newStore(t)
+	backend := admin.NewStoreBackend(store)
+	if err := backend.Reserve(allocation("10.30.0.1", "foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if issues, _ := backend.Check(); len(issues) != 0 {
+		t.Fatalf("Consistent store has issues: %v", issues)
+	}
+
+	// An allocated IP Address without allocation, and an allocation of an available one
+	store.MarkIPAsAllocated(testCIDR, "10.30.0.2", 0)
+	store.CreateARecord(record("10.30.0.3", "bar.example.com"))
+	expired := record("10.30.0.1", "baz.example.com")
+	expired.Name = "api"
+	expired.ReservedUntil = time.Now().Add(-time.Minute).Unix()
+	store.CreateARecord(expired)
+
+	issues, err := backend.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]string)
+	for _, issue := range issues {
+		kinds[issue.Kind] = issue.IPAddr
+	}
+	expected := map[string]string{
+		admin.ExpiredReservation: "10.30.0.1",
+		admin.SharedIPConflict:   "10.30.0.1",
+		admin.UnreferencedIP:     "10.30.0.2",
+		admin.DanglingAllocation: "10.30.0.3",
+	}
+	for kind, ipAddr := range expected {
+		if kinds[kind] != ipAddr {
+			t.Errorf("Expected issue %v of %v, got: %v", kind, ipAddr, issues)
+		}
+	}
+	if len(issues) != len(expected) {
+		t.Errorf("Unexpected issues: %v", issues)
+	}
+}
+
+func TestCheckSharedIP(t *testing.T) {
+	inResource := func(record sqlite.ARecord, namespace, name string) sqlite.ARecord {
+		record.Namespace, record.Name = namespace, name
+		return record
+	}
+	withKey := func(record sqlite.ARecord, key string) sqlite.ARecord {
+		record.Key = key
+		return record
+	}
+	reserved := func(record sqlite.ARecord) sqlite.ARecord {
+		record.ReservedUntil = time.Now().Add(time.Minute).Unix()
+		return record
+	}
+	tests := []struct {
+		name     string
+		records  []sqlite.ARecord
+		conflict bool
+	}{
+		{
+			name: "hosts of another resource",
+			records: []sqlite.ARecord{
+				record("10.30.0.1", "foo.example.com"),
+				inResource(record("10.30.0.1", "bar.example.com"), "default", "api"),
+			},
+			conflict: true,
+		},
+		{
+			name: "shared key",
+			records: []sqlite.ARecord{
+				withKey(record("10.30.0.1", "foo.example.com"), "shared"),
+				withKey(inResource(record("10.30.0.1", "bar.example.com"), "default", "api"), "shared"),
+			},
+		},
+		{
+			name: "shared key of another namespace",
+			records: []sqlite.ARecord{
+				withKey(record("10.30.0.1", "foo.example.com"), "shared"),
+				withKey(inResource(record("10.30.0.1", "bar.example.com"), "prod", "api"), "shared"),
+			},
+			conflict: true,
+		},
+		{
+			name: "host shared by the share host conflict policy",
+			records: []sqlite.ARecord{
+				record("10.30.0.1", "foo.example.com"),
+				inResource(record("10.30.0.1", "foo.example.com"), "default", "api"),
+				inResource(record("10.30.0.1", "foo.example.com"), "prod", "app"),
+			},
+		},
+		{
+			name: "renamed host",
+			records: []sqlite.ARecord{
+				record("10.30.0.1", "foo.example.com"),
+				reserved(record("10.30.0.1", "bar.example.com")),
+			},
+		},
+		{
+			name: "other hosts of the resource",
+			records: []sqlite.ARecord{
+				record("10.30.0.1", "foo.example.com"),
+				record("10.30.0.1", "bar.example.com"),
+			},
+			conflict: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newStore(t)
+			store.MarkIPAsAllocated(testCIDR, "10.30.0.1", 0)
+			for _, record := range test.records {
+				if !store.CreateARecord(record) {
+					t.Fatalf("Unable to create record: %+v", record)
+				}
+			}
+
+			issues, err := admin.NewStoreBackend(store).Check()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conflict := false
+			for _, issue := range issues {
+				if issue.Kind == admin.SharedIPConflict {
+					conflict = true
+				}
+			}
+			if conflict != test.conflict {
+				t.Errorf("%v got: %v, expected: %v, issues: %v", admin.SharedIPConflict, conflict, test.conflict, issues)
+			}
+		})
+	}
+}
+
+func TestReleaseAndReserve(t *testing.T) {
+	backend := admin.NewStoreBackend(newStore(t))
+	if err := backend.Reserve(allocation("10.30.0.1", "foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Reserve(allocation("10.30.0.1", "bar.example.com")); err == nil {
+		t.Error("Allocated IP Address is reserved again")
+	}
+	if err := backend.Reserve(allocation("10.40.0.1", "bar.example.com")); err == nil {
+		t.Error("IP Address outside of the CIDR is reserved")
+	}
+
+	released, err := backend.Release("10.30.0.1")
+	if err != nil || len(released) != 1 || released[0].HostName != "foo.example.com" {
+		t.Errorf("Release got: %v, %v", released, err)
+	}
+	if _, err = backend.Release("10.99.0.1"); err == nil {
+		t.Error("Unknown IP Address is released")
+	}
+	pools, _ := backend.Pools()
+	if len(pools) != 1 || pools[0].Total != 4 || pools[0].Allocated != 0 {
+		t.Errorf("Unexpected pools: %+v", pools)
+	}
+}
+
+func TestAllocationValidate(t *testing.T) {
+	withKey := func(alloc admin.Allocation, key string) admin.Allocation {
+		alloc.Key = key
+		return alloc
+	}
+	tests := []struct {
+		name  string
+		alloc admin.Allocation
+		valid bool
+	}{
+		{name: "host", alloc: allocation("10.30.0.1", "foo.example.com"), valid: true},
+		{name: "key without host", alloc: withKey(allocation("10.30.0.1", ""), "shared"), valid: true},
+		{name: "host and key", alloc: withKey(allocation("10.30.0.1", "foo.example.com"), "shared"), valid: true},
+		{name: "neither host nor key", alloc: allocation("10.30.0.1", "")},
+		{name: "ip outside of cidr", alloc: allocation("10.40.0.1", "foo.example.com")},
+		{name: "invalid ip", alloc: allocation("not-an-ip", "foo.example.com")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.alloc.Validate(); (err == nil) != test.valid {
+				t.Errorf("Validate of %+v got: %v", test.alloc, err)
+			}
+		})
+	}
+
+	backend := admin.NewStoreBackend(newStore(t))
+	if err := backend.Reserve(withKey(allocation("10.30.0.2", ""), "shared")); err != nil {
+		t.Errorf("Allocation of a key is not reserved: %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	backend := admin.NewStoreBackend(newStore(t))
+	server := httptest.NewServer(admin.NewHandler(backend, [][]byte{[]byte("s3cret")}))
+	defer server.Close()
+
+	if _, err := (&admin.Client{URL: server.URL}).Pools(); err == nil {
+		t.Error("Request without a token is served")
+	}
+
+	client := &admin.Client{URL: server.URL, Token: "s3cret"}
+	if err := client.Reserve(allocation("10.30.0.2", "foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Reserve(allocation("10.30.0.2", "bar.example.com")); err == nil {
+		t.Error("Allocated IP Address is reserved again")
+	}
+	allocs, err := client.Allocations(admin.Filter{HostName: "foo.example.com"})
+	if err != nil || len(allocs) != 1 || allocs[0].IPAddr != "10.30.0.2" {
+		t.Errorf("Allocations got: %v, %v", allocs, err)
+	}
+	if allocs, _ = client.Allocations(admin.Filter{Namespace: "other"}); len(allocs) != 0 {
+		t.Errorf("Allocations of another owner: %v", allocs)
+	}
+	if issues, err := client.Check(); err != nil || len(issues) != 0 {
+		t.Errorf("Check got: %v, %v", issues, err)
+	}
+	if released, err := client.Release("10.30.0.2"); err != nil || len(released) != 1 {
+		t.Errorf("Release got: %v, %v", released, err)
+	}
+	if pools, err := client.Pools(); err != nil || len(pools) != 1 || pools[0].Allocated != 0 {
+		t.Errorf("Pools got: %+v, %v", pools, err)
+	}
+
+	snapshot, err := client.Export()
+	if err != nil || len(snapshot.Pools) != 1 {
+		t.Fatalf("Export got: %+v, %v", snapshot, err)
+	}
+	snapshot.Allocations = []admin.Allocation{allocation("10.30.0.3", "foo.example.com")}
+	snapshot.Pools[0].Addresses[2].Allocated = true
+	report, err := client.Import(snapshot, admin.ImportOptions{Mode: admin.MergeMode, ValidateOnly: true})
+	if err != nil || report.Applied || !report.ValidateOnly || len(report.Conflicts) != 0 {
+		t.Errorf("Validation got: %+v, %v", report, err)
+	}
+	if report, err = client.Import(snapshot, admin.ImportOptions{}); err != nil || !report.Applied {
+		t.Errorf("Import got: %+v, %v", report, err)
+	}
+	if allocs, _ = client.Allocations(admin.Filter{IPAddr: "10.30.0.3"}); len(allocs) != 1 {
+		t.Errorf("Imported allocations: %v", allocs)
+	}
+}
+
+func TestWithoutTokens(t *testing.T) {
+	server := httptest.NewServer(admin.NewHandler(admin.NewStoreBackend(newStore(t)), nil))
+	defer server.Close()
+
+	client := &admin.Client{URL: server.URL}
+	if _, err := client.Pools(); err == nil {
+		t.Error("Request is served without tokens")
+	}
+	if err := client.Reserve(allocation("10.30.0.2", "foo.example.com")); err == nil {
+		t.Error("Reservation is served without tokens")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	server := httptest.NewServer(admin.NewHandler(admin.NewStoreBackend(newStore(t)), [][]byte{[]byte("s3cret")}))
+	defer server.Close()
+	defer log.SetLogLevel(log.GetLogLevel())
+
+	var changed []log.LogLevel
+	log.OnLogLevelChange(func(level log.LogLevel) {
+		changed = append(changed, level)
+	})
+	log.SetLogLevel(log.LL_INFO)
+	client := &admin.Client{URL: server.URL, Token: "s3cret"}
+	if level, err := client.LogLevel(); err != nil || level != "info" {
+		t.Errorf("LogLevel got: %v, %v", level, err)
+	}
+	if level, err := client.SetLogLevel("DEBUG"); err != nil || level != "debug" {
+		t.Errorf("SetLogLevel got: %v, %v", level, err)
+	}
+	if log.GetLogLevel() != log.LL_DEBUG || len(changed) != 2 || changed[1] != log.LL_DEBUG {
+		t.Errorf("Log level is not changed: %v, listener got: %v", log.GetLogLevel(), changed)
+	}
+	if _, err := client.SetLogLevel("verbose"); err == nil || log.GetLogLevel() != log.LL_DEBUG {
+		t.Errorf("Invalid log level is set: %v", log.GetLogLevel())
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	source := admin.NewStoreBackend(newStore(t))
+	if err := source.Reserve(allocation("10.30.0.1", "foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Release("10.30.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := source.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != admin.SnapshotVersion || len(snapshot.Pools) != 1 ||
+		len(snapshot.Pools[0].Addresses) != 4 || len(snapshot.Allocations) != 1 {
+		t.Fatalf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	// A store with another allocation of the IP Address conflicts when merging
+	store := newStore(t)
+	target := admin.NewStoreBackend(store)
+	if err = target.Reserve(allocation("10.30.0.1", "bar.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := target.Import(snapshot, admin.ImportOptions{Mode: admin.MergeMode})
+	if err != nil || report.Applied || len(report.Conflicts) != 1 || report.Conflicts[0].IPAddr != "10.30.0.1" {
+		t.Errorf("Conflicting merge got: %+v, %v", report, err)
+	}
+	report, err = target.Import(snapshot, admin.ImportOptions{Mode: admin.ReplaceMode, ValidateOnly: true})
+	if err != nil || report.Applied || len(report.Conflicts) != 0 {
+		t.Errorf("Validation got: %+v, %v", report, err)
+	}
+	if allocs, _ := target.Allocations(admin.Filter{}); len(allocs) != 1 || allocs[0].HostName != "bar.example.com" {
+		t.Errorf("Validation changed the allocations: %v", allocs)
+	}
+
+	report, err = target.Import(snapshot, admin.ImportOptions{Mode: admin.ReplaceMode})
+	if err != nil || !report.Applied {
+		t.Fatalf("Replace got: %+v, %v", report, err)
+	}
+	if allocs, _ := target.Allocations(admin.Filter{}); len(allocs) != 1 || allocs[0].HostName != "foo.example.com" {
+		t.Errorf("Unexpected allocations after replace: %v", allocs)
+	}
+	for _, record := range store.GetIPRecords(testCIDR) {
+		if record.IPAddr == "10.30.0.2" && record.ReleasedAt == 0 {
+			t.Error("Release time of the IP Address is not imported")
+		}
+	}
+	if issues, _ := target.Check(); len(issues) != 0 {
+		t.Errorf("Imported store has issues: %v", issues)
+	}
+
+	// Nothing is applied from a snapshot with IP Addresses outside of the configured pools
+	snapshot.Pools = append(snapshot.Pools, admin.SnapshotPool{
+		CIDR:      "10.40.0.0/24",
+		Addresses: []admin.SnapshotAddress{{IPAddr: "10.40.0.1", Allocated: true}},
+	})
+	snapshot.Allocations = append(snapshot.Allocations, admin.Allocation{IPAddr: "10.30.0.3",
+		HostName: "baz.example.com", CIDR: testCIDR, Namespace: "default", Name: "web"})
+	report, err = target.Import(snapshot, admin.ImportOptions{Mode: admin.ReplaceMode})
+	if err != nil || report.Applied || len(report.Conflicts) != 2 {
+		t.Errorf("Conflicting snapshot got: %+v, %v", report, err)
+	}
+	if allocs, _ := target.Allocations(admin.Filter{}); len(allocs) != 1 {
+		t.Errorf("Conflicting snapshot is applied: %v", allocs)
+	}
+
+	snapshot.Version = admin.SnapshotVersion + 1
+	if _, err = target.Import(snapshot, admin.ImportOptions{}); err == nil {
+		t.Error("Snapshot of an unknown version is imported")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	backend := admin.NewStoreBackend(newStore(t))
+	start := time.Now()
+	if err := backend.Reserve(allocation("10.30.0.1", "foo.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Reserve(allocation("10.30.0.1", "bar.example.com")); err == nil {
+		t.Fatal("Allocated IP Address is reserved again")
+	}
+	if _, err := backend.Release("10.30.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := backend.Audit(sqlite.AuditQuery{IPAddr: "10.30.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var operations []string
+	for _, event := range events {
+		operations = append(operations, event.Operation+"/"+event.Result)
+	}
+	expected := []string{"allocate-specific/success", "create-record/success", "allocate-specific/failure",
+		"delete-record/success", "release/success"}
+	if strings.Join(operations, ",") != strings.Join(expected, ",") {
+		t.Errorf("Audit events: %v, expected: %v", operations, expected)
+	}
+	if events[0].HostName != "foo.example.com" || events[0].Namespace != "default" ||
+		events[0].Reason != admin.AuditReason || events[0].Time.Before(start.Add(-time.Second)) {
+		t.Errorf("Unexpected event: %+v", events[0])
+	}
+
+	if events, _ = backend.Audit(sqlite.AuditQuery{HostName: "bar.example.com"}); len(events) != 1 {
+		t.Errorf("Audit events of the host: %v", events)
+	}
+	if events, _ = backend.Audit(sqlite.AuditQuery{Until: start.Add(-time.Second)}); len(events) != 0 {
+		t.Errorf("Audit events before the window: %v", events)
+	}
+}
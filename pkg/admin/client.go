@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+// DefaultClientTimeout of the requests of the Client
+const DefaultClientTimeout = 30 * time.Second
+
+// Client is the Backend of the admin API of a running controller
+type Client struct {
+	// URL of the controller, like http://127.0.0.1:8081
+	URL string
+	// Token is the bearer token of the requests, none when empty
+	Token string
+	// HTTPClient makes the requests, one with DefaultClientTimeout when nil
+	HTTPClient *http.Client
+}
+
+var _ Backend = &Client{}
+
+// Pools returns the utilization of the pools of the controller
+func (client *Client) Pools() ([]ipamspec.PoolStats, error) {
+	list := &PoolList{}
+	err := client.do(http.MethodGet, "pools", nil, list)
+	return list.Pools, err
+}
+
+// Allocations returns the allocations of the controller that match the filter
+func (client *Client) Allocations(filter Filter) ([]Allocation, error) {
+	query := url.Values{}
+	for param, value := range map[string]string{
+		"host":      filter.HostName,
+		"ip":        filter.IPAddr,
+		"cidr":      filter.CIDR,
+		"namespace": filter.Namespace,
+		"name":      filter.Name,
+	} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+	list := &AllocationList{}
+	err := client.do(http.MethodGet, "allocations?"+query.Encode(), nil, list)
+	return list.Allocations, err
+}
+
+// Release deletes the allocations of the IP Address and releases it
+func (client *Client) Release(ipAddr string) ([]Allocation, error) {
+	list := &AllocationList{}
+	err := client.do(http.MethodPost, "release", ReleaseRequest{IPAddr: ipAddr}, list)
+	return list.Allocations, err
+}
+
+// Reserve allocates the IP Address to the allocation
+func (client *Client) Reserve(alloc Allocation) error {
+	return client.do(http.MethodPost, "reserve", alloc, &Allocation{})
+}
+
+// Check reports the inconsistencies of the allocations of the controller
+func (client *Client) Check() ([]Issue, error) {
+	list := &IssueList{}
+	err := client.do(http.MethodGet, "check", nil, list)
+	return list.Issues, err
+}
+
+// Export returns a snapshot of the state of the allocations of the controller
+func (client *Client) Export() (Snapshot, error) {
+	snapshot := Snapshot{}
+	err := client.do(http.MethodGet, "snapshot", nil, &snapshot)
+	return snapshot, err
+}
+
+// Import applies the snapshot to the controller
+func (client *Client) Import(snapshot Snapshot, options ImportOptions) (ImportReport, error) {
+	query := url.Values{}
+	if options.Mode != "" {
+		query.Set("mode", options.Mode)
+	}
+	if options.ValidateOnly {
+		query.Set("validate", "true")
+	}
+	report := ImportReport{}
+	err := client.do(http.MethodPost, "snapshot?"+query.Encode(), snapshot, &report)
+	return report, err
+}
+
+// Audit returns the events of the audit log of the controller that match the query
+func (client *Client) Audit(query sqlite.AuditQuery) ([]sqlite.AuditEvent, error) {
+	values := url.Values{}
+	if query.IPAddr != "" {
+		values.Set("ip", query.IPAddr)
+	}
+	if query.HostName != "" {
+		values.Set("host", query.HostName)
+	}
+	if !query.Since.IsZero() {
+		values.Set("since", query.Since.Format(time.RFC3339Nano))
+	}
+	if !query.Until.IsZero() {
+		values.Set("until", query.Until.Format(time.RFC3339Nano))
+	}
+	list := &AuditList{}
+	err := client.do(http.MethodGet, "audit?"+values.Encode(), nil, list)
+	return list.Events, err
+}
+
+// LogLevel returns the log level of the controller
+func (client *Client) LogLevel() (string, error) {
+	setting := &LogLevelSetting{}
+	err := client.do(http.MethodGet, "log-level", nil, setting)
+	return setting.Level, err
+}
+
+// SetLogLevel changes the log level of the controller, until it is restarted
+func (client *Client) SetLogLevel(level string) (string, error) {
+	setting := &LogLevelSetting{}
+	err := client.do(http.MethodPost, "log-level", LogLevelSetting{Level: level}, setting)
+	return setting.Level, err
+}
+
+func (client *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(client.URL, "/")+APIPrefix+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if client.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultClientTimeout}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &apiError{}
+		if json.NewDecoder(resp.Body).Decode(apiErr) != nil || apiErr.Error == "" {
+			return fmt.Errorf("%v %v: %v", method, path, resp.Status)
+		}
+		return fmt.Errorf("%v", apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
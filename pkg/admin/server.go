@@ -0,0 +1,286 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+// adminLog logs the messages of the admin API
+var adminLog = log.NewSubsystemLogger("ADMIN")
+
+// APIPrefix is the path of the admin API
+const APIPrefix = "/admin/v1/"
+
+const (
+	maxRequestSize = 1 << 20
+	// maxSnapshotSize fits the snapshots of pools of a few hundred thousand IP Addresses
+	maxSnapshotSize = 256 << 20
+)
+
+// PoolList is the response of the pools of the admin API
+type PoolList struct {
+	Pools []ipamspec.PoolStats `json:"pools"`
+}
+
+// AllocationList is the response of the allocations of the admin API
+type AllocationList struct {
+	Allocations []Allocation `json:"allocations"`
+}
+
+// IssueList is the response of the consistency check of the admin API
+type IssueList struct {
+	Issues []Issue `json:"issues"`
+}
+
+// AuditList is the response of the audit log of the admin API
+type AuditList struct {
+	Events []sqlite.AuditEvent `json:"events"`
+}
+
+// ReleaseRequest releases an IP Address
+type ReleaseRequest struct {
+	IPAddr string `json:"ip"`
+}
+
+// LogLevelSetting is the log level of the controller, one of debug, info, warning, error or critical
+type LogLevelSetting struct {
+	Level string `json:"level"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewHandler serves the admin API of the backend. Requests need one of the bearer tokens, none
+// is served without tokens.
+func NewHandler(backend Backend, tokens [][]byte) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(APIPrefix+"pools", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		pools, err := backend.Pools()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, PoolList{Pools: pools})
+	})
+	mux.HandleFunc(APIPrefix+"allocations", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		query := r.URL.Query()
+		allocs, err := backend.Allocations(Filter{
+			HostName:  query.Get("host"),
+			IPAddr:    query.Get("ip"),
+			CIDR:      query.Get("cidr"),
+			Namespace: query.Get("namespace"),
+			Name:      query.Get("name"),
+		})
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, AllocationList{Allocations: allocs})
+	})
+	mux.HandleFunc(APIPrefix+"release", func(w http.ResponseWriter, r *http.Request) {
+		req := &ReleaseRequest{}
+		if !allowMethod(w, r, http.MethodPost) || !decode(w, r, req) {
+			return
+		}
+		released, err := backend.Release(req.IPAddr)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, apiError{err.Error()})
+			return
+		}
+		adminLog.Infof("Released IP: %v of %v allocations", req.IPAddr, len(released))
+		writeJSON(w, http.StatusOK, AllocationList{Allocations: released})
+	})
+	mux.HandleFunc(APIPrefix+"reserve", func(w http.ResponseWriter, r *http.Request) {
+		alloc := &Allocation{}
+		if !allowMethod(w, r, http.MethodPost) || !decode(w, r, alloc) {
+			return
+		}
+		if err := alloc.Validate(); err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+			return
+		}
+		if err := backend.Reserve(*alloc); err != nil {
+			writeJSON(w, http.StatusConflict, apiError{err.Error()})
+			return
+		}
+		adminLog.With(alloc.AllocationKey().LogFields()...).With(log.IPKey, alloc.IPAddr).Info("Reserved IP")
+		writeJSON(w, http.StatusOK, alloc)
+	})
+	mux.HandleFunc(APIPrefix+"check", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		issues, err := backend.Check()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, IssueList{Issues: issues})
+	})
+	mux.HandleFunc(APIPrefix+"audit", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		params := r.URL.Query()
+		query := sqlite.AuditQuery{IPAddr: params.Get("ip"), HostName: params.Get("host")}
+		for param, bound := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+			if value := params.Get(param); value != "" {
+				var err error
+				if *bound, err = time.Parse(time.RFC3339Nano, value); err != nil {
+					writeJSON(w, http.StatusBadRequest, apiError{"invalid " + param + ": " + value})
+					return
+				}
+			}
+		}
+		events, err := backend.Audit(query)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, AuditList{Events: events})
+	})
+	mux.HandleFunc(APIPrefix+"snapshot", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			snapshot, err := backend.Export()
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, apiError{err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, snapshot)
+		case http.MethodPost:
+			query := r.URL.Query()
+			options := ImportOptions{Mode: query.Get("mode")}
+			if validate := query.Get("validate"); validate != "" {
+				var err error
+				if options.ValidateOnly, err = strconv.ParseBool(validate); err != nil {
+					writeJSON(w, http.StatusBadRequest, apiError{"invalid validate: " + validate})
+					return
+				}
+			}
+			snapshot := &Snapshot{}
+			if !decodeLimited(w, r, snapshot, maxSnapshotSize) {
+				return
+			}
+			report, err := backend.Import(*snapshot, options)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, apiError{err.Error()})
+				return
+			}
+			if report.Applied {
+				adminLog.Infof("Imported snapshot in %v mode, %v IP Addresses, %v allocations, "+
+					"%v retained IP Addresses", report.Mode, report.Addresses, report.Allocations, report.Retained)
+			}
+			writeJSON(w, http.StatusOK, report)
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		}
+	})
+	mux.HandleFunc(APIPrefix+"log-level", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, LogLevelSetting{Level: log.GetLogLevel().String()})
+		case http.MethodPost:
+			setting := &LogLevelSetting{}
+			if !decode(w, r, setting) {
+				return
+			}
+			level := log.NewLogLevel(setting.Level)
+			if level == nil {
+				writeJSON(w, http.StatusBadRequest, apiError{"invalid level: " + setting.Level})
+				return
+			}
+			log.SetLogLevel(*level)
+			adminLog.Infof("Log level set to %v", *level)
+			writeJSON(w, http.StatusOK, LogLevelSetting{Level: level.String()})
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+		}
+	})
+	return RequireToken(tokens, mux)
+}
+
+// tokenIndexKey keeps the index of the bearer token of a request in its context
+type tokenIndexKey struct{}
+
+// RequireToken lets the requests with one of the bearer tokens through to the handler. Every
+// token is compared in constant time, and the index of the matching one is kept for TokenIndex.
+func RequireToken(tokens [][]byte, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "bearer "
+		index := -1
+		auth := r.Header.Get("Authorization")
+		if len(auth) > len(prefix) && strings.ToLower(auth[:len(prefix)]) == prefix {
+			token := []byte(strings.TrimSpace(auth[len(prefix):]))
+			for i, known := range tokens {
+				if subtle.ConstantTimeCompare(token, known) == 1 && index < 0 {
+					index = i
+				}
+			}
+		}
+		if index < 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="f5-ipam-controller"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{"a valid bearer token is required"})
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenIndexKey{}, index)))
+	})
+}
+
+// TokenIndex returns the index of the bearer token that RequireToken accepted for the request,
+// or -1 when the request did not go through RequireToken
+func TokenIndex(r *http.Request) int {
+	if index, ok := r.Context().Value(tokenIndexKey{}).(int); ok {
+		return index
+	}
+	return -1
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, apiError{"method not allowed"})
+	return false
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeLimited(w, r, v, maxRequestSize)
+}
+
+func decodeLimited(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{"invalid request: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		adminLog.Errorf("Unable to write response: %v", err)
+	}
+}
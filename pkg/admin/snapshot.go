@@ -0,0 +1,330 @@
+package admin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+// SnapshotVersion is the version of the snapshots that are exported and can be imported
+const SnapshotVersion = 1
+
+// Modes of an import
+const (
+	// MergeMode adds the snapshot to the state of the store
+	MergeMode = "merge"
+	// ReplaceMode replaces the state of the store with the snapshot
+	ReplaceMode = "replace"
+)
+
+// Snapshot is the state of the allocations, as exported and imported
+type Snapshot struct {
+	Version     int            `json:"version"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	Pools       []SnapshotPool `json:"pools"`
+	Allocations []Allocation   `json:"allocations"`
+	Retained    []Retention    `json:"retained"`
+}
+
+// SnapshotPool is the state of the IP Addresses of a pool
+type SnapshotPool struct {
+	CIDR      string            `json:"cidr"`
+	Addresses []SnapshotAddress `json:"addresses"`
+}
+
+// SnapshotAddress is the state of an IP Address
+type SnapshotAddress struct {
+	IPAddr    string `json:"ip"`
+	Allocated bool   `json:"allocated"`
+	// ReleasedAt starts the cooldown of the IP Address, in unix seconds
+	ReleasedAt int64 `json:"releasedAt,omitempty"`
+	// Retired IP Addresses are no longer in the configured pool, and are removed once released
+	Retired bool `json:"retired,omitempty"`
+}
+
+// Retention is an IP Address kept for a host after its allocation was deleted
+type Retention struct {
+	IPAddr    string `json:"ip"`
+	CIDR      string `json:"cidr"`
+	Namespace string `json:"namespace"`
+	HostName  string `json:"host"`
+	Key       string `json:"key,omitempty"`
+	// RetainedUntil in unix seconds, the IP Address is retained until it is claimed when zero
+	RetainedUntil int64 `json:"retainedUntil,omitempty"`
+}
+
+// ImportOptions select how a snapshot is imported
+type ImportOptions struct {
+	// Mode is MergeMode or ReplaceMode, MergeMode when empty
+	Mode string `json:"mode"`
+	// ValidateOnly reports the conflicts without applying the snapshot
+	ValidateOnly bool `json:"validateOnly"`
+}
+
+// ImportReport is the outcome of an import, a snapshot with conflicts is not applied at all
+type ImportReport struct {
+	Mode         string     `json:"mode"`
+	ValidateOnly bool       `json:"validateOnly"`
+	Applied      bool       `json:"applied"`
+	Addresses    int        `json:"addresses"`
+	Allocations  int        `json:"allocations"`
+	Retained     int        `json:"retained"`
+	Conflicts    []Conflict `json:"conflicts"`
+}
+
+// Conflict is a part of a snapshot that does not fit the configured pools or the store
+type Conflict struct {
+	IPAddr string `json:"ip"`
+	CIDR   string `json:"cidr,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Export returns the state of the store
+func (backend *StoreBackend) Export() (Snapshot, error) {
+	state := backend.store.GetState()
+	snapshot := Snapshot{
+		Version:     SnapshotVersion,
+		CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		Pools:       []SnapshotPool{},
+		Allocations: []Allocation{},
+		Retained:    []Retention{},
+	}
+	pools := make(map[string]int)
+	for _, ip := range state.IPs {
+		idx, ok := pools[ip.CIDR]
+		if !ok {
+			idx = len(snapshot.Pools)
+			pools[ip.CIDR] = idx
+			snapshot.Pools = append(snapshot.Pools, SnapshotPool{CIDR: ip.CIDR})
+		}
+		snapshot.Pools[idx].Addresses = append(snapshot.Pools[idx].Addresses, SnapshotAddress{
+			IPAddr:     ip.IPAddr,
+			Allocated:  ip.Status == sqlite.ALLOCATED,
+			ReleasedAt: ip.ReleasedAt,
+			Retired:    ip.Retired,
+		})
+	}
+	sort.SliceStable(snapshot.Pools, func(i, j int) bool {
+		return snapshot.Pools[i].CIDR < snapshot.Pools[j].CIDR
+	})
+	for _, record := range state.ARecords {
+		snapshot.Allocations = append(snapshot.Allocations, allocationOf(record))
+	}
+	for _, ip := range state.Retained {
+		snapshot.Retained = append(snapshot.Retained, Retention{
+			IPAddr:        ip.IPAddr,
+			CIDR:          ip.CIDR,
+			Namespace:     ip.Namespace,
+			HostName:      ip.HostName,
+			Key:           ip.Key,
+			RetainedUntil: ip.RetainedUntil,
+		})
+	}
+	return snapshot, nil
+}
+
+// Import applies the snapshot to the store, unless it conflicts with the configured pools or,
+// when merging, with the allocations of the store
+func (backend *StoreBackend) Import(snapshot Snapshot, options ImportOptions) (ImportReport, error) {
+	if options.Mode == "" {
+		options.Mode = MergeMode
+	}
+	report := ImportReport{
+		Mode:         options.Mode,
+		ValidateOnly: options.ValidateOnly,
+		Allocations:  len(snapshot.Allocations),
+		Retained:     len(snapshot.Retained),
+		Conflicts:    []Conflict{},
+	}
+	if options.Mode != MergeMode && options.Mode != ReplaceMode {
+		return report, fmt.Errorf("unknown import mode: %v", options.Mode)
+	}
+	if snapshot.Version != SnapshotVersion {
+		return report, fmt.Errorf("unsupported snapshot version %v, expected %v", snapshot.Version,
+			SnapshotVersion)
+	}
+	for _, pool := range snapshot.Pools {
+		report.Addresses += len(pool.Addresses)
+	}
+
+	state, conflicts := backend.stateOf(snapshot, options.Mode == MergeMode)
+	report.Conflicts = append(report.Conflicts, conflicts...)
+	if len(report.Conflicts) != 0 || options.ValidateOnly {
+		return report, nil
+	}
+	if !backend.store.ImportState(state, options.Mode == ReplaceMode) {
+		return report, fmt.Errorf("unable to import the snapshot into the store")
+	}
+	backend.store.RecordAudit(sqlite.AuditEvent{
+		Operation: sqlite.AuditImport,
+		Result:    sqlite.AuditSuccess,
+		Reason: fmt.Sprintf("%v of a snapshot of %v with %v allocations, %v", options.Mode,
+			snapshot.CreatedAt.Format(time.RFC3339), len(state.ARecords), AuditReason),
+	})
+	report.Applied = true
+	return report, nil
+}
+
+// stateOf converts the snapshot into the state of the store, and reports its conflicts with the
+// configured pools. Merged allocations also have to fit the allocations of the store.
+func (backend *StoreBackend) stateOf(snapshot Snapshot, merge bool) (sqlite.State, []Conflict) {
+	var state sqlite.State
+	var conflicts []Conflict
+	conflict := func(ipAddr, cidr, format string, args ...interface{}) {
+		conflicts = append(conflicts, Conflict{IPAddr: ipAddr, CIDR: cidr, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	// The configured pools are the IP Addresses of the store that are not retired
+	ipRecords := backend.ipRecords()
+	configured := func(ipAddr, cidr string) bool {
+		ipRecord, ok := ipRecords[ipAddr]
+		switch {
+		case !ok || ipRecord.Retired:
+			conflict(ipAddr, cidr, "not in the configured pools")
+		case ipRecord.CIDR != cidr:
+			conflict(ipAddr, cidr, "in pool %v of the configured pools", ipRecord.CIDR)
+		default:
+			return true
+		}
+		return false
+	}
+
+	allocated := make(map[string]bool)
+	for _, pool := range snapshot.Pools {
+		for _, address := range pool.Addresses {
+			if !configured(address.IPAddr, pool.CIDR) {
+				continue
+			}
+			status := sqlite.AVAILABLE
+			if address.Allocated {
+				status = sqlite.ALLOCATED
+				allocated[address.IPAddr] = true
+			}
+			state.IPs = append(state.IPs, sqlite.IPRecord{
+				IPAddr:     address.IPAddr,
+				CIDR:       pool.CIDR,
+				Status:     status,
+				ReleasedAt: address.ReleasedAt,
+			})
+		}
+	}
+
+	var existing map[string][]sqlite.ARecord
+	retained := make(map[string]sqlite.RetainedIP)
+	if merge {
+		existing = make(map[string][]sqlite.ARecord)
+		for _, record := range backend.store.GetAllARecords() {
+			existing[record.IPAddr] = append(existing[record.IPAddr], record)
+		}
+		for _, ip := range backend.store.GetAllRetainedIPs() {
+			retained[ip.IPAddr] = ip
+		}
+	}
+
+	byIP := make(map[string][]sqlite.ARecord)
+	for _, alloc := range snapshot.Allocations {
+		if err := alloc.Validate(); err != nil {
+			conflict(alloc.IPAddr, alloc.CIDR, "invalid allocation: %v", err)
+			continue
+		}
+		if !configured(alloc.IPAddr, alloc.CIDR) {
+			continue
+		}
+		if !allocated[alloc.IPAddr] {
+			conflict(alloc.IPAddr, alloc.CIDR, "allocated to %v of %v/%v, but not allocated in the snapshot",
+				alloc.HostName, alloc.Namespace, alloc.Name)
+			continue
+		}
+		record := sqlite.ARecord{
+			IPAddr:        alloc.IPAddr,
+			HostName:      alloc.HostName,
+			CIDR:          alloc.CIDR,
+			Namespace:     alloc.Namespace,
+			Name:          alloc.Name,
+			Key:           alloc.Key,
+			ReservedUntil: alloc.ReservedUntil,
+		}
+		if other, ok := conflictingRecord(record, byIP[record.IPAddr]); ok {
+			conflict(alloc.IPAddr, alloc.CIDR, "allocated to %v of %v/%v and to %v of %v/%v without a shared key",
+				other.HostName, other.Namespace, other.Name, alloc.HostName, alloc.Namespace, alloc.Name)
+			continue
+		}
+		if other, ok := conflictingRecord(record, existing[record.IPAddr]); ok {
+			conflict(alloc.IPAddr, alloc.CIDR, "allocated to %v of %v/%v in the store", other.HostName,
+				other.Namespace, other.Name)
+			continue
+		}
+		if ip, ok := retained[record.IPAddr]; ok && (ip.HostName != record.HostName || ip.Namespace != record.Namespace) {
+			conflict(alloc.IPAddr, alloc.CIDR, "retained for %v of %v in the store", ip.HostName, ip.Namespace)
+			continue
+		}
+		byIP[record.IPAddr] = append(byIP[record.IPAddr], record)
+		state.ARecords = append(state.ARecords, record)
+	}
+
+	seen := make(map[string]bool)
+	for _, ip := range snapshot.Retained {
+		if ip.HostName == "" || ip.Namespace == "" {
+			conflict(ip.IPAddr, ip.CIDR, "a retention needs a host and a namespace")
+			continue
+		}
+		if !configured(ip.IPAddr, ip.CIDR) {
+			continue
+		}
+		if seen[ip.IPAddr] {
+			conflict(ip.IPAddr, ip.CIDR, "retained more than once")
+			continue
+		}
+		seen[ip.IPAddr] = true
+		if !allocated[ip.IPAddr] {
+			conflict(ip.IPAddr, ip.CIDR, "retained for %v of %v, but not allocated in the snapshot",
+				ip.HostName, ip.Namespace)
+			continue
+		}
+		if other, ok := retained[ip.IPAddr]; ok && (other.HostName != ip.HostName || other.Namespace != ip.Namespace) {
+			conflict(ip.IPAddr, ip.CIDR, "retained for %v of %v in the store", other.HostName, other.Namespace)
+			continue
+		}
+		for _, record := range existing[ip.IPAddr] {
+			if record.HostName != ip.HostName || record.Namespace != ip.Namespace {
+				conflict(ip.IPAddr, ip.CIDR, "allocated to %v of %v/%v in the store", record.HostName,
+					record.Namespace, record.Name)
+				break
+			}
+		}
+		state.Retained = append(state.Retained, sqlite.RetainedIP{
+			ARecord: sqlite.ARecord{
+				IPAddr:    ip.IPAddr,
+				HostName:  ip.HostName,
+				CIDR:      ip.CIDR,
+				Namespace: ip.Namespace,
+				Key:       ip.Key,
+			},
+			RetainedUntil: ip.RetainedUntil,
+		})
+	}
+	return state, conflicts
+}
+
+// conflictingRecord returns a record of the IP Address that the record can not share it with.
+// Records share an IP Address when they are the same allocation, or have the same key. The share
+// host conflict policy gives the allocations of a host in a pool the same IP Address, and a
+// renamed host keeps the IP Address of its reserved record until the previous one is deleted.
+func conflictingRecord(record sqlite.ARecord, records []sqlite.ARecord) (sqlite.ARecord, bool) {
+	for _, other := range records {
+		same := other.HostName == record.HostName && other.Namespace == record.Namespace &&
+			other.Name == record.Name && other.CIDR == record.CIDR && other.Key == record.Key
+		shared := record.Key != "" && other.Key == record.Key && other.Namespace == record.Namespace &&
+			other.CIDR == record.CIDR
+		sameHost := record.HostName != "" && other.HostName == record.HostName &&
+			other.CIDR == record.CIDR && other.Key == record.Key
+		renamed := (record.ReservedUntil > 0 || other.ReservedUntil > 0) && other.Namespace == record.Namespace &&
+			other.Name == record.Name && other.CIDR == record.CIDR && other.Key == record.Key
+		if !same && !shared && !sameHost && !renamed {
+			return other, true
+		}
+	}
+	return sqlite.ARecord{}, false
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+)
+
+// adminBackend repairs the store of the Manager in between requests, and keeps the allocations
+// counted against the quotas in line with it
+type adminBackend struct {
+	*admin.StoreBackend
+	ctlr *Controller
+}
+
+// AdminBackend returns the backend of the admin API, it is not available when the Manager
+// has no store
+func (ctlr *Controller) AdminBackend() (admin.Backend, bool) {
+	storeMgr, ok := ctlr.Manager.(manager.StoreManager)
+	if !ok {
+		return nil, false
+	}
+	return &adminBackend{StoreBackend: admin.NewStoreBackend(storeMgr.Store()), ctlr: ctlr}, true
+}
+
+// Pools returns the utilization of the pools being served
+func (backend *adminBackend) Pools() ([]ipamspec.PoolStats, error) {
+	return backend.ctlr.GetPoolStats(), nil
+}
+
+// Release deletes the allocations of the IP Address and releases it. The Orchestrators are not
+// told, a resource that still claims the host gets an IP Address again once it is updated.
+func (backend *adminBackend) Release(ipAddr string) ([]admin.Allocation, error) {
+	backend.ctlr.poolLock.Lock()
+	defer backend.ctlr.poolLock.Unlock()
+
+	released, err := backend.StoreBackend.Release(ipAddr)
+	for _, alloc := range released {
+		reqLog(requestOf(alloc), ipAddr).Warning("Force released IP")
+		backend.ctlr.untrackAllocation(requestOf(alloc))
+	}
+	if err == nil {
+		backend.ctlr.retryPending()
+	}
+	return released, err
+}
+
+// Reserve allocates the IP Address to the allocation, which counts against the quotas of its owner
+func (backend *adminBackend) Reserve(alloc admin.Allocation) error {
+	backend.ctlr.poolLock.Lock()
+	defer backend.ctlr.poolLock.Unlock()
+
+	if err := backend.StoreBackend.Reserve(alloc); err != nil {
+		return err
+	}
+	reqLog(requestOf(alloc), alloc.IPAddr).Warning("Force reserved IP")
+	backend.ctlr.trackAllocation(requestOf(alloc))
+	return nil
+}
+
+// Export returns a snapshot of the store in between requests
+func (backend *adminBackend) Export() (admin.Snapshot, error) {
+	backend.ctlr.poolLock.Lock()
+	defer backend.ctlr.poolLock.Unlock()
+
+	return backend.StoreBackend.Export()
+}
+
+// Import applies the snapshot to the store, and counts its allocations against the quotas
+// instead of the ones that were replaced. The Orchestrators are not told.
+func (backend *adminBackend) Import(snapshot admin.Snapshot, options admin.ImportOptions) (admin.ImportReport, error) {
+	backend.ctlr.poolLock.Lock()
+	defer backend.ctlr.poolLock.Unlock()
+
+	report, err := backend.StoreBackend.Import(snapshot, options)
+	if err != nil || !report.Applied {
+		return report, err
+	}
+	coreLog.Warningf("Imported snapshot in %v mode, %v allocations", report.Mode, report.Allocations)
+
+	allocs, _ := backend.StoreBackend.Allocations(admin.Filter{})
+	current := make(map[ipamspec.AllocationKey]bool)
+	for _, alloc := range allocs {
+		current[alloc.AllocationKey()] = true
+		backend.ctlr.trackAllocation(requestOf(alloc))
+	}
+	for _, key := range backend.ctlr.trackedAllocations() {
+		if !current[key] {
+			backend.ctlr.untrackAllocation(ipamspec.IPAMRequest{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+				HostName:  key.HostName,
+				CIDR:      key.CIDR,
+				Key:       key.Key,
+			})
+		}
+	}
+	backend.ctlr.retryPending()
+	return report, nil
+}
+
+func requestOf(alloc admin.Allocation) ipamspec.IPAMRequest {
+	key := alloc.AllocationKey()
+	return ipamspec.IPAMRequest{
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		HostName:  key.HostName,
+		CIDR:      key.CIDR,
+		Key:       key.Key,
+	}
+}
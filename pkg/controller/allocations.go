@@ -0,0 +1,388 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+// Policies for a host in a pool that is claimed by more than one resource
+const (
+	// RejectHostConflicts keeps the host with the resource that claimed it first,
+	// the requests of other resources fail
+	RejectHostConflicts = "reject"
+	// ShareHostConflicts gives every resource the same IP Address, which is released
+	// when the last of them releases it
+	ShareHostConflicts = "share"
+
+	hostLockCount = 64
+)
+
+// IsValidHostConflictPolicy checks whether the host conflict policy is known
+func IsValidHostConflictPolicy(policy string) bool {
+	return policy == RejectHostConflicts || policy == ShareHostConflicts
+}
+
+// lockRequest locks the hosts and the shared key of the request, so that the conflicts of
+// resources and the shared allocations are resolved one request at a time
+func (ctlr *Controller) lockRequest(req ipamspec.IPAMRequest) func() {
+	names := []string{req.HostName}
+	if req.Operation == ipamspec.UPDATE {
+		names = append(names, req.PrevHostName)
+	}
+	if req.Key != "" {
+		names = append(names, req.Namespace+"/"+req.CIDR+"/"+req.Key)
+	}
+	// Locks are always taken in the same order, and each of them once
+	var locks []uint32
+	for _, name := range names {
+		index := lockIndex(name)
+		i := sort.Search(len(locks), func(i int) bool { return locks[i] >= index })
+		if i < len(locks) && locks[i] == index {
+			continue
+		}
+		locks = append(locks, 0)
+		copy(locks[i+1:], locks[i:])
+		locks[i] = index
+	}
+	for _, i := range locks {
+		ctlr.hostLocks[i].Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			ctlr.hostLocks[locks[i]].Unlock()
+		}
+	}
+}
+
+func lockIndex(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32() % hostLockCount
+}
+
+// allocate serves the request with the IP Address the resource already holds for the host,
+// or with a new allocation when it is within the policies and quotas of its namespace
+func (ctlr *Controller) allocate(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	key := req.AllocationKey()
+	ipAddr := ctlr.Manager.GetIPAddress(key)
+	owned, reason, message := ctlr.reserveAllocation(req)
+	if owned && ipAddr != "" {
+		return ctlr.heldAllocation(req, ipAddr)
+	}
+	if reason != "" {
+		return ctlr.rejectAllocation(req, reason, message)
+	}
+	if ipAddr != "" {
+		return ctlr.heldAllocation(req, ipAddr)
+	}
+
+	if holder, ok := ctlr.hostHolder(key); ok {
+		switch {
+		case isUnowned(holder.Key) && key.Key == "" && isRequested(req, holder.IPAddr):
+			if !ctlr.adoptAllocation(key, holder) {
+				return ctlr.recordFailed(req, holder.IPAddr)
+			}
+			return allocated(req, holder.IPAddr)
+		case ctlr.HostConflictPolicy == ShareHostConflicts && key.Key == "" && isRequested(req, holder.IPAddr):
+			reqLog(req, holder.IPAddr).Infof("Sharing IP of Host with %v/%v", holder.Key.Namespace, holder.Key.Name)
+			if !ctlr.reserveRecord(key, holder.IPAddr) {
+				return ctlr.recordFailed(req, holder.IPAddr)
+			}
+			return allocated(req, holder.IPAddr)
+		default:
+			ctlr.untrackAllocation(req)
+			return ctlr.rejectAllocation(req, HostConflict, conflictMessage(req, holder))
+		}
+	}
+
+	if key.Key != "" {
+		if ipAddr = ctlr.Manager.GetSharedIPAddress(key); ipAddr != "" {
+			if !isRequested(req, ipAddr) {
+				ctlr.untrackAllocation(req)
+				return ctlr.rejectAllocation(req, KeyConflict, fmt.Sprintf(
+					"Key %v in Pool %v is allocated IP Address %v", req.Key, req.CIDR, ipAddr))
+			}
+			reqLog(req, ipAddr).Debug("Shared IP of Key with Host")
+			if !ctlr.reserveRecord(key, ipAddr) {
+				return ctlr.recordFailed(req, ipAddr)
+			}
+			return allocated(req, ipAddr)
+		}
+	}
+
+	if resp, ok := ctlr.claimRetained(req, req.RequestedIP); ok {
+		return resp
+	}
+
+	if req.RequestedIP != "" {
+		return ctlr.allocateRequested(req)
+	}
+
+	// Requests of a pool are served in order once it was exhausted
+	if ctlr.waitsBehind(req) {
+		return ctlr.pendAllocation(req)
+	}
+	ipAddr = ctlr.Manager.GetNextIPAddress(key)
+	if ipAddr == "" {
+		return ctlr.pendAllocation(req)
+	}
+	reqLog(req, ipAddr).Debug("Allocated IP")
+	if !ctlr.reserveRecord(key, ipAddr) {
+		return ctlr.recordFailed(req, ipAddr)
+	}
+	return allocated(req, ipAddr)
+}
+
+// update moves the allocation of the previous host and pool of the request. A renamed host keeps
+// its IP Address. A host moved to another pool is allocated there. The previous allocation is
+// deleted by a DELETE once the new one is published.
+func (ctlr *Controller) update(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	prev := req
+	prev.HostName, prev.CIDR = req.PrevHostName, req.PrevCIDR
+	prevKey := prev.AllocationKey()
+	ipAddr := ctlr.Manager.GetIPAddress(prevKey)
+
+	if ipAddr == "" || req.CIDR != req.PrevCIDR {
+		// The previous allocation does not count against the quotas while it is being replaced,
+		// but until it is released
+		ctlr.untrackAllocation(prev)
+		resp := ctlr.allocate(req)
+		if ipAddr != "" {
+			ctlr.trackAllocation(prev)
+		}
+		return resp
+	}
+
+	key := req.AllocationKey()
+	if holder, ok := ctlr.hostHolder(key); ok {
+		return ctlr.rejectAllocation(req, HostConflict, conflictMessage(req, holder))
+	}
+	// The previous record is deleted by a DELETE once the renamed one is published
+	if !ctlr.reserveRecord(key, ipAddr) {
+		return ctlr.recordFailed(req, ipAddr)
+	}
+	ctlr.trackAllocation(req)
+	reqLog(req, ipAddr).Infof("Renamed Host: %v to %v", req.PrevHostName, req.HostName)
+	return allocated(req, ipAddr)
+}
+
+// allocateRequested allocates exactly the IP Address the resource asked for
+func (ctlr *Controller) allocateRequested(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	if !ctlr.Manager.IsIPAddressInPool(req.CIDR, req.RequestedIP) {
+		ctlr.untrackAllocation(req)
+		return ctlr.rejectAllocation(req, IPOutOfRange,
+			fmt.Sprintf("IP Address %v is not in Pool %v", req.RequestedIP, req.CIDR))
+	}
+	if !ctlr.Manager.AllocateIPAddress(req.AllocationKey(), req.RequestedIP) {
+		ctlr.untrackAllocation(req)
+		message := fmt.Sprintf("IP Address %v in Pool %v is not available", req.RequestedIP, req.CIDR)
+		if holders := ctlr.Manager.GetAllocationsOfIP(req.RequestedIP); len(holders) != 0 {
+			message = fmt.Sprintf("IP Address %v in Pool %v is already allocated to %v/%v", req.RequestedIP,
+				req.CIDR, holders[0].Key.Namespace, holders[0].Key.Name)
+		}
+		return ctlr.rejectAllocation(req, IPUnavailable, message)
+	}
+	reqLog(req, req.RequestedIP).Debug("Allocated requested IP")
+	if !ctlr.reserveRecord(req.AllocationKey(), req.RequestedIP) {
+		return ctlr.recordFailed(req, req.RequestedIP)
+	}
+	return allocated(req, req.RequestedIP)
+}
+
+// claimRetained re-assigns the IP Address retained for the namespace and host of the request,
+// unless the request asks for another one
+func (ctlr *Controller) claimRetained(req ipamspec.IPAMRequest, asked string) (ipamspec.IPAMResponse, bool) {
+	key := req.AllocationKey()
+	ipAddr := ctlr.Manager.GetRetainedIPAddress(key)
+	if ipAddr == "" || (asked != "" && asked != ipAddr) {
+		return ipamspec.IPAMResponse{}, false
+	}
+	if !ctlr.Manager.ClaimRetainedIPAddress(key, ipAddr) {
+		return ipamspec.IPAMResponse{}, false
+	}
+	reqLog(req, ipAddr).Info("Re-assigned retained IP")
+	if !ctlr.reserveRecord(key, ipAddr) {
+		return ctlr.recordFailed(req, ipAddr), true
+	}
+	return allocated(req, ipAddr), true
+}
+
+// reclaim releases or retains the IP Address of the deleted allocation as its reclaim policy decides
+func (ctlr *Controller) reclaim(req ipamspec.IPAMRequest, ipAddr string) {
+	policy, period := ctlr.reclaimPolicy(req)
+	switch policy {
+	case ipamspec.ReclaimRetain, ipamspec.ReclaimRetainForever:
+		reqLog(req, ipAddr).Infof("Retaining IP by reclaim policy %v", policy)
+		ctlr.Manager.RetainIPAddress(req.AllocationKey(), ipAddr, period)
+	default:
+		ctlr.Manager.ReleaseIPAddress(req.AllocationKey(), ipAddr)
+	}
+}
+
+// reclaimPolicy returns the reclaim policy of the request, or else of its pool, and the
+// retain period, which is zero for retaining forever
+func (ctlr *Controller) reclaimPolicy(req ipamspec.IPAMRequest) (string, time.Duration) {
+	policy, period := req.ReclaimPolicy, req.RetainPeriod
+	if policy == "" {
+		pool := ctlr.pools[req.CIDR]
+		policy, period = pool.ReclaimPolicy, pool.RetainPeriod
+	}
+	switch policy {
+	case ipamspec.ReclaimRetain:
+		if period <= 0 {
+			period = DefaultRetainPeriod
+		}
+		return policy, period
+	case ipamspec.ReclaimRetainForever:
+		return policy, 0
+	}
+	return ipamspec.ReclaimDelete, 0
+}
+
+// heldAllocation serves the request with the IP Address the resource already holds,
+// unless it asked for another one
+func (ctlr *Controller) heldAllocation(req ipamspec.IPAMRequest, ipAddr string) ipamspec.IPAMResponse {
+	if !isRequested(req, ipAddr) {
+		return ctlr.rejectAllocation(req, IPUnavailable, fmt.Sprintf(
+			"Host %v in Pool %v already holds IP Address %v", req.HostName, req.CIDR, ipAddr))
+	}
+	return allocated(req, ipAddr)
+}
+
+// isRequested checks whether the IP Address serves the request, any does when none was asked for
+func isRequested(req ipamspec.IPAMRequest, ipAddr string) bool {
+	return req.RequestedIP == "" || req.RequestedIP == ipAddr
+}
+
+// restoreAllocation allocates the IP Address of the request, which the resource held before
+func (ctlr *Controller) restoreAllocation(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	key := req.AllocationKey()
+	// A persistent store already holds the allocation after a restart, which is published
+	if ctlr.Manager.GetIPAddress(key) == req.IPAddr {
+		if !ctlr.commitPublished(key, req.IPAddr) {
+			return ctlr.recordFailed(req, req.IPAddr)
+		}
+		ctlr.trackAllocation(req)
+		return allocated(req, req.IPAddr)
+	}
+
+	if holder, ok := ctlr.hostHolder(key); ok {
+		switch {
+		case isUnowned(holder.Key) && key.Key == "" && holder.IPAddr == req.IPAddr:
+			if !ctlr.adoptAllocation(key, holder) {
+				return ctlr.recordFailed(req, req.IPAddr)
+			}
+		case ctlr.HostConflictPolicy == ShareHostConflicts && key.Key == "" && holder.IPAddr == req.IPAddr:
+			if !ctlr.Manager.CreateARecord(key, req.IPAddr) {
+				return ctlr.recordFailed(req, req.IPAddr)
+			}
+		default:
+			return ctlr.rejectAllocation(req, HostConflict, conflictMessage(req, holder))
+		}
+		ctlr.trackAllocation(req)
+		return allocated(req, req.IPAddr)
+	}
+
+	if key.Key != "" {
+		if shared := ctlr.Manager.GetSharedIPAddress(key); shared != "" {
+			if shared != req.IPAddr {
+				return ctlr.rejectAllocation(req, KeyConflict, fmt.Sprintf(
+					"Key %v in Pool %v is allocated IP Address %v", req.Key, req.CIDR, shared))
+			}
+			if !ctlr.Manager.CreateARecord(key, req.IPAddr) {
+				return ctlr.recordFailed(req, req.IPAddr)
+			}
+			ctlr.trackAllocation(req)
+			return allocated(req, req.IPAddr)
+		}
+	}
+
+	if resp, ok := ctlr.claimRetained(req, req.IPAddr); ok {
+		if !resp.Status {
+			return resp
+		}
+		// The restored allocation is already published
+		if !ctlr.commitPublished(key, req.IPAddr) {
+			return ctlr.recordFailed(req, req.IPAddr)
+		}
+		ctlr.trackAllocation(req)
+		return resp
+	}
+
+	if !ctlr.Manager.AllocateIPAddress(key, req.IPAddr) {
+		reqLog(req, req.IPAddr).Debug("Unable to Allocate asked IPAddress")
+		if !ctlr.Manager.IsIPAddressInPool(req.CIDR, req.IPAddr) {
+			return ctlr.rejectAllocation(req, IPOutOfRange, fmt.Sprintf(
+				"IP Address %v could not be restored, it is not in Pool %v", req.IPAddr, req.CIDR))
+		}
+		message := fmt.Sprintf("IP Address %v could not be restored, it is not available", req.IPAddr)
+		if holders := ctlr.Manager.GetAllocationsOfIP(req.IPAddr); len(holders) != 0 {
+			message = fmt.Sprintf("IP Address %v could not be restored, it is allocated to %v/%v",
+				req.IPAddr, holders[0].Key.Namespace, holders[0].Key.Name)
+		}
+		return ctlr.rejectAllocation(req, IPUnavailable, message)
+	}
+	reqLog(req, req.IPAddr).Debug("Allocated IP")
+	if !ctlr.Manager.CreateARecord(key, req.IPAddr) {
+		return ctlr.recordFailed(req, req.IPAddr)
+	}
+	ctlr.trackAllocation(req)
+	return allocated(req, req.IPAddr)
+}
+
+// hostHolder returns the allocation of the host in the pool of the key by another resource,
+// allocations that share the key of the request do not hold the host against it
+func (ctlr *Controller) hostHolder(key ipamspec.AllocationKey) (ipamspec.Allocation, bool) {
+	if key.HostName == "" {
+		return ipamspec.Allocation{}, false
+	}
+	for _, alloc := range ctlr.Manager.GetAllocations(key.HostName) {
+		if alloc.Key.CIDR != key.CIDR || alloc.Key == key {
+			continue
+		}
+		if key.Key != "" && alloc.Key.Key == key.Key && alloc.Key.Namespace == key.Namespace {
+			continue
+		}
+		return alloc, true
+	}
+	return ipamspec.Allocation{}, false
+}
+
+// isReferenced checks whether any allocation still refers to the IP Address
+func (ctlr *Controller) isReferenced(ipAddr string) bool {
+	return len(ctlr.Manager.GetAllocationsOfIP(ipAddr)) != 0
+}
+
+// isUnowned checks whether the allocation was recorded before owners were
+func isUnowned(key ipamspec.AllocationKey) bool {
+	return key.Namespace == "" && key.Name == ""
+}
+
+// adoptAllocation makes the resource of the key the owner of an allocation without owner,
+// which keeps its record unless the new one is created
+func (ctlr *Controller) adoptAllocation(key ipamspec.AllocationKey, alloc ipamspec.Allocation) bool {
+	if !ctlr.Manager.CreateARecord(key, alloc.IPAddr) {
+		return false
+	}
+	ctlr.Manager.DeleteARecord(alloc.Key, alloc.IPAddr)
+	coreLog.With(key.LogFields()...).With(log.IPKey, alloc.IPAddr).Info("Adopted IP of Host")
+	return true
+}
+
+func conflictMessage(req ipamspec.IPAMRequest, holder ipamspec.Allocation) string {
+	return fmt.Sprintf("Host %v in Pool %v is already allocated to %v/%v",
+		req.HostName, req.CIDR, holder.Key.Namespace, holder.Key.Name)
+}
+
+func allocated(req ipamspec.IPAMRequest, ipAddr string) ipamspec.IPAMResponse {
+	return ipamspec.IPAMResponse{
+		Request: req,
+		IPAddr:  ipAddr,
+		Status:  true,
+	}
+}
@@ -0,0 +1,528 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration/fake"
+)
+
+const otherCIDR = "10.10.20.0/24"
+
+// allocationStep is a request and the IP Address it is expected to get, none when it is rejected
+type allocationStep struct {
+	req    ipamspec.IPAMRequest
+	ipAddr string
+}
+
+func withNamespace(req ipamspec.IPAMRequest, namespace string) ipamspec.IPAMRequest {
+	req.Namespace = namespace
+	return req
+}
+
+func withCIDR(req ipamspec.IPAMRequest, cidr string) ipamspec.IPAMRequest {
+	req.CIDR = cidr
+	return req
+}
+
+// newAllocationController creates a controller with the given pools, or else the pools of
+// 10.10.10.1-10.10.10.10 and 10.10.20.1-10.10.20.10
+func newAllocationController(t *testing.T, spec Spec, pools ...ipamspec.PoolSpec) (*Controller, manager.Manager) {
+	if len(pools) == 0 {
+		pools = []ipamspec.PoolSpec{
+			{CIDR: "10.10.10.0/24", Ranges: []string{"10.10.10.1-10.10.10.10"}},
+			{CIDR: otherCIDR, Ranges: []string{"10.10.20.1-10.10.20.10"}},
+		}
+	}
+	mgr := newManager(t, manager.IPAMManagerParams{Pools: pools})
+	t.Cleanup(mgr.Close)
+	spec.Orchestrator = fake.NewOrchestrator()
+	spec.Manager = mgr
+	spec.StopCh = make(chan struct{})
+	return NewController(spec), mgr
+}
+
+// runSteps processes the requests in order, the responses of DELETEs are not checked
+func runSteps(t *testing.T, ctlr *Controller, steps []allocationStep) {
+	t.Helper()
+	for i, step := range steps {
+		resp := ctlr.processRequest(step.req)
+		if step.req.Operation == ipamspec.DELETE {
+			continue
+		}
+		if resp.IPAddr != step.ipAddr || resp.Status != (step.ipAddr != "") {
+			t.Errorf("Step %v: %v %v/%v of %v got: %v, expected: %q", i+1, step.req.Operation,
+				step.req.Namespace, step.req.Name, step.req.HostName, resp, step.ipAddr)
+			continue
+		}
+		if !resp.Status && (resp.Pending || resp.Reason == "") {
+			t.Errorf("Step %v: rejection got: %v", i+1, resp)
+		}
+	}
+}
+
+// expectHeld checks which IP Addresses are still referenced by allocations
+func expectHeld(t *testing.T, mgr manager.Manager, held, released []string) {
+	t.Helper()
+	for _, ipAddr := range held {
+		if len(mgr.GetAllocationsOfIP(ipAddr)) == 0 {
+			t.Errorf("IP Address %v is released", ipAddr)
+		}
+	}
+	for _, ipAddr := range released {
+		if allocations := mgr.GetAllocationsOfIP(ipAddr); len(allocations) != 0 {
+			t.Errorf("IP Address %v is held by: %v", ipAddr, allocations)
+		}
+	}
+}
+
+func TestHostConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		steps    []allocationStep
+		held     []string
+		released []string
+	}{
+		{
+			name: "another namespace is rejected",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod")},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name: "another resource is rejected",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: testRequest("api", "foo.example.com", ipamspec.CREATE)},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name: "another pool is allocated separately",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withCIDR(testRequest("api", "foo.example.com", ipamspec.CREATE), otherCIDR), ipAddr: "10.10.20.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+			},
+			held:     []string{"10.10.20.1"},
+			released: []string{"10.10.10.1"},
+		},
+		{
+			name: "rejected resource does not release the host",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod")},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.DELETE), "prod")},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name: "released host is allocated to another resource",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name:   "shared until the last resource releases it",
+			policy: ShareHostConflicts,
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), ipAddr: "10.10.10.1"},
+				{req: testRequest("api", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+				{req: testRequest("api", "foo.example.com", ipamspec.DELETE)},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name:   "shared and released",
+			policy: ShareHostConflicts,
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), ipAddr: "10.10.10.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.DELETE), "prod")},
+			},
+			released: []string{"10.10.10.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctlr, mgr := newAllocationController(t, Spec{HostConflictPolicy: test.policy})
+			runSteps(t, ctlr, test.steps)
+			expectHeld(t, mgr, test.held, test.released)
+		})
+	}
+}
+
+func TestAdoptUnowned(t *testing.T) {
+	ctlr, mgr := newAllocationController(t, Spec{})
+	// Allocations recorded before owners were have no namespace and name
+	unowned := ipamspec.AllocationKey{HostName: "foo.example.com", CIDR: "10.10.10.0/24"}
+	if !mgr.AllocateIPAddress(unowned, "10.10.10.5") || !mgr.CreateARecord(unowned, "10.10.10.5") {
+		t.Fatal("Unable to allocate without owner")
+	}
+
+	runSteps(t, ctlr, []allocationStep{
+		{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.5"},
+		{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod")},
+	})
+	allocations := mgr.GetAllocationsOfIP("10.10.10.5")
+	if len(allocations) != 1 || allocations[0].Key != testRequest("web", "foo.example.com", "").AllocationKey() {
+		t.Errorf("Allocation without owner is not adopted: %v", allocations)
+	}
+}
+
+func withKey(req ipamspec.IPAMRequest, key string) ipamspec.IPAMRequest {
+	req.Key = key
+	return req
+}
+
+func TestSharedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []allocationStep
+		held     []string
+		released []string
+	}{
+		{
+			name: "shared until the last reference is released",
+			steps: []allocationStep{
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("web", "baz.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.DELETE), "vip")},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.DELETE), "vip")},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name: "released with the last reference",
+			steps: []allocationStep{
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.DELETE), "vip")},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.DELETE), "vip")},
+				{req: withKey(testRequest("app", "foo.example.com", ipamspec.CREATE), "other"), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+		},
+		{
+			name: "keys without a host",
+			steps: []allocationStep{
+				{req: withKey(testRequest("web", "", ipamspec.CREATE), "tcp"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("api", "", ipamspec.CREATE), "tcp"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("app", "", ipamspec.CREATE), "udp"), ipAddr: "10.10.10.2"},
+				{req: withKey(testRequest("web", "", ipamspec.DELETE), "tcp")},
+				{req: withKey(testRequest("api", "", ipamspec.DELETE), "tcp")},
+			},
+			held:     []string{"10.10.10.2"},
+			released: []string{"10.10.10.1"},
+		},
+		{
+			name: "keys of other namespaces and pools are not shared",
+			steps: []allocationStep{
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: withNamespace(withKey(testRequest("web", "bar.example.com", ipamspec.CREATE), "vip"), "prod"), ipAddr: "10.10.10.2"},
+				{req: withCIDR(withKey(testRequest("web", "baz.example.com", ipamspec.CREATE), "vip"), otherCIDR), ipAddr: "10.10.20.1"},
+			},
+			held: []string{"10.10.10.1", "10.10.10.2", "10.10.20.1"},
+		},
+		{
+			name: "host held by another resource is not shared by a key",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("api", "foo.example.com", ipamspec.CREATE), "vip")},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.2"},
+			},
+			held: []string{"10.10.10.1", "10.10.10.2"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctlr, mgr := newAllocationController(t, Spec{})
+			runSteps(t, ctlr, test.steps)
+			expectHeld(t, mgr, test.held, test.released)
+		})
+	}
+}
+
+func withRequestedIP(req ipamspec.IPAMRequest, ipAddr string) ipamspec.IPAMRequest {
+	req.RequestedIP = ipAddr
+	return req
+}
+
+func TestRequestedIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []allocationStep
+		held     []string
+		released []string
+	}{
+		{
+			name: "exact",
+			steps: []allocationStep{
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.5"), ipAddr: "10.10.10.5"},
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.5"), ipAddr: "10.10.10.5"},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			held:     []string{"10.10.10.1", "10.10.10.5"},
+			released: []string{"10.10.10.2"},
+		},
+		{
+			name: "taken",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withRequestedIP(testRequest("api", "bar.example.com", ipamspec.CREATE), "10.10.10.1")},
+			},
+			held:     []string{"10.10.10.1"},
+			released: []string{"10.10.10.2"},
+		},
+		{
+			name: "out of range",
+			steps: []allocationStep{
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.50")},
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.20.5")},
+			},
+			released: []string{"10.10.10.1", "10.10.10.50", "10.10.20.5"},
+		},
+		{
+			name: "another IP Address of a held host",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.5")},
+			},
+			held:     []string{"10.10.10.1"},
+			released: []string{"10.10.10.5"},
+		},
+		{
+			name: "shared key",
+			steps: []allocationStep{
+				{req: withRequestedIP(withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "vip"), "10.10.10.5"), ipAddr: "10.10.10.5"},
+				{req: withRequestedIP(withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "vip"), "10.10.10.5"), ipAddr: "10.10.10.5"},
+				{req: withKey(testRequest("app", "baz.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.5"},
+				{req: withRequestedIP(withKey(testRequest("app", "qux.example.com", ipamspec.CREATE), "vip"), "10.10.10.6")},
+			},
+			held:     []string{"10.10.10.5"},
+			released: []string{"10.10.10.6"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctlr, mgr := newAllocationController(t, Spec{})
+			runSteps(t, ctlr, test.steps)
+			expectHeld(t, mgr, test.held, test.released)
+		})
+	}
+}
+
+func withReclaimPolicy(req ipamspec.IPAMRequest, policy string, period time.Duration) ipamspec.IPAMRequest {
+	req.ReclaimPolicy, req.RetainPeriod = policy, period
+	return req
+}
+
+func TestReclaimPolicies(t *testing.T) {
+	tests := []struct {
+		name string
+		// poolPolicy and poolPeriod are the reclaim policy of the pool
+		poolPolicy string
+		poolPeriod time.Duration
+		// deleted is the DELETE of the allocation of foo.example.com by default/web,
+		// which is allocated 10.10.10.1
+		deleted ipamspec.IPAMRequest
+		// wait after the deletion
+		wait  time.Duration
+		steps []allocationStep
+	}{
+		{
+			name:    "delete",
+			deleted: testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:       "retain by the pool",
+			poolPolicy: ipamspec.ReclaimRetain,
+			poolPeriod: time.Hour,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:    "retain by the resource",
+			deleted: withReclaimPolicy(testRequest("web", "foo.example.com", ipamspec.DELETE), ipamspec.ReclaimRetain, time.Hour),
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:       "delete by the resource overrides the pool",
+			poolPolicy: ipamspec.ReclaimRetainForever,
+			deleted:    withReclaimPolicy(testRequest("web", "foo.example.com", ipamspec.DELETE), ipamspec.ReclaimDelete, 0),
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:       "retain forever",
+			poolPolicy: ipamspec.ReclaimRetainForever,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:       "re-assigned to the host of another resource in the namespace",
+			poolPolicy: ipamspec.ReclaimRetainForever,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: testRequest("app", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name:       "not re-assigned to another namespace",
+			poolPolicy: ipamspec.ReclaimRetainForever,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: withNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), ipAddr: "10.10.10.2"},
+			},
+		},
+		{
+			name:       "not re-assigned on request of another IP Address",
+			poolPolicy: ipamspec.ReclaimRetainForever,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			steps: []allocationStep{
+				{req: withRequestedIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.5"), ipAddr: "10.10.10.5"},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+			},
+		},
+		{
+			name:       "retain period ended",
+			poolPolicy: ipamspec.ReclaimRetain,
+			poolPeriod: time.Second,
+			deleted:    testRequest("web", "foo.example.com", ipamspec.DELETE),
+			wait:       2100 * time.Millisecond,
+			steps: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ctlr, _ := newAllocationController(t, Spec{}, ipamspec.PoolSpec{
+				CIDR:          "10.10.10.0/24",
+				Ranges:        []string{"10.10.10.1-10.10.10.10"},
+				ReclaimPolicy: test.poolPolicy,
+				RetainPeriod:  test.poolPeriod,
+			})
+			runSteps(t, ctlr, []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: test.deleted},
+			})
+			time.Sleep(test.wait)
+			runSteps(t, ctlr, test.steps)
+		})
+	}
+}
+
+// updateOf makes the request an UPDATE of the allocation of the previous host and pool
+func updateOf(req ipamspec.IPAMRequest, prevHost, prevCIDR string) ipamspec.IPAMRequest {
+	req.Operation, req.PrevHostName, req.PrevCIDR = ipamspec.UPDATE, prevHost, prevCIDR
+	return req
+}
+
+func TestUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		steps    []allocationStep
+		held     []string
+		released []string
+	}{
+		{
+			name: "rename keeps the IP Address",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: updateOf(testRequest("web", "bar.example.com", ""), "foo.example.com", "10.10.10.0/24"), ipAddr: "10.10.10.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+				{req: testRequest("api", "baz.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+			},
+			held: []string{"10.10.10.1", "10.10.10.2"},
+		},
+		{
+			name: "rename to a host of another resource",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+				{req: updateOf(testRequest("web", "bar.example.com", ""), "foo.example.com", "10.10.10.0/24")},
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1", "10.10.10.2"},
+		},
+		{
+			name: "move to another pool keeps the previous IP Address until it is deleted",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: updateOf(withCIDR(testRequest("web", "foo.example.com", ""), otherCIDR), "foo.example.com", "10.10.10.0/24"), ipAddr: "10.10.20.1"},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+			},
+			held: []string{"10.10.10.1", "10.10.10.2", "10.10.20.1"},
+		},
+		{
+			name: "move to another pool releases the previous IP Address with its deletion",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: updateOf(withCIDR(testRequest("web", "foo.example.com", ""), otherCIDR), "foo.example.com", "10.10.10.0/24"), ipAddr: "10.10.20.1"},
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+			},
+			held:     []string{"10.10.20.1"},
+			released: []string{"10.10.10.1"},
+		},
+		{
+			name: "update without a previous allocation",
+			steps: []allocationStep{
+				{req: updateOf(testRequest("web", "bar.example.com", ""), "foo.example.com", "10.10.10.0/24"), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctlr, mgr := newAllocationController(t, Spec{})
+			runSteps(t, ctlr, test.steps)
+			expectHeld(t, mgr, test.held, test.released)
+		})
+	}
+}
+
+func TestMoveQuota(t *testing.T) {
+	// The previous allocation of a moved host does not count against the quota while it is replaced
+	ctlr, mgr := newAllocationController(t, Spec{
+		NamespacePolicies: []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: 1}},
+	})
+	runSteps(t, ctlr, []allocationStep{
+		{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+		{req: updateOf(withCIDR(testRequest("web", "foo.example.com", ""), otherCIDR), "foo.example.com", "10.10.10.0/24"), ipAddr: "10.10.20.1"},
+		// but until it is released
+		{req: testRequest("api", "bar.example.com", ipamspec.CREATE)},
+		{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+		{req: withCIDR(testRequest("web", "foo.example.com", ipamspec.CREATE), otherCIDR), ipAddr: "10.10.20.1"},
+	})
+	expectHeld(t, mgr, []string{"10.10.20.1"}, []string{"10.10.10.1"})
+}
@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"sync"
+	"time"
+
 	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
 	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
 	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
@@ -10,88 +13,252 @@ import (
 type Spec struct {
 	Orchestrator orchestration.Orchestrator
 	Manager      manager.Manager
-	StopCh       chan struct{}
+	// StopCh stops the routines of the Orchestrator, it is closed by Stop
+	StopCh chan struct{}
+	// NamespacePolicies of the configuration
+	NamespacePolicies []ipamspec.NamespacePolicy
+	// DefaultPolicy applies to the namespaces for what their own policies do not set
+	DefaultPolicy ipamspec.NamespacePolicy
+	// Workers process the requests of different resources in parallel, DefaultWorkers when zero
+	Workers int
+	// QueueSize bounds the requests waiting for the workers, DefaultQueueSize when zero.
+	// The Orchestrator blocks on sending requests while the queue is full.
+	QueueSize int
+	// HostConflictPolicy decides how a host in a pool that is claimed by a second resource is
+	// handled, RejectHostConflicts when empty
+	HostConflictPolicy string
+	// ReservationTimeout of new allocations that are not committed, DefaultReservationTimeout when zero
+	ReservationTimeout time.Duration
+	// PoolSources define pools besides the configuration. The CIDRs of the store that no pool
+	// defines are pruned once each of them is reconciled.
+	PoolSources []string
 }
 
+// coreLog logs the messages of the controller
+var coreLog = log.NewSubsystemLogger("CORE")
+
+// reqLog logs the messages about the request and its IP Address, when it has one
+func reqLog(req ipamspec.IPAMRequest, ipAddr string) *log.Entry {
+	entry := coreLog.With(req.LogFields()...)
+	if ipAddr != "" {
+		entry = entry.With(log.IPKey, ipAddr)
+	}
+	return entry
+}
+
+const (
+	// ConfigPoolSource is the source of the pools given to the Manager on start
+	ConfigPoolSource = "config"
+
+	DefaultWorkers   = 4
+	DefaultQueueSize = 64
+	// DefaultRetainPeriod of the Retain reclaim policy when it has no period
+	DefaultRetainPeriod = 24 * time.Hour
+)
+
 type Controller struct {
 	Spec
 	reqChan  chan ipamspec.IPAMRequest
 	respChan chan ipamspec.IPAMResponse
+
+	// Locks of the hosts and shared keys, selected by hashing their names
+	hostLocks [hostLockCount]sync.Mutex
+
+	// Queue of each worker, a resource is always served by the same worker
+	workQueues []chan workItem
+	workers    sync.WaitGroup
+	// routines of the pending requests and the reservations, which end before the workers
+	routines sync.WaitGroup
+	quitCh   chan struct{}
+	doneCh   chan struct{}
+	stopOnce sync.Once
+	// cancelCh drops the accepted requests that are not processed yet, once the shutdown times out
+	cancelCh   chan struct{}
+	cancelOnce sync.Once
+	// shutdownOnce runs Stop once, later calls return whether the first one drained the work
+	shutdownOnce sync.Once
+	drained      bool
+
+	// poolLock keeps the pools from being updated while a request is being processed
+	poolLock sync.RWMutex
+	// Pool definitions of each source, merged in the order the sources are known
+	poolSources map[string][]ipamspec.PoolSpec
+	sourceOrder []string
+	// pruned once the pools of all the sources are reconciled
+	pruned bool
+	// Pools being served by CIDR
+	pools map[string]ipamspec.PoolSpec
+
+	// mutex guards the namespace policies and the allocations counted against the quotas
+	mutex sync.Mutex
+	// Namespace policies of each source, in the order the sources are known
+	policySources     map[string][]ipamspec.NamespacePolicy
+	policySourceOrder []string
+
+	// Allocations counted against the quotas, by owner, namespace and resource
+	allocations    map[ipamspec.AllocationKey]bool
+	nsAllocations  map[string]int
+	rscAllocations map[string]int
+
+	// Requests waiting for an IP Address of an exhausted pool, by CIDR in order of arrival
+	pendingLock sync.Mutex
+	pending     map[string][]ipamspec.IPAMRequest
+	// Pools whose head request is handed to its worker and not served yet
+	retrying map[string]bool
+	retryCh  chan struct{}
 }
 
 func NewController(spec Spec) *Controller {
+	if spec.Workers <= 0 {
+		spec.Workers = DefaultWorkers
+	}
+	if spec.QueueSize <= 0 {
+		spec.QueueSize = DefaultQueueSize
+	}
+	if spec.ReservationTimeout <= 0 {
+		spec.ReservationTimeout = DefaultReservationTimeout
+	}
+	if spec.HostConflictPolicy == "" {
+		spec.HostConflictPolicy = RejectHostConflicts
+	}
+	if spec.StopCh == nil {
+		spec.StopCh = make(chan struct{})
+	}
 	ctlr := &Controller{
-		Spec:     spec,
-		reqChan:  make(chan ipamspec.IPAMRequest),
-		respChan: make(chan ipamspec.IPAMResponse),
+		Spec:        spec,
+		reqChan:     make(chan ipamspec.IPAMRequest, spec.QueueSize),
+		respChan:    make(chan ipamspec.IPAMResponse, spec.QueueSize),
+		quitCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
+		cancelCh:    make(chan struct{}),
+		poolSources: make(map[string][]ipamspec.PoolSpec),
+
+		policySources:  make(map[string][]ipamspec.NamespacePolicy),
+		allocations:    make(map[ipamspec.AllocationKey]bool),
+		nsAllocations:  make(map[string]int),
+		rscAllocations: make(map[string]int),
+
+		pending:  make(map[string][]ipamspec.IPAMRequest),
+		retrying: make(map[string]bool),
+		retryCh:  make(chan struct{}, 1),
 	}
+	for i := 0; i < spec.Workers; i++ {
+		ctlr.workQueues = append(ctlr.workQueues, make(chan workItem, spec.QueueSize))
+	}
+	ctlr.poolSources[ConfigPoolSource] = spec.Manager.GetPools()
+	ctlr.sourceOrder = []string{ConfigPoolSource}
+	ctlr.prunePools()
+	ctlr.updateServedPools()
+
+	ctlr.policySources[ConfigPolicySource] = spec.NamespacePolicies
+	ctlr.policySourceOrder = []string{ConfigPolicySource}
+	ctlr.updateQuotaMetrics()
 
 	return ctlr
 }
 
-func (ctlr *Controller) runController() {
-	for req := range ctlr.reqChan {
-		switch req.Operation {
-		case ipamspec.CREATE:
-
-			sendResponse := func(request ipamspec.IPAMRequest, ipAddr string) {
-				resp := ipamspec.IPAMResponse{
-					Request: request,
-					IPAddr:  ipAddr,
-					Status:  true,
-				}
-				ctlr.respChan <- resp
-			}
+// ReconcilePools replaces the pool definitions of the source, and updates the
+// pools of the Manager to the definitions of all sources.
+// Pools are updated in between requests, never while one is being processed.
+func (ctlr *Controller) ReconcilePools(source string, pools []ipamspec.PoolSpec) ipamspec.PoolReport {
+	ctlr.poolLock.Lock()
+	defer ctlr.poolLock.Unlock()
 
-			// Controller tries to allocate asked IP Address to be allocated for the host from the give cidr
-			// This happens during Starting of Controller to sync the DB with Initial Requests
-			if req.IPAddr != "" {
-				if ctlr.Manager.AllocateIPAddress(req.CIDR, req.IPAddr) {
-					log.Debugf("[CORE] Allocated IP: %v for CIDR: %v", req.IPAddr, req.CIDR)
-					ctlr.Manager.CreateARecord(req.HostName, req.IPAddr)
-					go sendResponse(req, req.IPAddr)
-				} else {
-					log.Debugf("[CORE] Unable to Allocate asked IPAddress: %v to Host: %v in CIDR: %v",
-						req.IPAddr, req.HostName, req.CIDR)
-					go func(request ipamspec.IPAMRequest) {
-						resp := ipamspec.IPAMResponse{
-							Request: request,
-							IPAddr:  "",
-							Status:  false,
-						}
-						ctlr.respChan <- resp
-					}(req)
-				}
-				break
-			}
+	if _, ok := ctlr.poolSources[source]; !ok {
+		ctlr.sourceOrder = append(ctlr.sourceOrder, source)
+	}
+	ctlr.poolSources[source] = pools
+	report := ctlr.Manager.ReconcilePools(ctlr.mergePools())
+	report.Orphaned = append(report.Orphaned, ctlr.prunePools().Orphaned...)
+	for _, orphan := range report.Orphaned {
+		coreLog.With(log.HostKey, orphan.HostName, log.CIDRKey, orphan.CIDR, log.IPKey, orphan.IPAddr).Warningf(
+			"Host: %v holds IP Address: %v which is no longer in Pool: %v", orphan.HostName, orphan.IPAddr, orphan.CIDR)
+	}
+	ctlr.updateServedPools()
+	// Pending requests may be served by the new definitions
+	ctlr.retryPending()
+	return report
+}
 
-			ipAddr := ctlr.Manager.GetIPAddress(req.HostName)
-			if ipAddr != "" {
-				go sendResponse(req, ipAddr)
-				break
-			}
+// prunePools prunes the pools of the Manager once the pools of all the sources are reconciled,
+// the caller holds the pool lock
+func (ctlr *Controller) prunePools() ipamspec.PoolReport {
+	if ctlr.pruned {
+		return ipamspec.PoolReport{}
+	}
+	for _, source := range ctlr.PoolSources {
+		if _, ok := ctlr.poolSources[source]; !ok {
+			return ipamspec.PoolReport{}
+		}
+	}
+	ctlr.pruned = true
+	return ctlr.Manager.PrunePools()
+}
 
-			ipAddr = ctlr.Manager.GetNextIPAddress(req.CIDR)
-			if ipAddr != "" {
-				log.Debugf("[CORE] Allocated IP: %v for CIDR: %v", ipAddr, req.CIDR)
-				ctlr.Manager.CreateARecord(req.HostName, ipAddr)
-				go sendResponse(req, ipAddr)
+// GetPoolStats returns the utilization of the pools
+func (ctlr *Controller) GetPoolStats() []ipamspec.PoolStats {
+	return ctlr.Manager.GetPoolStats()
+}
+
+// mergePools combines the pool definitions of all the sources,
+// a CIDR defined by more than one source is taken from the first one
+func (ctlr *Controller) mergePools() []ipamspec.PoolSpec {
+	var pools []ipamspec.PoolSpec
+	definedBy := make(map[string]string)
+	for _, source := range ctlr.sourceOrder {
+		for _, pool := range ctlr.poolSources[source] {
+			if other, ok := definedBy[pool.CIDR]; ok {
+				coreLog.Errorf("Pool %v of %v is already defined by %v, ignoring it", pool.CIDR, source, other)
+				continue
 			}
-		case ipamspec.DELETE:
-			ipAddr := ctlr.Manager.GetIPAddress(req.HostName)
-			if ipAddr != "" {
-				ctlr.Manager.ReleaseIPAddress(ipAddr)
-				ctlr.Manager.DeleteARecord(req.HostName, ipAddr)
+			definedBy[pool.CIDR] = source
+			pools = append(pools, pool)
+		}
+	}
+	return pools
+}
+
+func (ctlr *Controller) updateServedPools() {
+	ctlr.pools = make(map[string]ipamspec.PoolSpec)
+	for _, pool := range ctlr.Manager.GetPools() {
+		ctlr.pools[pool.CIDR] = pool
+	}
+}
+
+func (ctlr *Controller) processRequest(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	// Requests for a host or a shared key are processed one at a time
+	unlock := ctlr.lockRequest(req)
+	defer unlock()
+
+	switch req.Operation {
+	case ipamspec.CREATE:
+		// Controller tries to allocate asked IP Address to be allocated for the host from the give cidr
+		// This happens during Starting of Controller to sync the DB with Initial Requests
+		if req.IPAddr != "" {
+			return ctlr.restoreAllocation(req)
+		}
+		return ctlr.allocate(req)
+	case ipamspec.UPDATE:
+		return ctlr.update(req)
+	case ipamspec.COMMIT:
+		return ctlr.commit(req)
+	case ipamspec.DELETE:
+		key := req.AllocationKey()
+		ipAddr := ctlr.Manager.GetIPAddress(key)
+		if ipAddr != "" {
+			ctlr.Manager.DeleteARecord(key, ipAddr)
+			// A shared IP Address is reclaimed with its last allocation
+			if !ctlr.isReferenced(ipAddr) {
+				ctlr.reclaim(req, ipAddr)
+				ctlr.retryPending()
 			}
-			go func(request ipamspec.IPAMRequest) {
-				resp := ipamspec.IPAMResponse{
-					Request: request,
-					IPAddr:  "",
-					Status:  true,
-				}
-				ctlr.respChan <- resp
-			}(req)
 		}
+		ctlr.removePending(req)
+		ctlr.untrackAllocation(req)
+	}
+	return ipamspec.IPAMResponse{
+		Request: req,
+		Status:  true,
 	}
 }
 
@@ -100,13 +267,56 @@ func (ctlr *Controller) Start() {
 		ctlr.reqChan,
 		ctlr.respChan,
 	)
-	log.Info("[CORE] Controller started")
+	if poolOrcr, ok := ctlr.Orchestrator.(orchestration.PoolOrchestrator); ok {
+		poolOrcr.SetupPoolManager(ctlr)
+	}
+	if policyOrcr, ok := ctlr.Orchestrator.(orchestration.PolicyOrchestrator); ok {
+		policyOrcr.SetupPolicyManager(ctlr)
+	}
+	coreLog.Info("Controller started")
 
 	ctlr.Orchestrator.Start(ctlr.StopCh)
 
-	go ctlr.runController()
+	ctlr.startWorkers()
 }
 
-func (ctlr *Controller) Stop() {
-	ctlr.Orchestrator.Stop()
+// Stop stops the Orchestrator, and waits until the accepted requests are processed and their
+// responses are published, or the timeout passes. Requests that are not processed by then are
+// dropped. The store of the Manager is closed once no request is being processed anymore.
+// It returns whether all the work was drained in time. Stop may be called more than once, later
+// calls wait for the first one and return its result.
+func (ctlr *Controller) Stop(timeout time.Duration) bool {
+	ctlr.shutdownOnce.Do(func() { ctlr.drained = ctlr.shutdown(timeout) })
+	return ctlr.drained
+}
+
+// shutdown stops the Controller once for Stop
+func (ctlr *Controller) shutdown(timeout time.Duration) bool {
+	// Routines of the Orchestrator that wait for the stop channel, like the restore of the
+	// allocations, end before the requests are drained
+	close(ctlr.StopCh)
+
+	drainedCh := make(chan struct{})
+	go func() {
+		ctlr.Orchestrator.Stop()
+		ctlr.stopWorkers()
+		// No responses are sent after the workers are stopped
+		close(ctlr.respChan)
+		if drainOrcr, ok := ctlr.Orchestrator.(orchestration.DrainingOrchestrator); ok {
+			<-drainOrcr.Drained()
+		}
+		close(drainedCh)
+	}()
+
+	drained := true
+	select {
+	case <-drainedCh:
+		coreLog.Info("Controller stopped, all requests are drained")
+	case <-time.After(timeout):
+		drained = false
+		coreLog.Errorf("Controller stopped, requests were not drained within %v", timeout)
+		ctlr.cancelWorkers()
+	}
+	ctlr.Manager.Close()
+	return drained
 }
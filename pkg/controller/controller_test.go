@@ -0,0 +1,435 @@
+package controller
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration/fake"
+)
+
+// benchOrchestrator only holds the channels, requests are sent by the benchmark
+type benchOrchestrator struct {
+	reqChan  chan<- ipamspec.IPAMRequest
+	respChan <-chan ipamspec.IPAMResponse
+}
+
+func (orcr *benchOrchestrator) SetupCommunicationChannels(
+	reqChan chan<- ipamspec.IPAMRequest,
+	respChan <-chan ipamspec.IPAMResponse,
+) {
+	orcr.reqChan = reqChan
+	orcr.respChan = respChan
+}
+
+func (orcr *benchOrchestrator) Start(stopCh <-chan struct{}) {}
+
+func (orcr *benchOrchestrator) Stop() {}
+
+// slowManager adds the latency of an external provider to allocations and releases
+type slowManager struct {
+	manager.Manager
+	latency time.Duration
+}
+
+func (mgr *slowManager) GetNextIPAddress(key ipamspec.AllocationKey) string {
+	time.Sleep(mgr.latency)
+	return mgr.Manager.GetNextIPAddress(key)
+}
+
+func (mgr *slowManager) ReleaseIPAddress(key ipamspec.AllocationKey, ipAddr string) {
+	time.Sleep(mgr.latency)
+	mgr.Manager.ReleaseIPAddress(key, ipAddr)
+}
+
+// blockingManager holds allocations until they are released, and records whether the store
+// was closed while one of them was being made
+type blockingManager struct {
+	manager.Manager
+	started chan struct{}
+	release chan struct{}
+
+	mutex         sync.Mutex
+	allocations   int
+	closed        bool
+	usedAfterStop bool
+}
+
+func (mgr *blockingManager) GetNextIPAddress(key ipamspec.AllocationKey) string {
+	mgr.mutex.Lock()
+	mgr.allocations++
+	mgr.mutex.Unlock()
+	mgr.started <- struct{}{}
+	<-mgr.release
+
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	mgr.usedAfterStop = mgr.usedAfterStop || mgr.closed
+	return mgr.Manager.GetNextIPAddress(key)
+}
+
+func (mgr *blockingManager) Close() {
+	mgr.mutex.Lock()
+	mgr.closed = true
+	mgr.mutex.Unlock()
+	mgr.Manager.Close()
+}
+
+// newManager creates the Manager of the F5 IPAM Provider, which is closed by Controller.Stop
+func newManager(tb testing.TB, params manager.IPAMManagerParams) manager.Manager {
+	mgr, err := manager.NewManager(manager.Params{Provider: manager.F5IPAMProvider, IPAMManagerParams: params})
+	if err != nil {
+		tb.Fatalf("Unable to create Manager: %v", err)
+	}
+	return mgr
+}
+
+// newTestController creates a controller that is not started, the test processes the requests
+func newTestController(t *testing.T, mgr manager.Manager) *Controller {
+	t.Cleanup(mgr.Close)
+	return NewController(Spec{
+		Orchestrator: fake.NewOrchestrator(),
+		Manager:      mgr,
+		StopCh:       make(chan struct{}),
+	})
+}
+
+func testRequest(name, host, op string) ipamspec.IPAMRequest {
+	return ipamspec.IPAMRequest{
+		Namespace: "default",
+		Name:      name,
+		HostName:  host,
+		CIDR:      "10.10.10.0/24",
+		Operation: op,
+	}
+}
+
+// benchmarkController allocates and releases an IP Address for every iteration, spread over
+// 16 resources. A single worker processes the requests one at a time, as a single
+// controller routine did before the workers.
+func benchmarkController(b *testing.B, workers int) {
+	mgr := newManager(b, manager.IPAMManagerParams{
+		Range: "10.10.10.1/24-10.10.10.200/24",
+	})
+	orcr := &benchOrchestrator{}
+	ctlr := NewController(Spec{
+		Orchestrator: orcr,
+		Manager:      &slowManager{Manager: mgr, latency: 100 * time.Microsecond},
+		StopCh:       make(chan struct{}),
+		Workers:      workers,
+	})
+	ctlr.Start()
+	defer ctlr.Stop(time.Minute)
+
+	n := b.N
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < n; i++ {
+			for _, op := range []string{ipamspec.CREATE, ipamspec.DELETE} {
+				orcr.reqChan <- ipamspec.IPAMRequest{
+					Namespace: "default",
+					Name:      fmt.Sprintf("f5ipam-%d", i%16),
+					HostName:  fmt.Sprintf("host-%d.example.com", i),
+					CIDR:      "10.10.10.0/24",
+					Operation: op,
+				}
+			}
+		}
+	}()
+	for i := 0; i < 2*n; i++ {
+		if resp := <-orcr.respChan; !resp.Status {
+			b.Fatalf("Request failed: %v", resp.Reason)
+		}
+	}
+}
+
+func BenchmarkControllerSingleWorker(b *testing.B) { benchmarkController(b, 1) }
+
+func BenchmarkControllerDefaultWorkers(b *testing.B) { benchmarkController(b, DefaultWorkers) }
+
+func BenchmarkController16Workers(b *testing.B) { benchmarkController(b, 16) }
+
+func TestScriptedRequests(t *testing.T) {
+	mgr := newManager(t, manager.IPAMManagerParams{
+		Range:     "10.10.10.1/24-10.10.10.2/24",
+		StorePath: filepath.Join(t.TempDir(), "store.db"),
+	})
+	request := func(host, op string) ipamspec.IPAMRequest {
+		return ipamspec.IPAMRequest{
+			Namespace: "default",
+			Name:      "f5ipam",
+			HostName:  host,
+			CIDR:      "10.10.10.0/24",
+			Operation: op,
+		}
+	}
+	orcr := fake.NewOrchestrator(
+		request("foo.example.com", ipamspec.CREATE),
+		request("bar.example.com", ipamspec.CREATE),
+		request("baz.example.com", ipamspec.CREATE),
+	)
+	ctlr := NewController(Spec{
+		Orchestrator: orcr,
+		Manager:      mgr,
+		StopCh:       make(chan struct{}),
+		Workers:      1,
+	})
+	ctlr.Start()
+
+	resps, ok := orcr.WaitForResponses(3, 10*time.Second)
+	if !ok {
+		t.Fatalf("Responses are missing: %v", resps)
+	}
+	if !resps[0].Status || !resps[1].Status || resps[0].IPAddr == resps[1].IPAddr {
+		t.Fatalf("Hosts are not allocated IP Addresses of their own: %v", resps[:2])
+	}
+	if resps[2].Status || !resps[2].Pending || resps[2].Position != 1 {
+		t.Fatalf("Request of the exhausted pool is not pending: %v", resps[2])
+	}
+
+	// The pending host is served with the released IP Address
+	orcr.Send(request("foo.example.com", ipamspec.DELETE))
+	resps, ok = orcr.WaitFor(func(resps []ipamspec.IPAMResponse) bool {
+		last := resps[len(resps)-1]
+		return last.Request.HostName == "baz.example.com" && last.Status
+	}, 10*time.Second)
+	if !ok {
+		t.Fatalf("Pending request is not served: %v", resps)
+	}
+	if ipAddr := resps[len(resps)-1].IPAddr; ipAddr != resps[0].IPAddr {
+		t.Errorf("Pending host got IP Address: %v, expected the released %v", ipAddr, resps[0].IPAddr)
+	}
+
+	if !ctlr.Stop(10 * time.Second) {
+		t.Error("Requests are not drained on stop")
+	}
+}
+
+// TestPendingDeleted checks that a pending request deleted before its retry is not served
+func TestPendingDeleted(t *testing.T) {
+	mgr := newManager(t, manager.IPAMManagerParams{
+		Range:     "10.10.10.1/24-10.10.10.1/24",
+		StorePath: filepath.Join(t.TempDir(), "store.db"),
+	})
+	request := func(name, host, op string) ipamspec.IPAMRequest {
+		return ipamspec.IPAMRequest{
+			Namespace: "default",
+			Name:      name,
+			HostName:  host,
+			CIDR:      "10.10.10.0/24",
+			Operation: op,
+		}
+	}
+	orcr := fake.NewOrchestrator(
+		request("web", "foo.example.com", ipamspec.CREATE),
+		request("api", "bar.example.com", ipamspec.CREATE),
+	)
+	ctlr := NewController(Spec{
+		Orchestrator: orcr,
+		Manager:      mgr,
+		StopCh:       make(chan struct{}),
+		Workers:      1,
+	})
+	ctlr.Start()
+	defer ctlr.Stop(10 * time.Second)
+
+	resps, ok := orcr.WaitForResponses(2, 10*time.Second)
+	if !ok {
+		t.Fatalf("Responses are missing: %v", resps)
+	}
+	if !resps[1].Pending {
+		t.Fatalf("Request of the exhausted pool is not pending: %v", resps[1])
+	}
+
+	// The release retries bar.example.com, which is deleted before the worker gets to it
+	orcr.Send(request("web", "foo.example.com", ipamspec.DELETE))
+	orcr.Send(request("api", "bar.example.com", ipamspec.DELETE))
+	orcr.Send(request("app", "baz.example.com", ipamspec.CREATE))
+	resps, ok = orcr.WaitFor(func(resps []ipamspec.IPAMResponse) bool {
+		last := resps[len(resps)-1]
+		return last.Request.HostName == "baz.example.com" && !last.Pending
+	}, 10*time.Second)
+	if !ok {
+		t.Fatalf("Request of the released pool is not served: %v", resps)
+	}
+	if last := resps[len(resps)-1]; !last.Status || last.IPAddr != resps[0].IPAddr {
+		t.Errorf("New host is not allocated the released IP Address %v: %v", resps[0].IPAddr, last)
+	}
+	for _, resp := range resps {
+		if resp.Request.HostName == "bar.example.com" && resp.Status && resp.Request.Operation == ipamspec.CREATE {
+			t.Errorf("Deleted pending request is served: %v", resp)
+		}
+	}
+	if allocations := mgr.GetAllocations("bar.example.com"); len(allocations) != 0 {
+		t.Errorf("Deleted pending host is allocated: %v", allocations)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	mgr := &blockingManager{
+		Manager: newManager(t, manager.IPAMManagerParams{Range: "10.10.10.1/24-10.10.10.5/24"}),
+		started: make(chan struct{}, 3),
+		release: make(chan struct{}),
+	}
+	var requests []ipamspec.IPAMRequest
+	for _, host := range []string{"foo.example.com", "bar.example.com", "baz.example.com"} {
+		requests = append(requests, ipamspec.IPAMRequest{Namespace: "default", Name: "f5ipam",
+			HostName: host, CIDR: "10.10.10.0/24", Operation: ipamspec.CREATE})
+	}
+	orcr := fake.NewOrchestrator(requests...)
+	stopCh := make(chan struct{})
+	ctlr := NewController(Spec{Orchestrator: orcr, Manager: mgr, StopCh: stopCh, Workers: 1})
+	ctlr.Start()
+	<-mgr.started
+
+	stopped := make(chan bool)
+	go func() { stopped <- ctlr.Stop(50 * time.Millisecond) }()
+	select {
+	case <-stopCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop channel is not closed")
+	}
+	// The store stays open while the request is being processed, past the timeout
+	select {
+	case drained := <-stopped:
+		t.Fatalf("Stop returned while a request is being processed, drained: %v", drained)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(mgr.release)
+	if drained := <-stopped; drained {
+		t.Error("Stop reports the requests as drained after the timeout")
+	}
+
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+	if mgr.usedAfterStop || !mgr.closed {
+		t.Errorf("Store closed: %v, used after it was closed: %v", mgr.closed, mgr.usedAfterStop)
+	}
+	if mgr.allocations != 1 {
+		t.Errorf("Requests that were not processed by the timeout are processed: %v", mgr.allocations)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	mgr := newManager(t, manager.IPAMManagerParams{Range: "10.10.10.1/24-10.10.10.5/24"})
+	orcr := fake.NewOrchestrator(ipamspec.IPAMRequest{Namespace: "default", Name: "f5ipam",
+		HostName: "foo.example.com", CIDR: "10.10.10.0/24", Operation: ipamspec.CREATE})
+	ctlr := NewController(Spec{Orchestrator: orcr, Manager: mgr, StopCh: make(chan struct{})})
+	ctlr.Start()
+	if _, ok := orcr.WaitForResponses(1, 10*time.Second); !ok {
+		t.Fatal("Response is missing")
+	}
+
+	// A signal handler and a deferred Stop may both stop the Controller
+	if !ctlr.Stop(10 * time.Second) {
+		t.Error("Stop reports the requests as not drained")
+	}
+	if !ctlr.Stop(10 * time.Second) {
+		t.Error("Second Stop reports the requests as not drained")
+	}
+}
+
+func TestAdminRelease(t *testing.T) {
+	mgr := newManager(t, manager.IPAMManagerParams{
+		Range:     "10.10.10.1/24-10.10.10.1/24",
+		StorePath: filepath.Join(t.TempDir(), "store.db"),
+	})
+	request := func(host string) ipamspec.IPAMRequest {
+		return ipamspec.IPAMRequest{
+			Namespace: "default",
+			Name:      "f5ipam",
+			HostName:  host,
+			CIDR:      "10.10.10.0/24",
+			Operation: ipamspec.CREATE,
+		}
+	}
+	orcr := fake.NewOrchestrator(request("foo.example.com"), request("bar.example.com"))
+	ctlr := NewController(Spec{
+		Orchestrator: orcr,
+		Manager:      mgr,
+		StopCh:       make(chan struct{}),
+		// The namespace holds a single allocation, the released one makes room
+		DefaultPolicy: ipamspec.NamespacePolicy{MaxAllocations: 1},
+	})
+	ctlr.Start()
+	defer ctlr.Stop(10 * time.Second)
+
+	resps, ok := orcr.WaitForResponses(2, 10*time.Second)
+	if !ok || !resps[0].Status || resps[1].Status {
+		t.Fatalf("Unexpected responses: %v", resps)
+	}
+	backend, ok := ctlr.AdminBackend()
+	if !ok {
+		t.Fatal("Admin backend is not available")
+	}
+	released, err := backend.Release(resps[0].IPAddr)
+	if err != nil || len(released) != 1 || released[0].HostName != "foo.example.com" {
+		t.Fatalf("Release got: %v, %v", released, err)
+	}
+
+	orcr.Send(request("bar.example.com"))
+	resps, ok = orcr.WaitForResponses(3, 10*time.Second)
+	if !ok || !resps[2].Status || resps[2].IPAddr != resps[0].IPAddr {
+		t.Errorf("Released IP Address is not allocated again: %v", resps)
+	}
+}
+
+func TestAdminImport(t *testing.T) {
+	mgr := newManager(t, manager.IPAMManagerParams{
+		Range:     "10.10.10.1/24-10.10.10.2/24",
+		StorePath: filepath.Join(t.TempDir(), "store.db"),
+	})
+	request := func(host string) ipamspec.IPAMRequest {
+		return ipamspec.IPAMRequest{
+			Namespace: "default",
+			Name:      "f5ipam",
+			HostName:  host,
+			CIDR:      "10.10.10.0/24",
+			Operation: ipamspec.CREATE,
+		}
+	}
+	orcr := fake.NewOrchestrator(request("foo.example.com"))
+	ctlr := NewController(Spec{
+		Orchestrator:  orcr,
+		Manager:       mgr,
+		StopCh:        make(chan struct{}),
+		DefaultPolicy: ipamspec.NamespacePolicy{MaxAllocations: 1},
+	})
+	ctlr.Start()
+	defer ctlr.Stop(10 * time.Second)
+
+	resps, ok := orcr.WaitForResponses(1, 10*time.Second)
+	if !ok || !resps[0].Status {
+		t.Fatalf("Unexpected responses: %v", resps)
+	}
+	backend, ok := ctlr.AdminBackend()
+	if !ok {
+		t.Fatal("Admin backend is not available")
+	}
+	snapshot, err := backend.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacing the allocation with the one of another host moves the quota over to it
+	snapshot.Allocations[0].HostName = "bar.example.com"
+	report, err := backend.Import(snapshot, admin.ImportOptions{Mode: admin.ReplaceMode})
+	if err != nil || !report.Applied {
+		t.Fatalf("Import got: %+v, %v", report, err)
+	}
+	orcr.Send(request("bar.example.com"))
+	resps, ok = orcr.WaitForResponses(2, 10*time.Second)
+	if !ok || !resps[1].Status || resps[1].IPAddr != resps[0].IPAddr {
+		t.Errorf("Imported allocation is not in use: %v", resps)
+	}
+	orcr.Send(request("foo.example.com"))
+	resps, ok = orcr.WaitForResponses(3, 10*time.Second)
+	if !ok || resps[2].Status {
+		t.Errorf("Quota does not count the imported allocation: %v", resps)
+	}
+}
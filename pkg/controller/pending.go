@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/metrics"
+)
+
+// PendingRetryInterval is how often the pending requests are retried when no IP Address is
+// released, which serves them once cooldowns and retentions end
+const PendingRetryInterval = 30 * time.Second
+
+var pendingRequests = metrics.NewGaugeVec("f5_ipam_pending_requests",
+	"Number of requests waiting for an IP Address of the pool", "cidr")
+
+// pendAllocation queues the request of an exhausted pool. A request that is already queued
+// keeps its position.
+func (ctlr *Controller) pendAllocation(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	ctlr.untrackAllocation(req)
+
+	ctlr.pendingLock.Lock()
+	queue := ctlr.pending[req.CIDR]
+	position := 0
+	for i, queued := range queue {
+		if queued.AllocationKey() == req.AllocationKey() {
+			queue[i] = req
+			position = i + 1
+		}
+	}
+	if position == 0 {
+		queue = append(queue, req)
+		ctlr.pending[req.CIDR] = queue
+		position = len(queue)
+		pendingRequests.Set(float64(len(queue)), req.CIDR)
+		reqLog(req, "").Infof("No IP Address is available in Pool, Host is pending at position %v", position)
+	}
+	ctlr.pendingLock.Unlock()
+
+	return pendingResponse(req, position)
+}
+
+// waitsBehind checks whether the request has to wait behind the pending requests of its pool
+func (ctlr *Controller) waitsBehind(req ipamspec.IPAMRequest) bool {
+	ctlr.pendingLock.Lock()
+	defer ctlr.pendingLock.Unlock()
+
+	queue := ctlr.pending[req.CIDR]
+	return len(queue) != 0 && queue[0].AllocationKey() != req.AllocationKey()
+}
+
+// removePending drops the queued request of the allocation, the requests behind it move up
+func (ctlr *Controller) removePending(req ipamspec.IPAMRequest) {
+	ctlr.pendingLock.Lock()
+	queue := ctlr.pending[req.CIDR]
+	index := -1
+	for i, queued := range queue {
+		if queued.AllocationKey() == req.AllocationKey() {
+			index = i
+		}
+	}
+	if index == -1 {
+		ctlr.pendingLock.Unlock()
+		return
+	}
+	ctlr.setPending(req.CIDR, append(queue[:index:index], queue[index+1:]...))
+	moved := append([]ipamspec.IPAMRequest(nil), ctlr.pending[req.CIDR][index:]...)
+	ctlr.pendingLock.Unlock()
+
+	ctlr.publishPositions(moved, index)
+}
+
+// retryPending wakes up the routine that serves the pending requests
+func (ctlr *Controller) retryPending() {
+	select {
+	case ctlr.retryCh <- struct{}{}:
+	default:
+	}
+}
+
+// runPending hands the request at the head of each pending queue to the worker of its
+// resource, when IP Addresses are released, when pools change, and periodically
+func (ctlr *Controller) runPending() {
+	defer ctlr.routines.Done()
+	ticker := time.NewTicker(PendingRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctlr.quitCh:
+			return
+		case <-ctlr.retryCh:
+		case <-ticker.C:
+		}
+		for _, req := range ctlr.pendingHeads() {
+			ctlr.queueWork(workItem{req: req, retry: true})
+		}
+	}
+}
+
+// pendingHeads returns the head request of every pool, that is not with a worker already
+func (ctlr *Controller) pendingHeads() []ipamspec.IPAMRequest {
+	ctlr.pendingLock.Lock()
+	defer ctlr.pendingLock.Unlock()
+
+	var heads []ipamspec.IPAMRequest
+	for cidr, queue := range ctlr.pending {
+		if ctlr.retrying[cidr] {
+			continue
+		}
+		ctlr.retrying[cidr] = true
+		heads = append(heads, queue[0])
+	}
+	return heads
+}
+
+// servePending processes the pending request on the worker of its resource, which keeps it in
+// order with the other requests of the resource. The request is dropped when it was removed
+// or replaced while it waited for the worker. Once it is served, the next request of the pool
+// is handed to its worker.
+func (ctlr *Controller) servePending(req ipamspec.IPAMRequest) {
+	ctlr.pendingLock.Lock()
+	delete(ctlr.retrying, req.CIDR)
+	queue := ctlr.pending[req.CIDR]
+	if len(queue) == 0 || queue[0].AllocationKey() != req.AllocationKey() {
+		ctlr.pendingLock.Unlock()
+		reqLog(req, "").Debugf("Pending request is no longer queued, dropped its retry")
+		if len(queue) != 0 {
+			ctlr.retryPending()
+		}
+		return
+	}
+	// The queued request is the latest of the allocation
+	req = queue[0]
+	ctlr.pendingLock.Unlock()
+
+	ctlr.poolLock.RLock()
+	resp := ctlr.processRequest(req)
+	ctlr.poolLock.RUnlock()
+	if resp.Pending {
+		return
+	}
+
+	ctlr.pendingLock.Lock()
+	if queue := ctlr.pending[req.CIDR]; len(queue) != 0 && queue[0].AllocationKey() == req.AllocationKey() {
+		ctlr.setPending(req.CIDR, queue[1:])
+	}
+	moved := append([]ipamspec.IPAMRequest(nil), ctlr.pending[req.CIDR]...)
+	ctlr.pendingLock.Unlock()
+
+	ctlr.respond(resp)
+	ctlr.publishPositions(moved, 0)
+	if len(moved) != 0 {
+		ctlr.retryPending()
+	}
+}
+
+// setPending replaces the queue of the pool, the caller holds the pendingLock
+func (ctlr *Controller) setPending(cidr string, queue []ipamspec.IPAMRequest) {
+	if len(queue) == 0 {
+		delete(ctlr.pending, cidr)
+		pendingRequests.Delete(cidr)
+		return
+	}
+	ctlr.pending[cidr] = queue
+	pendingRequests.Set(float64(len(queue)), cidr)
+}
+
+// publishPositions sends the new positions of the queued requests, the first of them is at offset
+func (ctlr *Controller) publishPositions(queue []ipamspec.IPAMRequest, offset int) {
+	for i, req := range queue {
+		ctlr.respond(pendingResponse(req, offset+i+1))
+	}
+}
+
+func pendingResponse(req ipamspec.IPAMRequest, position int) ipamspec.IPAMResponse {
+	return ipamspec.IPAMResponse{
+		Request:  req,
+		Status:   false,
+		Pending:  true,
+		Position: position,
+		Reason:   fmt.Sprintf("Waiting for an IP Address of Pool %v at position %v", req.CIDR, position),
+	}
+}
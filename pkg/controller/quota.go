@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/metrics"
+)
+
+// ConfigPolicySource is the source of the namespace policies given on start
+const ConfigPolicySource = "config"
+
+// Reasons of rejected allocations, as reported in metrics
+const (
+	PoolNotAllowed     = "pool_not_allowed"
+	NamespaceQuota     = "namespace_quota"
+	ResourceQuota      = "resource_quota"
+	PoolNotFound       = "pool_not_found"
+	HostConflict       = "host_conflict"
+	KeyConflict        = "key_conflict"
+	IPOutOfRange       = "ip_out_of_range"
+	IPUnavailable      = "ip_unavailable"
+	resourceKeyPattern = "%v/%v"
+)
+
+var (
+	namespaceAllocations = metrics.NewGaugeVec("f5_ipam_namespace_allocations",
+		"Number of IP Addresses allocated to the resources of the namespace", "namespace")
+	namespaceQuota = metrics.NewGaugeVec("f5_ipam_namespace_allocation_quota",
+		"Maximum number of IP Addresses the resources of the namespace may allocate", "namespace")
+	resourceAllocations = metrics.NewGaugeVec("f5_ipam_resource_allocations",
+		"Number of IP Addresses allocated to the resource", "namespace", "name")
+	rejectedAllocations = metrics.NewCounterVec("f5_ipam_rejected_allocations_total",
+		"Number of allocation requests rejected by policy, quota or exhaustion", "namespace", "reason")
+)
+
+// ReconcileNamespacePolicies replaces the namespace policies of the source.
+// A namespace policy of an earlier source takes precedence, field by field.
+func (ctlr *Controller) ReconcileNamespacePolicies(source string, policies []ipamspec.NamespacePolicy) {
+	ctlr.mutex.Lock()
+	defer ctlr.mutex.Unlock()
+
+	if _, ok := ctlr.policySources[source]; !ok {
+		ctlr.policySourceOrder = append(ctlr.policySourceOrder, source)
+	}
+	ctlr.policySources[source] = policies
+	coreLog.Debugf("Updated %v namespace policies of %v", len(policies), source)
+	ctlr.updateQuotaMetrics()
+}
+
+// namespacePolicy returns the effective policy of the namespace
+func (ctlr *Controller) namespacePolicy(namespace string) ipamspec.NamespacePolicy {
+	policy := ipamspec.NamespacePolicy{Namespace: namespace}
+	for _, source := range ctlr.policySourceOrder {
+		for _, nsPolicy := range ctlr.policySources[source] {
+			if nsPolicy.Namespace != namespace {
+				continue
+			}
+			if len(policy.AllowedPools) == 0 {
+				policy.AllowedPools = nsPolicy.AllowedPools
+			}
+			if policy.MaxAllocations == 0 {
+				policy.MaxAllocations = nsPolicy.MaxAllocations
+			}
+			if policy.MaxAllocationsPerResource == 0 {
+				policy.MaxAllocationsPerResource = nsPolicy.MaxAllocationsPerResource
+			}
+		}
+	}
+	if len(policy.AllowedPools) == 0 {
+		policy.AllowedPools = ctlr.DefaultPolicy.AllowedPools
+	}
+	if policy.MaxAllocations == 0 {
+		policy.MaxAllocations = ctlr.DefaultPolicy.MaxAllocations
+	}
+	if policy.MaxAllocationsPerResource == 0 {
+		policy.MaxAllocationsPerResource = ctlr.DefaultPolicy.MaxAllocationsPerResource
+	}
+	return policy
+}
+
+// checkAllocation verifies that the resource of the request may allocate another IP Address
+// from the pool, it returns the reason of the rejection and a description of it
+func (ctlr *Controller) checkAllocation(req ipamspec.IPAMRequest) (string, string) {
+	pool, ok := ctlr.pools[req.CIDR]
+	if !ok {
+		return PoolNotFound, fmt.Sprintf("No Pool is defined for CIDR %v", req.CIDR)
+	}
+	if len(pool.AllowedNamespaces) != 0 && !contains(pool.AllowedNamespaces, req.Namespace) {
+		return PoolNotAllowed, fmt.Sprintf("Namespace %v is not allowed to use Pool %v", req.Namespace, req.CIDR)
+	}
+
+	policy := ctlr.namespacePolicy(req.Namespace)
+	if len(policy.AllowedPools) != 0 && !contains(policy.AllowedPools, req.CIDR) {
+		return PoolNotAllowed, fmt.Sprintf("Namespace %v is not allowed to use Pool %v", req.Namespace, req.CIDR)
+	}
+	if policy.MaxAllocations != 0 && ctlr.nsAllocations[req.Namespace] >= policy.MaxAllocations {
+		return NamespaceQuota, fmt.Sprintf("Namespace %v reached its quota of %v allocations",
+			req.Namespace, policy.MaxAllocations)
+	}
+	rscKey := fmt.Sprintf(resourceKeyPattern, req.Namespace, req.Name)
+	if policy.MaxAllocationsPerResource != 0 && ctlr.rscAllocations[rscKey] >= policy.MaxAllocationsPerResource {
+		return ResourceQuota, fmt.Sprintf("%v reached its quota of %v allocations",
+			rscKey, policy.MaxAllocationsPerResource)
+	}
+	return "", ""
+}
+
+func (ctlr *Controller) rejectAllocation(req ipamspec.IPAMRequest, reason, message string) ipamspec.IPAMResponse {
+	reqLog(req, "").Warningf("Rejected allocation for Host. %v", message)
+	rejectedAllocations.Inc(req.Namespace, reason)
+	return ipamspec.IPAMResponse{
+		Request: req,
+		IPAddr:  "",
+		Status:  false,
+		Reason:  message,
+	}
+}
+
+// reserveAllocation counts a new allocation of the request against the quotas when it is allowed.
+// It reports whether the owner already holds the allocation, or why a new one is rejected.
+func (ctlr *Controller) reserveAllocation(req ipamspec.IPAMRequest) (bool, string, string) {
+	ctlr.mutex.Lock()
+	defer ctlr.mutex.Unlock()
+
+	if ctlr.allocations[req.AllocationKey()] {
+		return true, "", ""
+	}
+	reason, message := ctlr.checkAllocation(req)
+	if reason == "" {
+		ctlr.addAllocation(req.AllocationKey())
+	}
+	return false, reason, message
+}
+
+// trackAllocation counts the allocation against the quotas of its namespace and resource
+func (ctlr *Controller) trackAllocation(req ipamspec.IPAMRequest) {
+	ctlr.mutex.Lock()
+	defer ctlr.mutex.Unlock()
+
+	if !ctlr.allocations[req.AllocationKey()] {
+		ctlr.addAllocation(req.AllocationKey())
+	}
+}
+
+func (ctlr *Controller) untrackAllocation(req ipamspec.IPAMRequest) {
+	ctlr.mutex.Lock()
+	defer ctlr.mutex.Unlock()
+
+	key := req.AllocationKey()
+	if !ctlr.allocations[key] {
+		return
+	}
+	delete(ctlr.allocations, key)
+	ctlr.nsAllocations[key.Namespace]--
+	rscKey := fmt.Sprintf(resourceKeyPattern, key.Namespace, key.Name)
+	ctlr.rscAllocations[rscKey]--
+	if ctlr.rscAllocations[rscKey] == 0 {
+		delete(ctlr.rscAllocations, rscKey)
+		resourceAllocations.Delete(key.Namespace, key.Name)
+	} else {
+		resourceAllocations.Set(float64(ctlr.rscAllocations[rscKey]), key.Namespace, key.Name)
+	}
+	ctlr.updateNamespaceMetrics(key.Namespace)
+}
+
+// trackedAllocations returns the allocations counted against the quotas
+func (ctlr *Controller) trackedAllocations() []ipamspec.AllocationKey {
+	ctlr.mutex.Lock()
+	defer ctlr.mutex.Unlock()
+
+	keys := make([]ipamspec.AllocationKey, 0, len(ctlr.allocations))
+	for key := range ctlr.allocations {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (ctlr *Controller) addAllocation(key ipamspec.AllocationKey) {
+	ctlr.allocations[key] = true
+	ctlr.nsAllocations[key.Namespace]++
+	rscKey := fmt.Sprintf(resourceKeyPattern, key.Namespace, key.Name)
+	ctlr.rscAllocations[rscKey]++
+	resourceAllocations.Set(float64(ctlr.rscAllocations[rscKey]), key.Namespace, key.Name)
+	ctlr.updateNamespaceMetrics(key.Namespace)
+}
+
+func (ctlr *Controller) updateNamespaceMetrics(namespace string) {
+	namespaceAllocations.Set(float64(ctlr.nsAllocations[namespace]), namespace)
+	if quota := ctlr.namespacePolicy(namespace).MaxAllocations; quota != 0 {
+		namespaceQuota.Set(float64(quota), namespace)
+	} else {
+		namespaceQuota.Delete(namespace)
+	}
+}
+
+// updateQuotaMetrics refreshes the metrics of the namespaces with allocations or policies
+func (ctlr *Controller) updateQuotaMetrics() {
+	namespaces := make(map[string]bool)
+	for namespace := range ctlr.nsAllocations {
+		namespaces[namespace] = true
+	}
+	for _, policies := range ctlr.policySources {
+		for _, policy := range policies {
+			namespaces[policy.Namespace] = true
+		}
+	}
+	for namespace := range namespaces {
+		ctlr.updateNamespaceMetrics(namespace)
+	}
+}
+
+func contains(list []string, item string) bool {
+	for _, elem := range list {
+		if elem == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration/fake"
+)
+
+func TestNamespacePolicies(t *testing.T) {
+	inNamespace := func(req ipamspec.IPAMRequest, namespace string) ipamspec.IPAMRequest {
+		req.Namespace = namespace
+		return req
+	}
+	inPool := func(req ipamspec.IPAMRequest, cidr string) ipamspec.IPAMRequest {
+		req.CIDR = cidr
+		return req
+	}
+	type step struct {
+		req     ipamspec.IPAMRequest
+		allowed bool
+	}
+	tests := []struct {
+		name          string
+		policies      []ipamspec.NamespacePolicy
+		defaultPolicy ipamspec.NamespacePolicy
+		// sourcePolicies are reconciled after the policies of the configuration
+		sourcePolicies []ipamspec.NamespacePolicy
+		// allowedNamespaces of the pool of 10.10.10.0/24
+		allowedNamespaces []string
+		steps             []step
+	}{
+		{
+			name:     "namespace quota",
+			policies: []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: 2}},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("app", "baz.example.com", ipamspec.CREATE)},
+				// An allocation that is held is not counted again
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: inNamespace(testRequest("app", "qux.example.com", ipamspec.CREATE), "prod"), allowed: true},
+				// A released allocation frees the quota
+				{req: testRequest("web", "foo.example.com", ipamspec.DELETE)},
+				{req: testRequest("app", "baz.example.com", ipamspec.CREATE), allowed: true},
+			},
+		},
+		{
+			name:     "resource quota",
+			policies: []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocationsPerResource: 1}},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("web", "bar.example.com", ipamspec.CREATE)},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), allowed: true},
+			},
+		},
+		{
+			name:          "default quota",
+			defaultPolicy: ipamspec.NamespacePolicy{MaxAllocations: 1},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE)},
+				{req: inNamespace(testRequest("api", "bar.example.com", ipamspec.CREATE), "prod"), allowed: true},
+			},
+		},
+		{
+			name:          "namespace quota overrides the default",
+			policies:      []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: 2}},
+			defaultPolicy: ipamspec.NamespacePolicy{MaxAllocations: 1},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("app", "baz.example.com", ipamspec.CREATE)},
+			},
+		},
+		{
+			name:           "configuration takes precedence over later sources",
+			policies:       []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: 1}},
+			sourcePolicies: []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: 3, MaxAllocationsPerResource: 1}},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE)},
+			},
+		},
+		{
+			name:           "later sources set what the configuration does not",
+			sourcePolicies: []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocationsPerResource: 1}},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), allowed: true},
+				{req: testRequest("web", "bar.example.com", ipamspec.CREATE)},
+			},
+		},
+		{
+			name:     "pool not allowed for the namespace",
+			policies: []ipamspec.NamespacePolicy{{Namespace: "default", AllowedPools: []string{"10.10.20.0/24"}}},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE)},
+				{req: inNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), allowed: true},
+			},
+		},
+		{
+			name:              "namespace not allowed by the pool",
+			allowedNamespaces: []string{"prod"},
+			steps: []step{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE)},
+				{req: inNamespace(testRequest("web", "foo.example.com", ipamspec.CREATE), "prod"), allowed: true},
+			},
+		},
+		{
+			name: "pool not found",
+			steps: []step{
+				{req: inPool(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.30.0/24")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mgr := newManager(t, manager.IPAMManagerParams{
+				Pools: []ipamspec.PoolSpec{{
+					CIDR:              "10.10.10.0/24",
+					Ranges:            []string{"10.10.10.1-10.10.10.10"},
+					AllowedNamespaces: test.allowedNamespaces,
+				}},
+			})
+			t.Cleanup(mgr.Close)
+			ctlr := NewController(Spec{
+				Orchestrator:      fake.NewOrchestrator(),
+				Manager:           mgr,
+				StopCh:            make(chan struct{}),
+				NamespacePolicies: test.policies,
+				DefaultPolicy:     test.defaultPolicy,
+			})
+			if test.sourcePolicies != nil {
+				ctlr.ReconcileNamespacePolicies("f5ipamnamespace", test.sourcePolicies)
+			}
+
+			for i, step := range test.steps {
+				resp := ctlr.processRequest(step.req)
+				if step.req.Operation == ipamspec.DELETE {
+					continue
+				}
+				if resp.Status != step.allowed {
+					t.Errorf("Step %v: %v/%v of %v got: %v, expected allowed: %v",
+						i+1, step.req.Namespace, step.req.Name, step.req.HostName, resp, step.allowed)
+					continue
+				}
+				if !resp.Status && (resp.Pending || resp.Reason == "") {
+					t.Errorf("Step %v: rejection got: %v", i+1, resp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+// DefaultReservationTimeout is how long a new allocation waits for the Orchestrator to publish it
+const DefaultReservationTimeout = 2 * time.Minute
+
+// reserveRecord creates the A record of a new allocation, which is rolled back unless the
+// Orchestrator commits it once it is published
+func (ctlr *Controller) reserveRecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	return ctlr.Manager.ReserveARecord(key, ipAddr, ctlr.ReservationTimeout)
+}
+
+// recordFailed answers the request whose A record could not be created. The IP Address is
+// released unless another allocation refers to it.
+func (ctlr *Controller) recordFailed(req ipamspec.IPAMRequest, ipAddr string) ipamspec.IPAMResponse {
+	reqLog(req, ipAddr).Error("Unable to create 'A' Record of IP")
+	ctlr.untrackAllocation(req)
+	if !ctlr.isReferenced(ipAddr) {
+		ctlr.Manager.ReleaseIPAddress(req.AllocationKey(), ipAddr)
+		ctlr.retryPending()
+	}
+	return ipamspec.IPAMResponse{
+		Request: req,
+		Status:  false,
+		Reason:  fmt.Sprintf("Unable to create the A record of IP Address %v", ipAddr),
+	}
+}
+
+// commit makes the reserved allocation of the request permanent
+func (ctlr *Controller) commit(req ipamspec.IPAMRequest) ipamspec.IPAMResponse {
+	if !ctlr.Manager.CommitARecord(req.AllocationKey(), req.IPAddr) {
+		reqLog(req, req.IPAddr).Warning("Unable to commit IP, the reservation expired")
+		return ipamspec.IPAMResponse{
+			Request: req,
+			Status:  false,
+			Reason:  "The reservation expired before it was committed",
+		}
+	}
+	return allocated(req, req.IPAddr)
+}
+
+// commitPublished makes the A record of an allocation that is already published permanent.
+// Its reservation may have expired while the controller was down, the record is created again then.
+func (ctlr *Controller) commitPublished(key ipamspec.AllocationKey, ipAddr string) bool {
+	if ctlr.Manager.CommitARecord(key, ipAddr) {
+		return true
+	}
+	ctlr.Manager.DeleteARecord(key, ipAddr)
+	return ctlr.Manager.CreateARecord(key, ipAddr)
+}
+
+// runReservations rolls back the reservations that were not committed in time
+func (ctlr *Controller) runReservations() {
+	defer ctlr.routines.Done()
+	ticker := time.NewTicker(ctlr.ReservationTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctlr.quitCh:
+			return
+		case <-ticker.C:
+		}
+		for _, alloc := range ctlr.Manager.GetExpiredReservations() {
+			ctlr.rollback(alloc)
+		}
+	}
+}
+
+// rollback deletes the allocation of an expired reservation, and releases its IP Address
+// when no other allocation refers to it
+func (ctlr *Controller) rollback(alloc ipamspec.Allocation) {
+	req := ipamspec.IPAMRequest{
+		Namespace: alloc.Key.Namespace,
+		Name:      alloc.Key.Name,
+		HostName:  alloc.Key.HostName,
+		CIDR:      alloc.Key.CIDR,
+		Key:       alloc.Key.Key,
+	}
+	ctlr.poolLock.RLock()
+	defer ctlr.poolLock.RUnlock()
+	unlock := ctlr.lockRequest(req)
+	defer unlock()
+
+	// The reservation may have been committed meanwhile
+	if !ctlr.Manager.RollbackARecord(alloc.Key, alloc.IPAddr) {
+		return
+	}
+	coreLog.With(alloc.Key.LogFields()...).With(log.IPKey, alloc.IPAddr).Warning(
+		"Rolled back IP, it was not committed in time")
+	if !ctlr.isReferenced(alloc.IPAddr) {
+		ctlr.Manager.ReleaseIPAddress(alloc.Key, alloc.IPAddr)
+		ctlr.retryPending()
+	}
+	ctlr.untrackAllocation(req)
+}
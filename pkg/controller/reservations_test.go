@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+)
+
+// recordFailingManager fails to create A records while failRecords is set
+type recordFailingManager struct {
+	manager.Manager
+	failRecords bool
+}
+
+func (mgr *recordFailingManager) CreateARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	if mgr.failRecords {
+		return false
+	}
+	return mgr.Manager.CreateARecord(key, ipAddr)
+}
+
+func (mgr *recordFailingManager) ReserveARecord(key ipamspec.AllocationKey, ipAddr string, timeout time.Duration) bool {
+	if mgr.failRecords {
+		return false
+	}
+	return mgr.Manager.ReserveARecord(key, ipAddr, timeout)
+}
+
+func TestRecordFailure(t *testing.T) {
+	withKey := func(req ipamspec.IPAMRequest, key string) ipamspec.IPAMRequest {
+		req.Key = key
+		return req
+	}
+	withIP := func(req ipamspec.IPAMRequest, requested, restored string) ipamspec.IPAMRequest {
+		req.RequestedIP, req.IPAddr = requested, restored
+		return req
+	}
+	tests := []struct {
+		name  string
+		setup []ipamspec.IPAMRequest
+		req   ipamspec.IPAMRequest
+		// released is whether the IP Address is free again, it is kept while another allocation refers to it
+		released bool
+	}{
+		{
+			name:     "new allocation",
+			req:      testRequest("web", "foo.example.com", ipamspec.CREATE),
+			released: true,
+		},
+		{
+			name:     "requested IP",
+			req:      withIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "10.10.10.1", ""),
+			released: true,
+		},
+		{
+			name:     "restored allocation",
+			req:      withIP(testRequest("web", "foo.example.com", ipamspec.CREATE), "", "10.10.10.1"),
+			released: true,
+		},
+		{
+			name:  "shared key",
+			setup: []ipamspec.IPAMRequest{withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "shared")},
+			req:   withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "shared"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mgr := &recordFailingManager{Manager: newManager(t, manager.IPAMManagerParams{
+				Range:     "10.10.10.1/24-10.10.10.1/24",
+				StorePath: filepath.Join(t.TempDir(), "store.db"),
+			})}
+			ctlr := newTestController(t, mgr)
+			for _, req := range test.setup {
+				if resp := ctlr.processRequest(req); !resp.Status {
+					t.Fatalf("Setup request failed: %v", resp)
+				}
+			}
+
+			mgr.failRecords = true
+			resp := ctlr.processRequest(test.req)
+			mgr.failRecords = false
+			if resp.Status || resp.Pending || resp.Reason == "" {
+				t.Fatalf("Request without an A record succeeded: %v", resp)
+			}
+			if allocations := mgr.GetAllocations(test.req.HostName); len(allocations) != 0 {
+				t.Errorf("Host is allocated without an A record: %v", allocations)
+			}
+			if held := len(mgr.GetAllocationsOfIP("10.10.10.1")) != 0; held == test.released {
+				t.Errorf("IP Address is held by: %v", mgr.GetAllocationsOfIP("10.10.10.1"))
+			}
+			if !test.released {
+				return
+			}
+			if resp := ctlr.processRequest(testRequest("app", "baz.example.com", ipamspec.CREATE)); resp.IPAddr != "10.10.10.1" {
+				t.Errorf("Released IP Address is not allocated again: %v", resp)
+			}
+		})
+	}
+}
+
+// commitOf makes the request the COMMIT of the IP Address published for it
+func commitOf(req ipamspec.IPAMRequest, ipAddr string) ipamspec.IPAMRequest {
+	req.Operation, req.IPAddr = ipamspec.COMMIT, ipAddr
+	return req
+}
+
+func TestReservationRollback(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota int
+		// steps before the reservations expire
+		steps []allocationStep
+		// expired are the steps after the reservations expire, before they are rolled back
+		expired []allocationStep
+		// rolledBack are the hosts that are no longer allocated
+		rolledBack []string
+		held       []string
+		released   []string
+		// after are the steps after the rollback
+		after []allocationStep
+	}{
+		{
+			name: "uncommitted allocation",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			rolledBack: []string{"foo.example.com"},
+			released:   []string{"10.10.10.1"},
+			after: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+		{
+			name: "committed allocation",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: commitOf(testRequest("web", "foo.example.com", ""), "10.10.10.1"), ipAddr: "10.10.10.1"},
+			},
+			held: []string{"10.10.10.1"},
+			after: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.2"},
+			},
+		},
+		{
+			name: "commit after the reservation expired",
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+			expired: []allocationStep{
+				{req: commitOf(testRequest("web", "foo.example.com", ""), "10.10.10.1")},
+			},
+			rolledBack: []string{"foo.example.com"},
+			released:   []string{"10.10.10.1"},
+		},
+		{
+			name: "shared key is kept for its committed allocation",
+			steps: []allocationStep{
+				{req: withKey(testRequest("web", "foo.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+				{req: commitOf(withKey(testRequest("web", "foo.example.com", ""), "vip"), "10.10.10.1"), ipAddr: "10.10.10.1"},
+				{req: withKey(testRequest("api", "bar.example.com", ipamspec.CREATE), "vip"), ipAddr: "10.10.10.1"},
+			},
+			rolledBack: []string{"bar.example.com"},
+			held:       []string{"10.10.10.1"},
+		},
+		{
+			name:  "rolled back allocation frees the quota",
+			quota: 1,
+			steps: []allocationStep{
+				{req: testRequest("web", "foo.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE)},
+			},
+			rolledBack: []string{"foo.example.com"},
+			after: []allocationStep{
+				{req: testRequest("api", "bar.example.com", ipamspec.CREATE), ipAddr: "10.10.10.1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			spec := Spec{ReservationTimeout: time.Second}
+			if test.quota != 0 {
+				spec.NamespacePolicies = []ipamspec.NamespacePolicy{{Namespace: "default", MaxAllocations: test.quota}}
+			}
+			ctlr, mgr := newAllocationController(t, spec)
+			runSteps(t, ctlr, test.steps)
+			time.Sleep(2100 * time.Millisecond)
+			runSteps(t, ctlr, test.expired)
+
+			for _, alloc := range mgr.GetExpiredReservations() {
+				ctlr.rollback(alloc)
+			}
+			for _, host := range test.rolledBack {
+				if allocations := mgr.GetAllocations(host); len(allocations) != 0 {
+					t.Errorf("Host %v is not rolled back: %v", host, allocations)
+				}
+			}
+			expectHeld(t, mgr, test.held, test.released)
+			runSteps(t, ctlr, test.after)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"hash/fnv"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+)
+
+// workItem is a request queued to a worker
+type workItem struct {
+	req ipamspec.IPAMRequest
+	// retry marks a pending request handed back by the pending routine, it is dropped when
+	// it no longer heads the queue of its pool
+	retry bool
+}
+
+// startWorkers starts the workers and the routine that dispatches the requests to them
+func (ctlr *Controller) startWorkers() {
+	for _, queue := range ctlr.workQueues {
+		ctlr.workers.Add(1)
+		go ctlr.runWorker(queue)
+	}
+	ctlr.routines.Add(2)
+	go ctlr.runPending()
+	go ctlr.runReservations()
+	go ctlr.runController()
+	coreLog.Debugf("Started %v workers", len(ctlr.workQueues))
+}
+
+// stopWorkers stops accepting requests, and waits for the workers to process the
+// requests that were already accepted
+func (ctlr *Controller) stopWorkers() {
+	ctlr.stopOnce.Do(func() { close(ctlr.quitCh) })
+	<-ctlr.doneCh
+	coreLog.Debugf("Workers stopped")
+}
+
+// cancelWorkers drops the requests that are not processed yet, and waits for the workers and
+// the routines of the pending requests and the reservations to end
+func (ctlr *Controller) cancelWorkers() {
+	ctlr.cancelOnce.Do(func() { close(ctlr.cancelCh) })
+	ctlr.stopOnce.Do(func() { close(ctlr.quitCh) })
+	<-ctlr.doneCh
+	coreLog.Debugf("Workers cancelled")
+}
+
+// cancelled checks whether the requests that are not processed yet are dropped
+func (ctlr *Controller) cancelled() bool {
+	select {
+	case <-ctlr.cancelCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// respond sends the response to the Orchestrator, it is dropped once the workers are cancelled
+func (ctlr *Controller) respond(resp ipamspec.IPAMResponse) {
+	select {
+	case ctlr.respChan <- resp:
+	case <-ctlr.cancelCh:
+	}
+}
+
+// runController dispatches the requests to the workers until the controller is stopped
+func (ctlr *Controller) runController() {
+	defer func() {
+		// The pending routine hands requests to the workers, so it ends before their queues close
+		ctlr.routines.Wait()
+		for _, queue := range ctlr.workQueues {
+			close(queue)
+		}
+		ctlr.workers.Wait()
+		close(ctlr.doneCh)
+	}()
+
+	for {
+		select {
+		case <-ctlr.quitCh:
+			// Requests that were sent before stopping are still processed, unless cancelled
+			for !ctlr.cancelled() {
+				select {
+				case req := <-ctlr.reqChan:
+					ctlr.dispatch(req)
+				default:
+					return
+				}
+			}
+			return
+		case req := <-ctlr.reqChan:
+			ctlr.dispatch(req)
+		}
+	}
+}
+
+// dispatch queues the request to the worker of its resource, blocking while the queue is full
+func (ctlr *Controller) dispatch(req ipamspec.IPAMRequest) {
+	ctlr.queueWork(workItem{req: req})
+}
+
+func (ctlr *Controller) queueWork(item workItem) {
+	select {
+	case ctlr.workQueues[ctlr.workerOf(item.req)] <- item:
+	case <-ctlr.cancelCh:
+	}
+}
+
+// workerOf returns the worker of the resource of the request, which keeps the
+// requests of a resource in order
+func (ctlr *Controller) workerOf(req ipamspec.IPAMRequest) int {
+	key := req.Namespace + "/" + req.Name
+	if req.Namespace == "" && req.Name == "" {
+		key = req.HostName
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(ctlr.workQueues)))
+}
+
+func (ctlr *Controller) runWorker(queue <-chan workItem) {
+	defer ctlr.workers.Done()
+	for item := range queue {
+		if ctlr.cancelled() {
+			continue
+		}
+		if item.retry {
+			ctlr.servePending(item.req)
+			continue
+		}
+		ctlr.poolLock.RLock()
+		resp := ctlr.processRequest(item.req)
+		ctlr.poolLock.RUnlock()
+		ctlr.respond(resp)
+	}
+}
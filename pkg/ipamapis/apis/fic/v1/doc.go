@@ -1,5 +1,5 @@
 // +k8s:deepcopy-gen=package
-// +groupName=k8s.nginx.org
+// +groupName=fic.f5.com
 
 // Package v1 is the v1 version of the API.
 package v1
@@ -38,6 +38,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		SchemeGroupVersion,
 		&F5IPAM{},
 		&F5IPAMList{},
+		&F5IPAMPool{},
+		&F5IPAMPoolList{},
 	)
 
 	scheme.AddKnownTypes(
@@ -19,11 +19,21 @@ type F5IPAM struct {
 
 type F5IPAMSpec struct {
 	HostSpecs []*HostSpec `json:"hostSpecs,omitempty"`
+	// ReclaimPolicy of the IP Addresses of deleted HostSpecs is one of Delete, Retain
+	// or RetainForever, the one of the pool when empty
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+	// RetainPeriod of the Retain reclaim policy is a duration like 24h
+	RetainPeriod string `json:"retainPeriod,omitempty"`
 }
 
 type HostSpec struct {
 	Host string `json:"host,omitempty"`
 	Cidr string `json:"cidr,omitempty"`
+	// Key shares one IP Address among the HostSpecs with the same Key and Cidr in a namespace,
+	// which is released when the last of them is deleted
+	Key string `json:"key,omitempty"`
+	// IP is reserved exactly from the pool of the Cidr, the allocation fails when it is unavailable
+	IP string `json:"ip,omitempty"`
 }
 
 type F5IPAMStatus struct {
@@ -33,7 +43,12 @@ type F5IPAMStatus struct {
 type IPSpec struct {
 	Host string `json:"host,omitempty"`
 	Cidr string `json:"cidr,omitempty"`
+	Key  string `json:"key,omitempty"`
 	IP   string `json:"ip,omitempty"`
+	// Status is Failed when no IP Address could be allocated, or Pending while waiting for one
+	// of an exhausted pool, for the given Reason
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -45,3 +60,62 @@ type F5IPAMList struct {
 
 	Items []F5IPAM `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// F5IPAMPool defines a cluster wide pool of IP Addresses.
+type F5IPAMPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   F5IPAMPoolSpec   `json:"spec,omitempty"`
+	Status F5IPAMPoolStatus `json:"status,omitempty"`
+}
+
+type F5IPAMPoolSpec struct {
+	Cidr string `json:"cidr,omitempty"`
+	// Ranges of IP Addresses given as <start-ip>-<end-ip>
+	Ranges []string `json:"ranges,omitempty"`
+	// Exclusions are IP Addresses or ranges that are never allocated
+	Exclusions []string `json:"exclusions,omitempty"`
+	// Strategy is one of sequential, random or least-recently-released
+	Strategy string `json:"strategy,omitempty"`
+	// ReleaseCooldown is a duration like 10m
+	ReleaseCooldown   string   `json:"releaseCooldown,omitempty"`
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// ReclaimPolicy of the IP Addresses of deleted allocations is one of Delete, Retain or RetainForever
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+	// RetainPeriod of the Retain reclaim policy is a duration like 24h
+	RetainPeriod string `json:"retainPeriod,omitempty"`
+	// Utilization percentages at which warning conditions are set, not set when zero
+	WarningThreshold  int `json:"warningThreshold,omitempty"`
+	CriticalThreshold int `json:"criticalThreshold,omitempty"`
+}
+
+type F5IPAMPoolStatus struct {
+	Total      int             `json:"total"`
+	Allocated  int             `json:"allocated"`
+	Free       int             `json:"free"`
+	Retained   int             `json:"retained"`
+	Conditions []PoolCondition `json:"conditions,omitempty"`
+}
+
+type PoolCondition struct {
+	Type               string      `json:"type"`
+	Status             string      `json:"status"`
+	Reason             string      `json:"reason,omitempty"`
+	Message            string      `json:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// F5IPAMPoolList is list of F5IPAMPool
+type F5IPAMPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []F5IPAMPool `json:"items"`
+}
@@ -34,9 +34,9 @@ type FakeF5IPAMs struct {
 	ns   string
 }
 
-var f5ipamsResource = schema.GroupVersionResource{Group: "k8s.nginx.org", Version: "v1", Resource: "f5ipams"}
+var f5ipamsResource = schema.GroupVersionResource{Group: "fic.f5.com", Version: "v1", Resource: "f5ipams"}
 
-var f5ipamsKind = schema.GroupVersionKind{Group: "k8s.nginx.org", Version: "v1", Kind: "F5IPAM"}
+var f5ipamsKind = schema.GroupVersionKind{Group: "fic.f5.com", Version: "v1", Kind: "F5IPAM"}
 
 // Get takes name of the f5IPAM, and returns the corresponding f5IPAM object, and an error if there is any.
 func (c *FakeF5IPAMs) Get(name string, options v1.GetOptions) (result *ficv1.F5IPAM, err error) {
@@ -32,6 +32,10 @@ func (c *FakeK8sV1) F5IPAMs(namespace string) v1.F5IPAMInterface {
 	return &FakeF5IPAMs{c, namespace}
 }
 
+func (c *FakeK8sV1) F5IPAMPools() v1.F5IPAMPoolInterface {
+	return &FakeF5IPAMPools{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeK8sV1) RESTClient() rest.Interface {
@@ -27,9 +27,10 @@ import (
 type K8sV1Interface interface {
 	RESTClient() rest.Interface
 	F5IPAMsGetter
+	F5IPAMPoolsGetter
 }
 
-// K8sV1Client is used to interact with features provided by the k8s.nginx.org group.
+// K8sV1Client is used to interact with features provided by the fic.f5.com group.
 type K8sV1Client struct {
 	restClient rest.Interface
 }
@@ -38,6 +39,10 @@ func (c *K8sV1Client) F5IPAMs(namespace string) F5IPAMInterface {
 	return newF5IPAMs(c, namespace)
 }
 
+func (c *K8sV1Client) F5IPAMPools() F5IPAMPoolInterface {
+	return newF5IPAMPools(c)
+}
+
 // NewForConfig creates a new K8sV1Client for the given config.
 func NewForConfig(c *rest.Config) (*K8sV1Client, error) {
 	config := *c
@@ -19,3 +19,5 @@ limitations under the License.
 package v1
 
 type F5IPAMExpansion interface{}
+
+type F5IPAMPoolExpansion interface{}
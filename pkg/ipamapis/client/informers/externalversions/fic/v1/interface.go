@@ -26,6 +26,8 @@ import (
 type Interface interface {
 	// F5IPAMs returns a F5IPAMInformer.
 	F5IPAMs() F5IPAMInformer
+	// F5IPAMPools returns a F5IPAMPoolInformer.
+	F5IPAMPools() F5IPAMPoolInformer
 }
 
 type version struct {
@@ -43,3 +45,8 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 func (v *version) F5IPAMs() F5IPAMInformer {
 	return &f5IPAMInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// F5IPAMPools returns a F5IPAMPoolInformer.
+func (v *version) F5IPAMPools() F5IPAMPoolInformer {
+	return &f5IPAMPoolInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
@@ -52,9 +52,11 @@ func (f *genericInformer) Lister() cache.GenericLister {
 // TODO extend this to unknown resources with a client pool
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
-	// Group=k8s.nginx.org, Version=v1
+	// Group=fic.f5.com, Version=v1
 	case v1.SchemeGroupVersion.WithResource("f5ipams"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.K8s().V1().F5IPAMs().Informer()}, nil
+	case v1.SchemeGroupVersion.WithResource("f5ipampools"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.K8s().V1().F5IPAMPools().Informer()}, nil
 
 	}
 
@@ -25,3 +25,7 @@ type F5IPAMListerExpansion interface{}
 // F5IPAMNamespaceListerExpansion allows custom methods to be added to
 // F5IPAMNamespaceLister.
 type F5IPAMNamespaceListerExpansion interface{}
+
+// F5IPAMPoolListerExpansion allows custom methods to be added to
+// F5IPAMPoolLister.
+type F5IPAMPoolListerExpansion interface{}
@@ -2,69 +2,64 @@ package ipammachinery
 
 import (
 	v1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	corev1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
-	"k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func (ipamCli *IPAMClient) Create(namespace string, obj *v1.F5IPAM) (*v1.F5IPAM, error) {
-	result := &v1.F5IPAM{}
-	err := ipamCli.restClient.Post().
-		Namespace(namespace).Resource("f5ipams").
-		Body(obj).Do().Into(result)
-	return result, err
+	return ipamCli.kubeCRClient.K8sV1().F5IPAMs(namespace).Create(obj)
 }
 
 func (ipamCli *IPAMClient) Update(namespace string, obj *v1.F5IPAM) (*v1.F5IPAM, error) {
-	result := &v1.F5IPAM{}
-	err := ipamCli.restClient.Put().
-		Namespace(namespace).Resource("f5ipams").
-		Name(obj.Name).
-		Body(obj).Do().Into(result)
-	return result, err
+	return ipamCli.kubeCRClient.K8sV1().F5IPAMs(namespace).Update(obj)
 }
 
 func (ipamCli *IPAMClient) Delete(namespace, name string, options *meta_v1.DeleteOptions) error {
-	return ipamCli.restClient.Delete().
-		Namespace(namespace).Resource("f5ipams").
-		Name(name).Body(options).Do().Error()
+	return ipamCli.kubeCRClient.K8sV1().F5IPAMs(namespace).Delete(name, options)
 }
 
 func (ipamCli *IPAMClient) Get(namespace, name string) (*v1.F5IPAM, error) {
-	result := &v1.F5IPAM{}
-	err := ipamCli.restClient.Get().
-		Namespace(namespace).Resource("f5ipams").
-		Name(name).Do().Into(result)
-	return result, err
+	return ipamCli.kubeCRClient.K8sV1().F5IPAMs(namespace).Get(name, meta_v1.GetOptions{})
 }
 
-func addKnownTypes(scheme *runtime.Scheme) error {
-	SchemeGroupVersion := schema.GroupVersion{Group: CRDGroup, Version: CRDVersion}
-	scheme.AddKnownTypes(SchemeGroupVersion,
-		&v1.F5IPAM{},
-		&v1.F5IPAMList{},
-	)
-	meta_v1.AddToGroupVersion(scheme, SchemeGroupVersion)
-	return nil
+// List lists the F5IPAMs of the watched namespaces
+func (ipamCli *IPAMClient) List() ([]*v1.F5IPAM, error) {
+	var rscs []*v1.F5IPAM
+	for namespace := range ipamCli.namespaces {
+		result, err := ipamCli.kubeCRClient.K8sV1().F5IPAMs(namespace).List(meta_v1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range result.Items {
+			rscs = append(rscs, &result.Items[i])
+		}
+	}
+	return rscs, nil
+}
+
+// ListPools lists the F5IPAMPools known to the pool informer
+func (ipamCli *IPAMClient) ListPools() ([]*v1.F5IPAMPool, error) {
+	if ipamCli.poolInformer == nil {
+		return nil, nil
+	}
+	return ipamCli.poolInformer.poolLister.List(labels.Everything())
 }
 
-func NewRESTClient(cfg *rest.Config) (rest.Interface, error) {
-	scheme := runtime.NewScheme()
-	SchemeBuilder := runtime.NewSchemeBuilder(addKnownTypes)
-	if err := SchemeBuilder.AddToScheme(scheme); err != nil {
-		return nil, err
+// ListNamespaces lists the Namespaces known to the namespace informer
+func (ipamCli *IPAMClient) ListNamespaces() []*corev1.Namespace {
+	if ipamCli.nsInformer == nil {
+		return nil
 	}
-	SchemeGroupVersion := schema.GroupVersion{Group: CRDGroup, Version: CRDVersion}
-	config := *cfg
-	config.GroupVersion = &SchemeGroupVersion
-	config.APIPath = "/apis"
-	config.ContentType = runtime.ContentTypeJSON
-	config.NegotiatedSerializer = serializer.NewCodecFactory(scheme)
-	client, err := rest.RESTClientFor(&config)
-	if err != nil {
-		return nil, err
+	var namespaces []*corev1.Namespace
+	for _, obj := range ipamCli.nsInformer.nsInformer.GetStore().List() {
+		if ns, ok := obj.(*corev1.Namespace); ok {
+			namespaces = append(namespaces, ns)
+		}
 	}
-	return client, nil
+	return namespaces
+}
+
+func (ipamCli *IPAMClient) UpdatePool(obj *v1.F5IPAMPool) (*v1.F5IPAMPool, error) {
+	return ipamCli.kubeCRClient.K8sV1().F5IPAMPools().Update(obj)
 }
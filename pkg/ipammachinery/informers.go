@@ -21,8 +21,12 @@ import (
 	"time"
 
 	ficInfV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/informers/externalversions/fic/v1"
+	listerV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/listers/fic/v1"
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -126,3 +130,78 @@ func (ipamCli *IPAMClient) getNamespacedInformer(
 	ipamInf, found := ipamCli.ipamInformers[namespace]
 	return ipamInf, found
 }
+
+func (ipamCli *IPAMClient) newPoolInformer(
+	eventHandlers *cache.ResourceEventHandlerFuncs,
+) *PoolInformer {
+	log.Debugf("[ipam] Creating F5IPAMPool Informer")
+	resyncPeriod := 0 * time.Second
+
+	poolInf := &PoolInformer{
+		stopCh: make(chan struct{}),
+	}
+	poolInf.poolInformer = ficInfV1.NewF5IPAMPoolInformer(
+		ipamCli.kubeCRClient,
+		resyncPeriod,
+		cache.Indexers{},
+	)
+	poolInf.poolInformer.AddEventHandler(eventHandlers)
+	poolInf.poolLister = listerV1.NewF5IPAMPoolLister(poolInf.poolInformer.GetIndexer())
+	return poolInf
+}
+
+// start the pool informer
+func (poolInfr *PoolInformer) start() {
+	log.Infof("Starting F5IPAMPool Informer")
+	go poolInfr.poolInformer.Run(poolInfr.stopCh)
+	cache.WaitForNamedCacheSync(
+		"F5 IPAMPool Controller",
+		poolInfr.stopCh,
+		poolInfr.poolInformer.HasSynced,
+	)
+}
+
+func (poolInfr *PoolInformer) stop() {
+	close(poolInfr.stopCh)
+}
+
+func (ipamCli *IPAMClient) newNamespaceInformer(
+	eventHandlers *cache.ResourceEventHandlerFuncs,
+) *NamespaceInformer {
+	log.Debugf("[ipam] Creating Namespace Informer")
+	resyncPeriod := 0 * time.Second
+
+	nsInf := &NamespaceInformer{
+		stopCh: make(chan struct{}),
+	}
+	nsInf.nsInformer = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return ipamCli.kubeClient.CoreV1().Namespaces().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return ipamCli.kubeClient.CoreV1().Namespaces().Watch(options)
+			},
+		},
+		&corev1.Namespace{},
+		resyncPeriod,
+		cache.Indexers{},
+	)
+	nsInf.nsInformer.AddEventHandler(eventHandlers)
+	return nsInf
+}
+
+// start the namespace informer
+func (nsInfr *NamespaceInformer) start() {
+	log.Infof("Starting Namespace Informer")
+	go nsInfr.nsInformer.Run(nsInfr.stopCh)
+	cache.WaitForNamedCacheSync(
+		"F5 IPAM Namespace Controller",
+		nsInfr.stopCh,
+		nsInfr.nsInformer.HasSynced,
+	)
+}
+
+func (nsInfr *NamespaceInformer) stop() {
+	close(nsInfr.stopCh)
+}
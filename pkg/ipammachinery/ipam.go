@@ -38,6 +38,12 @@ const (
 	CRDGroup    string = "fic.f5.com"
 	CRDVersion  string = "v1"
 	FullCRDName string = CRDPlural + "." + CRDGroup
+
+	// F5IPAMPool is a cluster scoped F5 Custom Resource Kind defining a pool of IP Addresses
+	F5ipamPool = "F5IPAMPool"
+
+	PoolCRDPlural   string = "f5ipampools"
+	FullPoolCRDName string = PoolCRDPlural + "." + CRDGroup
 )
 
 // NewIPAM creates a new IPAMClient Instance.
@@ -51,7 +57,10 @@ func NewIPAMClient(params Params) *IPAMClient {
 		ipamCli.namespaces[ns] = true
 	}
 
-	if err := ipamCli.setupClients(params.Config); err != nil {
+	if params.KubeClient != nil && params.KubeCRClient != nil {
+		ipamCli.kubeClient = params.KubeClient
+		ipamCli.kubeCRClient = params.KubeCRClient
+	} else if err := ipamCli.setupClients(params.Config); err != nil {
 		log.Error(err.Error())
 		return nil
 	}
@@ -59,6 +68,12 @@ func NewIPAMClient(params Params) *IPAMClient {
 	if err := ipamCli.setupInformersWithEventHandlers(params.EventHandlers); err != nil {
 		log.Error("Failed to Setup Informers")
 	}
+	if params.PoolEventHandlers != nil {
+		ipamCli.poolInformer = ipamCli.newPoolInformer(params.PoolEventHandlers)
+	}
+	if params.NamespaceEventHandlers != nil {
+		ipamCli.nsInformer = ipamCli.newNamespaceInformer(params.NamespaceEventHandlers)
+	}
 
 	log.Debugf("Created New IPAM Client")
 
@@ -77,14 +92,8 @@ func (ipamCli *IPAMClient) setupClients(config *rest.Config) error {
 		return fmt.Errorf("Failed to create Custom Resource Client: %v", err)
 	}
 
-	restCli, err := NewRESTClient(config)
-	if err != nil {
-		return fmt.Errorf("Failed to create Kubernets REST Client: %v", err)
-	}
-
 	ipamCli.kubeCRClient = kubeCRClient
 	ipamCli.kubeClient = kubeClient
-	ipamCli.restClient = restCli
 
 	return nil
 }
@@ -102,6 +111,12 @@ func (ipamCli *IPAMClient) setupInformersWithEventHandlers(eventHandlers *cache.
 
 // Start the Custom Resource Manager
 func (ipamCli *IPAMClient) Start() {
+	if ipamCli.nsInformer != nil {
+		ipamCli.nsInformer.start()
+	}
+	if ipamCli.poolInformer != nil {
+		ipamCli.poolInformer.start()
+	}
 	for _, inf := range ipamCli.ipamInformers {
 		inf.start()
 	}
@@ -111,6 +126,12 @@ func (ipamCli *IPAMClient) Stop() {
 	for _, inf := range ipamCli.ipamInformers {
 		inf.stop()
 	}
+	if ipamCli.poolInformer != nil {
+		ipamCli.poolInformer.stop()
+	}
+	if ipamCli.nsInformer != nil {
+		ipamCli.nsInformer.stop()
+	}
 }
 
 // RegisterCRD creates schema of F5IPAM and registers it with Kubernetes/Openshift
@@ -133,3 +154,24 @@ func RegisterCRD(clientset extClient.Interface) error {
 	}
 	return err
 }
+
+// RegisterPoolCRD creates schema of F5IPAMPool and registers it with Kubernetes/Openshift
+func RegisterPoolCRD(clientset extClient.Interface) error {
+	crd := &apiextensionv1beta1.CustomResourceDefinition{
+		ObjectMeta: meta_v1.ObjectMeta{Name: FullPoolCRDName},
+		Spec: apiextensionv1beta1.CustomResourceDefinitionSpec{
+			Group:   CRDGroup,
+			Version: CRDVersion,
+			Scope:   apiextensionv1beta1.ClusterScoped,
+			Names: apiextensionv1beta1.CustomResourceDefinitionNames{
+				Plural: PoolCRDPlural,
+				Kind:   F5ipamPool,
+			},
+		},
+	}
+	_, err := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
+	if err != nil && apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
@@ -18,6 +18,7 @@ package ipammachinery
 
 import (
 	"github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/clientset/versioned"
+	listerV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/listers/fic/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -28,8 +29,9 @@ type (
 	IPAMClient struct {
 		kubeCRClient  versioned.Interface
 		kubeClient    kubernetes.Interface
-		restClient    rest.Interface
 		ipamInformers map[string]*IPAMInformer
+		poolInformer  *PoolInformer
+		nsInformer    *NamespaceInformer
 		namespaces    map[string]bool
 		stopCh        chan interface{}
 	}
@@ -38,6 +40,13 @@ type (
 		Config        *rest.Config
 		EventHandlers *cache.ResourceEventHandlerFuncs
 		Namespaces    []string
+		// PoolEventHandlers enables watching F5IPAMPools when provided
+		PoolEventHandlers *cache.ResourceEventHandlerFuncs
+		// NamespaceEventHandlers enables watching Namespaces when provided
+		NamespaceEventHandlers *cache.ResourceEventHandlerFuncs
+		// KubeClient and KubeCRClient are used instead of the clients of the Config when provided
+		KubeClient   kubernetes.Interface
+		KubeCRClient versioned.Interface
 	}
 	// CRInformer defines the structure of Custom Resource Informer
 	IPAMInformer struct {
@@ -45,4 +54,15 @@ type (
 		stopCh       chan struct{}
 		ipamInformer cache.SharedIndexInformer
 	}
+	// PoolInformer defines the structure of the cluster wide F5IPAMPool Informer
+	PoolInformer struct {
+		stopCh       chan struct{}
+		poolInformer cache.SharedIndexInformer
+		poolLister   listerV1.F5IPAMPoolLister
+	}
+	// NamespaceInformer defines the structure of the Namespace Informer
+	NamespaceInformer struct {
+		stopCh     chan struct{}
+		nsInformer cache.SharedIndexInformer
+	}
 )
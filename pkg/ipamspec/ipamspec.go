@@ -1,20 +1,175 @@
 package ipamspec
 
+import (
+	"time"
+
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
 const (
 	CREATE = "Create"
 	DELETE = "Delete"
+	// UPDATE moves the allocation of the previous host and pool of the request to its host and pool
+	UPDATE = "Update"
+	// COMMIT makes the allocation of the request permanent once it is published, allocations
+	// that are not committed in time are rolled back
+	COMMIT = "Commit"
+)
+
+// Reclaim policies decide what happens to the IP Address of a deleted allocation
+const (
+	// ReclaimDelete releases the IP Address to its pool
+	ReclaimDelete = "Delete"
+	// ReclaimRetain keeps the IP Address for the namespace and host for a period
+	ReclaimRetain = "Retain"
+	// ReclaimRetainForever keeps the IP Address for the namespace and host until it is requested again
+	ReclaimRetainForever = "RetainForever"
 )
 
+// IsValidReclaimPolicy checks whether the reclaim policy is known
+func IsValidReclaimPolicy(policy string) bool {
+	switch policy {
+	case ReclaimDelete, ReclaimRetain, ReclaimRetainForever:
+		return true
+	}
+	return false
+}
+
 type IPAMRequest struct {
-	Metadata  interface{}
+	Metadata interface{}
+	// Namespace and Name of the resource the request is made for
+	Namespace string
+	Name      string
 	HostName  string
 	CIDR      string
-	IPAddr    string
-	Operation string
+	// Key of the shared allocation, requests with the same Key in a namespace and pool get the same IP Address
+	Key string
+	// IPAddr the resource held before, which is restored
+	IPAddr string
+	// RequestedIP is the IP Address asked by the resource, it is allocated exactly or not at all
+	RequestedIP string
+	// PrevHostName and PrevCIDR of an UPDATE are the host and pool the allocation is moved from
+	PrevHostName string
+	PrevCIDR     string
+	// ReclaimPolicy of the resource on deletion, the one of the pool when empty
+	ReclaimPolicy string
+	// RetainPeriod of the Retain reclaim policy of the resource
+	RetainPeriod time.Duration
+	Operation    string
 }
 
 type IPAMResponse struct {
 	Request IPAMRequest
 	IPAddr  string
 	Status  bool
+	// Reason of a failed request, empty when not known
+	Reason string
+	// Pending requests wait for an IP Address of their pool at Position of its queue, starting
+	// from 1. They are answered again when their position changes and once they are served.
+	Pending  bool
+	Position int
+}
+
+// AllocationKey identifies an allocation by the resource that owns it, the host and the pool.
+// Allocations with the same Key in a namespace and pool share one IP Address.
+type AllocationKey struct {
+	Namespace string
+	Name      string
+	HostName  string
+	CIDR      string
+	Key       string
+}
+
+// Allocation is an IP Address allocated for the key
+type Allocation struct {
+	Key    AllocationKey
+	IPAddr string
+}
+
+// AllocationKey returns the key of the allocation the request is made for
+func (req IPAMRequest) AllocationKey() AllocationKey {
+	return AllocationKey{
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		HostName:  req.HostName,
+		CIDR:      req.CIDR,
+		Key:       req.Key,
+	}
+}
+
+// LogFields returns the fields that trace the allocation in structured log messages
+func (key AllocationKey) LogFields() []interface{} {
+	fields := []interface{}{
+		log.NamespaceKey, key.Namespace,
+		log.ResourceKey, key.Name,
+		log.HostKey, key.HostName,
+		log.CIDRKey, key.CIDR,
+	}
+	if key.Key != "" {
+		fields = append(fields, log.SharedKeyKey, key.Key)
+	}
+	return fields
+}
+
+// LogFields returns the fields that trace the request in structured log messages
+func (req IPAMRequest) LogFields() []interface{} {
+	return req.AllocationKey().LogFields()
+}
+
+// PoolSpec defines a pool of IP Addresses that belong to a CIDR
+type PoolSpec struct {
+	CIDR string
+	// Ranges of IP Addresses given as <start-ip>-<end-ip>
+	Ranges []string
+	// Exclusions are IP Addresses or ranges within Ranges that are never allocated
+	Exclusions []string
+	// Strategy to allocate IP Addresses, provider default when empty
+	Strategy string
+	// ReleaseCooldown for the released IP Addresses, provider default when zero
+	ReleaseCooldown time.Duration
+	// AllowedNamespaces that may allocate from the pool, all when empty
+	AllowedNamespaces []string
+	// ReclaimPolicy of the deleted allocations, ReclaimDelete when empty
+	ReclaimPolicy string
+	// RetainPeriod of the Retain reclaim policy
+	RetainPeriod time.Duration
+}
+
+// PoolStats describes the utilization of a pool
+type PoolStats struct {
+	CIDR      string
+	Total     int
+	Allocated int
+	Free      int
+	// Retained are the allocated IP Addresses kept for deleted allocations
+	Retained int
+}
+
+// PoolReport describes the outcome of reconciling the pools with new definitions
+type PoolReport struct {
+	Added   []string
+	Updated []string
+	Removed []string
+	// Pools whose shrink was refused as it would orphan allocated IP Addresses
+	Refused []string
+	// Allocated IP Addresses that are outside of the new definitions
+	Orphaned []OrphanedIP
+}
+
+// OrphanedIP is an allocated IP Address that no pool definition covers anymore
+type OrphanedIP struct {
+	CIDR     string
+	IPAddr   string
+	HostName string
+}
+
+// NamespacePolicy restricts the allocations of the resources of a namespace
+type NamespacePolicy struct {
+	Namespace string
+	// AllowedPools are the CIDRs of the pools the namespace may allocate from, all when empty
+	AllowedPools []string
+	// MaxAllocations of the namespace, unlimited when zero
+	MaxAllocations int
+	// MaxAllocationsPerResource of each resource in the namespace, unlimited when zero
+	MaxAllocationsPerResource int
 }
@@ -1,80 +1,195 @@
 package manager
 
 import (
+	"errors"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
 	"github.com/subbuv26/f5-ipam-controller/pkg/provider"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 )
 
+// ipmgLog logs the messages of the IPAM Manager
+var ipmgLog = log.NewSubsystemLogger("IPMG")
+
 type IPAMManagerParams struct {
-	Range string
+	Range           string
+	Pools           []ipamspec.PoolSpec
+	Strategy        string
+	ReleaseCooldown time.Duration
+	StorePath       string
+	ShrinkPolicy    string
+	AuditFile       string
 }
 
 type IPAMManager struct {
 	provider *provider.IPAMProvider
 }
 
-func NewIPAMManager(params IPAMManagerParams) *IPAMManager {
-	provParams := provider.Params{Range: params.Range}
+// NewIPAMManager creates the Manager of the F5 IPAM Provider, it fails when the parameters of
+// the Provider are invalid or its store can not be opened
+func NewIPAMManager(params IPAMManagerParams) (Manager, error) {
+	provParams := provider.Params{
+		Range:           params.Range,
+		Pools:           params.Pools,
+		Strategy:        params.Strategy,
+		ReleaseCooldown: params.ReleaseCooldown,
+		StorePath:       params.StorePath,
+		ShrinkPolicy:    params.ShrinkPolicy,
+		AuditFile:       params.AuditFile,
+	}
 	prov := provider.NewProvider(provParams)
 	if prov == nil {
-		log.Error("[IPMG] Unable to create Provider")
-		return nil
+		ipmgLog.Error("Unable to create Provider")
+		return nil, errors.New("unable to create Provider")
 	}
-	return &IPAMManager{provider: prov}
+	return &IPAMManager{provider: prov}, nil
 }
 
 // Creates an A record
-func (ipMgr *IPAMManager) CreateARecord(hostname, ipAddr string) bool {
+func (ipMgr *IPAMManager) CreateARecord(key ipamspec.AllocationKey, ipAddr string) bool {
 	if !isIPV4Addr(ipAddr) {
-		log.Errorf("[IPMG] Invalid IP Address Provided")
+		ipmgLog.Errorf("Invalid IP Address Provided")
 		return false
 	}
 	// TODO: Validate hostname to be a proper dns hostname
-	ipMgr.provider.CreateARecord(hostname, ipAddr)
-	return true
+	return ipMgr.provider.CreateARecord(key, ipAddr)
 }
 
-// Deletes an A record and releases the IP address
-func (ipMgr *IPAMManager) DeleteARecord(hostname, ipAddr string) {
+// Deletes the A record of the allocation
+func (ipMgr *IPAMManager) DeleteARecord(key ipamspec.AllocationKey, ipAddr string) {
 	if !isIPV4Addr(ipAddr) {
-		log.Errorf("[IPMG] Invalid IP Address Provided")
+		ipmgLog.Errorf("Invalid IP Address Provided")
 		return
 	}
 	// TODO: Validate hostname to be a proper dns hostname
-	ipMgr.provider.DeleteARecord(hostname, ipAddr)
+	ipMgr.provider.DeleteARecord(key, ipAddr)
+}
+
+// Creates an A record for the allocation that is rolled back after the timeout unless committed
+func (ipMgr *IPAMManager) ReserveARecord(key ipamspec.AllocationKey, ipAddr string, timeout time.Duration) bool {
+	if !isIPV4Addr(ipAddr) {
+		ipmgLog.Errorf("Invalid IP Address Provided")
+		return false
+	}
+	return ipMgr.provider.ReserveARecord(key, ipAddr, timeout)
+}
+
+// Commits the reserved A record of the allocation, fails when the reservation expired
+func (ipMgr *IPAMManager) CommitARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	return ipMgr.provider.CommitARecord(key, ipAddr)
+}
+
+// Gets the reserved allocations that were not committed in time
+func (ipMgr *IPAMManager) GetExpiredReservations() []ipamspec.Allocation {
+	return ipMgr.provider.GetExpiredReservations()
+}
+
+// Deletes the A record of the allocation if its reservation expired
+func (ipMgr *IPAMManager) RollbackARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	return ipMgr.provider.RollbackARecord(key, ipAddr)
 }
 
-func (ipMgr *IPAMManager) GetIPAddress(hostname string) string {
+func (ipMgr *IPAMManager) GetIPAddress(key ipamspec.AllocationKey) string {
 	// TODO: Validate hostname to be a proper dns hostname
-	return ipMgr.provider.GetIPAddress(hostname)
+	return ipMgr.provider.GetIPAddress(key)
 }
 
-// Gets and reserves the next available IP address
-func (ipMgr *IPAMManager) GetNextIPAddress(cidr string) string {
-	_, _, err := net.ParseCIDR(cidr)
+// Gets the IP Address shared by the allocations of the key in its namespace and pool
+func (ipMgr *IPAMManager) GetSharedIPAddress(key ipamspec.AllocationKey) string {
+	return ipMgr.provider.GetSharedIPAddress(key)
+}
+
+// Gets the allocations of the hostname in all pools and of all owners
+func (ipMgr *IPAMManager) GetAllocations(hostname string) []ipamspec.Allocation {
+	return ipMgr.provider.GetAllocations(hostname)
+}
+
+// Gets the allocations that refer to the IP Address
+func (ipMgr *IPAMManager) GetAllocationsOfIP(ipAddr string) []ipamspec.Allocation {
+	return ipMgr.provider.GetAllocationsOfIP(ipAddr)
+}
+
+// Gets and reserves the next available IP address of the pool of the allocation
+func (ipMgr *IPAMManager) GetNextIPAddress(key ipamspec.AllocationKey) string {
+	_, _, err := net.ParseCIDR(key.CIDR)
 	if err != nil {
-		log.Debugf("[IPMG] Invalid CIDR Provided: %v", cidr)
+		ipmgLog.With(key.LogFields()...).Debug("Invalid CIDR Provided")
 		return ""
 	}
-	return ipMgr.provider.GetNextAddr(cidr)
+	return ipMgr.provider.GetNextAddr(key)
+}
+
+// Allocates this particular ip from the pool of the allocation
+func (ipMgr *IPAMManager) AllocateIPAddress(key ipamspec.AllocationKey, ipAddr string) bool {
+	return ipMgr.provider.AllocateIPAddress(key, ipAddr)
 }
 
-// Allocates this particular ip from the CIDR
-func (ipMgr *IPAMManager) AllocateIPAddress(cidr, ipAddr string) bool {
-	return ipMgr.provider.AllocateIPAddress(cidr, ipAddr)
+// Checks whether the IP Address is one of the pool of the CIDR
+func (ipMgr *IPAMManager) IsIPAddressInPool(cidr, ipAddr string) bool {
+	return ipMgr.provider.IsIPAddressInPool(cidr, ipAddr)
 }
 
-// Releases an IP address
-func (ipMgr *IPAMManager) ReleaseIPAddress(ipAddr string) {
+// Releases the IP address of the allocation
+func (ipMgr *IPAMManager) ReleaseIPAddress(key ipamspec.AllocationKey, ipAddr string) {
 
 	if !isIPV4Addr(ipAddr) {
-		log.Errorf("[IPMG] Invalid IP Address Provided")
+		ipmgLog.Errorf("Invalid IP Address Provided")
 		return
 	}
-	ipMgr.provider.ReleaseAddr(ipAddr)
+	ipMgr.provider.ReleaseAddr(key, ipAddr)
+}
+
+// Keeps the IP Address of the deleted allocation for its namespace and host, or its shared key
+func (ipMgr *IPAMManager) RetainIPAddress(key ipamspec.AllocationKey, ipAddr string, period time.Duration) {
+	if !isIPV4Addr(ipAddr) {
+		ipmgLog.Errorf("Invalid IP Address Provided")
+		return
+	}
+	ipMgr.provider.RetainAddr(key, ipAddr, period)
+}
+
+// Gets the IP Address retained for the allocation
+func (ipMgr *IPAMManager) GetRetainedIPAddress(key ipamspec.AllocationKey) string {
+	return ipMgr.provider.GetRetainedAddr(key)
+}
+
+// Ends the retention of the IP Address, it stays allocated for the claiming allocation
+func (ipMgr *IPAMManager) ClaimRetainedIPAddress(key ipamspec.AllocationKey, ipAddr string) bool {
+	return ipMgr.provider.ClaimRetainedAddr(key, ipAddr)
+}
+
+// Updates the pools to the given definitions
+func (ipMgr *IPAMManager) ReconcilePools(pools []ipamspec.PoolSpec) ipamspec.PoolReport {
+	return ipMgr.provider.ReconcilePools(pools)
+}
+
+// Prunes the CIDRs of the store that no pool defines, once the pools of all the sources are reconciled
+func (ipMgr *IPAMManager) PrunePools() ipamspec.PoolReport {
+	return ipMgr.provider.PrunePools()
+}
+
+// Gets the current pool definitions
+func (ipMgr *IPAMManager) GetPools() []ipamspec.PoolSpec {
+	return ipMgr.provider.GetPools()
+}
+
+// Gets the utilization of the pools
+func (ipMgr *IPAMManager) GetPoolStats() []ipamspec.PoolStats {
+	return ipMgr.provider.GetPoolStats()
+}
+
+// Gets the store of the allocations for administration
+func (ipMgr *IPAMManager) Store() *sqlite.DBStore {
+	return ipMgr.provider.Store()
+}
+
+// Closes the store of the allocations
+func (ipMgr *IPAMManager) Close() {
+	ipMgr.provider.Close()
 }
 
 func isIPV4Addr(ipAddr string) bool {
@@ -0,0 +1,103 @@
+package manager_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager/managertest"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+func TestConformance(t *testing.T) {
+	managertest.RunConformance(t, func(t *testing.T) manager.Manager {
+		mgr, err := manager.NewIPAMManager(manager.IPAMManagerParams{
+			StorePath: filepath.Join(t.TempDir(), "store.db"),
+		})
+		if err != nil {
+			return nil
+		}
+		return mgr
+	})
+}
+
+func TestNewManagerErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params manager.Params
+	}{
+		{"unknown provider", manager.Params{Provider: "bogus"}},
+		{"invalid strategy", manager.Params{Provider: manager.F5IPAMProvider,
+			IPAMManagerParams: manager.IPAMManagerParams{Strategy: "bogus"}}},
+		{"invalid shrink policy", manager.Params{Provider: manager.F5IPAMProvider,
+			IPAMManagerParams: manager.IPAMManagerParams{ShrinkPolicy: "bogus"}}},
+		{"unwritable store", manager.Params{Provider: manager.F5IPAMProvider,
+			IPAMManagerParams: manager.IPAMManagerParams{StorePath: filepath.Join(t.TempDir(), "missing", "store.db")}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mgr, err := manager.NewManager(test.params)
+			if err == nil || mgr != nil {
+				t.Errorf("Expected an error and no Manager, got: %v, %v", mgr, err)
+			}
+		})
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	auditFile := filepath.Join(dir, "audit.jsonl")
+	mgr, err := manager.NewIPAMManager(manager.IPAMManagerParams{
+		Range:     "10.20.30.1/24-10.20.30.1/24",
+		StorePath: filepath.Join(dir, "store.db"),
+		AuditFile: auditFile,
+	})
+	if err != nil {
+		t.Fatalf("Manager is not created: %v", err)
+	}
+	key := ipamspec.AllocationKey{Namespace: "default", Name: "f5ipam", HostName: "foo.example.com",
+		CIDR: "10.20.30.0/24"}
+	ip := mgr.GetNextIPAddress(key)
+	mgr.CreateARecord(key, ip)
+	if other := mgr.GetNextIPAddress(key); other != "" {
+		t.Fatalf("Exhausted pool allocated: %v", other)
+	}
+	mgr.DeleteARecord(key, ip)
+	mgr.ReleaseIPAddress(key, ip)
+
+	events := mgr.(manager.StoreManager).Store().GetAuditEvents(sqlite.AuditQuery{HostName: "foo.example.com"})
+	var operations []string
+	for _, event := range events {
+		operations = append(operations, event.Operation+"/"+event.Result)
+		if event.Namespace != "default" || event.Name != "f5ipam" || event.CIDR != key.CIDR {
+			t.Errorf("Event without the allocation: %+v", event)
+		}
+	}
+	expected := []string{"allocate/success", "create-record/success", "allocate/failure",
+		"delete-record/success", "release/success"}
+	if strings.Join(operations, ",") != strings.Join(expected, ",") {
+		t.Errorf("Audit events: %v, expected: %v", operations, expected)
+	}
+	if events[2].Reason != "pool is exhausted" {
+		t.Errorf("Failure without reason: %+v", events[2])
+	}
+	mgr.Close()
+
+	data, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Streamed events: %v", lines)
+	}
+	var event sqlite.AuditEvent
+	if err = json.Unmarshal([]byte(lines[0]), &event); err != nil || event.ID != events[0].ID ||
+		event.IPAddr != ip || !event.Time.Equal(events[0].Time) {
+		t.Errorf("Streamed event: %v, %v", lines[0], err)
+	}
+}
@@ -1,38 +1,87 @@
 package manager
 
-import log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+import (
+	"fmt"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
 
 // Manager defines the interface that the IPAM system should implement
 type Manager interface {
-	// Creates an A record
-	CreateARecord(hostname, ipAddr string) bool
-	// Deletes an A record and releases the IP address
-	DeleteARecord(hostname, ipAddr string)
-	// Gets IP Address associated with hostname
-	GetIPAddress(hostname string) string
-	// Gets and reserves the next available IP address
-	GetNextIPAddress(cidr string) string
-	// Allocates this particular ip from the CIDR
-	AllocateIPAddress(cidr, ipAddr string) bool
-	// Releases an IP address
-	ReleaseIPAddress(ipAddr string)
+	// Creates an A record for the allocation
+	CreateARecord(key ipamspec.AllocationKey, ipAddr string) bool
+	// Deletes the A record of the allocation
+	DeleteARecord(key ipamspec.AllocationKey, ipAddr string)
+	// Creates an A record for the allocation that is rolled back after the timeout unless committed
+	ReserveARecord(key ipamspec.AllocationKey, ipAddr string, timeout time.Duration) bool
+	// Commits the reserved A record of the allocation, fails when the reservation expired
+	CommitARecord(key ipamspec.AllocationKey, ipAddr string) bool
+	// Gets the reserved allocations that were not committed in time
+	GetExpiredReservations() []ipamspec.Allocation
+	// Deletes the A record of the allocation if its reservation expired
+	RollbackARecord(key ipamspec.AllocationKey, ipAddr string) bool
+	// Gets IP Address of the allocation
+	GetIPAddress(key ipamspec.AllocationKey) string
+	// Gets the IP Address shared by the allocations of the key in its namespace and pool
+	GetSharedIPAddress(key ipamspec.AllocationKey) string
+	// Gets the allocations of the hostname in all pools and of all owners
+	GetAllocations(hostname string) []ipamspec.Allocation
+	// Gets the allocations that refer to the IP Address
+	GetAllocationsOfIP(ipAddr string) []ipamspec.Allocation
+	// Gets and reserves the next available IP address of the pool of the allocation
+	GetNextIPAddress(key ipamspec.AllocationKey) string
+	// Allocates this particular ip from the pool of the allocation
+	AllocateIPAddress(key ipamspec.AllocationKey, ipAddr string) bool
+	// Checks whether the IP Address is one of the pool of the CIDR
+	IsIPAddressInPool(cidr, ipAddr string) bool
+	// Releases the IP address of the allocation
+	ReleaseIPAddress(key ipamspec.AllocationKey, ipAddr string)
+	// Keeps the IP Address of the deleted allocation for its namespace and host, or its shared key,
+	// for the period, forever when zero
+	RetainIPAddress(key ipamspec.AllocationKey, ipAddr string, period time.Duration)
+	// Gets the IP Address retained for the allocation
+	GetRetainedIPAddress(key ipamspec.AllocationKey) string
+	// Ends the retention of the IP Address, it stays allocated for the claiming allocation
+	ClaimRetainedIPAddress(key ipamspec.AllocationKey, ipAddr string) bool
+	// Updates the pools to the given definitions
+	ReconcilePools(pools []ipamspec.PoolSpec) ipamspec.PoolReport
+	// Prunes the CIDRs of the store that no pool defines, once the pools of all the sources are reconciled
+	PrunePools() ipamspec.PoolReport
+	// Gets the current pool definitions
+	GetPools() []ipamspec.PoolSpec
+	// Gets the utilization of the pools
+	GetPoolStats() []ipamspec.PoolStats
+	// Closes the store of the allocations, no calls are made after it
+	Close()
+}
+
+// StoreManager is a Manager that exposes its store to inspect and repair the allocations
+type StoreManager interface {
+	// Gets the store of the allocations
+	Store() *sqlite.DBStore
 }
 
 const F5IPAMProvider = "f5-ip-provider"
 
+// mgrLog logs the messages of creating Managers
+var mgrLog = log.NewSubsystemLogger("MGR")
+
 type Params struct {
 	Provider string
 	IPAMManagerParams
 }
 
-func NewManager(params Params) Manager {
+// NewManager creates the Manager of the Provider, it fails when the Provider can not be set up
+func NewManager(params Params) (Manager, error) {
 	switch params.Provider {
 	case F5IPAMProvider:
-		log.Debugf("[MGR] Creating Manager with Provider: %v", F5IPAMProvider)
-		f5IPAMParams := IPAMManagerParams{Range: params.Range}
-		return NewIPAMManager(f5IPAMParams)
+		mgrLog.Debugf("Creating Manager with Provider: %v", F5IPAMProvider)
+		return NewIPAMManager(params.IPAMManagerParams)
 	default:
-		log.Errorf("[MGR] Unknown Provider: %v", params.Provider)
+		mgrLog.Errorf("Unknown Provider: %v", params.Provider)
 	}
-	return nil
+	return nil, fmt.Errorf("unknown Provider: %v", params.Provider)
 }
@@ -0,0 +1,471 @@
+// Package managertest provides the conformance tests that every implementation of
+// manager.Manager is expected to pass.
+//
+// A provider runs them from a test of its own:
+//
+//	func TestConformance(t *testing.T) {
+//		managertest.RunConformance(t, func(t *testing.T) manager.Manager {
+//			return newManagerWithEmptyStore(t)
+//		})
+//	}
+package managertest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+)
+
+const (
+	// CIDR of the pools the conformance tests define
+	CIDR = "10.20.30.0/24"
+	// OtherCIDR of a second pool, for tests spanning pools
+	OtherCIDR = "10.20.40.0/24"
+)
+
+// NewManagerFunc creates a Manager without pools and allocations for the test. The Manager
+// is closed by the conformance tests when the test ends.
+type NewManagerFunc func(t *testing.T) manager.Manager
+
+// RunConformance runs every conformance test as a subtest with a new Manager
+func RunConformance(t *testing.T, newManager NewManagerFunc) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, mgr manager.Manager)
+	}{
+		{"NoDoubleAllocation", testNoDoubleAllocation},
+		{"ConcurrentAllocation", testConcurrentAllocation},
+		{"Exhaustion", testExhaustion},
+		{"AllocateSpecific", testAllocateSpecific},
+		{"ReleaseMakesAllocatable", testReleaseMakesAllocatable},
+		{"Records", testRecords},
+		{"ConcurrentRecords", testConcurrentRecords},
+		{"SharedKey", testSharedKey},
+		{"Reservations", testReservations},
+		{"Retention", testRetention},
+		{"ReconcilePools", testReconcilePools},
+		{"PoolStats", testPoolStats},
+	}
+	for _, tt := range tests {
+		test := tt.test
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := newManager(t)
+			if mgr == nil {
+				t.Fatal("Manager is not created")
+			}
+			t.Cleanup(mgr.Close)
+			test(t, mgr)
+		})
+	}
+}
+
+// pool defines the IP Addresses 10.20.30.1 to 10.20.30.<size> as the pool of CIDR
+func pool(t *testing.T, mgr manager.Manager, size int) []string {
+	t.Helper()
+	report := mgr.ReconcilePools([]ipamspec.PoolSpec{poolSpec(CIDR, size)})
+	if len(report.Added) != 1 || report.Added[0] != CIDR {
+		t.Fatalf("Pool %v is not added: %+v", CIDR, report)
+	}
+	return poolIPs(CIDR, size)
+}
+
+func poolSpec(cidr string, size int) ipamspec.PoolSpec {
+	ips := poolIPs(cidr, size)
+	return ipamspec.PoolSpec{
+		CIDR:   cidr,
+		Ranges: []string{ips[0] + "-" + ips[len(ips)-1]},
+	}
+}
+
+// poolIPs returns the first IP Addresses of the /24 CIDR
+func poolIPs(cidr string, size int) []string {
+	prefix := cidr[:len(cidr)-len("0/24")]
+	var ips []string
+	for i := 1; i <= size; i++ {
+		ips = append(ips, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return ips
+}
+
+func allocationKey(host string) ipamspec.AllocationKey {
+	return ipamspec.AllocationKey{
+		Namespace: "default",
+		Name:      "f5ipam",
+		HostName:  host,
+		CIDR:      CIDR,
+	}
+}
+
+// poolKey is the key of an allocation in the pool of the CIDR, for tests that do not depend on its host
+func poolKey(cidr string) ipamspec.AllocationKey {
+	return ipamspec.AllocationKey{
+		Namespace: "default",
+		Name:      "f5ipam",
+		CIDR:      cidr,
+	}
+}
+
+// allocateAll allocates from the pool of the CIDR until it is exhausted
+func allocateAll(t *testing.T, mgr manager.Manager, cidr string) []string {
+	t.Helper()
+	var ips []string
+	for {
+		ip := mgr.GetNextIPAddress(poolKey(cidr))
+		if ip == "" {
+			return ips
+		}
+		ips = append(ips, ip)
+	}
+}
+
+func sameIPs(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func testNoDoubleAllocation(t *testing.T, mgr manager.Manager) {
+	ips := pool(t, mgr, 10)
+
+	allocated := allocateAll(t, mgr, CIDR)
+	if !sameIPs(allocated, ips) {
+		t.Fatalf("Allocated IP Addresses: %v, expected each of: %v", allocated, ips)
+	}
+	for _, ip := range allocated {
+		if !mgr.IsIPAddressInPool(CIDR, ip) {
+			t.Errorf("Allocated IP Address %v is not in Pool %v", ip, CIDR)
+		}
+	}
+}
+
+func testConcurrentAllocation(t *testing.T, mgr manager.Manager) {
+	ips := pool(t, mgr, 50)
+
+	var mutex sync.Mutex
+	var allocated []string
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ip := mgr.GetNextIPAddress(poolKey(CIDR))
+				if ip == "" {
+					return
+				}
+				mutex.Lock()
+				allocated = append(allocated, ip)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if !sameIPs(allocated, ips) {
+		t.Fatalf("Concurrently allocated IP Addresses: %v, expected each of: %v", allocated, ips)
+	}
+}
+
+func testExhaustion(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 2)
+
+	allocateAll(t, mgr, CIDR)
+	for i := 0; i < 3; i++ {
+		if ip := mgr.GetNextIPAddress(poolKey(CIDR)); ip != "" {
+			t.Fatalf("Exhausted pool allocated IP Address: %v", ip)
+		}
+	}
+	if ip := mgr.GetNextIPAddress(poolKey(OtherCIDR)); ip != "" {
+		t.Errorf("Unknown pool %v allocated IP Address: %v", OtherCIDR, ip)
+	}
+	if ip := mgr.GetNextIPAddress(poolKey("not-a-cidr")); ip != "" {
+		t.Errorf("Invalid CIDR allocated IP Address: %v", ip)
+	}
+}
+
+func testAllocateSpecific(t *testing.T, mgr manager.Manager) {
+	ips := pool(t, mgr, 5)
+
+	if !mgr.AllocateIPAddress(poolKey(CIDR), ips[2]) {
+		t.Fatalf("IP Address %v of the pool is not allocated", ips[2])
+	}
+	if mgr.AllocateIPAddress(poolKey(CIDR), ips[2]) {
+		t.Errorf("IP Address %v is allocated twice", ips[2])
+	}
+	outside := poolIPs(CIDR, 6)[5]
+	if mgr.IsIPAddressInPool(CIDR, outside) {
+		t.Errorf("IP Address %v is reported in the pool", outside)
+	}
+	if mgr.AllocateIPAddress(poolKey(CIDR), outside) {
+		t.Errorf("IP Address %v outside of the pool is allocated", outside)
+	}
+	if mgr.AllocateIPAddress(poolKey(OtherCIDR), ips[3]) {
+		t.Errorf("IP Address %v is allocated from Pool %v", ips[3], OtherCIDR)
+	}
+
+	allocated := allocateAll(t, mgr, CIDR)
+	expected := append(append([]string(nil), ips[:2]...), ips[3:]...)
+	if !sameIPs(allocated, expected) {
+		t.Errorf("Allocated IP Addresses: %v, expected: %v", allocated, expected)
+	}
+}
+
+func testReleaseMakesAllocatable(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 3)
+
+	allocated := allocateAll(t, mgr, CIDR)
+	mgr.ReleaseIPAddress(poolKey(CIDR), allocated[1])
+	if ip := mgr.GetNextIPAddress(poolKey(CIDR)); ip != allocated[1] {
+		t.Fatalf("Allocated IP Address: %v, expected the released %v", ip, allocated[1])
+	}
+
+	mgr.ReleaseIPAddress(poolKey(CIDR), allocated[0])
+	if !mgr.AllocateIPAddress(poolKey(CIDR), allocated[0]) {
+		t.Errorf("Released IP Address %v can not be allocated", allocated[0])
+	}
+}
+
+func testRecords(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 3)
+	key := allocationKey("foo.example.com")
+
+	if ip := mgr.GetIPAddress(key); ip != "" {
+		t.Fatalf("Allocation has IP Address %v before it is created", ip)
+	}
+	ip := mgr.GetNextIPAddress(poolKey(CIDR))
+	if !mgr.CreateARecord(key, ip) {
+		t.Fatalf("Record of %v is not created", ip)
+	}
+	if got := mgr.GetIPAddress(key); got != ip {
+		t.Errorf("Allocation has IP Address: %v, expected: %v", got, ip)
+	}
+	if allocations := mgr.GetAllocations(key.HostName); len(allocations) != 1 ||
+		allocations[0].Key != key || allocations[0].IPAddr != ip {
+		t.Errorf("Allocations of the host: %v", allocations)
+	}
+	if allocations := mgr.GetAllocationsOfIP(ip); len(allocations) != 1 || allocations[0].Key != key {
+		t.Errorf("Allocations of the IP Address: %v", allocations)
+	}
+
+	mgr.DeleteARecord(key, ip)
+	if got := mgr.GetIPAddress(key); got != "" {
+		t.Errorf("Deleted allocation has IP Address: %v", got)
+	}
+	if allocations := mgr.GetAllocations(key.HostName); len(allocations) != 0 {
+		t.Errorf("Allocations of the host after deletion: %v", allocations)
+	}
+	if allocations := mgr.GetAllocationsOfIP(ip); len(allocations) != 0 {
+		t.Errorf("Allocations of the IP Address after deletion: %v", allocations)
+	}
+	if mgr.CreateARecord(key, "not-an-ip") {
+		t.Errorf("Record of an invalid IP Address is created")
+	}
+}
+
+func testConcurrentRecords(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 32)
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := allocationKey(fmt.Sprintf("host-%d.example.com", i))
+			ip := mgr.GetNextIPAddress(poolKey(CIDR))
+			if ip == "" || !mgr.CreateARecord(key, ip) {
+				errs <- fmt.Sprintf("%v is not allocated", key.HostName)
+				return
+			}
+			if got := mgr.GetIPAddress(key); got != ip {
+				errs <- fmt.Sprintf("%v has IP Address: %v, expected: %v", key.HostName, got, ip)
+				return
+			}
+			mgr.DeleteARecord(key, ip)
+			mgr.ReleaseIPAddress(poolKey(CIDR), ip)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if allocated := allocateAll(t, mgr, CIDR); len(allocated) != 32 {
+		t.Errorf("%v IP Addresses are allocatable after the releases, expected 32", len(allocated))
+	}
+}
+
+func testSharedKey(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 3)
+	first := allocationKey("foo.example.com")
+	first.Key = "shared"
+	second := allocationKey("bar.example.com")
+	second.Key = "shared"
+
+	ip := mgr.GetNextIPAddress(first)
+	if !mgr.CreateARecord(first, ip) {
+		t.Fatalf("Record of %v is not created", ip)
+	}
+	if got := mgr.GetSharedIPAddress(second); got != ip {
+		t.Fatalf("Shared IP Address of the key: %v, expected: %v", got, ip)
+	}
+	if !mgr.CreateARecord(second, ip) {
+		t.Fatalf("Record of the shared %v is not created", ip)
+	}
+	if allocations := mgr.GetAllocationsOfIP(ip); len(allocations) != 2 {
+		t.Errorf("Allocations of the shared IP Address: %v", allocations)
+	}
+
+	mgr.DeleteARecord(first, ip)
+	if got := mgr.GetSharedIPAddress(first); got != ip {
+		t.Errorf("Shared IP Address after deleting one allocation: %v, expected: %v", got, ip)
+	}
+	mgr.DeleteARecord(second, ip)
+	if got := mgr.GetSharedIPAddress(first); got != "" {
+		t.Errorf("Shared IP Address after deleting all allocations: %v", got)
+	}
+}
+
+func testReservations(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 3)
+	committed := allocationKey("foo.example.com")
+	expired := allocationKey("bar.example.com")
+
+	committedIP := mgr.GetNextIPAddress(committed)
+	if !mgr.ReserveARecord(committed, committedIP, time.Second) {
+		t.Fatalf("Record of %v is not reserved", committedIP)
+	}
+	if !mgr.CommitARecord(committed, committedIP) {
+		t.Fatalf("Reserved record of %v is not committed", committedIP)
+	}
+	expiredIP := mgr.GetNextIPAddress(expired)
+	if !mgr.ReserveARecord(expired, expiredIP, time.Second) {
+		t.Fatalf("Record of %v is not reserved", expiredIP)
+	}
+	if got := mgr.GetIPAddress(expired); got != expiredIP {
+		t.Errorf("Reserved allocation has IP Address: %v, expected: %v", got, expiredIP)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(mgr.GetExpiredReservations()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	reservations := mgr.GetExpiredReservations()
+	if len(reservations) != 1 || reservations[0].Key != expired || reservations[0].IPAddr != expiredIP {
+		t.Fatalf("Expired reservations: %v, expected the one of %v", reservations, expiredIP)
+	}
+	if mgr.CommitARecord(expired, expiredIP) {
+		t.Errorf("Expired reservation of %v is committed", expiredIP)
+	}
+	if !mgr.RollbackARecord(expired, expiredIP) {
+		t.Errorf("Expired reservation of %v is not rolled back", expiredIP)
+	}
+	if got := mgr.GetIPAddress(expired); got != "" {
+		t.Errorf("Rolled back allocation has IP Address: %v", got)
+	}
+	if mgr.RollbackARecord(committed, committedIP) {
+		t.Errorf("Committed record of %v is rolled back", committedIP)
+	}
+	if got := mgr.GetIPAddress(committed); got != committedIP {
+		t.Errorf("Committed allocation has IP Address: %v, expected: %v", got, committedIP)
+	}
+}
+
+func testRetention(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 2)
+	key := allocationKey("foo.example.com")
+	expiring := allocationKey("bar.example.com")
+
+	allocated := allocateAll(t, mgr, CIDR)
+	mgr.RetainIPAddress(key, allocated[0], 0)
+	mgr.RetainIPAddress(expiring, allocated[1], time.Second)
+	if got := mgr.GetRetainedIPAddress(key); got != allocated[0] {
+		t.Fatalf("Retained IP Address: %v, expected: %v", got, allocated[0])
+	}
+	if got := mgr.GetRetainedIPAddress(allocationKey("baz.example.com")); got != "" {
+		t.Errorf("IP Address %v is retained for another host", got)
+	}
+
+	// The expired retention releases its IP Address, the other one stays allocated
+	var ip string
+	deadline := time.Now().Add(5 * time.Second)
+	for ip == "" && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		ip = mgr.GetNextIPAddress(poolKey(CIDR))
+	}
+	if ip != allocated[1] {
+		t.Fatalf("Allocated IP Address: %v, expected the expired %v", ip, allocated[1])
+	}
+	if got := mgr.GetRetainedIPAddress(expiring); got != "" {
+		t.Errorf("Expired retention keeps IP Address: %v", got)
+	}
+
+	if !mgr.ClaimRetainedIPAddress(key, allocated[0]) {
+		t.Fatalf("Retained IP Address %v is not claimed", allocated[0])
+	}
+	if got := mgr.GetRetainedIPAddress(key); got != "" {
+		t.Errorf("Claimed IP Address %v is still retained", got)
+	}
+	if ip := mgr.GetNextIPAddress(poolKey(CIDR)); ip != "" {
+		t.Errorf("Claimed IP Address %v is allocated again", ip)
+	}
+}
+
+func testReconcilePools(t *testing.T, mgr manager.Manager) {
+	if pools := mgr.GetPools(); len(pools) != 0 {
+		t.Fatalf("New Manager has pools: %v", pools)
+	}
+	pool(t, mgr, 2)
+	allocated := allocateAll(t, mgr, CIDR)
+
+	report := mgr.ReconcilePools([]ipamspec.PoolSpec{poolSpec(CIDR, 4), poolSpec(OtherCIDR, 2)})
+	if len(report.Added) != 1 || report.Added[0] != OtherCIDR ||
+		len(report.Updated) != 1 || report.Updated[0] != CIDR {
+		t.Fatalf("Unexpected report of growing pools: %+v", report)
+	}
+	if pools := mgr.GetPools(); len(pools) != 2 {
+		t.Errorf("Pools after reconciling: %v", pools)
+	}
+	grown := allocateAll(t, mgr, CIDR)
+	if !sameIPs(grown, poolIPs(CIDR, 4)[2:]) {
+		t.Errorf("Allocated IP Addresses of the grown pool: %v", grown)
+	}
+	if other := allocateAll(t, mgr, OtherCIDR); !sameIPs(other, poolIPs(OtherCIDR, 2)) {
+		t.Errorf("Allocated IP Addresses of the added pool: %v", other)
+	}
+
+	// Allocated IP Addresses stay allocated when their pool is removed
+	report = mgr.ReconcilePools([]ipamspec.PoolSpec{poolSpec(OtherCIDR, 2)})
+	if len(report.Removed) != 1 || report.Removed[0] != CIDR {
+		t.Errorf("Unexpected report of removing a pool: %+v", report)
+	}
+	if mgr.AllocateIPAddress(poolKey(CIDR), allocated[0]) {
+		t.Errorf("IP Address %v of the removed pool is allocated", allocated[0])
+	}
+}
+
+func testPoolStats(t *testing.T, mgr manager.Manager) {
+	pool(t, mgr, 4)
+
+	var allocated []string
+	for i := 0; i < 3; i++ {
+		allocated = append(allocated, mgr.GetNextIPAddress(poolKey(CIDR)))
+	}
+	mgr.ReleaseIPAddress(poolKey(CIDR), allocated[2])
+	mgr.RetainIPAddress(allocationKey("foo.example.com"), allocated[1], 0)
+
+	stats := mgr.GetPoolStats()
+	if len(stats) != 1 {
+		t.Fatalf("Stats of the pools: %v", stats)
+	}
+	expected := ipamspec.PoolStats{CIDR: CIDR, Total: 4, Allocated: 2, Free: 2, Retained: 1}
+	if stats[0] != expected {
+		t.Errorf("Stats of the pool: %+v, expected: %+v", stats[0], expected)
+	}
+}
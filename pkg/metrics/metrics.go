@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric types of the Prometheus text format
+const (
+	gaugeType   = "gauge"
+	counterType = "counter"
+)
+
+var registry = struct {
+	sync.Mutex
+	vecs []*metricVec
+}{}
+
+// metricVec holds the values of a metric for every combination of its labels
+type metricVec struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+
+	mutex  sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func newMetricVec(name, help, metricType string, labelNames []string) *metricVec {
+	vec := &metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+	registry.Lock()
+	registry.vecs = append(registry.vecs, vec)
+	registry.Unlock()
+	return vec
+}
+
+func (vec *metricVec) key(labelValues []string) string {
+	if len(labelValues) != len(vec.labelNames) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v",
+			vec.name, len(vec.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (vec *metricVec) add(value float64, set bool, labelValues []string) {
+	key := vec.key(labelValues)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	if _, ok := vec.labels[key]; !ok {
+		vec.labels[key] = append([]string(nil), labelValues...)
+	}
+	if set {
+		vec.values[key] = value
+	} else {
+		vec.values[key] += value
+	}
+}
+
+func (vec *metricVec) delete(labelValues []string) {
+	key := vec.key(labelValues)
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+	delete(vec.values, key)
+	delete(vec.labels, key)
+}
+
+func (vec *metricVec) write(w io.Writer) {
+	vec.mutex.Lock()
+	defer vec.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", vec.name, vec.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", vec.name, vec.metricType)
+	keys := make([]string, 0, len(vec.values))
+	for key := range vec.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var pairs []string
+		for i, labelValue := range vec.labels[key] {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", vec.labelNames[i], escape(labelValue)))
+		}
+		if len(pairs) == 0 {
+			fmt.Fprintf(w, "%s %v\n", vec.name, vec.values[key])
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %v\n", vec.name, strings.Join(pairs, ","), vec.values[key])
+	}
+}
+
+func escape(labelValue string) string {
+	labelValue = strings.Replace(labelValue, `\`, `\\`, -1)
+	labelValue = strings.Replace(labelValue, `"`, `\"`, -1)
+	return strings.Replace(labelValue, "\n", `\n`, -1)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	vec *metricVec
+}
+
+// NewGaugeVec creates and registers a GaugeVec
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: newMetricVec(name, help, gaugeType, labelNames)}
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.vec.add(value, true, labelValues)
+}
+
+// Delete removes the gauge of the label values
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.vec.delete(labelValues)
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	vec *metricVec
+}
+
+// NewCounterVec creates and registers a CounterVec
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: newMetricVec(name, help, counterType, labelNames)}
+}
+
+// Inc increments the counter of the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.vec.add(1, false, labelValues)
+}
+
+// WriteMetrics writes all the registered metrics in the Prometheus text format
+func WriteMetrics(w io.Writer) {
+	registry.Lock()
+	vecs := append([]*metricVec(nil), registry.vecs...)
+	registry.Unlock()
+	for _, vec := range vecs {
+		vec.write(w)
+	}
+}
+
+// Handler serves the registered metrics to Prometheus
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
@@ -0,0 +1,15 @@
+package orchestration
+
+import (
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/clientset/versioned"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipammachinery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewTestIPAMK8SClient creates the client on the given clientsets instead of the cluster
+func NewTestIPAMK8SClient(kubeClient kubernetes.Interface, kubeCRClient versioned.Interface) *K8sIPAMClient {
+	return newIPAMK8SClient(ipammachinery.Params{
+		KubeClient:   kubeClient,
+		KubeCRClient: kubeCRClient,
+	})
+}
@@ -0,0 +1,136 @@
+// Package fake provides an in-memory Orchestrator for tests, which sends scripted requests
+// and records the responses of the controller.
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+)
+
+var (
+	_ orchestration.Orchestrator         = &Orchestrator{}
+	_ orchestration.DrainingOrchestrator = &Orchestrator{}
+)
+
+// Orchestrator sends the scripted requests in order once started, and records every response
+type Orchestrator struct {
+	reqChan  chan<- ipamspec.IPAMRequest
+	respChan <-chan ipamspec.IPAMResponse
+
+	// script holds the requests to send, sent in order by a single routine
+	script   chan ipamspec.IPAMRequest
+	sending  sync.WaitGroup
+	stopOnce sync.Once
+
+	mutex     sync.Mutex
+	responses []ipamspec.IPAMResponse
+	// updateCh is closed and replaced on every response
+	updateCh  chan struct{}
+	drainedCh chan struct{}
+}
+
+// NewOrchestrator creates the Orchestrator with the requests sent on start
+func NewOrchestrator(requests ...ipamspec.IPAMRequest) *Orchestrator {
+	orcr := &Orchestrator{
+		script:    make(chan ipamspec.IPAMRequest, len(requests)),
+		updateCh:  make(chan struct{}),
+		drainedCh: make(chan struct{}),
+	}
+	for _, req := range requests {
+		orcr.script <- req
+	}
+	return orcr
+}
+
+// SetupCommunicationChannels sets Request and Response channels
+func (orcr *Orchestrator) SetupCommunicationChannels(
+	reqChan chan<- ipamspec.IPAMRequest,
+	respChan <-chan ipamspec.IPAMResponse,
+) {
+	orcr.reqChan = reqChan
+	orcr.respChan = respChan
+}
+
+// Start sends the scripted requests and records the responses
+func (orcr *Orchestrator) Start(stopCh <-chan struct{}) {
+	orcr.sending.Add(1)
+	go func() {
+		defer orcr.sending.Done()
+		for req := range orcr.script {
+			orcr.reqChan <- req
+		}
+	}()
+	go orcr.record()
+}
+
+// Send sends the requests after the ones that are already scripted. It blocks until the
+// Orchestrator is started and the requests are taken, and must not be called after Stop.
+func (orcr *Orchestrator) Send(requests ...ipamspec.IPAMRequest) {
+	for _, req := range requests {
+		orcr.script <- req
+	}
+}
+
+// Stop returns once the scripted requests are sent
+func (orcr *Orchestrator) Stop() {
+	orcr.stopOnce.Do(func() { close(orcr.script) })
+	orcr.sending.Wait()
+}
+
+// Drained is closed once the response channel is closed
+func (orcr *Orchestrator) Drained() <-chan struct{} {
+	return orcr.drainedCh
+}
+
+func (orcr *Orchestrator) record() {
+	defer close(orcr.drainedCh)
+	for resp := range orcr.respChan {
+		orcr.mutex.Lock()
+		orcr.responses = append(orcr.responses, resp)
+		close(orcr.updateCh)
+		orcr.updateCh = make(chan struct{})
+		orcr.mutex.Unlock()
+	}
+}
+
+// Responses returns the responses recorded so far, in the order they were received
+func (orcr *Orchestrator) Responses() []ipamspec.IPAMResponse {
+	orcr.mutex.Lock()
+	defer orcr.mutex.Unlock()
+	return append([]ipamspec.IPAMResponse(nil), orcr.responses...)
+}
+
+// WaitFor waits until the recorded responses satisfy the condition, and returns them.
+// It returns false when the timeout passes first.
+func (orcr *Orchestrator) WaitFor(
+	condition func([]ipamspec.IPAMResponse) bool,
+	timeout time.Duration,
+) ([]ipamspec.IPAMResponse, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		orcr.mutex.Lock()
+		responses := append([]ipamspec.IPAMResponse(nil), orcr.responses...)
+		updateCh := orcr.updateCh
+		orcr.mutex.Unlock()
+
+		if condition(responses) {
+			return responses, true
+		}
+		select {
+		case <-updateCh:
+		case <-timer.C:
+			return responses, false
+		}
+	}
+}
+
+// WaitForResponses waits until at least n responses are recorded
+func (orcr *Orchestrator) WaitForResponses(n int, timeout time.Duration) ([]ipamspec.IPAMResponse, bool) {
+	return orcr.WaitFor(func(responses []ipamspec.IPAMResponse) bool {
+		return len(responses) >= n
+	}, timeout)
+}
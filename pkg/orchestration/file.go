@@ -0,0 +1,642 @@
+package orchestration
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// FileOrchestration watches a directory of F5IPAM documents
+	FileOrchestration = "file"
+
+	// DefaultFileInterval of checking the directory for changes
+	DefaultFileInterval = 5 * time.Second
+	// DefaultFileNamespace of the documents without a namespace
+	DefaultFileNamespace = "default"
+
+	// statusSuffix is added to the name of a document for its status file, web.yaml has web.status.yaml
+	statusSuffix = ".status"
+)
+
+// fileLog logs the messages of the file Orchestrator
+var fileLog = log.NewSubsystemLogger("FILE")
+
+func init() {
+	Register(FileOrchestration, func(params Params) (Orchestrator, error) {
+		return NewFileIPAMClient(params.Directory, params.Interval)
+	})
+}
+
+// FileIPAMClient turns the F5IPAM documents of a directory into requests, and writes the Status
+// of each document to a status file next to it. A document holds a single F5IPAM in YAML or
+// JSON, named after the file when it has no name. A removed document releases its allocations.
+type FileIPAMClient struct {
+	directory string
+	interval  time.Duration
+
+	// mutex guards the documents and the queued requests
+	mutex sync.Mutex
+	// Documents by namespace/name
+	documents map[string]*fileDocument
+	// Requests of the responses, which are sent by the routine that checks the directory
+	queued []ipamspec.IPAMRequest
+	wakeCh chan struct{}
+
+	// Restore requests of the startup that are not answered yet
+	restore restoreState
+
+	reqChan  chan<- ipamspec.IPAMRequest
+	respChan <-chan ipamspec.IPAMResponse
+
+	quitCh        chan struct{}
+	stopOnce      sync.Once
+	documentsDone chan struct{}
+	responsesDone chan struct{}
+}
+
+// fileDocument is an F5IPAM read from a file, its Status holds the published allocations
+type fileDocument struct {
+	path string
+	hash [sha256.Size]byte
+	rsc  *ficV1.F5IPAM
+	// removed documents are kept until their allocations are released
+	removed bool
+}
+
+// NewFileIPAMClient creates the client for the directory of F5IPAM documents
+func NewFileIPAMClient(directory string, interval time.Duration) (*FileIPAMClient, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("A directory of F5IPAM documents is required for %v orchestration",
+			FileOrchestration)
+	}
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", directory)
+	}
+	if interval <= 0 {
+		interval = DefaultFileInterval
+	}
+	return &FileIPAMClient{
+		directory:     directory,
+		interval:      interval,
+		documents:     make(map[string]*fileDocument),
+		wakeCh:        make(chan struct{}, 1),
+		quitCh:        make(chan struct{}),
+		documentsDone: make(chan struct{}),
+		responsesDone: make(chan struct{}),
+	}, nil
+}
+
+// SetupCommunicationChannels sets Request and Response channels
+func (fc *FileIPAMClient) SetupCommunicationChannels(
+	reqChan chan<- ipamspec.IPAMRequest,
+	respChan <-chan ipamspec.IPAMResponse,
+) {
+	fc.reqChan = reqChan
+	fc.respChan = respChan
+}
+
+// Start restores the allocations of the status files, and then watches the directory
+func (fc *FileIPAMClient) Start(stopCh <-chan struct{}) {
+	go func() {
+		defer close(fc.responsesDone)
+		for resp := range fc.respChan {
+			fc.processResponse(resp)
+		}
+	}()
+	go func() {
+		defer close(fc.documentsDone)
+		fc.run(stopCh)
+	}()
+	fileLog.Debugf("File Orchestrator Started, watching %v", fc.directory)
+}
+
+// Stop stops watching the directory, and waits for the requests being sent
+func (fc *FileIPAMClient) Stop() {
+	fc.stopOnce.Do(func() { close(fc.quitCh) })
+	<-fc.documentsDone
+	fileLog.Debugf("File Orchestrator Stopped")
+}
+
+// Drained is closed once the responses are published
+func (fc *FileIPAMClient) Drained() <-chan struct{} {
+	return fc.responsesDone
+}
+
+func (fc *FileIPAMClient) run(stopCh <-chan struct{}) {
+	if !fc.restoreAllocations(stopCh) {
+		return
+	}
+	ticker := time.NewTicker(fc.interval)
+	defer ticker.Stop()
+	for {
+		fc.send(fc.sync())
+		fc.send(fc.dequeue())
+		select {
+		case <-fc.quitCh:
+			return
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		case <-fc.wakeCh:
+		}
+	}
+}
+
+func (fc *FileIPAMClient) send(reqs []ipamspec.IPAMRequest) {
+	for _, req := range reqs {
+		withRequest(fileLog, req, req.IPAddr).Debugf("Sending %v request", req.Operation)
+		fc.reqChan <- req
+	}
+}
+
+func (fc *FileIPAMClient) dequeue() []ipamspec.IPAMRequest {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	reqs := fc.queued
+	fc.queued = nil
+	return reqs
+}
+
+// enqueue queues a request to be sent by the routine of the directory, the caller holds the mutex
+func (fc *FileIPAMClient) enqueue(req ipamspec.IPAMRequest) {
+	fc.queued = append(fc.queued, req)
+	select {
+	case fc.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// restoreAllocations requests the IP Addresses in the status files, and waits for all of them
+// to be restored before the documents are read. Allocations of the documents that were removed
+// are released after. It returns false when stopped while waiting.
+func (fc *FileIPAMClient) restoreAllocations(stopCh <-chan struct{}) bool {
+	var reqs []ipamspec.IPAMRequest
+	fc.mutex.Lock()
+	for _, path := range fc.listFiles(true) {
+		rsc, err := readStatus(path)
+		if err != nil {
+			fileLog.Errorf("Unable to read F5IPAM status %v: %v", path, err)
+			continue
+		}
+		if _, ok := fc.documents[keyOf(rsc)]; ok {
+			fileLog.Errorf("Ignoring F5IPAM status %v, %v already has a status", path, keyOf(rsc))
+			continue
+		}
+		docPath := documentPath(path)
+		// The HostSpecs are requested once the document is read again
+		doc := &fileDocument{path: docPath, rsc: rsc, removed: true}
+		if docRsc, err := readDocument(docPath); err == nil && keyOf(docRsc) == keyOf(rsc) {
+			docRsc.Status = rsc.Status
+			doc.rsc, doc.removed = docRsc, false
+		}
+		fc.documents[keyOf(rsc)] = doc
+		for _, ipSpec := range rsc.Status.IPStatus {
+			if ipSpec.IP != "" {
+				reqs = append(reqs, restoreRequest(rsc, ipSpec))
+			}
+		}
+	}
+	for _, doc := range fc.documents {
+		if doc.removed {
+			for _, req := range fc.remove(doc) {
+				fc.enqueue(req)
+			}
+		}
+	}
+	fc.mutex.Unlock()
+	if len(reqs) == 0 {
+		return true
+	}
+
+	doneCh := fc.restore.begin(len(reqs), fileLog)
+	fileLog.Infof("Restoring %v allocations of F5IPAM documents", len(reqs))
+	fc.send(reqs)
+	select {
+	case <-doneCh:
+		return true
+	case <-fc.quitCh:
+	case <-stopCh:
+	}
+	return false
+}
+
+// listFiles lists the F5IPAM documents of the directory, or their status files
+func (fc *FileIPAMClient) listFiles(status bool) []string {
+	files, err := ioutil.ReadDir(fc.directory)
+	if err != nil {
+		fileLog.Errorf("Unable to read directory of F5IPAM documents %v: %v", fc.directory, err)
+		return nil
+	}
+	var paths []string
+	for _, file := range files {
+		name := file.Name()
+		ext := filepath.Ext(name)
+		if file.IsDir() || strings.HasPrefix(name, ".") ||
+			strings.HasSuffix(strings.TrimSuffix(name, ext), statusSuffix) != status {
+			continue
+		}
+		switch ext {
+		case ".yaml", ".yml", ".json":
+			paths = append(paths, filepath.Join(fc.directory, name))
+		}
+	}
+	return paths
+}
+
+// sync reads the changed documents, and returns the requests of their changes
+func (fc *FileIPAMClient) sync() []ipamspec.IPAMRequest {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	var reqs []ipamspec.IPAMRequest
+	byPath := make(map[string]*fileDocument)
+	for _, doc := range fc.documents {
+		if !doc.removed {
+			byPath[doc.path] = doc
+		}
+	}
+
+	present := make(map[string]bool)
+	for _, path := range fc.listFiles(false) {
+		present[path] = true
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fileLog.Errorf("Unable to read F5IPAM document %v: %v", path, err)
+			continue
+		}
+		hash := sha256.Sum256(data)
+		old := byPath[path]
+		if old != nil && old.hash == hash {
+			continue
+		}
+		rsc, err := parseDocument(path, data)
+		if err != nil {
+			fileLog.Errorf("Ignoring F5IPAM document %v: %v", path, err)
+			continue
+		}
+		if old != nil && keyOf(old.rsc) != keyOf(rsc) {
+			// The document now holds another F5IPAM
+			reqs = append(reqs, fc.remove(old)...)
+			old = nil
+		}
+		if doc, ok := fc.documents[keyOf(rsc)]; ok && doc.path != path && !doc.removed {
+			fileLog.Errorf("Ignoring F5IPAM document %v, %v is already defined by %v", path, keyOf(rsc), doc.path)
+			continue
+		}
+
+		doc := fc.documents[keyOf(rsc)]
+		if doc == nil {
+			doc = &fileDocument{path: path, rsc: rsc}
+			fc.documents[keyOf(rsc)] = doc
+		}
+		rsc.Status = doc.rsc.Status
+		if old != nil && old.hash != [sha256.Size]byte{} {
+			reqs = append(reqs, specRequests(rsc, old.rsc.Spec.HostSpecs)...)
+		} else {
+			reqs = append(reqs, staleRequests(rsc)...)
+			reqs = append(reqs, specRequests(rsc, nil)...)
+		}
+		doc.path, doc.hash, doc.rsc, doc.removed = path, hash, rsc, false
+		fileLog.Debugf("Read F5IPAM document %v of %v", path, keyOf(rsc))
+	}
+
+	for path, doc := range byPath {
+		if !present[path] {
+			reqs = append(reqs, fc.remove(doc)...)
+		}
+	}
+	return reqs
+}
+
+// remove marks the document as removed, and returns the requests releasing its allocations.
+// The caller holds the mutex.
+func (fc *FileIPAMClient) remove(doc *fileDocument) []ipamspec.IPAMRequest {
+	fileLog.Debugf("F5IPAM document %v of %v is removed", doc.path, keyOf(doc.rsc))
+	doc.removed = true
+	if len(doc.rsc.Status.IPStatus) == 0 {
+		fc.release(doc)
+		return nil
+	}
+	var reqs []ipamspec.IPAMRequest
+	reclaimPolicy, retainPeriod := reclaimPolicyOf(doc.rsc)
+	for _, ipSpec := range doc.rsc.Status.IPStatus {
+		req := restoreRequest(doc.rsc, ipSpec)
+		req.ReclaimPolicy = reclaimPolicy
+		req.RetainPeriod = retainPeriod
+		req.Operation = ipamspec.DELETE
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// release forgets the removed document once its allocations are released, the caller holds the mutex
+func (fc *FileIPAMClient) release(doc *fileDocument) {
+	if err := os.Remove(statusPath(doc.path)); err != nil && !os.IsNotExist(err) {
+		fileLog.Errorf("Unable to remove status of F5IPAM document %v: %v", doc.path, err)
+	}
+	if fc.documents[keyOf(doc.rsc)] == doc {
+		delete(fc.documents, keyOf(doc.rsc))
+	}
+}
+
+// specRequests returns the requests of the HostSpecs that are added or deleted from the old ones.
+// The HostSpecs that are already allocated are requested again on the first read, which returns
+// their allocations.
+func specRequests(rsc *ficV1.F5IPAM, oldSpecs []*ficV1.HostSpec) []ipamspec.IPAMRequest {
+	oldSpecSet := make(specMap)
+	newSpecSet := make(specMap)
+	for _, hostSpec := range oldSpecs {
+		oldSpecSet[*hostSpec] = true
+	}
+	for _, hostSpec := range rsc.Spec.HostSpecs {
+		newSpecSet[*hostSpec] = true
+	}
+
+	var reqs []ipamspec.IPAMRequest
+	reclaimPolicy, retainPeriod := reclaimPolicyOf(rsc)
+	for _, spec := range sortedSpecs(oldSpecSet) {
+		if !newSpecSet[spec] {
+			reqs = append(reqs, ipamspec.IPAMRequest{
+				Metadata: ResourceMeta{
+					name:      rsc.Name,
+					namespace: rsc.Namespace,
+				},
+				Namespace:     rsc.Namespace,
+				Name:          rsc.Name,
+				HostName:      spec.Host,
+				CIDR:          spec.Cidr,
+				Key:           spec.Key,
+				ReclaimPolicy: reclaimPolicy,
+				RetainPeriod:  retainPeriod,
+				Operation:     ipamspec.DELETE,
+			})
+		}
+	}
+	for _, spec := range sortedSpecs(newSpecSet) {
+		if !oldSpecSet[spec] {
+			reqs = append(reqs, createRequest(rsc, spec))
+		}
+	}
+	return reqs
+}
+
+// staleRequests returns the requests releasing the allocations in the Status of a document
+// that is read for the first time, which none of its HostSpecs claims anymore
+func staleRequests(rsc *ficV1.F5IPAM) []ipamspec.IPAMRequest {
+	var reqs []ipamspec.IPAMRequest
+	reclaimPolicy, retainPeriod := reclaimPolicyOf(rsc)
+	for _, ipSpec := range rsc.Status.IPStatus {
+		claimed := false
+		for _, spec := range rsc.Spec.HostSpecs {
+			if spec.Host == ipSpec.Host && spec.Cidr == ipSpec.Cidr && spec.Key == ipSpec.Key {
+				claimed = true
+			}
+		}
+		if !claimed {
+			req := restoreRequest(rsc, ipSpec)
+			req.ReclaimPolicy = reclaimPolicy
+			req.RetainPeriod = retainPeriod
+			req.Operation = ipamspec.DELETE
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func createRequest(rsc *ficV1.F5IPAM, spec ficV1.HostSpec) ipamspec.IPAMRequest {
+	return ipamspec.IPAMRequest{
+		Metadata: ResourceMeta{
+			name:      rsc.Name,
+			namespace: rsc.Namespace,
+		},
+		Namespace:   rsc.Namespace,
+		Name:        rsc.Name,
+		HostName:    spec.Host,
+		CIDR:        spec.Cidr,
+		Key:         spec.Key,
+		RequestedIP: spec.IP,
+		Operation:   ipamspec.CREATE,
+	}
+}
+
+func sortedSpecs(specs specMap) []ficV1.HostSpec {
+	var sorted []ficV1.HostSpec
+	for spec := range specs {
+		sorted = append(sorted, spec)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+	return sorted
+}
+
+// processResponse records the outcome of the request in the status of its document
+func (fc *FileIPAMClient) processResponse(resp ipamspec.IPAMResponse) {
+	if resp.Request.Operation == ipamspec.CREATE && resp.Request.IPAddr != "" {
+		fc.restore.answered(resp)
+	}
+	metadata := resp.Request.Metadata.(ResourceMeta)
+
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	doc, ok := fc.documents[metadata.namespace+"/"+metadata.name]
+	if !ok {
+		withRequest(fileLog, resp.Request, resp.IPAddr).Debugf("No F5IPAM document of %v/%v for the response of Host",
+			metadata.namespace, metadata.name)
+		return
+	}
+	status := &doc.rsc.Status
+	index := statusIndex(status, resp.Request)
+
+	switch resp.Request.Operation {
+	case ipamspec.CREATE:
+		if doc.removed {
+			// The allocation is rolled back as it is never committed
+			return
+		}
+		if index == -1 {
+			status.IPStatus = append(status.IPStatus, &ficV1.IPSpec{
+				Host: resp.Request.HostName,
+				Cidr: resp.Request.CIDR,
+				Key:  resp.Request.Key,
+			})
+			index = len(status.IPStatus) - 1
+		}
+		entry := status.IPStatus[index]
+		switch {
+		case resp.Status:
+			entry.IP, entry.Status, entry.Reason = resp.IPAddr, "", ""
+		case resp.Reason != "":
+			entry.IP, entry.Status, entry.Reason = "", FailedStatus, resp.Reason
+			if resp.Pending {
+				entry.Status = PendingStatus
+			}
+		default:
+			removeStatus(status, index)
+		}
+		if !fc.writeStatus(doc) || !resp.Status {
+			return
+		}
+		commit := restoreRequest(doc.rsc, entry)
+		commit.Operation = ipamspec.COMMIT
+		fc.enqueue(commit)
+	case ipamspec.COMMIT:
+		if !resp.Status && !doc.removed {
+			// The reservation expired, the allocation is requested again
+			for _, spec := range doc.rsc.Spec.HostSpecs {
+				if spec.Host == resp.Request.HostName && spec.Cidr == resp.Request.CIDR && spec.Key == resp.Request.Key {
+					fc.enqueue(createRequest(doc.rsc, *spec))
+				}
+			}
+		}
+	case ipamspec.DELETE:
+		if index == -1 {
+			return
+		}
+		removeStatus(status, index)
+		if doc.removed && len(status.IPStatus) == 0 {
+			fc.release(doc)
+			return
+		}
+		fc.writeStatus(doc)
+	}
+}
+
+func statusIndex(status *ficV1.F5IPAMStatus, req ipamspec.IPAMRequest) int {
+	for i, ipSpec := range status.IPStatus {
+		if ipSpec.Host == req.HostName && ipSpec.Cidr == req.CIDR && ipSpec.Key == req.Key {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeStatus(status *ficV1.F5IPAMStatus, index int) {
+	status.IPStatus = append(status.IPStatus[:index], status.IPStatus[index+1:]...)
+}
+
+// writeStatus replaces the status file of the document, the caller holds the mutex
+func (fc *FileIPAMClient) writeStatus(doc *fileDocument) bool {
+	path := statusPath(doc.path)
+	// The status names its F5IPAM, which is released even when the document is removed while
+	// the controller is down
+	status := &ficV1.F5IPAM{
+		TypeMeta: metav1.TypeMeta{Kind: "F5IPAM", APIVersion: ficV1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      doc.rsc.Name,
+			Namespace: doc.rsc.Namespace,
+		},
+		Status: doc.rsc.Status,
+	}
+	var data []byte
+	var err error
+	if filepath.Ext(path) == ".json" {
+		data, err = json.MarshalIndent(status, "", "  ")
+	} else {
+		data, err = yaml.Marshal(status)
+	}
+	if err != nil {
+		fileLog.Errorf("Unable to encode status of F5IPAM document %v: %v", doc.path, err)
+		return false
+	}
+
+	// The status is replaced at once, readers never see a partial file
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fileLog.Errorf("Unable to write status of F5IPAM document %v: %v", doc.path, err)
+		return false
+	}
+	fileLog.Debugf("Updated status of F5IPAM document %v", doc.path)
+	return true
+}
+
+func keyOf(rsc *ficV1.F5IPAM) string {
+	return rsc.Namespace + "/" + rsc.Name
+}
+
+// statusPath returns the path of the status file of the document
+func statusPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + statusSuffix + ext
+}
+
+// documentPath returns the path of the document of the status file
+func documentPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, statusSuffix+ext) + ext
+}
+
+func readDocument(path string) (*ficV1.F5IPAM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(path, data)
+}
+
+// parseDocument decodes the F5IPAM of the document, its Status is ignored
+func parseDocument(path string, data []byte) (*ficV1.F5IPAM, error) {
+	rsc := &ficV1.F5IPAM{}
+	if err := yaml.Unmarshal(data, rsc); err != nil {
+		return nil, err
+	}
+	if rsc.Kind != "" && rsc.Kind != "F5IPAM" {
+		return nil, fmt.Errorf("unexpected kind %v", rsc.Kind)
+	}
+	if rsc.Name == "" {
+		rsc.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if rsc.Namespace == "" {
+		rsc.Namespace = DefaultFileNamespace
+	}
+	for _, hostSpec := range rsc.Spec.HostSpecs {
+		if hostSpec == nil || (hostSpec.Host == "" && hostSpec.Key == "") || hostSpec.Cidr == "" {
+			return nil, fmt.Errorf("every host spec needs a host or a key, and a cidr")
+		}
+	}
+	if _, _, err := parseReclaimPolicy(rsc.Spec.ReclaimPolicy, rsc.Spec.RetainPeriod); err != nil {
+		return nil, err
+	}
+	rsc.Status = ficV1.F5IPAMStatus{}
+	return rsc, nil
+}
+
+// readStatus decodes the F5IPAM of the status file, with its name and Status only
+func readStatus(path string) (*ficV1.F5IPAM, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rsc := &ficV1.F5IPAM{}
+	if err = yaml.Unmarshal(data, rsc); err != nil {
+		return nil, err
+	}
+	if rsc.Name == "" || rsc.Namespace == "" {
+		return nil, fmt.Errorf("the status does not name its F5IPAM")
+	}
+	rsc.Spec = ficV1.F5IPAMSpec{}
+	return rsc, nil
+}
@@ -0,0 +1,186 @@
+package orchestration_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/controller"
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	"sigs.k8s.io/yaml"
+)
+
+// fileHarness runs the file Orchestrator and the controller on a directory, with a store that
+// is kept across restarts
+type fileHarness struct {
+	t         *testing.T
+	directory string
+	storePath string
+
+	mgr    manager.Manager
+	ctlr   *controller.Controller
+	stopCh chan struct{}
+}
+
+func newFileHarness(t *testing.T) *fileHarness {
+	h := &fileHarness{
+		t:         t,
+		directory: t.TempDir(),
+		storePath: filepath.Join(t.TempDir(), "store.db"),
+	}
+	h.start()
+	t.Cleanup(h.stop)
+	return h
+}
+
+func (h *fileHarness) start() {
+	var err error
+	h.mgr, err = manager.NewManager(manager.Params{
+		Provider: manager.F5IPAMProvider,
+		IPAMManagerParams: manager.IPAMManagerParams{
+			Range:     testRange,
+			StorePath: h.storePath,
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create Manager: %v", err)
+	}
+	orcr, err := orchestration.NewOrchestrator(orchestration.FileOrchestration, orchestration.Params{
+		Directory: h.directory,
+		Interval:  10 * time.Millisecond,
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create file Orchestrator: %v", err)
+	}
+	h.stopCh = make(chan struct{})
+	h.ctlr = controller.NewController(controller.Spec{
+		Orchestrator: orcr,
+		Manager:      h.mgr,
+		StopCh:       h.stopCh,
+	})
+	h.ctlr.Start()
+}
+
+func (h *fileHarness) stop() {
+	if h.ctlr == nil {
+		return
+	}
+	if !h.ctlr.Stop(waitTimeout) {
+		h.t.Error("Requests are not drained on stop")
+	}
+	h.ctlr = nil
+}
+
+func (h *fileHarness) write(file string, hostSpecs ...*ficV1.HostSpec) {
+	data, err := yaml.Marshal(&ficV1.F5IPAM{Spec: ficV1.F5IPAMSpec{HostSpecs: hostSpecs}})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(h.directory, file), data, 0644); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// waitForIPs waits until the status file has an IP Address for every host, and returns them by host
+func (h *fileHarness) waitForIPs(statusFile string, hosts ...string) map[string]string {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		ips := make(map[string]string)
+		rsc := &ficV1.F5IPAM{}
+		data, err := ioutil.ReadFile(filepath.Join(h.directory, statusFile))
+		if err == nil {
+			err = yaml.Unmarshal(data, rsc)
+		}
+		if err == nil && len(rsc.Status.IPStatus) == len(hosts) {
+			for _, ipSpec := range rsc.Status.IPStatus {
+				if ipSpec.IP != "" {
+					ips[ipSpec.Host] = ipSpec.IP
+				}
+			}
+			if len(ips) == len(hosts) {
+				return ips
+			}
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Status %v is not published, got: %s, error: %v", statusFile, data, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (h *fileHarness) waitForRemoval(statusFile string) {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		if _, err := os.Stat(filepath.Join(h.directory, statusFile)); os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Status %v is not removed", statusFile)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileOrchestration(t *testing.T) {
+	h := newFileHarness(t)
+	h.write("web.yaml", hostSpec("foo.example.com"))
+	ips := h.waitForIPs("web.status.yaml", "foo.example.com")
+
+	h.write("web.yaml", hostSpec("foo.example.com"), hostSpec("bar.example.com"))
+	added := h.waitForIPs("web.status.yaml", "foo.example.com", "bar.example.com")
+	if added["foo.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Existing host changed its IP Address to: %v", added["foo.example.com"])
+	}
+
+	h.write("web.yaml", hostSpec("bar.example.com"))
+	h.waitForIPs("web.status.yaml", "bar.example.com")
+
+	if err := os.Remove(filepath.Join(h.directory, "web.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	h.waitForRemoval("web.status.yaml")
+	if allocations := h.mgr.GetAllocations("bar.example.com"); len(allocations) != 0 {
+		t.Errorf("Allocations of the removed document are not released: %v", allocations)
+	}
+}
+
+func TestFileOrchestrationRestart(t *testing.T) {
+	h := newFileHarness(t)
+	h.write("web.json", hostSpec("foo.example.com"))
+	h.write("api.yaml", hostSpec("bar.example.com"))
+	ips := h.waitForIPs("web.status.json", "foo.example.com")
+	h.waitForIPs("api.status.yaml", "bar.example.com")
+
+	h.stop()
+	// The document removed while the controller is down is released after the restart
+	if err := os.Remove(filepath.Join(h.directory, "api.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	h.waitForRemoval("api.status.yaml")
+	if restored := h.waitForIPs("web.status.json", "foo.example.com"); restored["foo.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Status changed on restart to: %v", restored)
+	}
+	if allocations := h.mgr.GetAllocations("bar.example.com"); len(allocations) != 0 {
+		t.Errorf("Allocations of the removed document are not released: %v", allocations)
+	}
+}
+
+func TestFileOrchestrationKey(t *testing.T) {
+	h := newFileHarness(t)
+	// A host spec with a key and without a host is allocated the IP Address of the key
+	h.write("web.yaml", &ficV1.HostSpec{Key: "shared", Cidr: testCIDR})
+	h.write("api.yaml", &ficV1.HostSpec{Host: "bar.example.com", Key: "shared", Cidr: testCIDR})
+
+	keyIPs := h.waitForIPs("web.status.yaml", "")
+	hostIPs := h.waitForIPs("api.status.yaml", "bar.example.com")
+	if keyIPs[""] != hostIPs["bar.example.com"] {
+		t.Errorf("Host spec of the key got IP Address: %v, expected the shared %v",
+			keyIPs[""], hostIPs["bar.example.com"])
+	}
+}
@@ -0,0 +1,132 @@
+package orchestration
+
+import (
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+)
+
+const (
+	// IPAMFinalizer keeps a deleted F5IPAM until its IP Addresses are released
+	IPAMFinalizer = "fic.f5.com/release-ip-addresses"
+	// ForceRemoveFinalizerAnnotation set to "true" on a deleted F5IPAM removes the finalizer
+	// without waiting for the release of its IP Addresses
+	ForceRemoveFinalizerAnnotation = "fic.f5.com/force-remove-finalizer"
+)
+
+func hasFinalizer(rsc *ficV1.F5IPAM) bool {
+	for _, finalizer := range rsc.Finalizers {
+		if finalizer == IPAMFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func isTerminating(rsc *ficV1.F5IPAM) bool {
+	return rsc.DeletionTimestamp != nil
+}
+
+// addFinalizer adds the finalizer to the F5IPAM, so that it is not removed before
+// its IP Addresses are released
+func (k8sc *K8sIPAMClient) addFinalizer(rsc *ficV1.F5IPAM) {
+	if hasFinalizer(rsc) {
+		return
+	}
+	ipamRsc, err := k8sc.ipamCli.Get(rsc.Namespace, rsc.Name)
+	if err != nil {
+		k8sLog.Errorf("Unable to find F5IPAM: %v/%v to add finalizer. Error: %v", rsc.Namespace, rsc.Name, err)
+		return
+	}
+	if hasFinalizer(ipamRsc) || isTerminating(ipamRsc) {
+		return
+	}
+	ipamRsc.Finalizers = append(ipamRsc.Finalizers, IPAMFinalizer)
+	if _, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc); err != nil {
+		k8sLog.Errorf("Unable to add finalizer to F5IPAM: %v/%v. Error: %v", rsc.Namespace, rsc.Name, err)
+	}
+}
+
+// removeFinalizer lets the deleted F5IPAM be removed
+func (k8sc *K8sIPAMClient) removeFinalizer(rsc *ficV1.F5IPAM) {
+	ipamRsc, err := k8sc.ipamCli.Get(rsc.Namespace, rsc.Name)
+	if err != nil {
+		k8sLog.Errorf("Unable to find F5IPAM: %v/%v to remove finalizer. Error: %v", rsc.Namespace, rsc.Name, err)
+		return
+	}
+	var finalizers []string
+	for _, finalizer := range ipamRsc.Finalizers {
+		if finalizer != IPAMFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if len(finalizers) == len(ipamRsc.Finalizers) {
+		return
+	}
+	ipamRsc.Finalizers = finalizers
+	if _, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc); err != nil {
+		k8sLog.Errorf("Unable to remove finalizer of F5IPAM: %v/%v. Error: %v", rsc.Namespace, rsc.Name, err)
+		return
+	}
+	k8sLog.Debugf("Removed finalizer of F5IPAM: %v/%v", rsc.Namespace, rsc.Name)
+}
+
+// finalize releases the allocations of the deleted F5IPAM. The finalizer is removed once the
+// Status has no entries left, each of them is removed with the response of its release.
+// Releases of entries are idempotent, they are requested again on every update.
+func (k8sc *K8sIPAMClient) finalize(rsc *ficV1.F5IPAM) {
+	if !hasFinalizer(rsc) {
+		return
+	}
+	if rsc.Annotations[ForceRemoveFinalizerAnnotation] == "true" {
+		k8sLog.Warningf("Force removing finalizer of F5IPAM: %v/%v, its IP Addresses are not released",
+			rsc.Namespace, rsc.Name)
+		k8sc.removeFinalizer(rsc)
+		return
+	}
+	if len(rsc.Status.IPStatus) == 0 {
+		k8sc.removeFinalizer(rsc)
+		return
+	}
+
+	for _, req := range releaseRequests(rsc) {
+		k8sc.sendRequest(req)
+	}
+}
+
+// releaseRequests are the DELETE requests of a deleted F5IPAM, for every entry of its Status
+// whether it has an IP Address or is pending or failed, and for the HostSpecs without an entry
+// yet, so that none of its hosts stays allocated or waits for one
+func releaseRequests(rsc *ficV1.F5IPAM) []ipamspec.IPAMRequest {
+	reclaimPolicy, retainPeriod := reclaimPolicyOf(rsc)
+	request := func(host, cidr, key, ipAddr string) ipamspec.IPAMRequest {
+		return ipamspec.IPAMRequest{
+			Metadata: ResourceMeta{
+				name:      rsc.Name,
+				namespace: rsc.Namespace,
+			},
+			Namespace:     rsc.Namespace,
+			Name:          rsc.Name,
+			HostName:      host,
+			CIDR:          cidr,
+			Key:           key,
+			IPAddr:        ipAddr,
+			ReclaimPolicy: reclaimPolicy,
+			RetainPeriod:  retainPeriod,
+			Operation:     ipamspec.DELETE,
+		}
+	}
+
+	var reqs []ipamspec.IPAMRequest
+	inStatus := make(map[ipamspec.AllocationKey]bool)
+	for _, ipSpec := range rsc.Status.IPStatus {
+		req := request(ipSpec.Host, ipSpec.Cidr, ipSpec.Key, ipSpec.IP)
+		inStatus[req.AllocationKey()] = true
+		reqs = append(reqs, req)
+	}
+	for _, hostSpec := range rsc.Spec.HostSpecs {
+		if req := request(hostSpec.Host, hostSpec.Cidr, hostSpec.Key, ""); !inStatus[req.AllocationKey()] {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
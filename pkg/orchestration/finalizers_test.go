@@ -0,0 +1,142 @@
+package orchestration_test
+
+import (
+	"testing"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const otherFinalizer = "example.com/other"
+
+func hasIPAMFinalizer(rsc *ficV1.F5IPAM) bool {
+	for _, finalizer := range rsc.Finalizers {
+		if finalizer == orchestration.IPAMFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFinalizer(t *testing.T) {
+	tests := []struct {
+		name string
+		// stopped deletes the F5IPAM while the controller is down
+		stopped bool
+		// forced removes the finalizer by annotation
+		forced bool
+		// other finalizers of the F5IPAM are kept
+		other    bool
+		released bool
+	}{
+		{name: "released on deletion", released: true},
+		{name: "released after deletion while stopped", stopped: true, released: true},
+		{name: "other finalizers are kept", other: true, released: true},
+		{name: "force removed", forced: true},
+		{name: "force removed while stopped", stopped: true, forced: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.create("web", hostSpec("foo.example.com"))
+			ips := h.waitForIPs("web", "foo.example.com")
+			h.waitFor("web", hasIPAMFinalizer)
+
+			if test.stopped {
+				h.stop()
+			}
+			rsc := h.get("web")
+			now := metav1.Now()
+			rsc.DeletionTimestamp = &now
+			if test.forced {
+				rsc.Annotations = map[string]string{orchestration.ForceRemoveFinalizerAnnotation: "true"}
+			}
+			if test.other {
+				rsc.Finalizers = append(rsc.Finalizers, otherFinalizer)
+			}
+			if _, err := h.crClient.K8sV1().F5IPAMs(orchestration.DefaultNamespace).Update(rsc); err != nil {
+				t.Fatalf("Unable to mark F5IPAM for deletion: %v", err)
+			}
+			if test.stopped {
+				h.create("api", hostSpec("bar.example.com"))
+				h.start()
+			}
+
+			rsc = h.waitFor("web", func(rsc *ficV1.F5IPAM) bool { return !hasIPAMFinalizer(rsc) })
+			if test.stopped {
+				// The IP Address of the deleted F5IPAM is restored, and kept until it is released
+				apiIPs := h.waitForIPs("api", "bar.example.com")
+				if allocations := h.mgr.GetAllocationsOfIP(apiIPs["bar.example.com"]); len(allocations) != 1 {
+					t.Errorf("IP Address: %v is allocated more than once: %v", apiIPs["bar.example.com"], allocations)
+				}
+				if !test.released && apiIPs["bar.example.com"] == ips["foo.example.com"] {
+					t.Errorf("IP Address: %v of the deleted F5IPAM is allocated again", ips["foo.example.com"])
+				}
+			}
+			if test.other && (len(rsc.Finalizers) != 1 || rsc.Finalizers[0] != otherFinalizer) {
+				t.Errorf("Finalizers got: %v, expected: %v", rsc.Finalizers, []string{otherFinalizer})
+			}
+			allocations := h.mgr.GetAllocations("foo.example.com")
+			if released := len(allocations) == 0; released != test.released {
+				t.Errorf("IP Address is released: %v, expected: %v, allocations: %v", released, test.released, allocations)
+			}
+			if test.released && len(rsc.Status.IPStatus) != 0 {
+				t.Errorf("Status of the released F5IPAM: %v", statusOf(rsc))
+			}
+		})
+	}
+}
+
+func TestDeletePending(t *testing.T) {
+	tests := []struct {
+		name string
+		// forced removes the finalizer by annotation, the F5IPAM is released once it is deleted
+		forced bool
+	}{
+		{name: "released by the finalizer"},
+		{name: "released on deletion", forced: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			pool := &ficV1.F5IPAMPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+				Spec:       ficV1.F5IPAMPoolSpec{Cidr: testPoolCIDR, Ranges: []string{"10.20.20.1-10.20.20.1"}},
+			}
+			if _, err := h.crClient.K8sV1().F5IPAMPools().Create(pool); err != nil {
+				t.Fatalf("Unable to create F5IPAMPool: %v", err)
+			}
+			h.waitForPool("pool", func(pool *ficV1.F5IPAMPool) bool {
+				cond := poolCondition(pool, orchestration.PoolAccepted)
+				return cond != nil && cond.Status == orchestration.ConditionTrue
+			})
+
+			h.create("web", &ficV1.HostSpec{Host: "foo.example.com", Cidr: testPoolCIDR})
+			ips := h.waitForIPs("web", "foo.example.com")
+			h.create("api", &ficV1.HostSpec{Host: "bar.example.com", Cidr: testPoolCIDR})
+			h.waitFor("api", func(rsc *ficV1.F5IPAM) bool {
+				return len(rsc.Status.IPStatus) == 1 && rsc.Status.IPStatus[0].Status == orchestration.PendingStatus
+			})
+
+			if test.forced {
+				rsc := h.get("api")
+				rsc.Annotations = map[string]string{orchestration.ForceRemoveFinalizerAnnotation: "true"}
+				if _, err := h.crClient.K8sV1().F5IPAMs(orchestration.DefaultNamespace).Update(rsc); err != nil {
+					t.Fatalf("Unable to annotate F5IPAM: %v", err)
+				}
+			}
+			h.delete("api")
+
+			// The pending host of the deleted F5IPAM is not served the released IP Address
+			h.delete("web")
+			h.create("app", &ficV1.HostSpec{Host: "baz.example.com", Cidr: testPoolCIDR})
+			if appIPs := h.waitForIPs("app", "baz.example.com"); appIPs["baz.example.com"] != ips["foo.example.com"] {
+				t.Errorf("New host got: %v, expected the released IP Address: %v", appIPs, ips["foo.example.com"])
+			}
+			if allocations := h.mgr.GetAllocations("bar.example.com"); len(allocations) != 0 {
+				t.Errorf("Host of the deleted F5IPAM is allocated: %v", allocations)
+			}
+		})
+	}
+}
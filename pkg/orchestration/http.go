@@ -0,0 +1,742 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/admin"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+const (
+	// HTTPOrchestration serves a REST API to request, look up and release IP Addresses
+	HTTPOrchestration = "http"
+
+	// DefaultHTTPAddress the http Orchestrator listens on
+	DefaultHTTPAddress = ":8443"
+	// DefaultHTTPNamespace of the requests without a namespace
+	DefaultHTTPNamespace = "default"
+	// AllocatedStatus of an allocation that holds its IP Address
+	AllocatedStatus = "Allocated"
+
+	// IdempotencyKeyHeader carries the key that makes a retried request safe, every request that
+	// changes allocations needs one
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyTTL is how long the result of a request is replayed for its idempotency key
+	IdempotencyKeyTTL = 24 * time.Hour
+
+	// httpRequestTimeout to wait for the controller to answer a request, the handlers that are
+	// still waiting on shutdown end before the default shutdown timeout of the controller
+	httpRequestTimeout     = 20 * time.Second
+	maxIdempotencyKeyLen   = 255
+	maxAllocationBodyBytes = 1 << 20
+)
+
+// httpLog logs the messages of the http Orchestrator
+var httpLog = log.NewSubsystemLogger("HTTP")
+
+func init() {
+	Register(HTTPOrchestration, func(params Params) (Orchestrator, error) {
+		return NewHTTPIPAMClient(params)
+	})
+}
+
+// HTTPIPAMClient serves a REST API to request, look up and release the IP Address of a host
+// in a pool. Clients authenticate with a bearer token, and the requests that change allocations
+// need an idempotency key. The allocations are kept in a state file, which restores them on startup.
+type HTTPIPAMClient struct {
+	listener  net.Listener
+	server    *http.Server
+	stateFile string
+	certFile  string
+	keyFile   string
+
+	// mutex guards the allocations, the waiters and the queued requests
+	mutex       sync.Mutex
+	allocations map[ipamspec.AllocationKey]*HTTPAllocation
+	// Handlers waiting for the response of their request, by the ID of the request
+	waiters map[uint64]chan ipamspec.IPAMResponse
+	lastID  uint64
+	// Requests of the responses, which are sent by the routine of the client
+	queued []ipamspec.IPAMRequest
+	wakeCh chan struct{}
+
+	idempotency idempotencyCache
+
+	// Restore requests of the startup that are not answered yet
+	restore restoreState
+
+	reqChan  chan<- ipamspec.IPAMRequest
+	respChan <-chan ipamspec.IPAMResponse
+
+	quitCh        chan struct{}
+	stopOnce      sync.Once
+	sendingDone   chan struct{}
+	responsesDone chan struct{}
+}
+
+// HTTPAllocation is an allocation requested through the REST API
+type HTTPAllocation struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Host      string `json:"host,omitempty"`
+	CIDR      string `json:"cidr"`
+	// Key shares the IP Address among the allocations with the same key and cidr in a
+	// namespace, an allocation of a key needs no host
+	Key string `json:"key,omitempty"`
+	// RequestedIP is the IP Address asked for, it is allocated exactly or not at all
+	RequestedIP string `json:"requestedIP,omitempty"`
+
+	IP     string `json:"ip,omitempty"`
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Position of a Pending allocation in the queue of its pool
+	Position int `json:"position,omitempty"`
+}
+
+// httpRequestMeta identifies the request of a handler, requests of the client itself have no ID
+type httpRequestMeta struct {
+	id uint64
+}
+
+// httpState is the content of the state file
+type httpState struct {
+	Allocations []*HTTPAllocation `json:"allocations"`
+}
+
+type httpError struct {
+	Error string `json:"error"`
+}
+
+// NewHTTPIPAMClient creates the client, listening on the address of the params
+func NewHTTPIPAMClient(params Params) (*HTTPIPAMClient, error) {
+	if params.TokenFile == "" {
+		return nil, fmt.Errorf("A file of bearer tokens is required for %v orchestration", HTTPOrchestration)
+	}
+	// Only the state file knows the allocations of the REST API after a restart
+	if params.StateFile == "" {
+		return nil, fmt.Errorf("A state file is required for %v orchestration", HTTPOrchestration)
+	}
+	tokens, err := admin.ReadTokens(params.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if (params.TLSCertFile == "") != (params.TLSKeyFile == "") {
+		return nil, fmt.Errorf("Both a TLS certificate and a key are required to serve over TLS")
+	}
+	address := params.Address
+	if address == "" {
+		address = DefaultHTTPAddress
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := &HTTPIPAMClient{
+		listener:      listener,
+		stateFile:     params.StateFile,
+		certFile:      params.TLSCertFile,
+		keyFile:       params.TLSKeyFile,
+		allocations:   make(map[ipamspec.AllocationKey]*HTTPAllocation),
+		waiters:       make(map[uint64]chan ipamspec.IPAMResponse),
+		wakeCh:        make(chan struct{}, 1),
+		idempotency:   idempotencyCache{entries: make(map[string]*idempotentEntry)},
+		quitCh:        make(chan struct{}),
+		sendingDone:   make(chan struct{}),
+		responsesDone: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/allocations", admin.RequireToken(tokens, http.HandlerFunc(hc.serveAllocations)))
+	mux.HandleFunc("/v1/openapi.json", serveOpenAPI)
+	hc.server = &http.Server{Handler: mux}
+	return hc, nil
+}
+
+// Addr returns the address the client listens on
+func (hc *HTTPIPAMClient) Addr() net.Addr {
+	return hc.listener.Addr()
+}
+
+// SetupCommunicationChannels sets Request and Response channels
+func (hc *HTTPIPAMClient) SetupCommunicationChannels(
+	reqChan chan<- ipamspec.IPAMRequest,
+	respChan <-chan ipamspec.IPAMResponse,
+) {
+	hc.reqChan = reqChan
+	hc.respChan = respChan
+}
+
+// Start restores the allocations of the state file, and then serves the REST API
+func (hc *HTTPIPAMClient) Start(stopCh <-chan struct{}) {
+	go func() {
+		defer close(hc.responsesDone)
+		for resp := range hc.respChan {
+			hc.processResponse(resp)
+		}
+	}()
+	go func() {
+		defer close(hc.sendingDone)
+		hc.run(stopCh)
+	}()
+	httpLog.Debugf("HTTP Orchestrator Started, listening on %v", hc.Addr())
+}
+
+// Stop stops serving the REST API, and waits for the requests being handled
+func (hc *HTTPIPAMClient) Stop() {
+	hc.stopOnce.Do(func() { close(hc.quitCh) })
+	if err := hc.server.Shutdown(context.Background()); err != nil {
+		httpLog.Errorf("Unable to shut down the REST API: %v", err)
+	}
+	// The listener is not closed by Shutdown when it is not served yet
+	_ = hc.listener.Close()
+	<-hc.sendingDone
+	httpLog.Debugf("HTTP Orchestrator Stopped")
+}
+
+// Drained is closed once the responses are recorded
+func (hc *HTTPIPAMClient) Drained() <-chan struct{} {
+	return hc.responsesDone
+}
+
+func (hc *HTTPIPAMClient) run(stopCh <-chan struct{}) {
+	if !hc.restoreAllocations(stopCh) {
+		return
+	}
+	go func() {
+		var err error
+		if hc.certFile != "" {
+			err = hc.server.ServeTLS(hc.listener, hc.certFile, hc.keyFile)
+		} else {
+			err = hc.server.Serve(hc.listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			httpLog.Errorf("Unable to serve the REST API: %v", err)
+		}
+	}()
+	for {
+		hc.send(hc.dequeue())
+		select {
+		case <-hc.quitCh:
+		case <-stopCh:
+		case <-hc.wakeCh:
+			continue
+		}
+		// The requests of the last responses are sent before stopping
+		hc.send(hc.dequeue())
+		return
+	}
+}
+
+func (hc *HTTPIPAMClient) send(reqs []ipamspec.IPAMRequest) {
+	for _, req := range reqs {
+		withRequest(httpLog, req, req.IPAddr).Debugf("Sending %v request", req.Operation)
+		hc.reqChan <- req
+	}
+}
+
+func (hc *HTTPIPAMClient) dequeue() []ipamspec.IPAMRequest {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	reqs := hc.queued
+	hc.queued = nil
+	return reqs
+}
+
+// enqueue queues a request to be sent by the routine of the client, the caller holds the mutex
+func (hc *HTTPIPAMClient) enqueue(req ipamspec.IPAMRequest) {
+	hc.queued = append(hc.queued, req)
+	select {
+	case hc.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// restoreAllocations requests the IP Addresses in the state file, and waits for all of them
+// to be restored before the REST API is served. Pending allocations are requested again after.
+// It returns false when stopped while waiting.
+func (hc *HTTPIPAMClient) restoreAllocations(stopCh <-chan struct{}) bool {
+	data, err := ioutil.ReadFile(hc.stateFile)
+	if os.IsNotExist(err) {
+		return true
+	}
+	state := &httpState{}
+	if err == nil {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil {
+		httpLog.Errorf("Unable to read state of the REST API %v: %v", hc.stateFile, err)
+		return true
+	}
+
+	var reqs []ipamspec.IPAMRequest
+	hc.mutex.Lock()
+	for _, allocation := range state.Allocations {
+		req := allocation.request(ipamspec.CREATE)
+		hc.allocations[req.AllocationKey()] = allocation
+		switch allocation.Status {
+		case AllocatedStatus:
+			req.IPAddr = allocation.IP
+			reqs = append(reqs, req)
+		case PendingStatus:
+			hc.enqueue(req)
+		}
+	}
+	hc.mutex.Unlock()
+	if len(reqs) == 0 {
+		return true
+	}
+
+	doneCh := hc.restore.begin(len(reqs), httpLog)
+	httpLog.Infof("Restoring %v allocations of the REST API", len(reqs))
+	hc.send(reqs)
+	select {
+	case <-doneCh:
+		return true
+	case <-hc.quitCh:
+	case <-stopCh:
+	}
+	return false
+}
+
+// request returns the request of the operation on the allocation
+func (allocation *HTTPAllocation) request(operation string) ipamspec.IPAMRequest {
+	return ipamspec.IPAMRequest{
+		Metadata:    httpRequestMeta{},
+		Namespace:   allocation.Namespace,
+		Name:        allocation.Name,
+		HostName:    allocation.Host,
+		CIDR:        allocation.CIDR,
+		Key:         allocation.Key,
+		RequestedIP: allocation.RequestedIP,
+		Operation:   operation,
+	}
+}
+
+// processResponse records the outcome of the request, and hands it to the handler waiting for it
+func (hc *HTTPIPAMClient) processResponse(resp ipamspec.IPAMResponse) {
+	if resp.Request.Operation == ipamspec.CREATE && resp.Request.IPAddr != "" {
+		hc.restore.answered(resp)
+	}
+	key := resp.Request.AllocationKey()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	allocation := hc.allocations[key]
+	switch resp.Request.Operation {
+	case ipamspec.CREATE:
+		if allocation == nil {
+			allocation = &HTTPAllocation{
+				Namespace:   key.Namespace,
+				Name:        key.Name,
+				Host:        key.HostName,
+				CIDR:        key.CIDR,
+				Key:         key.Key,
+				RequestedIP: resp.Request.RequestedIP,
+			}
+			hc.allocations[key] = allocation
+		}
+		switch {
+		case resp.Status:
+			allocation.IP, allocation.Status, allocation.Reason, allocation.Position =
+				resp.IPAddr, AllocatedStatus, "", 0
+		case resp.Pending:
+			allocation.IP, allocation.Status, allocation.Reason, allocation.Position =
+				"", PendingStatus, resp.Reason, resp.Position
+		default:
+			allocation.IP, allocation.Status, allocation.Reason, allocation.Position =
+				"", FailedStatus, resp.Reason, 0
+		}
+		if hc.writeState() && resp.Status {
+			commit := allocation.request(ipamspec.COMMIT)
+			commit.IPAddr = allocation.IP
+			hc.enqueue(commit)
+		}
+	case ipamspec.COMMIT:
+		if !resp.Status && allocation != nil && allocation.Status == AllocatedStatus {
+			// The reservation expired, the allocation is requested again
+			hc.enqueue(allocation.request(ipamspec.CREATE))
+		}
+	case ipamspec.DELETE:
+		if resp.Status && allocation != nil {
+			delete(hc.allocations, key)
+			hc.writeState()
+		}
+	}
+
+	metadata, _ := resp.Request.Metadata.(httpRequestMeta)
+	if respCh, ok := hc.waiters[metadata.id]; ok && metadata.id != 0 {
+		delete(hc.waiters, metadata.id)
+		respCh <- resp
+	}
+}
+
+// writeState replaces the state file with the allocations, the caller holds the mutex.
+// Failed allocations are not kept.
+func (hc *HTTPIPAMClient) writeState() bool {
+	state := &httpState{Allocations: []*HTTPAllocation{}}
+	for _, allocation := range hc.sortedAllocations(nil) {
+		if allocation.Status != FailedStatus {
+			state.Allocations = append(state.Allocations, allocation)
+		}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		httpLog.Errorf("Unable to encode state of the REST API: %v", err)
+		return false
+	}
+
+	// The state is replaced at once, a crash never leaves a partial file
+	tmp := filepath.Join(filepath.Dir(hc.stateFile), "."+filepath.Base(hc.stateFile)+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, hc.stateFile)
+	}
+	if err != nil {
+		httpLog.Errorf("Unable to write state of the REST API %v: %v", hc.stateFile, err)
+		return false
+	}
+	return true
+}
+
+// sortedAllocations returns the allocations that match the filter, all of them when it is nil.
+// The caller holds the mutex.
+func (hc *HTTPIPAMClient) sortedAllocations(match func(*HTTPAllocation) bool) []*HTTPAllocation {
+	var allocations []*HTTPAllocation
+	for _, allocation := range hc.allocations {
+		if match == nil || match(allocation) {
+			copied := *allocation
+			allocations = append(allocations, &copied)
+		}
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		a, b := allocations[i], allocations[j]
+		return fmt.Sprint(a.Namespace, a.Name, a.Host, a.CIDR, a.Key) <
+			fmt.Sprint(b.Namespace, b.Name, b.Host, b.CIDR, b.Key)
+	})
+	return allocations
+}
+
+// call sends the request of a handler, and waits for its response. It returns false when the
+// controller does not answer in time.
+func (hc *HTTPIPAMClient) call(req ipamspec.IPAMRequest) (ipamspec.IPAMResponse, bool) {
+	respCh := make(chan ipamspec.IPAMResponse, 1)
+	hc.mutex.Lock()
+	hc.lastID++
+	id := hc.lastID
+	hc.waiters[id] = respCh
+	hc.mutex.Unlock()
+
+	req.Metadata = httpRequestMeta{id: id}
+	withRequest(httpLog, req, req.IPAddr).Debugf("Sending %v request", req.Operation)
+	hc.reqChan <- req
+
+	timer := time.NewTimer(httpRequestTimeout)
+	defer timer.Stop()
+	select {
+	case resp := <-respCh:
+		return resp, true
+	case <-timer.C:
+		hc.mutex.Lock()
+		delete(hc.waiters, id)
+		hc.mutex.Unlock()
+		return ipamspec.IPAMResponse{}, false
+	}
+}
+
+// serveAllocations serves the requests that RequireToken let through, the results of the requests
+// that change allocations are kept for the index of their token
+func (hc *HTTPIPAMClient) serveAllocations(w http.ResponseWriter, r *http.Request) {
+	client := admin.TokenIndex(r)
+	switch r.Method {
+	case http.MethodGet:
+		hc.listAllocations(w, r)
+	case http.MethodPost:
+		hc.requestAllocation(w, r, client)
+	case http.MethodDelete:
+		hc.releaseAllocation(w, r, client)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, httpError{Error: "method not allowed"})
+	}
+}
+
+// listAllocations returns the allocations that match the query, every parameter that is given
+// has to match
+func (hc *HTTPIPAMClient) listAllocations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	matches := func(param, value string) bool {
+		values, ok := query[param]
+		return !ok || values[0] == value
+	}
+
+	hc.mutex.Lock()
+	allocations := hc.sortedAllocations(func(allocation *HTTPAllocation) bool {
+		return matches("namespace", allocation.Namespace) && matches("name", allocation.Name) &&
+			matches("host", allocation.Host) && matches("cidr", allocation.CIDR) &&
+			matches("key", allocation.Key)
+	})
+	hc.mutex.Unlock()
+
+	if allocations == nil {
+		allocations = []*HTTPAllocation{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Allocations []*HTTPAllocation `json:"allocations"`
+	}{allocations})
+}
+
+// requestAllocation requests the IP Address of the allocation in the body. It is answered
+// with 200 once allocated, 202 while it waits in the queue of an exhausted pool and 409 when
+// it can not be allocated.
+func (hc *HTTPIPAMClient) requestAllocation(w http.ResponseWriter, r *http.Request, client int) {
+	// Only the fields of the request are accepted
+	input := &struct {
+		Namespace   string `json:"namespace"`
+		Name        string `json:"name"`
+		Host        string `json:"host"`
+		CIDR        string `json:"cidr"`
+		Key         string `json:"key"`
+		RequestedIP string `json:"requestedIP"`
+	}{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAllocationBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(input); err != nil {
+		writeJSON(w, http.StatusBadRequest, httpError{Error: fmt.Sprintf("invalid allocation: %v", err)})
+		return
+	}
+	allocation := &HTTPAllocation{
+		Namespace:   input.Namespace,
+		Name:        input.Name,
+		Host:        input.Host,
+		CIDR:        input.CIDR,
+		Key:         input.Key,
+		RequestedIP: input.RequestedIP,
+	}
+	if err := validateAllocation(allocation); err != nil {
+		writeJSON(w, http.StatusBadRequest, httpError{Error: err.Error()})
+		return
+	}
+	fingerprint, _ := json.Marshal(allocation)
+
+	hc.idempotent(w, r, client, string(fingerprint), func() (int, interface{}) {
+		resp, ok := hc.call(allocation.request(ipamspec.CREATE))
+		if !ok {
+			return http.StatusGatewayTimeout, httpError{Error: "the allocation is not answered in time"}
+		}
+		allocation.IP, allocation.Reason, allocation.Position = resp.IPAddr, resp.Reason, resp.Position
+		switch {
+		case resp.Status:
+			allocation.Status = AllocatedStatus
+			return http.StatusOK, allocation
+		case resp.Pending:
+			allocation.Status = PendingStatus
+			return http.StatusAccepted, allocation
+		}
+		allocation.Status = FailedStatus
+		if allocation.Reason == "" {
+			allocation.Reason = "unable to allocate an IP Address"
+		}
+		return http.StatusConflict, allocation
+	})
+}
+
+// releaseAllocation releases the allocation named by the query, with the optional reclaimPolicy
+// and retainPeriod of the IP Address
+func (hc *HTTPIPAMClient) releaseAllocation(w http.ResponseWriter, r *http.Request, client int) {
+	query := r.URL.Query()
+	allocation := &HTTPAllocation{
+		Namespace: query.Get("namespace"),
+		Name:      query.Get("name"),
+		Host:      query.Get("host"),
+		CIDR:      query.Get("cidr"),
+		Key:       query.Get("key"),
+	}
+	if err := validateAllocation(allocation); err != nil {
+		writeJSON(w, http.StatusBadRequest, httpError{Error: err.Error()})
+		return
+	}
+	reclaimPolicy, retainPeriod, err := parseReclaimPolicy(query.Get("reclaimPolicy"), query.Get("retainPeriod"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, httpError{Error: err.Error()})
+		return
+	}
+	req := allocation.request(ipamspec.DELETE)
+	req.ReclaimPolicy = reclaimPolicy
+	req.RetainPeriod = retainPeriod
+	fingerprint, _ := json.Marshal(req)
+
+	hc.idempotent(w, r, client, string(fingerprint), func() (int, interface{}) {
+		hc.mutex.Lock()
+		existing, ok := hc.allocations[req.AllocationKey()]
+		if ok {
+			allocation = &HTTPAllocation{}
+			*allocation = *existing
+		}
+		hc.mutex.Unlock()
+		if !ok {
+			return http.StatusNotFound, httpError{Error: "no such allocation"}
+		}
+
+		resp, ok := hc.call(req)
+		if !ok {
+			return http.StatusGatewayTimeout, httpError{Error: "the release is not answered in time"}
+		}
+		if !resp.Status {
+			reason := resp.Reason
+			if reason == "" {
+				reason = "unable to release the allocation"
+			}
+			return http.StatusConflict, httpError{Error: reason}
+		}
+		return http.StatusOK, allocation
+	})
+}
+
+func validateAllocation(allocation *HTTPAllocation) error {
+	if allocation.Namespace == "" {
+		allocation.Namespace = DefaultHTTPNamespace
+	}
+	if allocation.Name == "" || (allocation.Host == "" && allocation.Key == "") || allocation.CIDR == "" {
+		return fmt.Errorf("an allocation needs a name, a host or a key, and a cidr")
+	}
+	if _, _, err := net.ParseCIDR(allocation.CIDR); err != nil {
+		return fmt.Errorf("invalid cidr: %v", allocation.CIDR)
+	}
+	if allocation.RequestedIP != "" && net.ParseIP(allocation.RequestedIP) == nil {
+		return fmt.Errorf("invalid requestedIP: %v", allocation.RequestedIP)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		httpLog.Errorf("Unable to encode response of the REST API: %v", err)
+		code, data = http.StatusInternalServerError, []byte(`{"error":"internal error"}`)
+	}
+	writeResult(w, httpResult{code: code, body: data})
+}
+
+func writeResult(w http.ResponseWriter, result httpResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(result.code)
+	_, _ = w.Write(result.body)
+}
+
+// httpResult is a response of the REST API, kept to be replayed for its idempotency key
+type httpResult struct {
+	code int
+	body []byte
+}
+
+// idempotencyCache holds the results of the requests by the client and their idempotency key
+type idempotencyCache struct {
+	sync.Mutex
+	entries map[string]*idempotentEntry
+}
+
+type idempotentEntry struct {
+	// fingerprint of the request, a key can not be reused for another request
+	fingerprint string
+	created     time.Time
+	// done is closed once the result is known
+	done   chan struct{}
+	result httpResult
+}
+
+// idempotent handles the request once for its idempotency key, and replays the result when it
+// is retried. Results of server errors are not kept, so the retries are handled again.
+func (hc *HTTPIPAMClient) idempotent(
+	w http.ResponseWriter,
+	r *http.Request,
+	client int,
+	fingerprint string,
+	handle func() (int, interface{}),
+) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		writeJSON(w, http.StatusBadRequest, httpError{
+			Error: fmt.Sprintf("an %v header of at most %v characters is required",
+				IdempotencyKeyHeader, maxIdempotencyKeyLen),
+		})
+		return
+	}
+	key = fmt.Sprintf("%d/%s", client, key)
+	fingerprint = r.Method + " " + fingerprint
+
+	entry, owned := hc.idempotency.claim(key, fingerprint)
+	if !owned {
+		if entry.fingerprint != fingerprint {
+			writeJSON(w, http.StatusUnprocessableEntity, httpError{
+				Error: fmt.Sprintf("the %v is already used for another request", IdempotencyKeyHeader),
+			})
+			return
+		}
+		select {
+		case <-entry.done:
+			w.Header().Set("Idempotent-Replayed", "true")
+			writeResult(w, entry.result)
+		default:
+			writeJSON(w, http.StatusConflict, httpError{
+				Error: fmt.Sprintf("a request with the %v is in progress", IdempotencyKeyHeader),
+			})
+		}
+		return
+	}
+
+	code, body := handle()
+	data, err := json.Marshal(body)
+	if err != nil {
+		httpLog.Errorf("Unable to encode response of the REST API: %v", err)
+		code, data = http.StatusInternalServerError, []byte(`{"error":"internal error"}`)
+	}
+	hc.idempotency.complete(key, entry, httpResult{code: code, body: data})
+	writeResult(w, entry.result)
+}
+
+// claim returns the entry of the key, and whether it is new and the caller handles the request
+func (cache *idempotencyCache) claim(key, fingerprint string) (*idempotentEntry, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	now := time.Now()
+	for k, entry := range cache.entries {
+		select {
+		case <-entry.done:
+			if now.Sub(entry.created) > IdempotencyKeyTTL {
+				delete(cache.entries, k)
+			}
+		default:
+		}
+	}
+	if entry, ok := cache.entries[key]; ok {
+		return entry, false
+	}
+	entry := &idempotentEntry{fingerprint: fingerprint, created: now, done: make(chan struct{})}
+	cache.entries[key] = entry
+	return entry, true
+}
+
+// complete records the result of the entry, and forgets the key when it is a server error
+func (cache *idempotencyCache) complete(key string, entry *idempotentEntry, result httpResult) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	entry.result = result
+	close(entry.done)
+	if result.code >= http.StatusInternalServerError && cache.entries[key] == entry {
+		delete(cache.entries, key)
+	}
+}
@@ -0,0 +1,213 @@
+package orchestration
+
+import "net/http"
+
+// openAPI describes the REST API of the http Orchestrator in OpenAPI 3
+const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "F5 IPAM Controller",
+    "description": "Requests, looks up and releases the IP Address of a host in a pool.",
+    "version": "v1"
+  },
+  "security": [{"bearerAuth": []}],
+  "paths": {
+    "/v1/allocations": {
+      "get": {
+        "summary": "Look up allocations",
+        "description": "Returns the allocations that match every query parameter that is given.",
+        "parameters": [
+          {"$ref": "#/components/parameters/namespace"},
+          {"$ref": "#/components/parameters/name"},
+          {"$ref": "#/components/parameters/host"},
+          {"$ref": "#/components/parameters/cidr"},
+          {"$ref": "#/components/parameters/key"}
+        ],
+        "responses": {
+          "200": {
+            "description": "The matching allocations",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AllocationList"}}}
+          },
+          "401": {"$ref": "#/components/responses/Unauthorized"}
+        }
+      },
+      "post": {
+        "summary": "Request an IP Address",
+        "description": "Requests the IP Address of a host in the pool of the cidr. Requesting an allocation that exists returns its IP Address.",
+        "parameters": [{"$ref": "#/components/parameters/idempotencyKey"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AllocationRequest"}}}
+        },
+        "responses": {
+          "200": {
+            "description": "The IP Address is allocated",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Allocation"}}}
+          },
+          "202": {
+            "description": "The pool is exhausted, the allocation waits at its position in the queue of the pool",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Allocation"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "409": {
+            "description": "The IP Address can not be allocated, or a request with the idempotency key is in progress",
+            "content": {"application/json": {"schema": {"oneOf": [
+              {"$ref": "#/components/schemas/Allocation"},
+              {"$ref": "#/components/schemas/Error"}
+            ]}}}
+          },
+          "422": {"$ref": "#/components/responses/KeyReused"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      },
+      "delete": {
+        "summary": "Release an IP Address",
+        "parameters": [
+          {"$ref": "#/components/parameters/idempotencyKey"},
+          {"$ref": "#/components/parameters/namespace"},
+          {"name": "name", "in": "query", "required": true, "description": "Name of the owner", "schema": {"type": "string"}},
+          {"name": "host", "in": "query", "description": "Host of the allocation, required unless a key is given", "schema": {"type": "string"}},
+          {"name": "cidr", "in": "query", "required": true, "description": "CIDR of the pool", "schema": {"type": "string"}},
+          {"$ref": "#/components/parameters/key"},
+          {
+            "name": "reclaimPolicy",
+            "in": "query",
+            "description": "What happens to the IP Address, the reclaim policy of the pool when not given",
+            "schema": {"type": "string", "enum": ["Delete", "Retain", "RetainForever"]}
+          },
+          {
+            "name": "retainPeriod",
+            "in": "query",
+            "description": "Period of the Retain reclaim policy, like 1h",
+            "schema": {"type": "string"}
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "The allocation is released",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Allocation"}}}
+          },
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "404": {
+            "description": "There is no such allocation",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+          },
+          "409": {
+            "description": "The allocation can not be released, or a request with the idempotency key is in progress",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+          },
+          "422": {"$ref": "#/components/responses/KeyReused"},
+          "504": {"$ref": "#/components/responses/Timeout"}
+        }
+      }
+    },
+    "/v1/openapi.json": {
+      "get": {
+        "summary": "This description",
+        "security": [],
+        "responses": {"200": {"description": "The OpenAPI description", "content": {"application/json": {}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearerAuth": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "idempotencyKey": {
+        "name": "Idempotency-Key",
+        "in": "header",
+        "required": true,
+        "description": "Unique key of the request, a retry with the same key replays the result for 24 hours",
+        "schema": {"type": "string", "maxLength": 255}
+      },
+      "namespace": {
+        "name": "namespace",
+        "in": "query",
+        "description": "Namespace of the owner, default when not given",
+        "schema": {"type": "string"}
+      },
+      "name": {"name": "name", "in": "query", "description": "Name of the owner", "schema": {"type": "string"}},
+      "host": {"name": "host", "in": "query", "schema": {"type": "string"}},
+      "cidr": {"name": "cidr", "in": "query", "description": "CIDR of the pool", "schema": {"type": "string"}},
+      "key": {
+        "name": "key",
+        "in": "query",
+        "description": "Key of a shared allocation",
+        "schema": {"type": "string"}
+      }
+    },
+    "responses": {
+      "BadRequest": {
+        "description": "The request is invalid",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Unauthorized": {
+        "description": "A valid bearer token is required",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "KeyReused": {
+        "description": "The idempotency key is already used for another request",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      },
+      "Timeout": {
+        "description": "The controller did not answer in time, the request can be retried with the same idempotency key",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    },
+    "schemas": {
+      "AllocationRequest": {
+        "type": "object",
+        "required": ["name", "cidr"],
+        "additionalProperties": false,
+        "properties": {
+          "namespace": {"type": "string", "description": "Namespace of the owner, default when empty"},
+          "name": {"type": "string", "description": "Name of the owner"},
+          "host": {"type": "string", "description": "Host of the allocation, required unless a key is given"},
+          "cidr": {"type": "string", "description": "CIDR of the pool"},
+          "key": {"type": "string", "description": "Requests with the same key in a namespace and pool share one IP Address"},
+          "requestedIP": {"type": "string", "description": "IP Address to allocate exactly or not at all"}
+        }
+      },
+      "Allocation": {
+        "type": "object",
+        "properties": {
+          "namespace": {"type": "string"},
+          "name": {"type": "string"},
+          "host": {"type": "string"},
+          "cidr": {"type": "string"},
+          "key": {"type": "string"},
+          "requestedIP": {"type": "string"},
+          "ip": {"type": "string"},
+          "status": {"type": "string", "enum": ["Allocated", "Pending", "Failed"]},
+          "reason": {"type": "string"},
+          "position": {"type": "integer", "description": "Position of a Pending allocation in the queue of its pool"}
+        }
+      },
+      "AllocationList": {
+        "type": "object",
+        "properties": {
+          "allocations": {"type": "array", "items": {"$ref": "#/components/schemas/Allocation"}}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {"error": {"type": "string"}}
+      }
+    }
+  }
+}
+`
+
+// serveOpenAPI serves the description of the REST API, it needs no authentication
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, httpError{Error: "method not allowed"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(openAPI))
+}
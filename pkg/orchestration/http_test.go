@@ -0,0 +1,252 @@
+package orchestration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/controller"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+)
+
+const testToken = "s3cret"
+
+// httpHarness runs the http Orchestrator and the controller, with a state file and a store that
+// are kept across restarts
+type httpHarness struct {
+	t         *testing.T
+	tokenFile string
+	stateFile string
+	storePath string
+
+	mgr    manager.Manager
+	ctlr   *controller.Controller
+	stopCh chan struct{}
+	url    string
+}
+
+func newHTTPHarness(t *testing.T) *httpHarness {
+	dir := t.TempDir()
+	h := &httpHarness{
+		t:         t,
+		tokenFile: filepath.Join(dir, "tokens"),
+		stateFile: filepath.Join(dir, "state.json"),
+		storePath: filepath.Join(dir, "store.db"),
+	}
+	if err := ioutil.WriteFile(h.tokenFile, []byte("# clients\n"+testToken+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+	t.Cleanup(h.stop)
+	return h
+}
+
+func (h *httpHarness) start() {
+	var err error
+	h.mgr, err = manager.NewManager(manager.Params{
+		Provider: manager.F5IPAMProvider,
+		IPAMManagerParams: manager.IPAMManagerParams{
+			Range:     testRange,
+			StorePath: h.storePath,
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create Manager: %v", err)
+	}
+	orcr, err := orchestration.NewOrchestrator(orchestration.HTTPOrchestration, orchestration.Params{
+		Address:   "127.0.0.1:0",
+		TokenFile: h.tokenFile,
+		StateFile: h.stateFile,
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create http Orchestrator: %v", err)
+	}
+	h.url = "http://" + orcr.(*orchestration.HTTPIPAMClient).Addr().String()
+	h.stopCh = make(chan struct{})
+	h.ctlr = controller.NewController(controller.Spec{
+		Orchestrator: orcr,
+		Manager:      h.mgr,
+		StopCh:       h.stopCh,
+	})
+	h.ctlr.Start()
+}
+
+func (h *httpHarness) stop() {
+	if h.ctlr == nil {
+		return
+	}
+	if !h.ctlr.Stop(waitTimeout) {
+		h.t.Error("Requests are not drained on stop")
+	}
+	h.ctlr = nil
+}
+
+// do sends the request with the token and the idempotency key when given, and decodes the
+// response into out
+func (h *httpHarness) do(method, path, token, key string, body interface{}, out interface{}) *http.Response {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			h.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, h.url+path, bytes.NewReader(data))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key != "" {
+		req.Header.Set(orchestration.IdempotencyKeyHeader, key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("%v %v failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			h.t.Fatalf("Unable to decode response of %v %v: %v", method, path, err)
+		}
+	}
+	return resp
+}
+
+func (h *httpHarness) request(key, host string) (*orchestration.HTTPAllocation, *http.Response) {
+	allocation := &orchestration.HTTPAllocation{}
+	resp := h.do(http.MethodPost, "/v1/allocations", testToken, key,
+		map[string]string{"name": "terraform", "host": host, "cidr": testCIDR}, allocation)
+	return allocation, resp
+}
+
+func (h *httpHarness) lookup(host string) []*orchestration.HTTPAllocation {
+	var list struct {
+		Allocations []*orchestration.HTTPAllocation `json:"allocations"`
+	}
+	h.do(http.MethodGet, "/v1/allocations?host="+url.QueryEscape(host), testToken, "", nil, &list)
+	return list.Allocations
+}
+
+func releasePath(host string) string {
+	return "/v1/allocations?" + url.Values{
+		"name": {"terraform"},
+		"host": {host},
+		"cidr": {testCIDR},
+	}.Encode()
+}
+
+func TestHTTPOrchestration(t *testing.T) {
+	h := newHTTPHarness(t)
+
+	if resp := h.do(http.MethodGet, "/v1/allocations", "wrong", "", nil, nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Unknown token got: %v", resp.Status)
+	}
+	if _, resp := h.request("", "foo.example.com"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Request without an idempotency key got: %v", resp.Status)
+	}
+
+	allocation, resp := h.request("key-1", "foo.example.com")
+	if resp.StatusCode != http.StatusOK || allocation.IP == "" || allocation.Status != orchestration.AllocatedStatus {
+		t.Fatalf("Request got: %v, %+v", resp.Status, allocation)
+	}
+	replayed, resp := h.request("key-1", "foo.example.com")
+	if resp.Header.Get("Idempotent-Replayed") != "true" || replayed.IP != allocation.IP {
+		t.Errorf("Retried request is not replayed: %+v", replayed)
+	}
+	if _, resp = h.request("key-1", "bar.example.com"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Reused idempotency key got: %v", resp.Status)
+	}
+
+	if found := h.lookup("foo.example.com"); len(found) != 1 || found[0].IP != allocation.IP {
+		t.Errorf("Lookup got: %+v", found)
+	}
+
+	released := &orchestration.HTTPAllocation{}
+	if resp = h.do(http.MethodDelete, releasePath("foo.example.com"), testToken, "key-2", nil, released); resp.StatusCode != http.StatusOK {
+		t.Errorf("Release got: %v", resp.Status)
+	}
+	if found := h.lookup("foo.example.com"); len(found) != 0 {
+		t.Errorf("Released allocation is found: %+v", found)
+	}
+	if allocations := h.mgr.GetAllocations("foo.example.com"); len(allocations) != 0 {
+		t.Errorf("Allocations are not released: %v", allocations)
+	}
+	if resp = h.do(http.MethodDelete, releasePath("foo.example.com"), testToken, "key-3", nil, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Release of an unknown allocation got: %v", resp.Status)
+	}
+}
+
+func TestHTTPOrchestrationRestart(t *testing.T) {
+	h := newHTTPHarness(t)
+	allocation, resp := h.request("key-1", "foo.example.com")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Request got: %v", resp.Status)
+	}
+
+	h.stop()
+	h.start()
+
+	if found := h.lookup("foo.example.com"); len(found) != 1 || found[0].IP != allocation.IP {
+		t.Errorf("Allocation is not restored: %+v", found)
+	}
+	allocations := h.mgr.GetAllocations("foo.example.com")
+	if len(allocations) != 1 || allocations[0].IPAddr != allocation.IP {
+		t.Errorf("Allocation of foo.example.com is not restored: %v", allocations)
+	}
+}
+
+func TestHTTPOpenAPI(t *testing.T) {
+	h := newHTTPHarness(t)
+	var doc map[string]interface{}
+	if resp := h.do(http.MethodGet, "/v1/openapi.json", "", "", nil, &doc); resp.StatusCode != http.StatusOK {
+		t.Fatalf("OpenAPI description got: %v", resp.Status)
+	}
+	if _, ok := doc["paths"].(map[string]interface{})["/v1/allocations"]; !ok {
+		t.Errorf("OpenAPI description does not describe the allocations: %v", doc["paths"])
+	}
+}
+
+func TestHTTPOrchestrationKey(t *testing.T) {
+	h := newHTTPHarness(t)
+
+	// An allocation of a key needs no host
+	allocation := &orchestration.HTTPAllocation{}
+	resp := h.do(http.MethodPost, "/v1/allocations", testToken, "key-1",
+		map[string]string{"name": "terraform", "key": "shared", "cidr": testCIDR}, allocation)
+	if resp.StatusCode != http.StatusOK || allocation.IP == "" {
+		t.Fatalf("Request of a key got: %v, %+v", resp.Status, allocation)
+	}
+	var list struct {
+		Allocations []*orchestration.HTTPAllocation `json:"allocations"`
+	}
+	h.do(http.MethodGet, "/v1/allocations?key=shared", testToken, "", nil, &list)
+	if len(list.Allocations) != 1 || list.Allocations[0].IP != allocation.IP {
+		t.Errorf("Lookup of the key got: %+v", list.Allocations)
+	}
+
+	path := "/v1/allocations?" + url.Values{"name": {"terraform"}, "key": {"shared"}, "cidr": {testCIDR}}.Encode()
+	if resp = h.do(http.MethodDelete, path, testToken, "key-2", nil, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Release of the key got: %v", resp.Status)
+	}
+	if allocations := h.mgr.GetAllocationsOfIP(allocation.IP); len(allocations) != 0 {
+		t.Errorf("Allocations of the key are not released: %v", allocations)
+	}
+}
+
+func TestHTTPOrchestrationStateFileRequired(t *testing.T) {
+	h := newHTTPHarness(t)
+	_, err := orchestration.NewOrchestrator(orchestration.HTTPOrchestration, orchestration.Params{
+		Address:   "127.0.0.1:0",
+		TokenFile: h.tokenFile,
+	})
+	if err == nil {
+		t.Error("http Orchestrator is created without a state file")
+	}
+}
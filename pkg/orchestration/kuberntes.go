@@ -1,6 +1,9 @@
 package orchestration
 
 import (
+	"fmt"
+	"sync"
+
 	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
 	"github.com/subbuv26/f5-ipam-controller/pkg/ipammachinery"
 	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
@@ -10,6 +13,7 @@ import (
 
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 
 	//"k8s.io/client-go/rest"
@@ -22,24 +26,62 @@ type K8sIPAMClient struct {
 
 	// Queue and informers for namespaces and resources
 	rscQueue workqueue.RateLimitingInterface
+	// Queue for F5IPAMPool events
+	poolQueue workqueue.RateLimitingInterface
+
+	// PoolManager for the pools defined by F5IPAMPools
+	poolMgr    PoolManager
+	poolResult poolResult
+	poolMutex  sync.Mutex
+
+	// Queue for Namespace events and the PolicyManager for the policies of their annotations
+	nsQueue   workqueue.RateLimitingInterface
+	policyMgr PolicyManager
+
+	// Restore requests of the startup that are not answered yet
+	restore restoreState
 
 	// Channel for sending request to controller
 	reqChan chan<- ipamspec.IPAMRequest
 	// Channel for receiving responce from controller
 	respChan <-chan ipamspec.IPAMResponse
+
+	// Closed when the resource worker and the response worker are done
+	resourcesDone chan struct{}
+	responsesDone chan struct{}
+	// Pool and Namespace routines, which use the managers until they end
+	routines sync.WaitGroup
 }
 
 const (
+	// KubernetesOrchestration watches the F5IPAM resources of the cluster
+	KubernetesOrchestration = "kubernetes"
+
 	CREATE = "Create"
 	UPDATE = "Update"
 	DELETE = "Delete"
+	// RELEASE releases the previous allocation of a renamed or moved HostSpec
+	RELEASE = "Release"
+	// COMMIT commits an allocation that is published in the Status of F5IPAM CR
+	COMMIT = "Commit"
 
 	DefaultNamespace = "kube-system"
+
+	// FailedStatus of an IPSpec in the Status of F5IPAM CR that has no IP Address
+	FailedStatus = "Failed"
+	// PendingStatus of an IPSpec that waits for an IP Address of an exhausted pool
+	PendingStatus = "Pending"
 )
 
+// k8sLog logs the messages of the kubernetes Orchestrator
+var k8sLog = log.NewSubsystemLogger("K8S")
+
 type rqKey struct {
-	rsc       *ficV1.F5IPAM
-	oldRsc    *ficV1.F5IPAM
+	rsc    *ficV1.F5IPAM
+	oldRsc *ficV1.F5IPAM
+	// ipSpec of a RELEASE is the previous allocation of a renamed or moved HostSpec,
+	// and of a COMMIT the published allocation
+	ipSpec    *ficV1.IPSpec
 	Operation string
 }
 
@@ -50,16 +92,40 @@ type ResourceMeta struct {
 	namespace string
 }
 
-func NewIPAMK8SClient() *K8sIPAMClient {
-	log.Debugf("Creating IPAM Kubernetes Client")
+func init() {
+	Register(KubernetesOrchestration, func(params Params) (Orchestrator, error) {
+		k8sc := NewIPAMK8SClient(params.Namespaces...)
+		if k8sc == nil {
+			return nil, fmt.Errorf("Unable to create IPAM Kubernetes Client")
+		}
+		return k8sc, nil
+	})
+}
+
+// NewIPAMK8SClient creates the client of the cluster, watching the F5IPAMs of the namespaces,
+// or of all the namespaces when none is given
+func NewIPAMK8SClient(namespaces ...string) *K8sIPAMClient {
+	k8sLog.Debugf("Creating IPAM Kubernetes Client")
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatalf("Error creating configuration: %v", err)
 		return nil
 	}
+	return newIPAMK8SClient(ipammachinery.Params{Config: config, Namespaces: namespaces})
+}
+
+// newIPAMK8SClient creates the client watching the resources with the Config or the clients
+// of ipamParams, in all the namespaces unless ipamParams has some
+func newIPAMK8SClient(ipamParams ipammachinery.Params) *K8sIPAMClient {
 	k8sIPAMClient := &K8sIPAMClient{
 		rscQueue: workqueue.NewNamedRateLimitingQueue(
 			workqueue.DefaultControllerRateLimiter(), "ipam-controller"),
+		poolQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "ipam-pools"),
+		nsQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.DefaultControllerRateLimiter(), "ipam-namespaces"),
+		resourcesDone: make(chan struct{}),
+		responsesDone: make(chan struct{}),
 	}
 
 	eventHandlers := &cache.ResourceEventHandlerFuncs{
@@ -68,12 +134,25 @@ func NewIPAMK8SClient() *K8sIPAMClient {
 		DeleteFunc: func(obj interface{}) { k8sIPAMClient.enqueueDeletedIPAM(obj) },
 	}
 
-	ipamParams := ipammachinery.Params{
-		Config:        config,
-		EventHandlers: eventHandlers,
-		Namespaces:    []string{DefaultNamespace},
+	poolEventHandlers := &cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { k8sIPAMClient.enqueuePools(obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) { k8sIPAMClient.enqueuePools(newObj) },
+		DeleteFunc: func(obj interface{}) { k8sIPAMClient.enqueuePools(obj) },
 	}
 
+	nsEventHandlers := &cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { k8sIPAMClient.enqueueNamespaces(obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) { k8sIPAMClient.enqueueNamespaces(newObj) },
+		DeleteFunc: func(obj interface{}) { k8sIPAMClient.enqueueNamespaces(obj) },
+	}
+
+	ipamParams.EventHandlers = eventHandlers
+	if len(ipamParams.Namespaces) == 0 {
+		ipamParams.Namespaces = []string{metav1.NamespaceAll}
+	}
+	ipamParams.PoolEventHandlers = poolEventHandlers
+	ipamParams.NamespaceEventHandlers = nsEventHandlers
+
 	ipamCli := ipammachinery.NewIPAMClient(ipamParams)
 
 	if ipamCli == nil {
@@ -133,14 +212,54 @@ func (k8sc *K8sIPAMClient) SetupCommunicationChannels(
 // Runs the Orchestrator, watching for resources
 func (k8sc *K8sIPAMClient) Start(stopCh <-chan struct{}) {
 	k8sc.ipamCli.Start()
-	go wait.Until(k8sc.customResourceWorker, time.Second, stopCh)
-	go wait.Until(k8sc.responseWorker, time.Second, stopCh)
+	go func() {
+		defer close(k8sc.responsesDone)
+		k8sc.responseWorker()
+	}()
+	go func() {
+		defer close(k8sc.resourcesDone)
+		// Allocations of all F5IPAMs are restored before new ones are made, into the pools of
+		// the F5IPAMPools as well
+		k8sc.reconcilePools()
+		if k8sc.restoreAllocations(stopCh) {
+			k8sc.customResourceWorker()
+		}
+	}()
+	for _, routine := range []struct {
+		f      func()
+		period time.Duration
+	}{
+		{k8sc.poolWorker, time.Second},
+		{k8sc.namespaceWorker, time.Second},
+		{k8sc.updatePoolStatus, PoolStatusInterval},
+	} {
+		routine := routine
+		k8sc.routines.Add(1)
+		go func() {
+			defer k8sc.routines.Done()
+			wait.Until(routine.f, routine.period, stopCh)
+		}()
+	}
 
-	log.Debugf("K8S Orchestrator Started")
+	k8sLog.Debugf("K8S Orchestrator Started")
 }
 
+// Stop stops the informers, and waits for the queued F5IPAM events to be sent as requests and
+// for the Pool and Namespace routines to end. Responses are published until the response
+// channel is closed.
 func (k8sc *K8sIPAMClient) Stop() {
 	k8sc.ipamCli.Stop()
+	k8sc.rscQueue.ShutDown()
+	k8sc.poolQueue.ShutDown()
+	k8sc.nsQueue.ShutDown()
+	<-k8sc.resourcesDone
+	k8sc.routines.Wait()
+	k8sLog.Debugf("K8S Orchestrator Stopped")
+}
+
+// Drained is closed once the responses are published
+func (k8sc *K8sIPAMClient) Drained() <-chan struct{} {
+	return k8sc.responsesDone
 }
 
 func (k8sc *K8sIPAMClient) enqueueIPAM(obj interface{}) {
@@ -150,7 +269,7 @@ func (k8sc *K8sIPAMClient) enqueueIPAM(obj interface{}) {
 		oldRsc:    nil,
 		Operation: CREATE,
 	}
-	log.Debugf("Enqueueing on Create: %v/%v", key.rsc.Namespace, key.rsc.Name)
+	k8sLog.Debugf("Enqueueing on Create: %v/%v", key.rsc.Namespace, key.rsc.Name)
 
 	k8sc.rscQueue.Add(key)
 }
@@ -161,14 +280,27 @@ func (k8sc *K8sIPAMClient) enqueueUpdatedIPAM(old, cur interface{}) {
 		oldRsc:    old.(*ficV1.F5IPAM),
 		Operation: UPDATE,
 	}
-	log.Debugf("Enqueueing on Update: %v/%v", key.rsc.Namespace, key.rsc.Name)
+	k8sLog.Debugf("Enqueueing on Update: %v/%v", key.rsc.Namespace, key.rsc.Name)
 
 	k8sc.rscQueue.Add(key)
 }
 
 func (k8sc *K8sIPAMClient) enqueueDeletedIPAM(obj interface{}) {
+	rsc, ok := obj.(*ficV1.F5IPAM)
+	if !ok {
+		// The deletion was missed by the watch, the last known state is used
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			k8sLog.Errorf("Unexpected object on deletion of F5IPAM: %v", obj)
+			return
+		}
+		if rsc, ok = tombstone.Obj.(*ficV1.F5IPAM); !ok {
+			k8sLog.Errorf("Unexpected object in tombstone of F5IPAM: %v", tombstone.Obj)
+			return
+		}
+	}
 	key := &rqKey{
-		rsc:       obj.(*ficV1.F5IPAM),
+		rsc:       rsc,
 		oldRsc:    nil,
 		Operation: DELETE,
 	}
@@ -176,15 +308,21 @@ func (k8sc *K8sIPAMClient) enqueueDeletedIPAM(obj interface{}) {
 	k8sc.rscQueue.Add(key)
 }
 
+// sendRequest sends the request of the resource to the controller
+func (k8sc *K8sIPAMClient) sendRequest(req ipamspec.IPAMRequest) {
+	withRequest(k8sLog, req, req.IPAddr).Debugf("Sending %v request", req.Operation)
+	k8sc.reqChan <- req
+}
+
 // customResourceWorker starts the Custom Resource Worker.
 func (k8sc *K8sIPAMClient) customResourceWorker() {
-	log.Debugf("Starting Custom Resource Worker")
+	k8sLog.Debugf("Starting Custom Resource Worker")
 	for k8sc.processResource() {
 	}
 }
 
 func (k8sc *K8sIPAMClient) responseWorker() {
-	log.Debugf("Starting Response Worker")
+	k8sLog.Debugf("Starting Response Worker")
 	for k8sc.processResponse() {
 	}
 }
@@ -193,13 +331,21 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 	key, quit := k8sc.rscQueue.Get()
 	if quit {
 		// The controller is shutting down.
-		log.Debugf("Resource Queue is empty, Going to StandBy Mode")
+		k8sLog.Debugf("Resource Queue is empty, Going to StandBy Mode")
 		return false
 	}
 
 	defer k8sc.rscQueue.Done(key)
 	rKey := key.(*rqKey)
-	log.Debugf("Processing Key: %v", rKey)
+	k8sLog.Debugf("Processing Key: %v", rKey)
+
+	if rKey.Operation == CREATE || rKey.Operation == UPDATE {
+		if isTerminating(rKey.rsc) {
+			k8sc.finalize(rKey.rsc)
+			return true
+		}
+		k8sc.addFinalizer(rKey.rsc)
+	}
 
 	switch rKey.Operation {
 	case CREATE:
@@ -207,17 +353,11 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 
 		// On FIC restart build already allocated IPSpec Store from the Status of F5IPAM CR
 		for _, ipSpec := range rKey.rsc.Status.IPStatus {
-			ipamReq := ipamspec.IPAMRequest{
-				Metadata: ResourceMeta{
-					name:      rKey.rsc.Name,
-					namespace: rKey.rsc.Namespace,
-				},
-				HostName:  ipSpec.Host,
-				CIDR:      ipSpec.Cidr,
-				IPAddr:    ipSpec.IP,
-				Operation: ipamspec.CREATE,
+			if ipSpec.IP == "" {
+				// Failed allocations are requested again from the HostSpecs
+				continue
 			}
-			k8sc.reqChan <- ipamReq
+			k8sc.sendRequest(restoreRequest(rKey.rsc, ipSpec))
 		}
 
 		for _, hostSpec := range rKey.rsc.Spec.HostSpecs {
@@ -226,27 +366,56 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 					name:      rKey.rsc.Name,
 					namespace: rKey.rsc.Namespace,
 				},
-				HostName:  hostSpec.Host,
-				CIDR:      hostSpec.Cidr,
-				Operation: ipamspec.CREATE,
+				Namespace:   rKey.rsc.Namespace,
+				Name:        rKey.rsc.Name,
+				HostName:    hostSpec.Host,
+				CIDR:        hostSpec.Cidr,
+				Key:         hostSpec.Key,
+				RequestedIP: hostSpec.IP,
+				Operation:   ipamspec.CREATE,
 			}
-			k8sc.reqChan <- ipamReq
+			k8sc.sendRequest(ipamReq)
 		}
 	case DELETE:
-		for _, ipStatus := range rKey.rsc.Status.IPStatus {
-			ipamReq := ipamspec.IPAMRequest{
-				Metadata: ResourceMeta{
-					name:      rKey.rsc.Name,
-					namespace: rKey.rsc.Namespace,
-				},
-				HostName:  ipStatus.Host,
-				CIDR:      ipStatus.Cidr,
-				IPAddr:    ipStatus.IP,
-				Operation: ipamspec.DELETE,
-			}
-			k8sc.reqChan <- ipamReq
+		for _, ipamReq := range releaseRequests(rKey.rsc) {
+			k8sc.sendRequest(ipamReq)
 		}
+	case COMMIT:
+		ipamReq := ipamspec.IPAMRequest{
+			Metadata: ResourceMeta{
+				name:      rKey.rsc.Name,
+				namespace: rKey.rsc.Namespace,
+			},
+			Namespace: rKey.rsc.Namespace,
+			Name:      rKey.rsc.Name,
+			HostName:  rKey.ipSpec.Host,
+			CIDR:      rKey.ipSpec.Cidr,
+			Key:       rKey.ipSpec.Key,
+			IPAddr:    rKey.ipSpec.IP,
+			Operation: ipamspec.COMMIT,
+		}
+		k8sc.sendRequest(ipamReq)
+	case RELEASE:
+		// The allocation was renamed or moved, and the new one is published
+		reclaimPolicy, retainPeriod := reclaimPolicyOf(rKey.rsc)
+		ipamReq := ipamspec.IPAMRequest{
+			Metadata: ResourceMeta{
+				name:      rKey.rsc.Name,
+				namespace: rKey.rsc.Namespace,
+			},
+			Namespace:     rKey.rsc.Namespace,
+			Name:          rKey.rsc.Name,
+			HostName:      rKey.ipSpec.Host,
+			CIDR:          rKey.ipSpec.Cidr,
+			Key:           rKey.ipSpec.Key,
+			IPAddr:        rKey.ipSpec.IP,
+			ReclaimPolicy: reclaimPolicy,
+			RetainPeriod:  retainPeriod,
+			Operation:     ipamspec.DELETE,
+		}
+		k8sc.sendRequest(ipamReq)
 	case UPDATE:
+		reclaimPolicy, retainPeriod := reclaimPolicyOf(rKey.rsc)
 		oldSpecSet := make(specMap)
 		newSpecSet := make(specMap)
 		for _, hostSpec := range rKey.oldRsc.Spec.HostSpecs {
@@ -256,6 +425,42 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 			newSpecSet[*hostSpec] = true
 		}
 
+		// A spec replaced by another one at its position is a rename or a move to another pool,
+		// which keeps the allocation instead of deleting and creating it
+		changed := make(map[ficV1.HostSpec]ficV1.HostSpec)
+		for i, oldSpec := range rKey.oldRsc.Spec.HostSpecs {
+			if i >= len(rKey.rsc.Spec.HostSpecs) {
+				break
+			}
+			newSpec := rKey.rsc.Spec.HostSpecs[i]
+			if newSpecSet[*oldSpec] || oldSpecSet[*newSpec] || !isRenameOrMove(*oldSpec, *newSpec) {
+				continue
+			}
+			changed[*oldSpec] = *newSpec
+			ipamReq := ipamspec.IPAMRequest{
+				Metadata: ResourceMeta{
+					name:      rKey.rsc.Name,
+					namespace: rKey.rsc.Namespace,
+				},
+				Namespace:     rKey.rsc.Namespace,
+				Name:          rKey.rsc.Name,
+				HostName:      newSpec.Host,
+				CIDR:          newSpec.Cidr,
+				Key:           newSpec.Key,
+				RequestedIP:   newSpec.IP,
+				PrevHostName:  oldSpec.Host,
+				PrevCIDR:      oldSpec.Cidr,
+				ReclaimPolicy: reclaimPolicy,
+				RetainPeriod:  retainPeriod,
+				Operation:     ipamspec.UPDATE,
+			}
+			k8sc.sendRequest(ipamReq)
+		}
+		for oldSpec, newSpec := range changed {
+			delete(oldSpecSet, oldSpec)
+			delete(newSpecSet, newSpec)
+		}
+
 		for spec, _ := range oldSpecSet {
 			if _, ok := newSpecSet[spec]; !ok {
 				// This spec got deleted
@@ -264,11 +469,16 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 						name:      rKey.rsc.Name,
 						namespace: rKey.rsc.Namespace,
 					},
-					HostName:  spec.Host,
-					CIDR:      spec.Cidr,
-					Operation: ipamspec.DELETE,
+					Namespace:     rKey.rsc.Namespace,
+					Name:          rKey.rsc.Name,
+					HostName:      spec.Host,
+					CIDR:          spec.Cidr,
+					Key:           spec.Key,
+					ReclaimPolicy: reclaimPolicy,
+					RetainPeriod:  retainPeriod,
+					Operation:     ipamspec.DELETE,
 				}
-				k8sc.reqChan <- ipamReq
+				k8sc.sendRequest(ipamReq)
 			}
 		}
 
@@ -279,11 +489,15 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 						name:      rKey.rsc.Name,
 						namespace: rKey.rsc.Namespace,
 					},
-					HostName:  spec.Host,
-					CIDR:      spec.Cidr,
-					Operation: ipamspec.CREATE,
+					Namespace:   rKey.rsc.Namespace,
+					Name:        rKey.rsc.Name,
+					HostName:    spec.Host,
+					CIDR:        spec.Cidr,
+					Key:         spec.Key,
+					RequestedIP: spec.IP,
+					Operation:   ipamspec.CREATE,
 				}
-				k8sc.reqChan <- ipamReq
+				k8sc.sendRequest(ipamReq)
 			}
 		}
 
@@ -291,26 +505,68 @@ func (k8sc *K8sIPAMClient) processResource() bool {
 	return true
 }
 
+// isRenameOrMove checks whether the new spec only changes the host or the pool of the old one
+func isRenameOrMove(oldSpec, newSpec ficV1.HostSpec) bool {
+	return oldSpec.Key == newSpec.Key && oldSpec.IP == newSpec.IP &&
+		(oldSpec.Host != newSpec.Host || oldSpec.Cidr != newSpec.Cidr)
+}
+
+// reclaimPolicyOf returns the reclaim policy of the F5IPAM, an invalid one is ignored
+// for the policy of the pool
+func reclaimPolicyOf(rsc *ficV1.F5IPAM) (string, time.Duration) {
+	policy, period, err := parseReclaimPolicy(rsc.Spec.ReclaimPolicy, rsc.Spec.RetainPeriod)
+	if err != nil {
+		k8sLog.Errorf("Ignoring reclaim policy of F5IPAM: %v/%v: %v", rsc.Namespace, rsc.Name, err)
+		return "", 0
+	}
+	return policy, period
+}
+
 func (k8sc *K8sIPAMClient) processResponse() bool {
 	for resp := range k8sc.respChan {
+		rspLog := withRequest(k8sLog, resp.Request, resp.IPAddr)
+		rspLog.Debugf("Received %v response, Status: %v", resp.Request.Operation, resp.Status)
 		removeStatusEntry := false
+		if resp.Request.Operation == ipamspec.CREATE && resp.Request.IPAddr != "" {
+			k8sc.restore.answered(resp)
+		}
 		switch resp.Request.Operation {
+		case ipamspec.COMMIT:
+			if !resp.Status {
+				// The published allocation was rolled back, it is requested again
+				k8sc.resync(resp.Request.Metadata.(ResourceMeta))
+			}
+		case ipamspec.UPDATE:
+			if resp.Status {
+				k8sc.updateMovedStatus(resp)
+				break
+			}
+			// The previous allocation stays published
+			k8sc.updateFailedStatus(resp)
 		case ipamspec.CREATE:
 			if resp.Status {
 				metadata := resp.Request.Metadata.(ResourceMeta)
 				ipamRsc, err := k8sc.ipamCli.Get(metadata.namespace, metadata.name)
 				if err != nil {
-					log.Errorf("Unable to find F5IPAM: %v/%v to update. Error: %v",
+					rspLog.Errorf("Unable to find F5IPAM: %v/%v to update. Error: %v",
 						metadata.namespace, metadata.name, err)
 					break
 				}
+				if isTerminating(ipamRsc) {
+					// The allocation is rolled back as it is never committed
+					rspLog.Debugf("F5IPAM: %v/%v is being deleted, not publishing IP", metadata.namespace, metadata.name)
+					break
+				}
 
 				found := false
 				for _, ipSpec := range ipamRsc.Status.IPStatus {
 					if ipSpec.Host == resp.Request.HostName &&
-						ipSpec.Cidr == resp.Request.CIDR {
+						ipSpec.Cidr == resp.Request.CIDR &&
+						ipSpec.Key == resp.Request.Key {
 
 						ipSpec.IP = resp.IPAddr
+						ipSpec.Status = ""
+						ipSpec.Reason = ""
 						found = true
 					}
 				}
@@ -318,22 +574,24 @@ func (k8sc *K8sIPAMClient) processResponse() bool {
 					ipSpec := &ficV1.IPSpec{
 						Host: resp.Request.HostName,
 						Cidr: resp.Request.CIDR,
+						Key:  resp.Request.Key,
 						IP:   resp.IPAddr,
 					}
 					ipamRsc.Status.IPStatus = append(ipamRsc.Status.IPStatus, ipSpec)
 				}
 
-				_, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
+				ipamRsc, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
 				if err != nil {
-					log.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+					// The allocation is rolled back as it is never committed
+					rspLog.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+					break
 				}
-				log.Debugf("Updated: %v/%v with Status. Added Host: %v, CIDR: %v, IP: %v",
-					metadata.namespace,
-					metadata.name,
-					resp.Request.HostName,
-					resp.Request.CIDR,
-					resp.IPAddr,
-				)
+				rspLog.Debugf("Updated: %v/%v with Status. Added Host", metadata.namespace, metadata.name)
+				k8sc.commit(ipamRsc, resp)
+				break
+			}
+			if resp.Reason != "" {
+				k8sc.updateFailedStatus(resp)
 				break
 			}
 			// If response status is fail then ensure Entry from Status of f5ipam CR is removed
@@ -345,12 +603,15 @@ func (k8sc *K8sIPAMClient) processResponse() bool {
 				metadata := resp.Request.Metadata.(ResourceMeta)
 				ipamRsc, err := k8sc.ipamCli.Get(metadata.namespace, metadata.name)
 				if err != nil {
-					log.Errorf("Unable to find F5IPAM: %v/%v to update", metadata.namespace, metadata.name)
+					// Releases of a deleted F5IPAM have no Status left to update
+					rspLog.Debugf("Unable to find F5IPAM: %v/%v to update: %v", metadata.namespace, metadata.name, err)
+					continue
 				}
 				index := -1
 				for i, ipSpec := range ipamRsc.Status.IPStatus {
 					if ipSpec.Host == resp.Request.HostName &&
-						ipSpec.Cidr == resp.Request.CIDR {
+						ipSpec.Cidr == resp.Request.CIDR &&
+						ipSpec.Key == resp.Request.Key {
 
 						index = i
 					}
@@ -360,20 +621,151 @@ func (k8sc *K8sIPAMClient) processResponse() bool {
 						ipamRsc.Status.IPStatus[:index],
 						ipamRsc.Status.IPStatus[index+1:]...,
 					)
-					_, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
+					ipamRsc, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
 					if err != nil {
-						log.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+						rspLog.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+					} else if isTerminating(ipamRsc) && len(ipamRsc.Status.IPStatus) == 0 {
+						// All allocations of the deleted F5IPAM are released
+						k8sc.removeFinalizer(ipamRsc)
 					}
 				}
-				log.Debugf("Updated: %v/%v with Status. Removed Host: %v, CIDR: %v, IP: %v",
-					metadata.namespace,
-					metadata.name,
-					resp.Request.HostName,
-					resp.Request.CIDR,
-					resp.IPAddr,
-				)
+				rspLog.Debugf("Updated: %v/%v with Status. Removed Host", metadata.namespace, metadata.name)
 			}
 		}
 	}
-	return true
+	// The response channel is closed on shutdown
+	return false
+}
+
+// updateMovedStatus replaces the entry of the previous host and pool in the Status of F5IPAM CR
+// with the allocation it was moved to. The previous allocation is released once the new one is
+// published and committed.
+func (k8sc *K8sIPAMClient) updateMovedStatus(resp ipamspec.IPAMResponse) {
+	rspLog := withRequest(k8sLog, resp.Request, resp.IPAddr)
+	metadata := resp.Request.Metadata.(ResourceMeta)
+	ipamRsc, err := k8sc.ipamCli.Get(metadata.namespace, metadata.name)
+	if err != nil {
+		rspLog.Errorf("Unable to find F5IPAM: %v/%v to update. Error: %v",
+			metadata.namespace, metadata.name, err)
+		return
+	}
+	if isTerminating(ipamRsc) {
+		return
+	}
+
+	var prev ficV1.IPSpec
+	var entry *ficV1.IPSpec
+	var ipStatus []*ficV1.IPSpec
+	for _, ipSpec := range ipamRsc.Status.IPStatus {
+		switch {
+		case ipSpec.Host == resp.Request.PrevHostName && ipSpec.Cidr == resp.Request.PrevCIDR &&
+			ipSpec.Key == resp.Request.Key:
+			prev = *ipSpec
+			entry = ipSpec
+		case ipSpec.Host == resp.Request.HostName && ipSpec.Cidr == resp.Request.CIDR &&
+			ipSpec.Key == resp.Request.Key:
+			// A failed or pending entry of the move is replaced by the moved one
+			continue
+		}
+		ipStatus = append(ipStatus, ipSpec)
+	}
+	ipamRsc.Status.IPStatus = ipStatus
+	if entry == nil {
+		entry = &ficV1.IPSpec{}
+		ipamRsc.Status.IPStatus = append(ipamRsc.Status.IPStatus, entry)
+	}
+	*entry = ficV1.IPSpec{
+		Host: resp.Request.HostName,
+		Cidr: resp.Request.CIDR,
+		Key:  resp.Request.Key,
+		IP:   resp.IPAddr,
+	}
+
+	ipamRsc, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
+	if err != nil {
+		// The moved allocation is rolled back as it is never committed
+		rspLog.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+		return
+	}
+	rspLog.Debugf("Updated: %v/%v with Status. Moved Host: %v, CIDR: %v",
+		metadata.namespace, metadata.name, resp.Request.PrevHostName, resp.Request.PrevCIDR)
+
+	k8sc.commit(ipamRsc, resp)
+	if prev.IP != "" {
+		k8sc.rscQueue.Add(&rqKey{
+			rsc:       ipamRsc,
+			ipSpec:    &prev,
+			Operation: RELEASE,
+		})
+	}
+}
+
+// commit queues the commit of the allocation of the response, which is published
+func (k8sc *K8sIPAMClient) commit(ipamRsc *ficV1.F5IPAM, resp ipamspec.IPAMResponse) {
+	k8sc.rscQueue.Add(&rqKey{
+		rsc: ipamRsc,
+		ipSpec: &ficV1.IPSpec{
+			Host: resp.Request.HostName,
+			Cidr: resp.Request.CIDR,
+			Key:  resp.Request.Key,
+			IP:   resp.IPAddr,
+		},
+		Operation: COMMIT,
+	})
+}
+
+// resync requests the allocations of the F5IPAM again
+func (k8sc *K8sIPAMClient) resync(metadata ResourceMeta) {
+	ipamRsc, err := k8sc.ipamCli.Get(metadata.namespace, metadata.name)
+	if err != nil {
+		k8sLog.Errorf("Unable to find F5IPAM: %v/%v to resync. Error: %v", metadata.namespace, metadata.name, err)
+		return
+	}
+	k8sc.rscQueue.Add(&rqKey{
+		rsc:       ipamRsc,
+		Operation: CREATE,
+	})
+}
+
+// updateFailedStatus records the reason of a failed or pending allocation in the Status of F5IPAM CR
+func (k8sc *K8sIPAMClient) updateFailedStatus(resp ipamspec.IPAMResponse) {
+	rspLog := withRequest(k8sLog, resp.Request, "")
+	metadata := resp.Request.Metadata.(ResourceMeta)
+	ipamRsc, err := k8sc.ipamCli.Get(metadata.namespace, metadata.name)
+	if err != nil {
+		rspLog.Errorf("Unable to find F5IPAM: %v/%v to update. Error: %v",
+			metadata.namespace, metadata.name, err)
+		return
+	}
+	if isTerminating(ipamRsc) {
+		return
+	}
+
+	var entry *ficV1.IPSpec
+	for _, ipSpec := range ipamRsc.Status.IPStatus {
+		if ipSpec.Host == resp.Request.HostName && ipSpec.Cidr == resp.Request.CIDR &&
+			ipSpec.Key == resp.Request.Key {
+			entry = ipSpec
+		}
+	}
+	if entry == nil {
+		entry = &ficV1.IPSpec{
+			Host: resp.Request.HostName,
+			Cidr: resp.Request.CIDR,
+			Key:  resp.Request.Key,
+		}
+		ipamRsc.Status.IPStatus = append(ipamRsc.Status.IPStatus, entry)
+	}
+	entry.IP = ""
+	entry.Status = FailedStatus
+	if resp.Pending {
+		entry.Status = PendingStatus
+	}
+	entry.Reason = resp.Reason
+
+	_, err = k8sc.ipamCli.Update(ipamRsc.Namespace, ipamRsc)
+	if err != nil {
+		rspLog.Errorf("Unable to Update F5IPAM: %v/%v", metadata.namespace, metadata.name)
+	}
+	rspLog.Debugf("Updated: %v/%v with Status. Failed Host, Reason: %v", metadata.namespace, metadata.name, resp.Reason)
 }
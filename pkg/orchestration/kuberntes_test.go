@@ -0,0 +1,325 @@
+package orchestration_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/controller"
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	ficfake "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/client/clientset/versioned/fake"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/manager"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testCIDR    = "10.10.10.0/24"
+	testRange   = "10.10.10.1/24-10.10.10.20/24"
+	waitTimeout = 10 * time.Second
+)
+
+// harness runs the K8sIPAMClient and the controller on fake clientsets. Each start uses a new
+// store, so allocations are restored from the Status of F5IPAMs like after losing the store.
+type harness struct {
+	t          *testing.T
+	kubeClient *k8sfake.Clientset
+	crClient   *ficfake.Clientset
+	// watchCh receives the namespace of every F5IPAM watch that is established
+	watchCh chan string
+	starts  int
+	// namespace of the F5IPAMs of the helpers
+	namespace string
+	// policies are the namespace policies of the configuration of the controller
+	policies []ipamspec.NamespacePolicy
+
+	mgr    manager.Manager
+	ctlr   *controller.Controller
+	stopCh chan struct{}
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:          t,
+		kubeClient: k8sfake.NewSimpleClientset(),
+		crClient:   ficfake.NewSimpleClientset(),
+		watchCh:    make(chan string, 1),
+		namespace:  orchestration.DefaultNamespace,
+	}
+	// Events are only seen once the watch is established, which is after the informer syncs
+	h.crClient.PrependWatchReactor("f5ipams", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := h.crClient.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+		select {
+		case h.watchCh <- action.GetNamespace():
+		default:
+		}
+		return true, w, nil
+	})
+	h.start()
+	t.Cleanup(h.stop)
+	return h
+}
+
+func (h *harness) start() {
+	h.starts++
+	var err error
+	h.mgr, err = manager.NewManager(manager.Params{
+		Provider: manager.F5IPAMProvider,
+		IPAMManagerParams: manager.IPAMManagerParams{
+			Range:     testRange,
+			StorePath: filepath.Join(h.t.TempDir(), fmt.Sprintf("store-%d.db", h.starts)),
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("Unable to create Manager: %v", err)
+	}
+	h.stopCh = make(chan struct{})
+	h.ctlr = controller.NewController(controller.Spec{
+		Orchestrator: orchestration.NewTestIPAMK8SClient(h.kubeClient, h.crClient),
+		Manager:      h.mgr,
+		StopCh:       h.stopCh,
+		PoolSources:  []string{orchestration.PoolSource},
+
+		NamespacePolicies: h.policies,
+	})
+	// Watches of the previous start are not waited for
+	select {
+	case <-h.watchCh:
+	default:
+	}
+	h.ctlr.Start()
+
+	select {
+	case <-h.watchCh:
+	case <-time.After(waitTimeout):
+		h.t.Fatal("F5IPAMs are not watched")
+	}
+}
+
+func (h *harness) stop() {
+	if h.ctlr == nil {
+		return
+	}
+	if !h.ctlr.Stop(waitTimeout) {
+		h.t.Error("Requests are not drained on stop")
+	}
+	h.ctlr = nil
+}
+
+func (h *harness) restart() {
+	h.stop()
+	h.start()
+}
+
+func (h *harness) create(name string, hostSpecs ...*ficV1.HostSpec) {
+	rsc := &ficV1.F5IPAM{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: h.namespace},
+		Spec:       ficV1.F5IPAMSpec{HostSpecs: hostSpecs},
+	}
+	if _, err := h.crClient.K8sV1().F5IPAMs(h.namespace).Create(rsc); err != nil {
+		h.t.Fatalf("Unable to create F5IPAM %v: %v", name, err)
+	}
+}
+
+func (h *harness) get(name string) *ficV1.F5IPAM {
+	rsc, err := h.crClient.K8sV1().F5IPAMs(h.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		h.t.Fatalf("Unable to get F5IPAM %v: %v", name, err)
+	}
+	return rsc
+}
+
+func (h *harness) update(name string, hostSpecs ...*ficV1.HostSpec) {
+	rsc := h.get(name)
+	rsc.Spec.HostSpecs = hostSpecs
+	if _, err := h.crClient.K8sV1().F5IPAMs(h.namespace).Update(rsc); err != nil {
+		h.t.Fatalf("Unable to update F5IPAM %v: %v", name, err)
+	}
+}
+
+// delete deletes the F5IPAM the way the API server does, it is marked for deletion until
+// its finalizers are removed
+func (h *harness) delete(name string) {
+	rsc := h.get(name)
+	now := metav1.Now()
+	rsc.DeletionTimestamp = &now
+	if _, err := h.crClient.K8sV1().F5IPAMs(h.namespace).Update(rsc); err != nil {
+		h.t.Fatalf("Unable to mark F5IPAM %v for deletion: %v", name, err)
+	}
+	h.waitFor(name, func(rsc *ficV1.F5IPAM) bool { return len(rsc.Finalizers) == 0 })
+	if err := h.crClient.K8sV1().F5IPAMs(h.namespace).Delete(name, nil); err != nil {
+		h.t.Fatalf("Unable to delete F5IPAM %v: %v", name, err)
+	}
+}
+
+// waitFor waits until the F5IPAM satisfies the condition
+func (h *harness) waitFor(name string, condition func(*ficV1.F5IPAM) bool) *ficV1.F5IPAM {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		rsc := h.get(name)
+		if condition(rsc) {
+			return rsc
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("F5IPAM %v did not reach the expected state: %+v, Status: %v",
+				name, rsc.ObjectMeta, statusOf(rsc))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForIPs waits until every host of the F5IPAM has an IP Address, and returns them by host
+func (h *harness) waitForIPs(name string, hosts ...string) map[string]string {
+	var ips map[string]string
+	h.waitFor(name, func(rsc *ficV1.F5IPAM) bool {
+		ips = make(map[string]string)
+		for _, ipSpec := range rsc.Status.IPStatus {
+			if ipSpec.IP != "" {
+				ips[ipSpec.Host] = ipSpec.IP
+			}
+		}
+		if len(ips) != len(hosts) || len(rsc.Status.IPStatus) != len(hosts) {
+			return false
+		}
+		for _, host := range hosts {
+			if ips[host] == "" {
+				return false
+			}
+		}
+		return true
+	})
+	return ips
+}
+
+func statusOf(rsc *ficV1.F5IPAM) []ficV1.IPSpec {
+	var status []ficV1.IPSpec
+	for _, ipSpec := range rsc.Status.IPStatus {
+		status = append(status, *ipSpec)
+	}
+	return status
+}
+
+func hostSpec(host string) *ficV1.HostSpec {
+	return &ficV1.HostSpec{Host: host, Cidr: testCIDR}
+}
+
+func TestCreate(t *testing.T) {
+	h := newHarness(t)
+	h.create("web", hostSpec("foo.example.com"), hostSpec("bar.example.com"))
+
+	ips := h.waitForIPs("web", "foo.example.com", "bar.example.com")
+	if ips["foo.example.com"] == ips["bar.example.com"] {
+		t.Errorf("Hosts share the IP Address: %v", ips["foo.example.com"])
+	}
+	rsc := h.waitFor("web", func(rsc *ficV1.F5IPAM) bool { return len(rsc.Finalizers) != 0 })
+	if rsc.Finalizers[0] != orchestration.IPAMFinalizer {
+		t.Errorf("Unexpected finalizers: %v", rsc.Finalizers)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	h := newHarness(t)
+	h.create("web", hostSpec("foo.example.com"))
+	ips := h.waitForIPs("web", "foo.example.com")
+
+	// A renamed host keeps its IP Address
+	h.update("web", hostSpec("baz.example.com"))
+	renamed := h.waitForIPs("web", "baz.example.com")
+	if renamed["baz.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Renamed host got IP Address: %v, expected: %v",
+			renamed["baz.example.com"], ips["foo.example.com"])
+	}
+
+	h.update("web", hostSpec("baz.example.com"), hostSpec("qux.example.com"))
+	added := h.waitForIPs("web", "baz.example.com", "qux.example.com")
+	if added["baz.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Existing host changed its IP Address to: %v", added["baz.example.com"])
+	}
+}
+
+func TestDelete(t *testing.T) {
+	h := newHarness(t)
+	h.create("web", hostSpec("foo.example.com"))
+	h.waitForIPs("web", "foo.example.com")
+
+	h.delete("web")
+	deadline := time.Now().Add(waitTimeout)
+	for len(h.mgr.GetAllocations("foo.example.com")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Allocations are not released: %v", h.mgr.GetAllocations("foo.example.com"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	h := newHarness(t)
+	h.create("web", hostSpec("foo.example.com"))
+	ips := h.waitForIPs("web", "foo.example.com")
+
+	h.restart()
+	// The restored allocation is not given to a new host
+	h.create("api", hostSpec("bar.example.com"))
+	apiIPs := h.waitForIPs("api", "bar.example.com")
+	if apiIPs["bar.example.com"] == ips["foo.example.com"] {
+		t.Errorf("IP Address: %v of a restored allocation is allocated again", ips["foo.example.com"])
+	}
+	allocations := h.mgr.GetAllocations("foo.example.com")
+	if len(allocations) != 1 || allocations[0].IPAddr != ips["foo.example.com"] {
+		t.Errorf("Allocation of foo.example.com is not restored: %v", allocations)
+	}
+	if restored := h.waitForIPs("web", "foo.example.com"); restored["foo.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Status changed on restart to: %v", restored)
+	}
+}
+
+func TestRestartListFailure(t *testing.T) {
+	backoff := orchestration.RestoreListBackoff
+	orchestration.RestoreListBackoff = 10 * time.Millisecond
+	defer func() { orchestration.RestoreListBackoff = backoff }()
+
+	h := newHarness(t)
+	h.create("web", hostSpec("foo.example.com"))
+	ips := h.waitForIPs("web", "foo.example.com")
+	h.stop()
+
+	// F5IPAMs can not be listed for a while once the informer synced, when they are restored
+	lists := int32(0)
+	h.crClient.PrependReactor("list", "f5ipams", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if n := atomic.AddInt32(&lists, 1); n > 1 && n <= 4 {
+			return true, nil, errors.New("API server is not reachable")
+		}
+		return false, nil, nil
+	})
+	// The F5IPAM to restore is listed after the new one, which the worker would serve first
+	web := h.get("web")
+	web.ResourceVersion = ""
+	if err := h.crClient.K8sV1().F5IPAMs(orchestration.DefaultNamespace).Delete("web", nil); err != nil {
+		t.Fatal(err)
+	}
+	h.create("api", hostSpec("bar.example.com"))
+	if _, err := h.crClient.K8sV1().F5IPAMs(orchestration.DefaultNamespace).Create(web); err != nil {
+		t.Fatal(err)
+	}
+	h.start()
+
+	// The allocation is restored before the new host is allocated
+	apiIPs := h.waitForIPs("api", "bar.example.com")
+	if apiIPs["bar.example.com"] == ips["foo.example.com"] {
+		t.Errorf("IP Address: %v of an allocation to restore is allocated again", ips["foo.example.com"])
+	}
+	if restored := h.waitForIPs("web", "foo.example.com"); restored["foo.example.com"] != ips["foo.example.com"] {
+		t.Errorf("Status changed on restart to: %v", restored)
+	}
+}
@@ -0,0 +1,93 @@
+package orchestration
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	// PolicySource is the source of the namespace policies defined by Namespace annotations
+	PolicySource = "annotations"
+
+	// Annotations of a Namespace that restrict the allocations of its F5IPAMs
+	AllowedPoolsAnnotation            = "fic.f5.com/allowed-pools"
+	MaxAllocationsAnnotation          = "fic.f5.com/max-allocations"
+	MaxAllocationsPerF5IPAMAnnotation = "fic.f5.com/max-allocations-per-f5ipam"
+
+	// namespacesKey is the single key queued for any Namespace event, as policies are always
+	// reconciled together
+	namespacesKey = "namespaces"
+)
+
+// SetupPolicyManager sets the PolicyManager for the namespace policies
+func (k8sc *K8sIPAMClient) SetupPolicyManager(policyMgr PolicyManager) {
+	k8sc.policyMgr = policyMgr
+}
+
+func (k8sc *K8sIPAMClient) enqueueNamespaces(obj interface{}) {
+	k8sc.nsQueue.Add(namespacesKey)
+}
+
+// namespaceWorker starts the Namespace Worker.
+func (k8sc *K8sIPAMClient) namespaceWorker() {
+	k8sLog.Debugf("Starting Namespace Worker")
+	for k8sc.processNamespaces() {
+	}
+}
+
+func (k8sc *K8sIPAMClient) processNamespaces() bool {
+	key, quit := k8sc.nsQueue.Get()
+	if quit {
+		return false
+	}
+	defer k8sc.nsQueue.Done(key)
+
+	if k8sc.policyMgr == nil {
+		return true
+	}
+
+	var policies []ipamspec.NamespacePolicy
+	for _, ns := range k8sc.ipamCli.ListNamespaces() {
+		if policy, ok := policyFromNamespace(ns); ok {
+			policies = append(policies, policy)
+		}
+	}
+	k8sc.policyMgr.ReconcileNamespacePolicies(PolicySource, policies)
+	return true
+}
+
+// policyFromNamespace reads the namespace policy from the annotations, invalid values are ignored
+func policyFromNamespace(ns *coreV1.Namespace) (ipamspec.NamespacePolicy, bool) {
+	policy := ipamspec.NamespacePolicy{Namespace: ns.Name}
+	found := false
+
+	if pools, ok := ns.Annotations[AllowedPoolsAnnotation]; ok {
+		for _, cidr := range strings.Split(pools, ",") {
+			if cidr = strings.TrimSpace(cidr); cidr != "" {
+				policy.AllowedPools = append(policy.AllowedPools, cidr)
+			}
+		}
+		found = true
+	}
+	if value, ok := ns.Annotations[MaxAllocationsAnnotation]; ok {
+		if max, err := strconv.Atoi(value); err == nil && max >= 0 {
+			policy.MaxAllocations = max
+			found = true
+		} else {
+			k8sLog.Errorf("Invalid annotation %v: %v of Namespace: %v", MaxAllocationsAnnotation, value, ns.Name)
+		}
+	}
+	if value, ok := ns.Annotations[MaxAllocationsPerF5IPAMAnnotation]; ok {
+		if max, err := strconv.Atoi(value); err == nil && max >= 0 {
+			policy.MaxAllocationsPerResource = max
+			found = true
+		} else {
+			k8sLog.Errorf("Invalid annotation %v: %v of Namespace: %v",
+				MaxAllocationsPerF5IPAMAnnotation, value, ns.Name)
+		}
+	}
+	return policy, found
+}
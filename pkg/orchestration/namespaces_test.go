@@ -0,0 +1,58 @@
+package orchestration_test
+
+import (
+	"testing"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+)
+
+func TestNamespaceQuota(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		policies  []ipamspec.NamespacePolicy
+		// limited is whether the second F5IPAM of the namespace is refused an IP Address
+		limited bool
+	}{
+		{
+			name:      "quota of another namespace than kube-system",
+			namespace: "prod",
+			policies:  []ipamspec.NamespacePolicy{{Namespace: "prod", MaxAllocations: 1}},
+			limited:   true,
+		},
+		{
+			name:      "quota of kube-system",
+			namespace: orchestration.DefaultNamespace,
+			policies:  []ipamspec.NamespacePolicy{{Namespace: orchestration.DefaultNamespace, MaxAllocations: 1}},
+			limited:   true,
+		},
+		{
+			name:      "quota of another namespace",
+			namespace: "prod",
+			policies:  []ipamspec.NamespacePolicy{{Namespace: "dev", MaxAllocations: 1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.stop()
+			h.policies = test.policies
+			h.start()
+
+			h.namespace = test.namespace
+			h.create("web", hostSpec("foo.example.com"))
+			h.waitForIPs("web", "foo.example.com")
+			h.create("api", hostSpec("bar.example.com"))
+			if !test.limited {
+				h.waitForIPs("api", "bar.example.com")
+				return
+			}
+			h.waitFor("api", func(rsc *ficV1.F5IPAM) bool {
+				return len(rsc.Status.IPStatus) == 1 && rsc.Status.IPStatus[0].IP == "" &&
+					rsc.Status.IPStatus[0].Status == orchestration.FailedStatus
+			})
+		})
+	}
+}
@@ -1,18 +1,127 @@
 package orchestration
 
 import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 )
 
+// withRequest adds the fields of the request and its IP Address, when it has one, to the entry
+func withRequest(entry *log.Entry, req ipamspec.IPAMRequest, ipAddr string) *log.Entry {
+	entry = entry.With(req.LogFields()...)
+	if ipAddr != "" {
+		entry = entry.With(log.IPKey, ipAddr)
+	}
+	return entry
+}
+
 type Orchestrator interface {
 	// SetupCommunicationChannels sets Request and Response channels
 	SetupCommunicationChannels(reqChan chan<- ipamspec.IPAMRequest, respChan <-chan ipamspec.IPAMResponse)
 	// Starts the Orchestrator, watching for resources
 	Start(stopCh <-chan struct{})
-
+	// Stops watching for resources, and returns once the requests of the resources that
+	// were already received are sent
 	Stop()
 }
 
-func NewOrchestrator() Orchestrator {
-	return NewIPAMK8SClient()
+// DrainingOrchestrator is an Orchestrator that keeps publishing responses on shutdown
+type DrainingOrchestrator interface {
+	// Drained is closed once the responses are published, after the response channel is closed
+	Drained() <-chan struct{}
+}
+
+// PoolManager maintains the pools of IP Addresses defined by Orchestrators
+type PoolManager interface {
+	// ReconcilePools replaces the pool definitions of the source
+	ReconcilePools(source string, pools []ipamspec.PoolSpec) ipamspec.PoolReport
+	// GetPoolStats returns the utilization of the pools
+	GetPoolStats() []ipamspec.PoolStats
+}
+
+// PoolOrchestrator is an Orchestrator that also defines pools of IP Addresses
+type PoolOrchestrator interface {
+	// SetupPoolManager sets the PoolManager for the pool definitions
+	SetupPoolManager(poolMgr PoolManager)
+}
+
+// PolicyManager enforces the namespace policies defined by Orchestrators
+type PolicyManager interface {
+	// ReconcileNamespacePolicies replaces the namespace policies of the source
+	ReconcileNamespacePolicies(source string, policies []ipamspec.NamespacePolicy)
+}
+
+// PolicyOrchestrator is an Orchestrator that also defines namespace policies
+type PolicyOrchestrator interface {
+	// SetupPolicyManager sets the PolicyManager for the namespace policies
+	SetupPolicyManager(policyMgr PolicyManager)
+}
+
+// Params of the Orchestrators, each of them uses the ones that apply to it
+type Params struct {
+	// Directory of the F5IPAM documents of the file Orchestrator
+	Directory string
+	// Interval to check the Directory for changes, DefaultFileInterval when zero
+	Interval time.Duration
+
+	// Address the http Orchestrator listens on, DefaultHTTPAddress when empty
+	Address string
+	// TokenFile holds the bearer tokens accepted by the http Orchestrator, one per line
+	TokenFile string
+	// StateFile persists the allocations of the http Orchestrator, which requires it
+	StateFile string
+	// TLSCertFile and TLSKeyFile serve the http Orchestrator over TLS when given
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Namespaces whose F5IPAMs the kubernetes Orchestrator watches, all of them when empty
+	Namespaces []string
+}
+
+// Factory creates an Orchestrator with the params
+type Factory func(params Params) (Orchestrator, error)
+
+var (
+	registryMutex sync.Mutex
+	registry      = make(map[string]Factory)
+)
+
+// Register makes an Orchestrator available by its name
+func Register(name string, factory Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("Orchestrator %v is registered twice", name))
+	}
+	registry[name] = factory
+}
+
+// Names returns the names of the registered Orchestrators in order
+func Names() []string {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewOrchestrator creates the Orchestrator registered by the name
+func NewOrchestrator(name string, params Params) (Orchestrator, error) {
+	registryMutex.Lock()
+	factory, ok := registry[name]
+	registryMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown orchestration: %v, supported orchestrations are %v", name, Names())
+	}
+	return factory(params)
 }
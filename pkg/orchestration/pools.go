@@ -0,0 +1,268 @@
+package orchestration
+
+import (
+	"fmt"
+	"time"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PoolSource is the source of the pools defined by F5IPAMPools
+	PoolSource = "f5ipampool"
+
+	// PoolStatusInterval is the period at which the utilization in F5IPAMPool status is refreshed
+	PoolStatusInterval = 30 * time.Second
+
+	// Conditions of F5IPAMPool status
+	PoolAccepted        = "Accepted"
+	UtilizationWarning  = "UtilizationWarning"
+	UtilizationCritical = "UtilizationCritical"
+
+	ConditionTrue  = "True"
+	ConditionFalse = "False"
+
+	// poolsKey is the single key queued for any F5IPAMPool event, as pools are always
+	// reconciled together
+	poolsKey = "f5ipampools"
+)
+
+// SetupPoolManager sets the PoolManager for the pool definitions
+func (k8sc *K8sIPAMClient) SetupPoolManager(poolMgr PoolManager) {
+	k8sc.poolMgr = poolMgr
+}
+
+func (k8sc *K8sIPAMClient) enqueuePools(obj interface{}) {
+	k8sc.poolQueue.Add(poolsKey)
+}
+
+// poolWorker starts the F5IPAMPool Worker.
+func (k8sc *K8sIPAMClient) poolWorker() {
+	k8sLog.Debugf("Starting F5IPAMPool Worker")
+	for k8sc.processPools() {
+	}
+}
+
+func (k8sc *K8sIPAMClient) processPools() bool {
+	key, quit := k8sc.poolQueue.Get()
+	if quit {
+		return false
+	}
+	defer k8sc.poolQueue.Done(key)
+
+	if !k8sc.reconcilePools() {
+		k8sc.poolQueue.AddRateLimited(key)
+		return true
+	}
+	k8sc.poolQueue.Forget(key)
+	return true
+}
+
+// reconcilePools reconciles the pools of the F5IPAMPools known to the informer, and returns
+// whether they are listed
+func (k8sc *K8sIPAMClient) reconcilePools() bool {
+	if k8sc.poolMgr == nil {
+		return true
+	}
+
+	pools, err := k8sc.ipamCli.ListPools()
+	if err != nil {
+		k8sLog.Errorf("Unable to list F5IPAMPools: %v", err)
+		return false
+	}
+
+	var poolSpecs []ipamspec.PoolSpec
+	invalid := make(map[string]string)
+	for _, pool := range pools {
+		poolSpec, err := poolSpecFromResource(pool)
+		if err != nil {
+			k8sLog.Errorf("Invalid F5IPAMPool %v: %v", pool.Name, err)
+			invalid[pool.Name] = err.Error()
+			continue
+		}
+		poolSpecs = append(poolSpecs, poolSpec)
+	}
+
+	report := k8sc.poolMgr.ReconcilePools(PoolSource, poolSpecs)
+	refused := make(map[string]bool)
+	for _, cidr := range report.Refused {
+		refused[cidr] = true
+	}
+
+	k8sc.poolMutex.Lock()
+	k8sc.poolResult = poolResult{invalid: invalid, refused: refused}
+	k8sc.poolMutex.Unlock()
+	k8sc.updatePoolStatus()
+	return true
+}
+
+func poolSpecFromResource(pool *ficV1.F5IPAMPool) (ipamspec.PoolSpec, error) {
+	poolSpec := ipamspec.PoolSpec{
+		CIDR:              pool.Spec.Cidr,
+		Ranges:            pool.Spec.Ranges,
+		Exclusions:        pool.Spec.Exclusions,
+		Strategy:          pool.Spec.Strategy,
+		AllowedNamespaces: pool.Spec.AllowedNamespaces,
+	}
+	if poolSpec.CIDR == "" {
+		return poolSpec, fmt.Errorf("cidr is required")
+	}
+	if pool.Spec.ReleaseCooldown != "" {
+		cooldown, err := time.ParseDuration(pool.Spec.ReleaseCooldown)
+		if err != nil {
+			return poolSpec, fmt.Errorf("invalid releaseCooldown: %v", err)
+		}
+		poolSpec.ReleaseCooldown = cooldown
+	}
+	policy, period, err := parseReclaimPolicy(pool.Spec.ReclaimPolicy, pool.Spec.RetainPeriod)
+	if err != nil {
+		return poolSpec, err
+	}
+	poolSpec.ReclaimPolicy, poolSpec.RetainPeriod = policy, period
+	return poolSpec, nil
+}
+
+// parseReclaimPolicy validates the reclaim policy and parses its retain period
+func parseReclaimPolicy(policy, retainPeriod string) (string, time.Duration, error) {
+	if policy != "" && !ipamspec.IsValidReclaimPolicy(policy) {
+		return "", 0, fmt.Errorf("invalid reclaimPolicy: %v", policy)
+	}
+	if retainPeriod == "" {
+		return policy, 0, nil
+	}
+	period, err := time.ParseDuration(retainPeriod)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid retainPeriod: %v", err)
+	}
+	return policy, period, nil
+}
+
+// poolResult is the outcome of the last reconciliation of F5IPAMPools
+type poolResult struct {
+	// Error of each invalid F5IPAMPool by name
+	invalid map[string]string
+	// CIDRs whose shrink was refused
+	refused map[string]bool
+}
+
+// updatePoolStatus writes the utilization and conditions to the status of every F5IPAMPool
+func (k8sc *K8sIPAMClient) updatePoolStatus() {
+	if k8sc.poolMgr == nil {
+		return
+	}
+	k8sc.poolMutex.Lock()
+	defer k8sc.poolMutex.Unlock()
+
+	pools, err := k8sc.ipamCli.ListPools()
+	if err != nil {
+		k8sLog.Errorf("Unable to list F5IPAMPools: %v", err)
+		return
+	}
+
+	stats := make(map[string]ipamspec.PoolStats)
+	for _, poolStats := range k8sc.poolMgr.GetPoolStats() {
+		stats[poolStats.CIDR] = poolStats
+	}
+
+	for _, pool := range pools {
+		status := pool.Status.DeepCopy()
+		poolStats, served := stats[pool.Spec.Cidr]
+		status.Total = poolStats.Total
+		status.Allocated = poolStats.Allocated
+		status.Free = poolStats.Free
+		status.Retained = poolStats.Retained
+
+		switch {
+		case k8sc.poolResult.invalid[pool.Name] != "":
+			setPoolCondition(status, PoolAccepted, ConditionFalse, "InvalidSpec", k8sc.poolResult.invalid[pool.Name])
+		case k8sc.poolResult.refused[pool.Spec.Cidr]:
+			setPoolCondition(status, PoolAccepted, ConditionFalse, "ShrinkRefused",
+				"Allocated IP Addresses would be orphaned, the previous definition is in use")
+		case !served:
+			setPoolCondition(status, PoolAccepted, ConditionFalse, "NotServed",
+				"The pool is not served, it may be invalid or defined elsewhere")
+		default:
+			setPoolCondition(status, PoolAccepted, ConditionTrue, "Served", "")
+		}
+
+		utilization := 0
+		if poolStats.Total != 0 {
+			utilization = poolStats.Allocated * 100 / poolStats.Total
+		}
+		setThresholdCondition(status, UtilizationWarning, pool.Spec.WarningThreshold, utilization)
+		setThresholdCondition(status, UtilizationCritical, pool.Spec.CriticalThreshold, utilization)
+
+		if equalPoolStatus(&pool.Status, status) {
+			continue
+		}
+		updated := pool.DeepCopy()
+		updated.Status = *status
+		if _, err := k8sc.ipamCli.UpdatePool(updated); err != nil {
+			k8sLog.Errorf("Unable to Update status of F5IPAMPool: %v, Error: %v", pool.Name, err)
+		}
+	}
+}
+
+func setThresholdCondition(status *ficV1.F5IPAMPoolStatus, condType string, threshold, utilization int) {
+	if threshold <= 0 {
+		removePoolCondition(status, condType)
+		return
+	}
+	if utilization >= threshold {
+		setPoolCondition(status, condType, ConditionTrue, "ThresholdCrossed",
+			fmt.Sprintf("Utilization %v%% crossed the threshold of %v%%", utilization, threshold))
+		return
+	}
+	setPoolCondition(status, condType, ConditionFalse, "BelowThreshold",
+		fmt.Sprintf("Utilization %v%% is below the threshold of %v%%", utilization, threshold))
+}
+
+// setPoolCondition sets the condition, its transition time changes only with its status
+func setPoolCondition(status *ficV1.F5IPAMPoolStatus, condType, condStatus, reason, message string) {
+	for i := range status.Conditions {
+		cond := &status.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status != condStatus {
+			cond.LastTransitionTime = metaV1.Now()
+		}
+		cond.Status = condStatus
+		cond.Reason = reason
+		cond.Message = message
+		return
+	}
+	status.Conditions = append(status.Conditions, ficV1.PoolCondition{
+		Type:               condType,
+		Status:             condStatus,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metaV1.Now(),
+	})
+}
+
+func removePoolCondition(status *ficV1.F5IPAMPoolStatus, condType string) {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			status.Conditions = append(status.Conditions[:i], status.Conditions[i+1:]...)
+			return
+		}
+	}
+}
+
+func equalPoolStatus(a, b *ficV1.F5IPAMPoolStatus) bool {
+	if a.Total != b.Total || a.Allocated != b.Allocated || a.Free != b.Free || a.Retained != b.Retained {
+		return false
+	}
+	if len(a.Conditions) != len(b.Conditions) {
+		return false
+	}
+	for i := range a.Conditions {
+		if a.Conditions[i] != b.Conditions[i] {
+			return false
+		}
+	}
+	return true
+}
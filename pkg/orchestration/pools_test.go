@@ -0,0 +1,185 @@
+package orchestration_test
+
+import (
+	"testing"
+	"time"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testPoolCIDR = "10.20.20.0/24"
+
+// waitForPool waits until the F5IPAMPool satisfies the condition
+func (h *harness) waitForPool(name string, condition func(*ficV1.F5IPAMPool) bool) *ficV1.F5IPAMPool {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		pool, err := h.crClient.K8sV1().F5IPAMPools().Get(name, metav1.GetOptions{})
+		if err != nil {
+			h.t.Fatalf("Unable to get F5IPAMPool %v: %v", name, err)
+		}
+		if condition(pool) {
+			return pool
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("F5IPAMPool %v did not reach the expected state, Status: %+v", name, pool.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func poolCondition(pool *ficV1.F5IPAMPool, condType string) *ficV1.PoolCondition {
+	for i := range pool.Status.Conditions {
+		if pool.Status.Conditions[i].Type == condType {
+			return &pool.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+func TestPoolStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ficV1.F5IPAMPoolSpec
+		// hosts are allocated from the pool before its status is checked
+		hosts     []string
+		accepted  string
+		reason    string
+		total     int
+		allocated int
+		// warning and critical are the expected statuses of the utilization conditions,
+		// empty when the condition is not expected
+		warning  string
+		critical string
+	}{
+		{
+			name:     "served",
+			spec:     ficV1.F5IPAMPoolSpec{Cidr: testPoolCIDR, Ranges: []string{"10.20.20.1-10.20.20.4"}},
+			accepted: orchestration.ConditionTrue,
+			reason:   "Served",
+			total:    4,
+		},
+		{
+			name: "utilization below the thresholds",
+			spec: ficV1.F5IPAMPoolSpec{
+				Cidr:              testPoolCIDR,
+				Ranges:            []string{"10.20.20.1-10.20.20.4"},
+				WarningThreshold:  50,
+				CriticalThreshold: 75,
+			},
+			hosts:     []string{"foo.example.com"},
+			accepted:  orchestration.ConditionTrue,
+			reason:    "Served",
+			total:     4,
+			allocated: 1,
+			warning:   orchestration.ConditionFalse,
+			critical:  orchestration.ConditionFalse,
+		},
+		{
+			name: "utilization crossed the warning threshold",
+			spec: ficV1.F5IPAMPoolSpec{
+				Cidr:              testPoolCIDR,
+				Ranges:            []string{"10.20.20.1-10.20.20.4"},
+				WarningThreshold:  50,
+				CriticalThreshold: 75,
+			},
+			hosts:     []string{"foo.example.com", "bar.example.com"},
+			accepted:  orchestration.ConditionTrue,
+			reason:    "Served",
+			total:     4,
+			allocated: 2,
+			warning:   orchestration.ConditionTrue,
+			critical:  orchestration.ConditionFalse,
+		},
+		{
+			name:     "missing cidr",
+			spec:     ficV1.F5IPAMPoolSpec{Ranges: []string{"10.20.20.1-10.20.20.4"}},
+			accepted: orchestration.ConditionFalse,
+			reason:   "InvalidSpec",
+		},
+		{
+			name: "invalid releaseCooldown",
+			spec: ficV1.F5IPAMPoolSpec{
+				Cidr:            testPoolCIDR,
+				Ranges:          []string{"10.20.20.1-10.20.20.4"},
+				ReleaseCooldown: "soon",
+			},
+			accepted: orchestration.ConditionFalse,
+			reason:   "InvalidSpec",
+		},
+		{
+			name: "invalid reclaimPolicy",
+			spec: ficV1.F5IPAMPoolSpec{
+				Cidr:          testPoolCIDR,
+				Ranges:        []string{"10.20.20.1-10.20.20.4"},
+				ReclaimPolicy: "Keep",
+			},
+			accepted: orchestration.ConditionFalse,
+			reason:   "InvalidSpec",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			pool := &ficV1.F5IPAMPool{
+				ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+				Spec:       test.spec,
+			}
+			if _, err := h.crClient.K8sV1().F5IPAMPools().Create(pool); err != nil {
+				t.Fatalf("Unable to create F5IPAMPool: %v", err)
+			}
+			// The status may be refreshed before the pool is reconciled, which is not served then
+			accepted := func(pool *ficV1.F5IPAMPool) bool {
+				cond := poolCondition(pool, orchestration.PoolAccepted)
+				return cond != nil && cond.Reason == test.reason
+			}
+			h.waitForPool("pool", accepted)
+
+			if len(test.hosts) != 0 {
+				var hostSpecs []*ficV1.HostSpec
+				for _, host := range test.hosts {
+					hostSpecs = append(hostSpecs, &ficV1.HostSpec{Host: host, Cidr: testPoolCIDR})
+				}
+				h.create("web", hostSpecs...)
+				h.waitForIPs("web", test.hosts...)
+
+				// The utilization is refreshed with any change of the F5IPAMPools
+				pool, err := h.crClient.K8sV1().F5IPAMPools().Get("pool", metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Unable to get F5IPAMPool: %v", err)
+				}
+				pool.Labels = map[string]string{"refresh": "true"}
+				if _, err := h.crClient.K8sV1().F5IPAMPools().Update(pool); err != nil {
+					t.Fatalf("Unable to update F5IPAMPool: %v", err)
+				}
+			}
+
+			pool = h.waitForPool("pool", func(pool *ficV1.F5IPAMPool) bool {
+				return accepted(pool) && pool.Status.Allocated == test.allocated
+			})
+			if pool.Status.Total != test.total || pool.Status.Free != test.total-test.allocated {
+				t.Errorf("Status got: %+v, expected Total: %v, Allocated: %v", pool.Status, test.total, test.allocated)
+			}
+			cond := poolCondition(pool, orchestration.PoolAccepted)
+			if cond.Status != test.accepted || cond.Reason != test.reason {
+				t.Errorf("%v condition got: %v/%v, expected: %v/%v",
+					orchestration.PoolAccepted, cond.Status, cond.Reason, test.accepted, test.reason)
+			}
+			for condType, expected := range map[string]string{
+				orchestration.UtilizationWarning:  test.warning,
+				orchestration.UtilizationCritical: test.critical,
+			} {
+				cond := poolCondition(pool, condType)
+				switch {
+				case expected == "" && cond != nil:
+					t.Errorf("%v condition is set: %+v", condType, *cond)
+				case expected != "" && cond == nil:
+					t.Errorf("%v condition is not set", condType)
+				case expected != "" && cond.Status != expected:
+					t.Errorf("%v condition got: %v, expected: %v", condType, cond.Status, expected)
+				}
+			}
+		})
+	}
+}
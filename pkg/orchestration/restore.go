@@ -0,0 +1,126 @@
+package orchestration
+
+import (
+	"sync"
+	"time"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+// Backoff of listing the F5IPAMs to restore, while the API server can not be reached.
+// No new allocation is requested before the allocations are restored.
+var (
+	RestoreListBackoff    = time.Second
+	MaxRestoreListBackoff = 30 * time.Second
+)
+
+// restoreState counts the outstanding restore requests of the startup
+type restoreState struct {
+	sync.Mutex
+	pending  int
+	failed   int
+	restored int
+	doneCh   chan struct{}
+	// logger of the Orchestrator that restores the allocations
+	logger *log.Entry
+}
+
+// restoreAllocations requests the IP Addresses in the Status of every F5IPAM, and waits for all
+// of them to be restored before any new allocation is requested. Listing the F5IPAMs is retried
+// until it succeeds. It returns false when stopped while waiting.
+func (k8sc *K8sIPAMClient) restoreAllocations(stopCh <-chan struct{}) bool {
+	var rscs []*ficV1.F5IPAM
+	for backoff := RestoreListBackoff; ; backoff *= 2 {
+		var err error
+		if rscs, err = k8sc.ipamCli.List(); err == nil {
+			break
+		}
+		if backoff > MaxRestoreListBackoff {
+			backoff = MaxRestoreListBackoff
+		}
+		k8sLog.Errorf("Unable to list F5IPAMs to restore their allocations, retrying in %v: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-stopCh:
+			return false
+		}
+	}
+
+	var reqs []ipamspec.IPAMRequest
+	for _, rsc := range rscs {
+		for _, ipSpec := range rsc.Status.IPStatus {
+			if ipSpec.IP != "" {
+				reqs = append(reqs, restoreRequest(rsc, ipSpec))
+			}
+		}
+	}
+	if len(reqs) == 0 {
+		return true
+	}
+
+	doneCh := k8sc.restore.begin(len(reqs), k8sLog)
+	k8sLog.Infof("Restoring %v allocations of %v F5IPAMs", len(reqs), len(rscs))
+	for _, req := range reqs {
+		k8sc.sendRequest(req)
+	}
+	select {
+	case <-doneCh:
+		return true
+	case <-stopCh:
+	}
+	return false
+}
+
+// begin starts counting the responses of the restore requests, the returned channel is closed
+// once all of them are answered
+func (state *restoreState) begin(pending int, logger *log.Entry) <-chan struct{} {
+	state.Lock()
+	defer state.Unlock()
+
+	state.pending = pending
+	state.logger = logger
+	state.doneCh = make(chan struct{})
+	return state.doneCh
+}
+
+// answered counts the response of a restore request of the startup
+func (state *restoreState) answered(resp ipamspec.IPAMResponse) {
+	state.Lock()
+	defer state.Unlock()
+
+	if state.pending == 0 {
+		return
+	}
+	if resp.Status {
+		state.restored++
+	} else {
+		state.failed++
+		withRequest(state.logger, resp.Request, resp.Request.IPAddr).Warningf(
+			"Unable to restore IP Address of Host. %v", resp.Reason)
+	}
+	state.pending--
+	if state.pending == 0 {
+		state.logger.Infof("Restored %v allocations, %v could not be restored",
+			state.restored, state.failed)
+		close(state.doneCh)
+	}
+}
+
+// restoreRequest requests the IP Address the F5IPAM held for the host before
+func restoreRequest(rsc *ficV1.F5IPAM, ipSpec *ficV1.IPSpec) ipamspec.IPAMRequest {
+	return ipamspec.IPAMRequest{
+		Metadata: ResourceMeta{
+			name:      rsc.Name,
+			namespace: rsc.Namespace,
+		},
+		Namespace: rsc.Namespace,
+		Name:      rsc.Name,
+		HostName:  ipSpec.Host,
+		CIDR:      ipSpec.Cidr,
+		Key:       ipSpec.Key,
+		IPAddr:    ipSpec.IP,
+		Operation: ipamspec.CREATE,
+	}
+}
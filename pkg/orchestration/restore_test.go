@@ -0,0 +1,170 @@
+package orchestration_test
+
+import (
+	"testing"
+
+	ficV1 "github.com/subbuv26/f5-ipam-controller/pkg/ipamapis/apis/fic/v1"
+	"github.com/subbuv26/f5-ipam-controller/pkg/orchestration"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// restoredResource is an F5IPAM as the controller left it before a restart
+type restoredResource struct {
+	name  string
+	hosts []string
+	// status holds the IP Address of each host allocated before the restart
+	status map[string]string
+	// cidr of the hosts, testCIDR when empty
+	cidr string
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name string
+		// pool is an F5IPAMPool that exists before the restart
+		pool *ficV1.F5IPAMPoolSpec
+		// resources are listed in order
+		resources []restoredResource
+		// expected IP Address of each host by resource after the restart
+		expected map[string]map[string]string
+	}{
+		{
+			name: "restored before new hosts of other resources",
+			resources: []restoredResource{
+				{name: "api", hosts: []string{"bar.example.com"}},
+				{name: "web", hosts: []string{"foo.example.com"}, status: map[string]string{"foo.example.com": "10.10.10.1"}},
+			},
+			expected: map[string]map[string]string{
+				"api": {"bar.example.com": "10.10.10.2"},
+				"web": {"foo.example.com": "10.10.10.1"},
+			},
+		},
+		{
+			name: "restored before new hosts of the resource",
+			resources: []restoredResource{
+				{
+					name:   "web",
+					hosts:  []string{"bar.example.com", "foo.example.com"},
+					status: map[string]string{"foo.example.com": "10.10.10.1"},
+				},
+			},
+			expected: map[string]map[string]string{
+				"web": {"bar.example.com": "10.10.10.2", "foo.example.com": "10.10.10.1"},
+			},
+		},
+		{
+			name: "restored from every resource",
+			resources: []restoredResource{
+				{name: "app", hosts: []string{"baz.example.com"}},
+				{name: "web", hosts: []string{"foo.example.com"}, status: map[string]string{"foo.example.com": "10.10.10.3"}},
+				{name: "api", hosts: []string{"bar.example.com"}, status: map[string]string{"bar.example.com": "10.10.10.1"}},
+			},
+			expected: map[string]map[string]string{
+				"app": {"baz.example.com": "10.10.10.2"},
+				"web": {"foo.example.com": "10.10.10.3"},
+				"api": {"bar.example.com": "10.10.10.1"},
+			},
+		},
+		{
+			name: "restored into the pool of an F5IPAMPool",
+			pool: &ficV1.F5IPAMPoolSpec{Cidr: testPoolCIDR, Ranges: []string{"10.20.20.1-10.20.20.4"}},
+			resources: []restoredResource{
+				{name: "api", hosts: []string{"bar.example.com"}, cidr: testPoolCIDR},
+				{
+					name:   "web",
+					hosts:  []string{"foo.example.com"},
+					status: map[string]string{"foo.example.com": "10.20.20.3"},
+					cidr:   testPoolCIDR,
+				},
+			},
+			expected: map[string]map[string]string{
+				"api": {"bar.example.com": "10.20.20.1"},
+				"web": {"foo.example.com": "10.20.20.3"},
+			},
+		},
+		{
+			name: "address out of the pool is allocated again",
+			resources: []restoredResource{
+				{name: "web", hosts: []string{"foo.example.com"}, status: map[string]string{"foo.example.com": "10.10.10.50"}},
+			},
+			expected: map[string]map[string]string{
+				"web": {"foo.example.com": "10.10.10.1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.stop()
+			if test.pool != nil {
+				pool := &ficV1.F5IPAMPool{ObjectMeta: metav1.ObjectMeta{Name: "pool"}, Spec: *test.pool}
+				if _, err := h.crClient.K8sV1().F5IPAMPools().Create(pool); err != nil {
+					t.Fatalf("Unable to create F5IPAMPool: %v", err)
+				}
+			}
+			h.createRestored(test.resources...)
+			h.start()
+
+			// The Status of the restart is replaced when an address can not be restored
+			for name, expected := range test.expected {
+				expected := expected
+				h.waitFor(name, func(rsc *ficV1.F5IPAM) bool {
+					if len(rsc.Status.IPStatus) != len(expected) {
+						return false
+					}
+					for _, ipSpec := range rsc.Status.IPStatus {
+						if ipSpec.IP != expected[ipSpec.Host] {
+							return false
+						}
+					}
+					return true
+				})
+			}
+		})
+	}
+}
+
+func TestRestoreConflict(t *testing.T) {
+	h := newHarness(t)
+	h.stop()
+	// Either of the F5IPAMs restores the address, the other one is allocated another
+	h.createRestored(
+		restoredResource{name: "web", hosts: []string{"foo.example.com"}, status: map[string]string{"foo.example.com": "10.10.10.1"}},
+		restoredResource{name: "api", hosts: []string{"bar.example.com"}, status: map[string]string{"bar.example.com": "10.10.10.1"}},
+	)
+	h.start()
+
+	ips := make(map[string]bool)
+	for name, host := range map[string]string{"web": "foo.example.com", "api": "bar.example.com"} {
+		rsc := h.waitFor(name, func(rsc *ficV1.F5IPAM) bool {
+			return len(h.mgr.GetAllocations(host)) == 1 && len(rsc.Status.IPStatus) == 1 &&
+				rsc.Status.IPStatus[0].IP == h.mgr.GetAllocations(host)[0].IPAddr
+		})
+		ips[rsc.Status.IPStatus[0].IP] = true
+	}
+	if !ips["10.10.10.1"] || !ips["10.10.10.2"] {
+		t.Errorf("Conflicting addresses are restored as: %v", ips)
+	}
+}
+
+// createRestored creates the F5IPAMs with the Status of the allocations before a restart
+func (h *harness) createRestored(resources ...restoredResource) {
+	for _, res := range resources {
+		rsc := &ficV1.F5IPAM{
+			ObjectMeta: metav1.ObjectMeta{Name: res.name, Namespace: orchestration.DefaultNamespace},
+		}
+		cidr := res.cidr
+		if cidr == "" {
+			cidr = testCIDR
+		}
+		for _, host := range res.hosts {
+			rsc.Spec.HostSpecs = append(rsc.Spec.HostSpecs, &ficV1.HostSpec{Host: host, Cidr: cidr})
+			if ip := res.status[host]; ip != "" {
+				rsc.Status.IPStatus = append(rsc.Status.IPStatus, &ficV1.IPSpec{Host: host, Cidr: cidr, IP: ip})
+			}
+		}
+		if _, err := h.crClient.K8sV1().F5IPAMs(orchestration.DefaultNamespace).Create(rsc); err != nil {
+			h.t.Fatalf("Unable to create F5IPAM %v: %v", res.name, err)
+		}
+	}
+}
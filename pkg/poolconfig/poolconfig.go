@@ -0,0 +1,202 @@
+package poolconfig
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+	"sigs.k8s.io/yaml"
+)
+
+// poolLog logs the messages of the pool configuration
+var poolLog = log.NewSubsystemLogger("POOL")
+
+// DefaultInterval is the period at which the pool configuration file is checked for changes
+const DefaultInterval = 10 * time.Second
+
+// Config is the content of the pool configuration file, in YAML or JSON
+//
+//	pools:
+//	- cidr: 10.10.1.0/24
+//	  ranges:
+//	  - 10.10.1.10-10.10.1.50
+//	  exclusions:
+//	  - 10.10.1.20
+//	  strategy: random
+//	  releaseCooldown: 10m
+//	  reclaimPolicy: Retain
+//	  retainPeriod: 24h
+//	  allowedNamespaces:
+//	  - team-a
+//	namespaces:
+//	- name: team-a
+//	  allowedPools:
+//	  - 10.10.1.0/24
+//	  maxAllocations: 20
+//	  maxAllocationsPerF5IPAM: 5
+type Config struct {
+	Pools      []Pool      `json:"pools"`
+	Namespaces []Namespace `json:"namespaces,omitempty"`
+}
+
+type Pool struct {
+	CIDR              string   `json:"cidr"`
+	Ranges            []string `json:"ranges"`
+	Exclusions        []string `json:"exclusions,omitempty"`
+	Strategy          string   `json:"strategy,omitempty"`
+	ReleaseCooldown   string   `json:"releaseCooldown,omitempty"`
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	ReclaimPolicy     string   `json:"reclaimPolicy,omitempty"`
+	RetainPeriod      string   `json:"retainPeriod,omitempty"`
+}
+
+// Namespace is the policy of a namespace, zero maximums are unlimited
+type Namespace struct {
+	Name                    string   `json:"name"`
+	AllowedPools            []string `json:"allowedPools,omitempty"`
+	MaxAllocations          int      `json:"maxAllocations,omitempty"`
+	MaxAllocationsPerF5IPAM int      `json:"maxAllocationsPerF5IPAM,omitempty"`
+}
+
+// Definitions are the pools and namespace policies of the configuration file
+type Definitions struct {
+	Pools    []ipamspec.PoolSpec
+	Policies []ipamspec.NamespacePolicy
+}
+
+// Load reads the pool definitions and namespace policies from the configuration file
+func Load(path string) (Definitions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Definitions{}, err
+	}
+	return parse(data)
+}
+
+func parse(data []byte) (Definitions, error) {
+	var defs Definitions
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return defs, fmt.Errorf("Invalid pool configuration: %v", err)
+	}
+
+	for _, pool := range cfg.Pools {
+		poolSpec := ipamspec.PoolSpec{
+			CIDR:              pool.CIDR,
+			Ranges:            pool.Ranges,
+			Exclusions:        pool.Exclusions,
+			Strategy:          pool.Strategy,
+			AllowedNamespaces: pool.AllowedNamespaces,
+			ReclaimPolicy:     pool.ReclaimPolicy,
+		}
+		if pool.ReleaseCooldown != "" {
+			cooldown, err := time.ParseDuration(pool.ReleaseCooldown)
+			if err != nil {
+				return defs, fmt.Errorf("Invalid releaseCooldown of Pool %v: %v", pool.CIDR, err)
+			}
+			poolSpec.ReleaseCooldown = cooldown
+		}
+		if pool.ReclaimPolicy != "" && !ipamspec.IsValidReclaimPolicy(pool.ReclaimPolicy) {
+			return defs, fmt.Errorf("Invalid reclaimPolicy of Pool %v: %v", pool.CIDR, pool.ReclaimPolicy)
+		}
+		if pool.RetainPeriod != "" {
+			period, err := time.ParseDuration(pool.RetainPeriod)
+			if err != nil {
+				return defs, fmt.Errorf("Invalid retainPeriod of Pool %v: %v", pool.CIDR, err)
+			}
+			poolSpec.RetainPeriod = period
+		}
+		defs.Pools = append(defs.Pools, poolSpec)
+	}
+
+	for _, ns := range cfg.Namespaces {
+		if ns.Name == "" {
+			return defs, fmt.Errorf("Invalid namespace policy: name is required")
+		}
+		if ns.MaxAllocations < 0 || ns.MaxAllocationsPerF5IPAM < 0 {
+			return defs, fmt.Errorf("Invalid namespace policy of %v: maximums can not be negative", ns.Name)
+		}
+		defs.Policies = append(defs.Policies, ipamspec.NamespacePolicy{
+			Namespace:                 ns.Name,
+			AllowedPools:              ns.AllowedPools,
+			MaxAllocations:            ns.MaxAllocations,
+			MaxAllocationsPerResource: ns.MaxAllocationsPerF5IPAM,
+		})
+	}
+	return defs, nil
+}
+
+// Watcher reloads the pool configuration file when its content changes.
+// The file may be a mounted ConfigMap, which is updated in place by the kubelet.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	onChange func(Definitions)
+
+	mutex sync.Mutex
+	hash  [sha256.Size]byte
+}
+
+// NewWatcher creates a Watcher for the file, onChange is called with the definitions
+// every time the content of the file changes
+func NewWatcher(path string, interval time.Duration, onChange func(Definitions)) *Watcher {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	w := &Watcher{
+		path:     path,
+		interval: interval,
+		onChange: onChange,
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		w.hash = sha256.Sum256(data)
+	}
+	return w
+}
+
+// Run checks the file for changes until stopCh is closed
+func (w *Watcher) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			w.reload(false)
+		}
+	}
+}
+
+// Reload applies the pool configuration file even when its content has not changed
+func (w *Watcher) Reload() {
+	w.reload(true)
+}
+
+func (w *Watcher) reload(force bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		poolLog.Errorf("Unable to read pool configuration %v: %v", w.path, err)
+		return
+	}
+	hash := sha256.Sum256(data)
+	if !force && hash == w.hash {
+		return
+	}
+
+	defs, err := parse(data)
+	if err != nil {
+		poolLog.Errorf("Not reloading pool configuration %v: %v", w.path, err)
+		return
+	}
+	w.hash = hash
+	poolLog.Infof("Reloading pool configuration %v", w.path)
+	w.onChange(defs)
+}
@@ -0,0 +1,97 @@
+package poolconfig
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{
+			name: "pools and namespaces",
+			config: `
+pools:
+- cidr: 10.10.1.0/24
+  ranges: [10.10.1.10-10.10.1.50]
+  releaseCooldown: 10m
+  reclaimPolicy: Retain
+  retainPeriod: 24h
+namespaces:
+- name: team-a
+  maxAllocations: 20
+`,
+			valid: true,
+		},
+		{name: "invalid yaml", config: "pools: ["},
+		{name: "invalid cooldown", config: "pools:\n- cidr: 10.10.1.0/24\n  releaseCooldown: soon\n"},
+		{name: "invalid reclaim policy", config: "pools:\n- cidr: 10.10.1.0/24\n  reclaimPolicy: Keep\n"},
+		{name: "invalid retain period", config: "pools:\n- cidr: 10.10.1.0/24\n  retainPeriod: forever\n"},
+		{name: "namespace without name", config: "namespaces:\n- maxAllocations: 1\n"},
+		{name: "negative maximum", config: "namespaces:\n- name: team-a\n  maxAllocations: -1\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defs, err := parse([]byte(test.config))
+			if (err == nil) != test.valid {
+				t.Fatalf("Parse got: %+v, %v", defs, err)
+			}
+			if !test.valid {
+				return
+			}
+			if len(defs.Pools) != 1 || defs.Pools[0].ReleaseCooldown != 10*time.Minute ||
+				defs.Pools[0].RetainPeriod != 24*time.Hour {
+				t.Errorf("Unexpected pools: %+v", defs.Pools)
+			}
+			if len(defs.Policies) != 1 || defs.Policies[0].MaxAllocations != 20 {
+				t.Errorf("Unexpected policies: %+v", defs.Policies)
+			}
+		})
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.yaml")
+	write := func(config string) {
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pools:\n- cidr: 10.10.1.0/24\n  ranges: [10.10.1.1-10.10.1.5]\n")
+
+	var reloads []Definitions
+	w := NewWatcher(path, time.Hour, func(defs Definitions) { reloads = append(reloads, defs) })
+	w.reload(false)
+	if len(reloads) != 0 {
+		t.Errorf("Unchanged configuration is reloaded: %+v", reloads)
+	}
+
+	write("pools:\n- cidr: 10.10.1.0/24\n  ranges: [10.10.1.1-10.10.1.9]\n")
+	w.reload(false)
+	if len(reloads) != 1 || reloads[0].Pools[0].Ranges[0] != "10.10.1.1-10.10.1.9" {
+		t.Fatalf("Changed configuration is not reloaded: %+v", reloads)
+	}
+
+	// An invalid configuration keeps the previous one
+	write("pools:\n- cidr: 10.10.1.0/24\n  releaseCooldown: soon\n")
+	w.reload(false)
+	if len(reloads) != 1 {
+		t.Errorf("Invalid configuration is reloaded: %+v", reloads[1:])
+	}
+
+	// The applied configuration is not reloaded on change, but on SIGHUP
+	write("pools:\n- cidr: 10.10.1.0/24\n  ranges: [10.10.1.1-10.10.1.9]\n")
+	w.reload(false)
+	if len(reloads) != 1 {
+		t.Errorf("Applied configuration is reloaded: %+v", reloads[1:])
+	}
+	w.Reload()
+	if len(reloads) != 2 {
+		t.Errorf("Forced reload got %v reloads, expected 2", len(reloads))
+	}
+}
@@ -0,0 +1,48 @@
+package provider
+
+import (
+	"os"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+// openAuditFile streams the audit events of the store to the file, appending to it
+func (prov *IPAMProvider) openAuditFile(path string) bool {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		provLog.Errorf("Unable to open the audit file: %v", err)
+		return false
+	}
+	prov.auditFile = file
+	prov.store.SetAuditStream(file)
+	provLog.Infof("Streaming the audit log to %v", path)
+	return true
+}
+
+// audit records the decision about the IP Address of the allocation in the audit log
+func (prov *IPAMProvider) audit(operation string, key ipamspec.AllocationKey, ipAddr string, ok bool, reason string) {
+	result := sqlite.AuditSuccess
+	if !ok {
+		result = sqlite.AuditFailure
+	}
+	prov.store.RecordAudit(sqlite.AuditEvent{
+		Operation: operation,
+		IPAddr:    ipAddr,
+		CIDR:      key.CIDR,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		HostName:  key.HostName,
+		Key:       key.Key,
+		Result:    result,
+		Reason:    reason,
+	})
+}
+
+func retainReason(period time.Duration) string {
+	if period <= 0 {
+		return "retained until claimed"
+	}
+	return "retained for " + period.String()
+}
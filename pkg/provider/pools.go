@@ -0,0 +1,310 @@
+package provider
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+// Policies for pool shrinks that leave allocated IP Addresses outside of the pool
+const (
+	// DrainShrinkPolicy applies the shrink, the orphaned IP Addresses stay allocated
+	// until they are released and are never allocated again
+	DrainShrinkPolicy = "drain"
+	// RefuseShrinkPolicy keeps the previous definition of the pool
+	RefuseShrinkPolicy = "refuse"
+)
+
+// ReconcilePools updates the pools to the given definitions.
+// New pools and ranges are added, IP Addresses no longer defined are removed
+// when available and handled as per the shrink policy when allocated.
+// CIDRs of a persisted store that were never defined are kept as they are until PrunePools.
+func (prov *IPAMProvider) ReconcilePools(pools []ipamspec.PoolSpec) ipamspec.PoolReport {
+	prov.poolsMutex.Lock()
+	defer prov.poolsMutex.Unlock()
+
+	return prov.reconcilePools(pools)
+}
+
+// PrunePools removes the available IP Addresses of the CIDRs of the store that no pool defines,
+// and retires the allocated ones, once the pools of all the sources are reconciled. The CIDRs
+// reconciled later are handled right away.
+func (prov *IPAMProvider) PrunePools() ipamspec.PoolReport {
+	prov.poolsMutex.Lock()
+	defer prov.poolsMutex.Unlock()
+
+	prov.prune = true
+	var pools []ipamspec.PoolSpec
+	for _, cidr := range sortedPoolCIDRs(prov.pools) {
+		pools = append(pools, prov.pools[cidr])
+	}
+	return prov.reconcilePools(pools)
+}
+
+// reconcilePools updates the pools for ReconcilePools and PrunePools, the caller holds the mutex
+func (prov *IPAMProvider) reconcilePools(pools []ipamspec.PoolSpec) ipamspec.PoolReport {
+	var report ipamspec.PoolReport
+
+	newPools := make(map[string]ipamspec.PoolSpec)
+	poolIPs := make(map[string][]string)
+	for _, pool := range pools {
+		if err := validatePool(pool); err != nil {
+			provLog.Errorf("Ignoring Pool: %v", err)
+			continue
+		}
+		ips, err := expandRanges(pool.CIDR, pool.Ranges)
+		if err != nil {
+			provLog.Errorf("Ignoring Pool %v: %v", pool.CIDR, err)
+			continue
+		}
+		excluded, err := expandExclusions(pool.CIDR, pool.Exclusions)
+		if err != nil {
+			provLog.Errorf("Ignoring Pool %v: %v", pool.CIDR, err)
+			continue
+		}
+		newPools[pool.CIDR] = pool
+		for _, ip := range ips {
+			if !excluded[ip] {
+				poolIPs[pool.CIDR] = append(poolIPs[pool.CIDR], ip)
+			}
+		}
+	}
+
+	// CIDRs known to the pools or to a persisted store
+	cidrs := make(map[string]bool)
+	for cidr := range prov.pools {
+		cidrs[cidr] = true
+	}
+	for _, cidr := range prov.store.GetCIDRs() {
+		cidrs[cidr] = true
+	}
+	for cidr := range newPools {
+		cidrs[cidr] = true
+	}
+
+	for _, cidr := range sortedKeys(cidrs) {
+		_, existed := prov.pools[cidr]
+		_, exists := newPools[cidr]
+		// Without a definition, as on start with a persisted store, the IP Addresses keep their
+		// state and history until the pools of all the sources are reconciled, one may define it
+		if !existed && !exists && !prov.prune {
+			continue
+		}
+
+		keep := make(map[string]bool)
+		for _, ip := range poolIPs[cidr] {
+			keep[ip] = true
+		}
+
+		var available, orphaned []sqlite.IPRecord
+		for _, record := range prov.store.GetIPRecords(cidr) {
+			if keep[record.IPAddr] {
+				continue
+			}
+			if record.Status == sqlite.AVAILABLE {
+				available = append(available, record)
+			} else {
+				orphaned = append(orphaned, record)
+			}
+		}
+
+		// Without a previous definition there is nothing to keep and the orphaned IP Addresses
+		// are drained
+		if oldPool, ok := prov.pools[cidr]; ok && len(orphaned) != 0 && prov.shrinkPolicy == RefuseShrinkPolicy {
+			provLog.Warningf("Refused to shrink Pool %v, %v allocated IP Addresses would be orphaned",
+				cidr, len(orphaned))
+			report.Refused = append(report.Refused, cidr)
+			newPools[cidr] = oldPool
+			continue
+		}
+
+		for _, record := range available {
+			prov.store.RemoveIP(record.IPAddr)
+		}
+		for _, record := range orphaned {
+			orphan := ipamspec.OrphanedIP{
+				CIDR:     cidr,
+				IPAddr:   record.IPAddr,
+				HostName: prov.store.GetHostName(record.IPAddr),
+			}
+			provLog.With(log.HostKey, orphan.HostName, log.CIDRKey, cidr, log.IPKey, orphan.IPAddr).Warningf(
+				"IP Address %v allocated to Host: %v is outside of the definition of Pool %v, "+
+					"it is retired once released", orphan.IPAddr, orphan.HostName, cidr)
+			prov.store.RetireIP(record.IPAddr)
+			report.Orphaned = append(report.Orphaned, orphan)
+		}
+		prov.store.InsertIP(poolIPs[cidr], cidr)
+
+		switch {
+		case exists && !existed:
+			report.Added = append(report.Added, cidr)
+		case !exists && existed:
+			report.Removed = append(report.Removed, cidr)
+		case exists && existed:
+			if !equalPools(prov.pools[cidr], newPools[cidr]) {
+				report.Updated = append(report.Updated, cidr)
+			}
+		}
+	}
+	prov.pools = newPools
+
+	provLog.Infof("Reconciled Pools. Added: %v, Updated: %v, Removed: %v, Refused: %v, Orphaned IPs: %v",
+		report.Added, report.Updated, report.Removed, report.Refused, len(report.Orphaned))
+	return report
+}
+
+func validatePool(pool ipamspec.PoolSpec) error {
+	if _, _, err := net.ParseCIDR(pool.CIDR); err != nil {
+		return fmt.Errorf("Invalid CIDR: %v", pool.CIDR)
+	}
+	if pool.Strategy != "" && !sqlite.IsValidStrategy(pool.Strategy) {
+		return fmt.Errorf("Invalid allocation strategy %v for CIDR: %v", pool.Strategy, pool.CIDR)
+	}
+	return nil
+}
+
+// expandRanges lists the IP Addresses of the ranges, which must belong to the CIDR
+func expandRanges(cidr string, ranges []string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, ipRange := range ranges {
+		bounds := strings.Split(strings.Trim(ipRange, " "), "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Invalid IP Range: %v", ipRange)
+		}
+		start := net.ParseIP(strings.Trim(bounds[0], " ")).To4()
+		end := net.ParseIP(strings.Trim(bounds[1], " ")).To4()
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("Invalid IPv4 Range: %v", ipRange)
+		}
+		if !ipNet.Contains(start) || !ipNet.Contains(end) {
+			return nil, fmt.Errorf("IP Range %v is not in CIDR: %v", ipRange, cidr)
+		}
+		for ip := start; ipNet.Contains(ip); inc(ip) {
+			ips = append(ips, ip.String())
+			if ip.Equal(end) {
+				break
+			}
+		}
+	}
+	return ips, nil
+}
+
+// expandExclusions lists the excluded IP Addresses, given as single IP Addresses or ranges
+func expandExclusions(cidr string, exclusions []string) (map[string]bool, error) {
+	excluded := make(map[string]bool)
+	for _, exclusion := range exclusions {
+		exclusion = strings.Trim(exclusion, " ")
+		if !strings.Contains(exclusion, "-") {
+			exclusion = exclusion + "-" + exclusion
+		}
+		ips, err := expandRanges(cidr, []string{exclusion})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exclusion: %v", err)
+		}
+		for _, ip := range ips {
+			excluded[ip] = true
+		}
+	}
+	return excluded, nil
+}
+
+// GetPools returns the current pool definitions
+func (prov *IPAMProvider) GetPools() []ipamspec.PoolSpec {
+	prov.poolsMutex.RLock()
+	defer prov.poolsMutex.RUnlock()
+
+	var pools []ipamspec.PoolSpec
+	for _, cidr := range sortedPoolCIDRs(prov.pools) {
+		pools = append(pools, prov.pools[cidr])
+	}
+	return pools
+}
+
+// Store returns the store of the provider
+func (prov *IPAMProvider) Store() *sqlite.DBStore {
+	return prov.store
+}
+
+// Close closes the store of the provider
+func (prov *IPAMProvider) Close() {
+	prov.store.Close()
+	if prov.auditFile != nil {
+		if err := prov.auditFile.Close(); err != nil {
+			provLog.Errorf("Unable to close the audit file: %v", err)
+		}
+	}
+	provLog.Debugf("Store closed")
+}
+
+// GetPoolStats returns the utilization of the pools, retired IP Addresses are not counted
+func (prov *IPAMProvider) GetPoolStats() []ipamspec.PoolStats {
+	prov.poolsMutex.RLock()
+	defer prov.poolsMutex.RUnlock()
+
+	prov.releaseExpired()
+	var stats []ipamspec.PoolStats
+	for _, cidr := range sortedPoolCIDRs(prov.pools) {
+		poolStats := ipamspec.PoolStats{CIDR: cidr}
+		for _, record := range prov.store.GetIPRecords(cidr) {
+			if record.Retired {
+				continue
+			}
+			poolStats.Total++
+			if record.Status == sqlite.ALLOCATED {
+				poolStats.Allocated++
+			}
+		}
+		poolStats.Free = poolStats.Total - poolStats.Allocated
+		poolStats.Retained = len(prov.store.GetRetainedIPs(cidr))
+		stats = append(stats, poolStats)
+	}
+	return stats
+}
+
+func equalPools(a, b ipamspec.PoolSpec) bool {
+	if a.CIDR != b.CIDR || a.Strategy != b.Strategy || a.ReleaseCooldown != b.ReleaseCooldown ||
+		a.ReclaimPolicy != b.ReclaimPolicy || a.RetainPeriod != b.RetainPeriod {
+		return false
+	}
+	return equalStrings(a.Ranges, b.Ranges) &&
+		equalStrings(a.Exclusions, b.Exclusions) &&
+		equalStrings(a.AllowedNamespaces, b.AllowedNamespaces)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedPoolCIDRs(pools map[string]ipamspec.PoolSpec) []string {
+	cidrs := make(map[string]bool)
+	for cidr := range pools {
+		cidrs[cidr] = true
+	}
+	return sortedKeys(cidrs)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+func testPool(ranges ...string) ipamspec.PoolSpec {
+	return ipamspec.PoolSpec{CIDR: testCIDR, Ranges: ranges}
+}
+
+func TestReconcilePools(t *testing.T) {
+	tests := []struct {
+		name         string
+		shrinkPolicy string
+		pools        []ipamspec.PoolSpec
+		// allocated before the pools are reconciled, from the pool of 10.10.10.1-10.10.10.4
+		allocated []string
+		added     []string
+		updated   []string
+		removed   []string
+		refused   []string
+		orphaned  []string
+		// allocatable are the IP Addresses of the pool after reconciling
+		allocatable []string
+	}{
+		{
+			name:        "unchanged",
+			pools:       []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4")},
+			allocatable: []string{"10.10.10.1", "10.10.10.2", "10.10.10.3", "10.10.10.4"},
+		},
+		{
+			name:        "grown",
+			pools:       []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4", "10.10.10.10-10.10.10.11")},
+			updated:     []string{testCIDR},
+			allocatable: []string{"10.10.10.1", "10.10.10.2", "10.10.10.3", "10.10.10.4", "10.10.10.10", "10.10.10.11"},
+		},
+		{
+			name: "added",
+			pools: []ipamspec.PoolSpec{
+				testPool("10.10.10.1-10.10.10.4"),
+				{CIDR: "10.10.20.0/24", Ranges: []string{"10.10.20.1-10.10.20.2"}},
+			},
+			added:       []string{"10.10.20.0/24"},
+			allocatable: []string{"10.10.10.1", "10.10.10.2", "10.10.10.3", "10.10.10.4"},
+		},
+		{
+			name:        "shrunk, available IP Addresses are removed",
+			pools:       []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.2")},
+			allocated:   []string{"10.10.10.1"},
+			updated:     []string{testCIDR},
+			allocatable: []string{"10.10.10.2"},
+		},
+		{
+			name:        "shrunk, allocated IP Addresses are drained",
+			pools:       []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.2")},
+			allocated:   []string{"10.10.10.4"},
+			updated:     []string{testCIDR},
+			orphaned:    []string{"10.10.10.4"},
+			allocatable: []string{"10.10.10.1", "10.10.10.2"},
+		},
+		{
+			name:         "shrink refused",
+			shrinkPolicy: RefuseShrinkPolicy,
+			pools:        []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.2")},
+			allocated:    []string{"10.10.10.4"},
+			refused:      []string{testCIDR},
+			allocatable:  []string{"10.10.10.1", "10.10.10.2", "10.10.10.3"},
+		},
+		{
+			name:      "removed",
+			allocated: []string{"10.10.10.1"},
+			removed:   []string{testCIDR},
+			orphaned:  []string{"10.10.10.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prov := newTestProvider(t, Params{
+				Pools:        []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4")},
+				ShrinkPolicy: test.shrinkPolicy,
+			})
+			for _, ipAddr := range test.allocated {
+				if !prov.AllocateIPAddress(testKey("host-"+ipAddr), ipAddr) ||
+					!prov.CreateARecord(testKey("host-"+ipAddr), ipAddr) {
+					t.Fatalf("Unable to allocate %v", ipAddr)
+				}
+			}
+
+			report := prov.ReconcilePools(test.pools)
+			expectStrings(t, "Added", report.Added, test.added)
+			expectStrings(t, "Updated", report.Updated, test.updated)
+			expectStrings(t, "Removed", report.Removed, test.removed)
+			expectStrings(t, "Refused", report.Refused, test.refused)
+			var orphaned []string
+			for _, orphan := range report.Orphaned {
+				if orphan.HostName != "host-"+orphan.IPAddr {
+					t.Errorf("Orphaned IP Address %v is reported for Host: %v", orphan.IPAddr, orphan.HostName)
+				}
+				orphaned = append(orphaned, orphan.IPAddr)
+			}
+			expectStrings(t, "Orphaned", orphaned, test.orphaned)
+
+			// Orphaned IP Addresses are not allocated again once they are released
+			for _, orphan := range report.Orphaned {
+				prov.ReleaseAddr(testKey(orphan.HostName), orphan.IPAddr)
+			}
+			var allocatable []string
+			for ipAddr := prov.GetNextAddr(testKey("new")); ipAddr != ""; ipAddr = prov.GetNextAddr(testKey("new")) {
+				allocatable = append(allocatable, ipAddr)
+			}
+			expectStrings(t, "Allocatable", allocatable, test.allocatable)
+		})
+	}
+}
+
+func TestRestartWithoutPools(t *testing.T) {
+	tests := []struct {
+		name string
+		// pools reconciled after the restart without pools
+		pools []ipamspec.PoolSpec
+		prune bool
+		// kept is whether the IP Addresses keep their state and history
+		kept     bool
+		orphaned []string
+	}{
+		{name: "kept until pruned", kept: true},
+		{name: "defined again", pools: []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4")}, kept: true},
+		{
+			name:  "defined again before pruning",
+			pools: []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4")},
+			prune: true,
+			kept:  true,
+		},
+		{name: "pruned without a definition", prune: true, orphaned: []string{"10.10.10.2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storePath := filepath.Join(t.TempDir(), "store.db")
+			prov := NewProvider(Params{Pools: []ipamspec.PoolSpec{testPool("10.10.10.1-10.10.10.4")}, StorePath: storePath})
+			if prov == nil {
+				t.Fatal("Unable to create Provider")
+			}
+			for _, host := range []string{"foo.example.com", "bar.example.com"} {
+				if prov.GetNextAddr(testKey(host)) == "" {
+					t.Fatalf("Unable to allocate an IP Address to %v", host)
+				}
+			}
+			prov.ReleaseAddr(testKey("foo.example.com"), "10.10.10.1")
+			before := make(map[string]sqlite.IPRecord)
+			for _, record := range prov.store.GetIPRecords(testCIDR) {
+				before[record.IPAddr] = record
+			}
+			prov.Close()
+
+			// The pools of the store are defined by a source that is reconciled after the start
+			prov = newTestProvider(t, Params{StorePath: storePath})
+			if test.pools != nil {
+				prov.ReconcilePools(test.pools)
+			}
+			var orphaned []string
+			if test.prune {
+				for _, orphan := range prov.PrunePools().Orphaned {
+					orphaned = append(orphaned, orphan.IPAddr)
+				}
+			}
+			expectStrings(t, "Orphaned", orphaned, test.orphaned)
+
+			after := make(map[string]sqlite.IPRecord)
+			for _, record := range prov.store.GetIPRecords(testCIDR) {
+				after[record.IPAddr] = record
+			}
+			for ipAddr, record := range before {
+				if kept := after[ipAddr] == record; kept != test.kept {
+					t.Errorf("IP Address %v got: %+v, before the restart: %+v", ipAddr, after[ipAddr], record)
+				}
+			}
+			if !test.kept && (len(after) != 1 || !after["10.10.10.2"].Retired) {
+				t.Errorf("Pruned IP Addresses got: %+v", after)
+			}
+		})
+	}
+}
+
+func expectStrings(t *testing.T, what string, got, expected []string) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Errorf("%v got: %v, expected: %v", what, got, expected)
+		return
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Errorf("%v got: %v, expected: %v", what, got, expected)
+			return
+		}
+	}
+}
@@ -3,8 +3,12 @@ package provider
 import (
 	"fmt"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
 	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 )
@@ -14,27 +18,101 @@ const (
 	IPV6 = "IPv6"
 )
 
+const DefaultStrategy = sqlite.SequentialStrategy
+
+// provLog logs the messages of the provider
+var provLog = log.NewSubsystemLogger("PROV")
+
+// keyLog logs the messages about the allocation and its IP Address, when it has one
+func keyLog(key ipamspec.AllocationKey, ipAddr string) *log.Entry {
+	entry := provLog.With(key.LogFields()...)
+	if ipAddr != "" {
+		entry = entry.With(log.IPKey, ipAddr)
+	}
+	return entry
+}
+
 type IPAMProvider struct {
 	store *sqlite.DBStore
-	cidrs map[string]bool
+	// Pool definitions by CIDR
+	pools           map[string]ipamspec.PoolSpec
+	poolsMutex      sync.RWMutex
+	defaultStrategy string
+	releaseCooldown time.Duration
+	shrinkPolicy    string
+	auditFile       *os.File
+	// prune the IP Addresses of the CIDRs of the store without a definition, which are kept
+	// until the pools of all the sources are reconciled
+	prune bool
 }
 
 type Params struct {
 	Range string
+	// Pools are used instead of Range when provided
+	Pools []ipamspec.PoolSpec
+	// Strategy is either a single strategy for all the CIDRs or a comma separated
+	// list of <cidr>=<strategy> entries
+	Strategy string
+	// ReleaseCooldown is the time a released IP Address stays unallocatable
+	ReleaseCooldown time.Duration
+	// StorePath is the database file, allocations are kept in memory when empty
+	StorePath string
+	// ShrinkPolicy decides how pool shrinks that orphan allocated IP Addresses are handled
+	ShrinkPolicy string
+	// AuditFile receives the audit events as lines of JSON, they are only kept in the store when empty
+	AuditFile string
 }
 
 func NewProvider(params Params) *IPAMProvider {
-	//ipArr := []string{"172.16.1.1-172.16.1.5/24", "172.16.1.50/22-172.16.1.55/22"}
-	ipRanges := parseIPRange(params.Range)
-	if ipRanges == nil {
+	defaultStrategy, strategies, err := parseStrategy(params.Strategy)
+	if err != nil {
+		provLog.Errorf("%v", err)
+		return nil
+	}
+
+	pools := params.Pools
+	if len(pools) == 0 && params.Range != "" {
+		//ipArr := []string{"172.16.1.1-172.16.1.5/24", "172.16.1.50/22-172.16.1.55/22"}
+		ipRanges := parseIPRange(params.Range)
+		if ipRanges == nil {
+			return nil
+		}
+		pools = generatePools(ipRanges)
+		for i := range pools {
+			pools[i].Strategy = strategies[pools[i].CIDR]
+		}
+	}
+
+	shrinkPolicy := params.ShrinkPolicy
+	if shrinkPolicy == "" {
+		shrinkPolicy = DrainShrinkPolicy
+	}
+	if shrinkPolicy != DrainShrinkPolicy && shrinkPolicy != RefuseShrinkPolicy {
+		provLog.Errorf("Invalid pool shrink policy: %v", shrinkPolicy)
+		return nil
+	}
+
+	store := sqlite.NewStore(params.StorePath)
+	if store == nil {
 		return nil
 	}
 
 	prov := &IPAMProvider{
-		store: sqlite.NewStore(),
-		cidrs: make(map[string]bool),
+		store:           store,
+		pools:           make(map[string]ipamspec.PoolSpec),
+		defaultStrategy: defaultStrategy,
+		releaseCooldown: params.ReleaseCooldown,
+		shrinkPolicy:    shrinkPolicy,
+	}
+	if params.AuditFile != "" && !prov.openAuditFile(params.AuditFile) {
+		store.Close()
+		return nil
+	}
+	if len(pools) == 0 {
+		provLog.Infof("No pools are configured, waiting for pool definitions")
 	}
-	prov.generateExternalIPAddr(ipRanges)
+	prov.ReconcilePools(pools)
+	prov.store.DisplayIPRecords()
 	return prov
 
 }
@@ -43,7 +121,7 @@ func parseIPRange(ipRange string) []string {
 	if len(ipRange) == 0 {
 		return nil
 	}
-	log.Debugf("[PROV] Parsing IP Ranges: %v", ipRange)
+	provLog.Debugf("Parsing IP Ranges: %v", ipRange)
 	ranges := strings.Split(ipRange, ",")
 	var ipRanges []string
 	for _, ipRange := range ranges {
@@ -52,13 +130,69 @@ func parseIPRange(ipRange string) []string {
 	return ipRanges
 }
 
-// generateExternalIPAddr ...
-func (prov *IPAMProvider) generateExternalIPAddr(ipRnages []string) {
+// parseStrategy parses the allocation strategy parameter into the default strategy
+// and the strategies of individual CIDRs
+func parseStrategy(strategy string) (string, map[string]string, error) {
+	defaultStrategy := DefaultStrategy
+	strategies := make(map[string]string)
+	if len(strategy) == 0 {
+		return defaultStrategy, strategies, nil
+	}
+	for _, entry := range strings.Split(strategy, ",") {
+		entry = strings.Trim(entry, " ")
+		kv := strings.Split(entry, "=")
+		switch len(kv) {
+		case 1:
+			if !sqlite.IsValidStrategy(kv[0]) {
+				return "", nil, fmt.Errorf("Invalid allocation strategy: %v", kv[0])
+			}
+			defaultStrategy = kv[0]
+		case 2:
+			if _, _, err := net.ParseCIDR(kv[0]); err != nil {
+				return "", nil, fmt.Errorf("Invalid CIDR in allocation strategy: %v", entry)
+			}
+			if !sqlite.IsValidStrategy(kv[1]) {
+				return "", nil, fmt.Errorf("Invalid allocation strategy: %v", entry)
+			}
+			strategies[kv[0]] = kv[1]
+		default:
+			return "", nil, fmt.Errorf("Invalid allocation strategy: %v", entry)
+		}
+	}
+	return defaultStrategy, strategies, nil
+}
+
+func (prov *IPAMProvider) getPool(cidr string) (ipamspec.PoolSpec, bool) {
+	prov.poolsMutex.RLock()
+	defer prov.poolsMutex.RUnlock()
+	pool, ok := prov.pools[cidr]
+	return pool, ok
+}
+
+func (prov *IPAMProvider) strategyFor(pool ipamspec.PoolSpec) string {
+	if pool.Strategy != "" {
+		return pool.Strategy
+	}
+	return prov.defaultStrategy
+}
+
+func (prov *IPAMProvider) cooldownFor(pool ipamspec.PoolSpec) time.Duration {
+	if pool.ReleaseCooldown != 0 {
+		return pool.ReleaseCooldown
+	}
+	return prov.releaseCooldown
+}
+
+// generatePools builds the pool definitions from IP ranges of the form
+// <start-ip>/<mask>-<end-ip>/<mask>, ranges of the same CIDR make one pool
+func generatePools(ipRnages []string) []ipamspec.PoolSpec {
 	var startRangeIP, endRangeIP, Subnet string
 	if len(ipRnages) == 0 {
 		log.Fatal("[PROV] No IP range provided")
 	}
 
+	var pools []ipamspec.PoolSpec
+	poolIndex := make(map[string]int)
 	for _, ip := range ipRnages {
 		ip = strings.Trim(ip, "\"")
 		ipRangeArr := strings.Split(ip, "-")
@@ -78,16 +212,16 @@ func (prov *IPAMProvider) generateExternalIPAddr(ipRnages []string) {
 		}
 
 		if ipRangeStart[1] != ipRangeEnd[1] {
-			log.Debugf("[PROV] IPv4 Range Subnet mask is inconsistent")
+			provLog.Debugf("IPv4 Range Subnet mask is inconsistent")
 			continue
 		}
 		switch ipv4or6(ip) {
 		case IPV6:
-			log.Debugf("[PROV] IPv6 is not supported")
+			provLog.Debugf("IPv6 is not supported")
 		case IPV4:
 			break
 		default:
-			log.Debugf("[PROV] Invalid IP Address provided in the range")
+			provLog.Debugf("Invalid IP Address provided in the range")
 		}
 
 		Subnet = ipRangeStart[1]
@@ -95,40 +229,28 @@ func (prov *IPAMProvider) generateExternalIPAddr(ipRnages []string) {
 		startRangeIP = ipRangeStart[0]
 		endRangeIP = ipRangeEnd[0]
 
-		log.Debugf("[PROV] IP Pool: %v to %v/%v", startRangeIP, endRangeIP, Subnet)
+		provLog.Debugf("IP Pool: %v to %v/%v", startRangeIP, endRangeIP, Subnet)
 
 		//endip validation
-		ipEnd, ipNet, err := net.ParseCIDR(endRangeIP + "/" + Subnet)
+		_, ipNet, err := net.ParseCIDR(endRangeIP + "/" + Subnet)
 		if err != nil {
-			log.Debugf("[PROV] Parsing err :  ", err)
+			provLog.Debugf("Parsing err: %v", err)
 			continue
 		}
 
 		maskSize, _ := ipNet.Mask.Size()
 		cidr := fmt.Sprintf("%s/%v", ipNet.IP.String(), maskSize)
-		prov.cidrs[cidr] = true
-		log.Debugf("[PROV] Processed CIDR: %v", cidr)
+		provLog.Debugf("Processed CIDR: %v", cidr)
 
-		//startip validation
-		ipStart, ipnetStart, err := net.ParseCIDR(startRangeIP + "/" + Subnet)
-		if err != nil {
-			log.Debugf("[PROV] Parsing err : ", err)
+		ipRange := startRangeIP + "-" + endRangeIP
+		if i, ok := poolIndex[cidr]; ok {
+			pools[i].Ranges = append(pools[i].Ranges, ipRange)
 			continue
 		}
-		ips := []string{}
-		for ; ipnetStart.Contains(ipStart); inc(ipStart) {
-			ips = append(ips, ipStart.String())
-			// if len(ips) == EXTERNAL_IP_RANGE_COUNT {
-			// 	break
-			// }
-			if ipStart.String() == ipEnd.String() {
-				break
-			}
-		}
-		prov.store.InsertIP(ips, cidr)
+		poolIndex[cidr] = len(pools)
+		pools = append(pools, ipamspec.PoolSpec{CIDR: cidr, Ranges: []string{ipRange}})
 	}
-
-	prov.store.DisplayIPRecords()
+	return pools
 }
 
 func inc(ip net.IP) {
@@ -155,55 +277,202 @@ func ipv4or6(s string) string {
 }
 
 // Creates an A record
-func (prov *IPAMProvider) CreateARecord(hostname, ipAddr string) bool {
-	prov.store.CreateARecord(hostname, ipAddr)
-	log.Debugf("[PROV] Created 'A' Record. Host:%v, IP:%v", hostname, ipAddr)
+func (prov *IPAMProvider) CreateARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	ok := prov.store.CreateARecord(aRecord(key, ipAddr))
+	prov.audit(sqlite.AuditCreateRecord, key, ipAddr, ok, "")
+	if !ok {
+		return false
+	}
+	keyLog(key, ipAddr).Debug("Created 'A' Record")
 	return true
 }
 
-// Deletes an A record and releases the IP address
-func (prov *IPAMProvider) DeleteARecord(hostname, ipAddr string) {
-	prov.store.DeleteARecord(hostname, ipAddr)
-	log.Debugf("[PROV] Deleted 'A' Record. Host:%v, IP:%v", hostname, ipAddr)
+// Deletes an A record
+func (prov *IPAMProvider) DeleteARecord(key ipamspec.AllocationKey, ipAddr string) {
+	ok := prov.store.DeleteARecord(aRecord(key, ipAddr))
+	prov.audit(sqlite.AuditDeleteRecord, key, ipAddr, ok, "")
+	keyLog(key, ipAddr).Debug("Deleted 'A' Record")
 }
 
-func (prov *IPAMProvider) GetIPAddress(hostname string) string {
-	return prov.store.GetIPAddress(hostname)
+// ReserveARecord creates an A record that is removed after the timeout unless it is committed
+func (prov *IPAMProvider) ReserveARecord(key ipamspec.AllocationKey, ipAddr string, timeout time.Duration) bool {
+	record := aRecord(key, ipAddr)
+	record.ReservedUntil = time.Now().Add(timeout).Unix()
+	ok := prov.store.CreateARecord(record)
+	prov.audit(sqlite.AuditCreateRecord, key, ipAddr, ok, "reserved until published, for "+timeout.String())
+	if !ok {
+		return false
+	}
+	keyLog(key, ipAddr).Debug("Reserved 'A' Record")
+	return true
 }
 
-// Gets and reserves the next available IP address
-func (prov *IPAMProvider) GetNextAddr(cidr string) string {
-	if _, ok := prov.cidrs[cidr]; !ok {
-		log.Debugf("[PROV] Unsupported CIDR: %v", cidr)
+// CommitARecord makes the reserved A record permanent
+func (prov *IPAMProvider) CommitARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	return prov.store.CommitARecord(aRecord(key, ipAddr))
+}
+
+// GetExpiredReservations returns the reserved A records that were not committed in time
+func (prov *IPAMProvider) GetExpiredReservations() []ipamspec.Allocation {
+	return allocations(prov.store.GetExpiredARecords())
+}
+
+// RollbackARecord removes the A record if its reservation expired
+func (prov *IPAMProvider) RollbackARecord(key ipamspec.AllocationKey, ipAddr string) bool {
+	if !prov.store.DeleteExpiredARecord(aRecord(key, ipAddr)) {
+		return false
+	}
+	prov.audit(sqlite.AuditDeleteRecord, key, ipAddr, true, "reservation expired")
+	return true
+}
+
+func (prov *IPAMProvider) GetIPAddress(key ipamspec.AllocationKey) string {
+	return prov.store.GetIPAddress(aRecord(key, ""))
+}
+
+// GetSharedIPAddress returns the IP Address shared by the allocations of the key in its namespace and pool
+func (prov *IPAMProvider) GetSharedIPAddress(key ipamspec.AllocationKey) string {
+	return prov.store.GetSharedIPAddress(key.Namespace, key.CIDR, key.Key)
+}
+
+// GetAllocations returns the allocations of the host in all pools and of all owners
+func (prov *IPAMProvider) GetAllocations(hostname string) []ipamspec.Allocation {
+	return allocations(prov.store.GetARecords(hostname))
+}
+
+// GetAllocationsOfIP returns the allocations that refer to the IP Address
+func (prov *IPAMProvider) GetAllocationsOfIP(ipAddr string) []ipamspec.Allocation {
+	return allocations(prov.store.GetARecordsOfIP(ipAddr))
+}
+
+func allocations(records []sqlite.ARecord) []ipamspec.Allocation {
+	var allocs []ipamspec.Allocation
+	for _, record := range records {
+		allocs = append(allocs, ipamspec.Allocation{
+			Key: ipamspec.AllocationKey{
+				Namespace: record.Namespace,
+				Name:      record.Name,
+				HostName:  record.HostName,
+				CIDR:      record.CIDR,
+				Key:       record.Key,
+			},
+			IPAddr: record.IPAddr,
+		})
+	}
+	return allocs
+}
+
+func aRecord(key ipamspec.AllocationKey, ipAddr string) sqlite.ARecord {
+	return sqlite.ARecord{
+		IPAddr:    ipAddr,
+		HostName:  key.HostName,
+		CIDR:      key.CIDR,
+		Namespace: key.Namespace,
+		Name:      key.Name,
+		Key:       key.Key,
+	}
+}
+
+// Gets and reserves the next available IP address of the pool of the allocation
+func (prov *IPAMProvider) GetNextAddr(key ipamspec.AllocationKey) string {
+	pool, ok := prov.getPool(key.CIDR)
+	if !ok {
+		keyLog(key, "").Debug("Unsupported CIDR")
+		prov.audit(sqlite.AuditAllocate, key, "", false, "pool is not configured")
 		return ""
 	}
-	return prov.store.AllocateIP(cidr)
+	prov.releaseExpired()
+	ipAddr := prov.store.AllocateIP(key.CIDR, prov.strategyFor(pool), prov.cooldownFor(pool))
+	if ipAddr == "" {
+		prov.audit(sqlite.AuditAllocate, key, "", false, "pool is exhausted")
+		return ""
+	}
+	prov.audit(sqlite.AuditAllocate, key, ipAddr, true, "")
+	return ipAddr
 }
 
-// Marks an IP address as allocated if it belongs to that CIDR
-func (prov *IPAMProvider) AllocateIPAddress(cidr, ipAddr string) bool {
-	if _, ok := prov.cidrs[cidr]; !ok {
-		log.Debugf("[PROV] Unsupported CIDR: %v", cidr)
+// Marks an IP address as allocated if it belongs to the pool of the allocation
+func (prov *IPAMProvider) AllocateIPAddress(key ipamspec.AllocationKey, ipAddr string) bool {
+	pool, ok := prov.getPool(key.CIDR)
+	if !ok {
+		keyLog(key, ipAddr).Debug("Unsupported CIDR")
+		prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, false, "pool is not configured")
 		return false
 	}
 
-	_, ipNet, err := net.ParseCIDR(cidr)
+	_, ipNet, err := net.ParseCIDR(key.CIDR)
 	if err != nil {
-		log.Debugf("[PROV] Parsing CIDR error : ", err)
+		keyLog(key, ipAddr).With(log.ErrorKey, err).Debug("Parsing CIDR error")
+		prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, false, "invalid CIDR")
 		return false
 	}
 	ip := net.ParseIP(ipAddr)
 	if ip == nil {
-		log.Debugf("[PROV] Parsing IP error")
+		keyLog(key, ipAddr).Debug("Parsing IP error")
+		prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, false, "invalid IP Address")
+		return false
+	}
+	if !ipNet.Contains(ip) {
+		prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, false, "IP Address is not in the CIDR")
 		return false
 	}
-	if ipNet.Contains(ip) {
-		return prov.store.MarkIPAsAllocated(cidr, ipAddr)
+	prov.releaseExpired()
+	if !prov.store.MarkIPAsAllocated(key.CIDR, ipAddr, prov.cooldownFor(pool)) {
+		prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, false, "IP Address is not available")
+		return false
+	}
+	prov.audit(sqlite.AuditAllocateSpecific, key, ipAddr, true, "")
+	return true
+}
+
+// IsIPAddressInPool checks whether the IP Address is allocatable from the pool of the CIDR,
+// excluded and retired IP Addresses are not
+func (prov *IPAMProvider) IsIPAddressInPool(cidr, ipAddr string) bool {
+	if _, ok := prov.getPool(cidr); !ok {
+		return false
 	}
-	return false
+	return prov.store.HasIP(cidr, ipAddr)
 }
 
-// Releases an IP address
-func (prov *IPAMProvider) ReleaseAddr(ipAddr string) {
+// Releases the IP address of the allocation
+func (prov *IPAMProvider) ReleaseAddr(key ipamspec.AllocationKey, ipAddr string) {
 	prov.store.ReleaseIP(ipAddr)
+	prov.audit(sqlite.AuditRelease, key, ipAddr, true, "")
+}
+
+// RetainAddr keeps the IP Address of the deleted allocation for its namespace and host,
+// or its shared key, for the period, forever when zero
+func (prov *IPAMProvider) RetainAddr(key ipamspec.AllocationKey, ipAddr string, period time.Duration) {
+	var until int64
+	if period > 0 {
+		until = time.Now().Add(period).Unix()
+	}
+	ok := prov.store.RetainIP(aRecord(key, ipAddr), until)
+	prov.audit(sqlite.AuditRetain, key, ipAddr, ok, retainReason(period))
+	if ok {
+		keyLog(key, ipAddr).Debug("Retained IP")
+	}
+}
+
+// GetRetainedAddr returns the IP Address retained for the allocation
+func (prov *IPAMProvider) GetRetainedAddr(key ipamspec.AllocationKey) string {
+	prov.releaseExpired()
+	return prov.store.GetRetainedIP(aRecord(key, ""))
+}
+
+// ClaimRetainedAddr ends the retention of the IP Address, it stays allocated for the claimer
+func (prov *IPAMProvider) ClaimRetainedAddr(key ipamspec.AllocationKey, ipAddr string) bool {
+	if !prov.store.ClaimRetainedIP(ipAddr) {
+		return false
+	}
+	prov.audit(sqlite.AuditClaimRetained, key, ipAddr, true, "")
+	return true
+}
+
+// releaseExpired releases the IP Addresses whose retention ended
+func (prov *IPAMProvider) releaseExpired() {
+	for _, ipAddr := range prov.store.ReleaseExpiredIPs() {
+		provLog.With(log.IPKey, ipAddr).Info("Released IP as its retention ended")
+		prov.audit(sqlite.AuditRelease, ipamspec.AllocationKey{}, ipAddr, true, "retention ended")
+	}
 }
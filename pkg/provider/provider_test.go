@@ -0,0 +1,167 @@
+package provider
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/subbuv26/f5-ipam-controller/pkg/ipamspec"
+	"github.com/subbuv26/f5-ipam-controller/pkg/provider/sqlite"
+)
+
+const testCIDR = "10.10.10.0/24"
+
+func newTestProvider(t *testing.T, params Params) *IPAMProvider {
+	prov := NewProvider(params)
+	if prov == nil {
+		t.Fatalf("Unable to create Provider with %+v", params)
+	}
+	t.Cleanup(prov.Close)
+	return prov
+}
+
+func testKey(host string) ipamspec.AllocationKey {
+	return ipamspec.AllocationKey{Namespace: "default", Name: "web", HostName: host, CIDR: testCIDR}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		strategy        string
+		defaultStrategy string
+		strategies      map[string]string
+		valid           bool
+	}{
+		{strategy: "", defaultStrategy: DefaultStrategy, valid: true},
+		{strategy: "random", defaultStrategy: sqlite.RandomStrategy, valid: true},
+		{
+			strategy:        "least-recently-released, 10.10.10.0/24=random",
+			defaultStrategy: sqlite.LeastRecentlyReleasedStrategy,
+			strategies:      map[string]string{testCIDR: sqlite.RandomStrategy},
+			valid:           true,
+		},
+		{strategy: "fastest"},
+		{strategy: "10.10.10.0/24=fastest"},
+		{strategy: "not-a-cidr=random"},
+		{strategy: "10.10.10.0/24=random=sequential"},
+	}
+	for _, test := range tests {
+		defaultStrategy, strategies, err := parseStrategy(test.strategy)
+		if (err == nil) != test.valid {
+			t.Errorf("Strategy %q got error: %v", test.strategy, err)
+			continue
+		}
+		if !test.valid {
+			continue
+		}
+		if defaultStrategy != test.defaultStrategy || len(strategies) != len(test.strategies) {
+			t.Errorf("Strategy %q got: %v, %v", test.strategy, defaultStrategy, strategies)
+		}
+		for cidr, strategy := range test.strategies {
+			if strategies[cidr] != strategy {
+				t.Errorf("Strategy %q got %v for %v, expected %v", test.strategy, strategies[cidr], cidr, strategy)
+			}
+		}
+	}
+}
+
+func TestAllocationStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		// expected allocations after 10.10.10.1 and 10.10.10.2 are allocated and 10.10.10.1 is
+		// released, empty when any IP Address of the pool is expected
+		expected []string
+	}{
+		{
+			strategy: sqlite.SequentialStrategy,
+			expected: []string{"10.10.10.1", "10.10.10.3", "10.10.10.4"},
+		},
+		{
+			strategy: sqlite.LeastRecentlyReleasedStrategy,
+			expected: []string{"10.10.10.3", "10.10.10.4", "10.10.10.1"},
+		},
+		{strategy: sqlite.RandomStrategy},
+	}
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			prov := newTestProvider(t, Params{
+				Range:    "10.10.10.1/24-10.10.10.4/24",
+				Strategy: test.strategy,
+			})
+			first := prov.GetNextAddr(testKey("foo.example.com"))
+			second := prov.GetNextAddr(testKey("bar.example.com"))
+			if first == "" || second == "" || first == second {
+				t.Fatalf("Hosts are not allocated IP Addresses of their own: %v, %v", first, second)
+			}
+			if test.expected != nil && (first != "10.10.10.1" || second != "10.10.10.2") {
+				t.Fatalf("First allocations got: %v, %v", first, second)
+			}
+			prov.ReleaseAddr(testKey("foo.example.com"), first)
+
+			seen := map[string]bool{second: true}
+			for i := 0; i < 3; i++ {
+				ipAddr := prov.GetNextAddr(testKey("baz.example.com"))
+				if test.expected != nil && ipAddr != test.expected[i] {
+					t.Errorf("Allocation %v got: %v, expected: %v", i+1, ipAddr, test.expected[i])
+				}
+				if ipAddr == "" || seen[ipAddr] {
+					t.Errorf("Allocation %v got: %q, which is not available", i+1, ipAddr)
+				}
+				seen[ipAddr] = true
+			}
+			if ipAddr := prov.GetNextAddr(testKey("qux.example.com")); ipAddr != "" {
+				t.Errorf("Exhausted pool allocated: %v", ipAddr)
+			}
+		})
+	}
+}
+
+func TestReleaseCooldown(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		// poolCooldown of the pool takes precedence over the cooldown of the provider
+		poolCooldown time.Duration
+		wait         time.Duration
+		restart      bool
+		reused       bool
+	}{
+		{name: "no cooldown", reused: true},
+		{name: "cooldown", cooldown: time.Hour},
+		{name: "cooldown of the pool", cooldown: time.Hour, poolCooldown: time.Second, wait: 2100 * time.Millisecond, reused: true},
+		{name: "cooldown across restarts", cooldown: time.Hour, restart: true},
+		{name: "expired cooldown", cooldown: time.Second, wait: 2100 * time.Millisecond, reused: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			params := Params{
+				Pools: []ipamspec.PoolSpec{{
+					CIDR:            testCIDR,
+					Ranges:          []string{"10.10.10.1-10.10.10.1"},
+					ReleaseCooldown: test.poolCooldown,
+				}},
+				ReleaseCooldown: test.cooldown,
+				StorePath:       filepath.Join(t.TempDir(), "store.db"),
+			}
+			prov := newTestProvider(t, params)
+			ipAddr := prov.GetNextAddr(testKey("foo.example.com"))
+			if ipAddr != "10.10.10.1" {
+				t.Fatalf("Allocation got: %v", ipAddr)
+			}
+			prov.ReleaseAddr(testKey("foo.example.com"), ipAddr)
+			if test.restart {
+				prov.Close()
+				prov = newTestProvider(t, params)
+			}
+			time.Sleep(test.wait)
+
+			if reused := prov.GetNextAddr(testKey("bar.example.com")) == ipAddr; reused != test.reused {
+				t.Errorf("Released IP Address is reused: %v, expected: %v", reused, test.reused)
+			}
+			if !test.reused && prov.AllocateIPAddress(testKey("bar.example.com"), ipAddr) {
+				t.Error("IP Address in cooldown is allocated when it is requested")
+			}
+		})
+	}
+}
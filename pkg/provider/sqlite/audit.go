@@ -0,0 +1,169 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Operations of the audit events
+const (
+	AuditAllocate         = "allocate"
+	AuditAllocateSpecific = "allocate-specific"
+	AuditRelease          = "release"
+	AuditRetain           = "retain"
+	AuditClaimRetained    = "claim-retained"
+	AuditCreateRecord     = "create-record"
+	AuditDeleteRecord     = "delete-record"
+	AuditImport           = "import"
+)
+
+// Results of the audit events
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an allocation decision, as recorded in the audit log
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	IPAddr    string    `json:"ip,omitempty"`
+	CIDR      string    `json:"cidr,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	HostName  string    `json:"host,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Result    string    `json:"result"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// AuditQuery selects audit events, the fields that are given have to match
+type AuditQuery struct {
+	IPAddr   string
+	HostName string
+	// Since and Until bound the time of the events, both included
+	Since time.Time
+	Until time.Time
+}
+
+// createAuditTable creates the audit log, which refuses updates and deletes of its events
+func (store *DBStore) createAuditTable() bool {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS audit_log (
+		"id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"time" INT NOT NULL,
+		"operation" TEXT NOT NULL,
+		"ipaddress" TEXT NOT NULL DEFAULT '',
+		"cidr" TEXT NOT NULL DEFAULT '',
+		"namespace" TEXT NOT NULL DEFAULT '',
+		"name" TEXT NOT NULL DEFAULT '',
+		"hostname" TEXT NOT NULL DEFAULT '',
+		"shared_key" TEXT NOT NULL DEFAULT '',
+		"result" TEXT NOT NULL,
+		"reason" TEXT NOT NULL DEFAULT ''
+	  );`,
+		"CREATE INDEX IF NOT EXISTS audit_log_ipaddress ON audit_log(ipaddress, time)",
+		"CREATE INDEX IF NOT EXISTS audit_log_hostname ON audit_log(hostname, time)",
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			storeLog.Errorf("Unable to Create Table 'audit_log' in Database: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// SetAuditStream writes every audit event that is recorded from now on to w as a line of JSON,
+// none are written when nil
+func (store *DBStore) SetAuditStream(w io.Writer) {
+	store.auditMutex.Lock()
+	defer store.auditMutex.Unlock()
+	store.auditStream = w
+}
+
+// RecordAudit appends the event to the audit log, at the current time when it has none
+func (store *DBStore) RecordAudit(event AuditEvent) bool {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+
+	// Events are streamed in the order of the log
+	store.auditMutex.Lock()
+	defer store.auditMutex.Unlock()
+
+	result, err := store.db.Exec(
+		"INSERT INTO audit_log(time, operation, ipaddress, cidr, namespace, name, hostname, shared_key, "+
+			"result, reason) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.Time.UnixNano(), event.Operation, event.IPAddr, event.CIDR, event.Namespace, event.Name,
+		event.HostName, event.Key, event.Result, event.Reason,
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to Insert row in Table 'audit_log': %v", err)
+		return false
+	}
+	event.ID, _ = result.LastInsertId()
+
+	if store.auditStream != nil {
+		data, err := json.Marshal(event)
+		if err == nil {
+			_, err = store.auditStream.Write(append(data, '\n'))
+		}
+		if err != nil {
+			storeLog.Errorf("Unable to stream audit event %v: %v", event.ID, err)
+		}
+	}
+	return true
+}
+
+// GetAuditEvents returns the events of the audit log that match the query, oldest first
+func (store *DBStore) GetAuditEvents(query AuditQuery) []AuditEvent {
+	var conditions []string
+	var args []interface{}
+	if query.IPAddr != "" {
+		conditions = append(conditions, "ipaddress = ?")
+		args = append(args, query.IPAddr)
+	}
+	if query.HostName != "" {
+		conditions = append(conditions, "hostname = ?")
+		args = append(args, query.HostName)
+	}
+	if !query.Since.IsZero() {
+		conditions = append(conditions, "time >= ?")
+		args = append(args, query.Since.UnixNano())
+	}
+	if !query.Until.IsZero() {
+		conditions = append(conditions, "time <= ?")
+		args = append(args, query.Until.UnixNano())
+	}
+	stmt := "SELECT id, time, operation, ipaddress, cidr, namespace, name, hostname, shared_key, result, " +
+		"reason FROM audit_log"
+	if len(conditions) != 0 {
+		stmt += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := store.db.Query(stmt+" ORDER BY id", args...)
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'audit_log': %v", err)
+		return nil
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		var nanos int64
+		if rows.Scan(&event.ID, &nanos, &event.Operation, &event.IPAddr, &event.CIDR, &event.Namespace,
+			&event.Name, &event.HostName, &event.Key, &event.Result, &event.Reason) == nil {
+			event.Time = time.Unix(0, nanos).UTC()
+			events = append(events, event)
+		}
+	}
+	return events
+}
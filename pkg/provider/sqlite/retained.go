@@ -0,0 +1,116 @@
+package sqlite
+
+import "time"
+
+// RetainedIP is an allocated IP Address kept for the namespace and host, or the shared key,
+// of a deleted A record. A zero RetainedUntil retains it until it is claimed.
+type RetainedIP struct {
+	ARecord
+	RetainedUntil int64
+}
+
+func (store *DBStore) createRetainedTable() bool {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS retained_ips (
+		"ipaddress" TEXT PRIMARY KEY,
+		"cidr" TEXT NOT NULL DEFAULT '',
+		"namespace" TEXT NOT NULL DEFAULT '',
+		"hostname" TEXT NOT NULL DEFAULT '',
+		"shared_key" TEXT NOT NULL DEFAULT '',
+		"retained_until" INT NOT NULL DEFAULT 0
+	  );`)
+	if err != nil {
+		storeLog.Errorf("Unable to Create Table 'retained_ips' in Database: %v", err)
+		return false
+	}
+	return true
+}
+
+// RetainIP keeps the allocated IP Address of the record until the given time, forever when zero
+func (store *DBStore) RetainIP(record ARecord, until int64) bool {
+	_, err := store.db.Exec(
+		"INSERT OR REPLACE INTO retained_ips(ipaddress, cidr, namespace, hostname, shared_key, retained_until) "+
+			"VALUES (?, ?, ?, ?, ?, ?)",
+		record.IPAddr, record.CIDR, record.Namespace, record.HostName, record.Key, until,
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to Insert row in Table 'retained_ips': %v", err)
+		return false
+	}
+	return true
+}
+
+// GetRetainedIP returns the IP Address retained for the namespace and pool of the record,
+// and its shared key or else its host
+func (store *DBStore) GetRetainedIP(record ARecord) string {
+	var ipaddress string
+	query := "SELECT ipaddress FROM retained_ips WHERE namespace=? AND cidr=? AND shared_key=? AND hostname=? " +
+		"ORDER BY ipaddress ASC LIMIT 1"
+	args := []interface{}{record.Namespace, record.CIDR, record.Key, record.HostName}
+	if record.Key != "" {
+		query = "SELECT ipaddress FROM retained_ips WHERE namespace=? AND cidr=? AND shared_key=? " +
+			"ORDER BY ipaddress ASC LIMIT 1"
+		args = args[:3]
+	}
+	if err := store.db.QueryRow(query, args...).Scan(&ipaddress); err != nil {
+		return ""
+	}
+	return ipaddress
+}
+
+// ClaimRetainedIP ends the retention of the IP Address, which stays allocated
+func (store *DBStore) ClaimRetainedIP(ip string) bool {
+	result, err := store.db.Exec("DELETE FROM retained_ips WHERE ipaddress = ?", ip)
+	if err != nil {
+		storeLog.Errorf("Unable to delete row from Table 'retained_ips': %v", err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows == 1
+}
+
+// GetRetainedIPs returns the IP Addresses retained in the pool of the CIDR
+func (store *DBStore) GetRetainedIPs(cidr string) []RetainedIP {
+	var retained []RetainedIP
+	rows, err := store.db.Query(
+		"SELECT ipaddress, namespace, hostname, shared_key, retained_until FROM retained_ips "+
+			"WHERE cidr = ? ORDER BY ipaddress",
+		cidr,
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'retained_ips': %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ip := RetainedIP{ARecord: ARecord{CIDR: cidr}}
+		if rows.Scan(&ip.IPAddr, &ip.Namespace, &ip.HostName, &ip.Key, &ip.RetainedUntil) == nil {
+			retained = append(retained, ip)
+		}
+	}
+	return retained
+}
+
+// ReleaseExpiredIPs releases the IP Addresses whose retention ended, and returns them
+func (store *DBStore) ReleaseExpiredIPs() []string {
+	var expired []string
+	rows, err := store.db.Query(
+		"SELECT ipaddress FROM retained_ips WHERE retained_until > 0 AND retained_until <= ?",
+		time.Now().Unix(),
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'retained_ips': %v", err)
+		return nil
+	}
+	for rows.Next() {
+		var ip string
+		if rows.Scan(&ip) == nil {
+			expired = append(expired, ip)
+		}
+	}
+	rows.Close()
+
+	for _, ip := range expired {
+		store.ReleaseIP(ip)
+	}
+	return expired
+}
@@ -0,0 +1,110 @@
+package sqlite
+
+// State is the content of the store, as exported to and imported from snapshots
+type State struct {
+	IPs      []IPRecord
+	ARecords []ARecord
+	Retained []RetainedIP
+}
+
+// GetAllRetainedIPs returns the IP Addresses retained in all pools
+func (store *DBStore) GetAllRetainedIPs() []RetainedIP {
+	var retained []RetainedIP
+	for _, cidr := range store.GetCIDRs() {
+		retained = append(retained, store.GetRetainedIPs(cidr)...)
+	}
+	return retained
+}
+
+// GetState returns the content of the store
+func (store *DBStore) GetState() State {
+	var state State
+	for _, cidr := range store.GetCIDRs() {
+		state.IPs = append(state.IPs, store.GetIPRecords(cidr)...)
+	}
+	state.ARecords = store.GetAllARecords()
+	state.Retained = store.GetAllRetainedIPs()
+	return state
+}
+
+// ImportState applies the state to the IP Addresses that are in the store, all at once or not at
+// all. Replacing clears the A records, the retentions, the allocations and the retired IP
+// Addresses of the store first, merging keeps them. IP Addresses are only ever marked allocated,
+// and keep the latest release.
+func (store *DBStore) ImportState(state State, replace bool) bool {
+	tx, err := store.db.Begin()
+	if err != nil {
+		storeLog.Errorf("Unable to begin the import: %v", err)
+		return false
+	}
+	fail := func(table string, err error) bool {
+		storeLog.Errorf("Unable to import Table '%v': %v", table, err)
+		if err := tx.Rollback(); err != nil {
+			storeLog.Errorf("Unable to roll back the import: %v", err)
+		}
+		return false
+	}
+
+	if replace {
+		if _, err = tx.Exec("DELETE FROM a_records"); err != nil {
+			return fail("a_records", err)
+		}
+		if _, err = tx.Exec("DELETE FROM retained_ips"); err != nil {
+			return fail("retained_ips", err)
+		}
+		// Retired IP Addresses are only kept while they are allocated
+		if _, err = tx.Exec("DELETE FROM ipaddress_range WHERE retired = 1"); err != nil {
+			return fail("ipaddress_range", err)
+		}
+		if _, err = tx.Exec("UPDATE ipaddress_range SET status = ?, released_at = 0", AVAILABLE); err != nil {
+			return fail("ipaddress_range", err)
+		}
+	}
+
+	for _, ip := range state.IPs {
+		_, err = tx.Exec(
+			"UPDATE ipaddress_range SET released_at = MAX(released_at, ?) WHERE ipaddress = ? AND cidr = ?",
+			ip.ReleasedAt, ip.IPAddr, ip.CIDR,
+		)
+		if err == nil && ip.Status == ALLOCATED {
+			_, err = tx.Exec("UPDATE ipaddress_range SET status = ? WHERE ipaddress = ? AND cidr = ?",
+				ALLOCATED, ip.IPAddr, ip.CIDR)
+		}
+		if err != nil {
+			return fail("ipaddress_range", err)
+		}
+	}
+
+	for _, record := range state.ARecords {
+		_, err = tx.Exec(
+			"INSERT INTO a_records(ipaddress, hostname, cidr, namespace, name, shared_key, reserved_until) "+
+				"SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM a_records WHERE ipaddress=? "+
+				"AND hostname=? AND cidr=? AND namespace=? AND name=? AND shared_key=?)",
+			record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key,
+			record.ReservedUntil,
+			record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key,
+		)
+		if err != nil {
+			return fail("a_records", err)
+		}
+	}
+
+	for _, ip := range state.Retained {
+		_, err = tx.Exec(
+			"INSERT OR REPLACE INTO retained_ips(ipaddress, cidr, namespace, hostname, shared_key, retained_until) "+
+				"VALUES (?, ?, ?, ?, ?, ?)",
+			ip.IPAddr, ip.CIDR, ip.Namespace, ip.HostName, ip.Key, ip.RetainedUntil,
+		)
+		if err != nil {
+			return fail("retained_ips", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		storeLog.Errorf("Unable to commit the import: %v", err)
+		return false
+	}
+	storeLog.Infof("Imported %v IP Addresses, %v A records and %v retained IP Addresses",
+		len(state.IPs), len(state.ARecords), len(state.Retained))
+	return true
+}
@@ -3,12 +3,49 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"io"
+	"sync"
+	"time"
+
 	_ "github.com/mattn/go-sqlite3"
 	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
 )
 
+// storeLog logs the messages of the store
+var storeLog = log.NewSubsystemLogger("STORE")
+
 type DBStore struct {
 	db *sql.DB
+
+	// auditMutex orders the audit events that are streamed
+	auditMutex  sync.Mutex
+	auditStream io.Writer
+}
+
+// IPRecord is the state of an IP Address in the store
+type IPRecord struct {
+	IPAddr string
+	CIDR   string
+	Status int
+	// ReleasedAt is the last release of the IP Address in unix seconds, its cooldown starts then
+	ReleasedAt int64
+	// Retired IP Addresses are outside of the pool definitions, they are
+	// never allocated and are removed once released
+	Retired bool
+}
+
+// ARecord maps the host of a resource to an IP Address of the pool of the CIDR.
+// A record without Namespace and Name was created before owners were recorded.
+// Records with the same Key in a namespace and pool share their IP Address.
+// A record with ReservedUntil is removed then, unless it is committed before.
+type ARecord struct {
+	IPAddr        string
+	HostName      string
+	CIDR          string
+	Namespace     string
+	Name          string
+	Key           string
+	ReservedUntil int64
 }
 
 const (
@@ -16,16 +53,45 @@ const (
 	AVAILABLE = 1
 )
 
-func NewStore() *DBStore {
-	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+// Allocation strategies decide which of the available IP Addresses of a CIDR is handed out next
+const (
+	// SequentialStrategy allocates the lowest available IP Address
+	SequentialStrategy = "sequential"
+	// RandomStrategy allocates any of the available IP Addresses
+	RandomStrategy = "random"
+	// LeastRecentlyReleasedStrategy prefers IP Addresses that were never allocated,
+	// and then the ones released the longest time ago
+	LeastRecentlyReleasedStrategy = "least-recently-released"
+)
+
+// IsValidStrategy tells whether the store can allocate IP Addresses with the given strategy
+func IsValidStrategy(strategy string) bool {
+	switch strategy {
+	case SequentialStrategy, RandomStrategy, LeastRecentlyReleasedStrategy:
+		return true
+	}
+	return false
+}
+
+// NewStore creates the store in the database file at path.
+// An empty path keeps the store in memory, which does not survive restarts.
+func NewStore(path string) *DBStore {
+	dsn := "file::memory:?cache=shared"
+	if path != "" {
+		dsn = fmt.Sprintf("file:%s?cache=shared", path)
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		log.Errorf("[STORE] Unable to Initialise DB, %v", err)
+		storeLog.Errorf("Unable to Initialise DB, %v", err)
 		return nil
 	}
+	// The store is used from concurrent workers, a single connection serializes the
+	// statements and avoids table locks of the shared cache
+	db.SetMaxOpenConns(1)
 
 	err = db.Ping()
 	if err != nil {
-		log.Errorf("[STORE] Unable to Establish Connection to DB, %v", err)
+		storeLog.Errorf("Unable to Establish Connection to DB, %v", err)
 		return nil
 	}
 
@@ -38,44 +104,103 @@ func NewStore() *DBStore {
 }
 
 func (store *DBStore) CreateTables() bool {
-	createIPAddressTableSQL := `CREATE TABLE ipaddress_range (
+	createIPAddressTableSQL := `CREATE TABLE IF NOT EXISTS ipaddress_range (
 		"id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"ipaddress" TEXT,
+		"ipaddress" TEXT UNIQUE,
 		"status" INT,
-		"cidr" TEXT	
+		"cidr" TEXT,
+		"released_at" INT NOT NULL DEFAULT 0,
+		"retired" INT NOT NULL DEFAULT 0
 	  );`
 
 	statement, _ := store.db.Prepare(createIPAddressTableSQL)
 
 	_, err := statement.Exec()
 	if err != nil {
-		log.Errorf("[STORE] Unable to Create Table 'ipaddress_range' in Database")
+		storeLog.Errorf("Unable to Create Table 'ipaddress_range' in Database")
 		return false
 	}
-	createARecodsTableSQL := `CREATE TABLE a_records (
+	createARecodsTableSQL := `CREATE TABLE IF NOT EXISTS a_records (
 		"ipaddress" TEXT PRIMARY_KEY,
-		"hostname" TEXT	
+		"hostname" TEXT,
+		"cidr" TEXT NOT NULL DEFAULT '',
+		"namespace" TEXT NOT NULL DEFAULT '',
+		"name" TEXT NOT NULL DEFAULT '',
+		"shared_key" TEXT NOT NULL DEFAULT '',
+		"reserved_until" INT NOT NULL DEFAULT 0
 	  );`
 
 	statement, _ = store.db.Prepare(createARecodsTableSQL)
 
 	_, err = statement.Exec()
 	if err != nil {
-		log.Errorf("[STORE] Unable to Create  Table 'a_records' in Database")
+		storeLog.Errorf("Unable to Create  Table 'a_records' in Database")
 		return false
 	}
+	return store.createRetainedTable() && store.createAuditTable() && store.migrateARecords()
+}
+
+// migrateARecords adds the columns of a store created before they were introduced.
+// The pool is taken from the IP Address, the owner and key are left empty, and the
+// records are committed.
+func (store *DBStore) migrateARecords() bool {
+	rows, err := store.db.Query("PRAGMA table_info(a_records)")
+	if err != nil {
+		storeLog.Errorf("Unable to read columns of Table 'a_records': %v", err)
+		return false
+	}
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk) == nil {
+			columns[name] = true
+		}
+	}
+	rows.Close()
+
+	for _, column := range []string{"cidr", "namespace", "name", "shared_key"} {
+		if columns[column] {
+			continue
+		}
+		storeLog.Infof("Migrating Table 'a_records', adding column %v", column)
+		_, err = store.db.Exec(fmt.Sprintf("ALTER TABLE a_records ADD COLUMN %s TEXT NOT NULL DEFAULT ''", column))
+		if err != nil {
+			storeLog.Errorf("Unable to add column %v to Table 'a_records': %v", column, err)
+			return false
+		}
+	}
+	if !columns["reserved_until"] {
+		storeLog.Infof("Migrating Table 'a_records', adding column reserved_until")
+		_, err = store.db.Exec("ALTER TABLE a_records ADD COLUMN reserved_until INT NOT NULL DEFAULT 0")
+		if err != nil {
+			storeLog.Errorf("Unable to add column reserved_until to Table 'a_records': %v", err)
+			return false
+		}
+	}
+	if !columns["cidr"] {
+		_, err = store.db.Exec(`UPDATE a_records SET cidr = COALESCE(
+		(SELECT cidr FROM ipaddress_range WHERE ipaddress_range.ipaddress = a_records.ipaddress), '')`)
+		if err != nil {
+			storeLog.Errorf("Unable to update Table 'a_records': %v", err)
+			return false
+		}
+	}
 	return true
 }
 
 func (store *DBStore) InsertIP(ips []string, cidr string) {
 	for _, j := range ips {
-		insertIPSQL := `INSERT INTO ipaddress_range(ipaddress, status, cidr) VALUES (?, ?, ?)`
+		// IP Addresses already known to the store keep their state, and are back in use if retired
+		insertIPSQL := `INSERT INTO ipaddress_range(ipaddress, status, cidr) VALUES (?, ?, ?)
+			ON CONFLICT(ipaddress) DO UPDATE SET retired = 0 WHERE cidr = excluded.cidr`
 
 		statement, _ := store.db.Prepare(insertIPSQL)
 
 		_, err := statement.Exec(j, AVAILABLE, cidr)
 		if err != nil {
-			log.Error("[STORE] Unable to Insert row in Table 'ipaddress_range'")
+			storeLog.Error("Unable to Insert row in Table 'ipaddress_range'")
 		}
 	}
 }
@@ -90,116 +215,340 @@ func (store *DBStore) DisplayIPRecords() {
 	if err != nil {
 		log.Debugf(" err : ", err)
 	}
-	log.Debugf("[STORE] Column names: %v", columns)
+	storeLog.Debugf("Column names: %v", columns)
 	defer row.Close()
 	for row.Next() {
 		var id int
 		var ipaddress string
 		var status int
 		var cidr string
-		row.Scan(&id, &ipaddress, &status, &cidr)
-		log.Debugf("[STORE] ipaddress_range: %v\t %v\t%v\t%v", id, ipaddress, status, cidr)
+		var releasedAt int64
+		var retired int
+		row.Scan(&id, &ipaddress, &status, &cidr, &releasedAt, &retired)
+		storeLog.Debugf("ipaddress_range: %v\t %v\t%v\t%v\t%v\t%v", id, ipaddress, status, cidr, releasedAt, retired)
 	}
 }
 
-func (store *DBStore) AllocateIP(cidr string) string {
+// AllocateIP allocates an available IP Address from the CIDR using the given strategy.
+// IP Addresses released within the cooldown period are not allocated.
+func (store *DBStore) AllocateIP(cidr, strategy string, cooldown time.Duration) string {
 	var ipaddress string
 	var id int
 
+	orderBy := "id ASC"
+	switch strategy {
+	case RandomStrategy:
+		orderBy = "RANDOM()"
+	case LeastRecentlyReleasedStrategy:
+		orderBy = "released_at ASC, id ASC"
+	}
 	queryString := fmt.Sprintf(
-		"SELECT ipaddress,id FROM ipaddress_range where status=%d AND cidr=\"%s\" order by id ASC limit 1",
-		AVAILABLE,
-		cidr,
+		"SELECT ipaddress,id FROM ipaddress_range where status=? AND cidr=? AND released_at<=? AND retired=0 "+
+			"order by %s limit 1",
+		orderBy,
 	)
-	err := store.db.QueryRow(queryString).Scan(&ipaddress, &id)
-	if err != nil {
-		log.Infof("[STORE] No Available IP Addresses to Allocate: %v", err)
-		return ""
+	// Another worker may allocate the selected IP Address first, then the next one is selected
+	for {
+		err := store.db.QueryRow(queryString, AVAILABLE, cidr, cooldownCutoff(cooldown)).Scan(&ipaddress, &id)
+		if err != nil {
+			storeLog.Infof("No Available IP Addresses to Allocate: %v", err)
+			return ""
+		}
+		allocated, err := store.markAllocated(id)
+		if err != nil {
+			storeLog.Errorf("Unable to update row in Table 'ipaddress_range': %v", err)
+			return ""
+		}
+		if allocated {
+			return ipaddress
+		}
 	}
+}
 
-	allocateIPSql := fmt.Sprintf("UPDATE ipaddress_range set status = %d where id = ?", ALLOCATED)
-	statement, _ := store.db.Prepare(allocateIPSql)
-
-	_, err = statement.Exec(id)
+// markAllocated allocates the IP Address of the row unless it is no longer available
+func (store *DBStore) markAllocated(id int) (bool, error) {
+	result, err := store.db.Exec(
+		"UPDATE ipaddress_range set status = ? where id = ? AND status = ?", ALLOCATED, id, AVAILABLE)
 	if err != nil {
-		log.Errorf("[STORE] Unable to update row in Table 'ipaddress_range': %v", err)
+		return false, err
 	}
-	return ipaddress
+	rows, err := result.RowsAffected()
+	return rows == 1, err
 }
 
-func (store *DBStore) MarkIPAsAllocated(cidr, ipAddr string) bool {
+// MarkIPAsAllocated allocates the given IP Address of the CIDR,
+// unless it is in use or was released within the cooldown period
+func (store *DBStore) MarkIPAsAllocated(cidr, ipAddr string, cooldown time.Duration) bool {
 	var id int
 
-	queryString := fmt.Sprintf(
-		"SELECT id FROM ipaddress_range where status=%d AND cidr=\"%s\" AND ipaddress=\"%s\" order by id ASC limit 1",
-		AVAILABLE,
-		cidr,
-		ipAddr,
-	)
-	err := store.db.QueryRow(queryString).Scan(&id)
+	queryString := "SELECT id FROM ipaddress_range where status=? AND cidr=? AND ipaddress=? AND released_at<=? " +
+		"AND retired=0 order by id ASC limit 1"
+	err := store.db.QueryRow(queryString, AVAILABLE, cidr, ipAddr, cooldownCutoff(cooldown)).Scan(&id)
 	if err != nil {
-		log.Infof("[STORE] No Available IP Addresses to Allocate: %v", err)
+		storeLog.Infof("No Available IP Addresses to Allocate: %v", err)
 		return false
 	}
 
-	allocateIPSql := fmt.Sprintf("UPDATE ipaddress_range set status = %d where id = ?", ALLOCATED)
-	statement, _ := store.db.Prepare(allocateIPSql)
-
-	_, err = statement.Exec(id)
+	allocated, err := store.markAllocated(id)
 	if err != nil {
-		log.Errorf("[STORE] Unable to update row in Table 'ipaddress_range': %v", err)
+		storeLog.Errorf("Unable to update row in Table 'ipaddress_range': %v", err)
 		return false
 	}
-	return true
+	return allocated
+}
+
+// HasIP checks whether the IP Address is one of the pool of the CIDR and not retired
+func (store *DBStore) HasIP(cidr, ipAddr string) bool {
+	var id int
+	err := store.db.QueryRow(
+		"SELECT id FROM ipaddress_range WHERE cidr=? AND ipaddress=? AND retired=0 LIMIT 1",
+		cidr, ipAddr,
+	).Scan(&id)
+	return err == nil
 }
 
-func (store *DBStore) GetIPAddress(hostname string) string {
+// GetIPAddress returns the IP Address of the A record of the host, pool and owner of the record
+func (store *DBStore) GetIPAddress(record ARecord) string {
 	var ipaddress string
 
-	queryString := fmt.Sprintf(
-		"SELECT ipaddress FROM a_records where hostname=\"%s\" order by ipaddress ASC limit 1",
-		hostname,
-	)
-	err := store.db.QueryRow(queryString).Scan(&ipaddress)
+	err := store.db.QueryRow(
+		"SELECT ipaddress FROM a_records WHERE hostname=? AND cidr=? AND namespace=? AND name=? AND shared_key=? "+
+			"ORDER BY ipaddress ASC LIMIT 1",
+		record.HostName, record.CIDR, record.Namespace, record.Name, record.Key,
+	).Scan(&ipaddress)
 	if err != nil {
-		log.Infof("[STORE] No A record with Host: %v", hostname)
+		storeLog.Infof("No A record with Host: %v, CIDR: %v, Owner: %v/%v, Key: %v",
+			record.HostName, record.CIDR, record.Namespace, record.Name, record.Key)
 		return ""
 	}
 	return ipaddress
 }
 
+// GetSharedIPAddress returns the IP Address shared by the A records of the key in the namespace and pool
+func (store *DBStore) GetSharedIPAddress(namespace, cidr, key string) string {
+	var ipaddress string
+
+	err := store.db.QueryRow(
+		"SELECT ipaddress FROM a_records WHERE namespace=? AND cidr=? AND shared_key=? LIMIT 1",
+		namespace, cidr, key,
+	).Scan(&ipaddress)
+	if err != nil {
+		return ""
+	}
+	return ipaddress
+}
+
+// GetARecords returns the A records of the host, in all pools and of all owners
+func (store *DBStore) GetARecords(hostname string) []ARecord {
+	return store.queryARecords("hostname", hostname)
+}
+
+// GetARecordsOfIP returns the A records that refer to the IP Address
+func (store *DBStore) GetARecordsOfIP(ipAddr string) []ARecord {
+	return store.queryARecords("ipaddress", ipAddr)
+}
+
+// GetAllARecords returns every A record of the store
+func (store *DBStore) GetAllARecords() []ARecord {
+	return store.queryARecordsWhere("1 = 1")
+}
+
+func (store *DBStore) queryARecords(column, value string) []ARecord {
+	return store.queryARecordsWhere(fmt.Sprintf("%s = ?", column), value)
+}
+
+func (store *DBStore) queryARecordsWhere(condition string, args ...interface{}) []ARecord {
+	var records []ARecord
+	rows, err := store.db.Query(
+		"SELECT ipaddress, hostname, cidr, namespace, name, shared_key, reserved_until FROM a_records WHERE "+
+			condition+" ORDER BY cidr, namespace, name, hostname",
+		args...,
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'a_records': %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record ARecord
+		err := rows.Scan(&record.IPAddr, &record.HostName, &record.CIDR, &record.Namespace, &record.Name, &record.Key,
+			&record.ReservedUntil)
+		if err == nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// ReleaseIP makes the IP Address available again and records the time of release
 func (store *DBStore) ReleaseIP(ip string) {
-	unallocateIPSql := fmt.Sprintf("UPDATE ipaddress_range set status = %d where ipaddress = ?", AVAILABLE)
+	unallocateIPSql := fmt.Sprintf("UPDATE ipaddress_range set status = %d, released_at = ? where ipaddress = ?", AVAILABLE)
 	statement, _ := store.db.Prepare(unallocateIPSql)
 
-	_, err := statement.Exec(ip)
+	_, err := statement.Exec(time.Now().Unix(), ip)
 	if err != nil {
-		log.Errorf("[STORE] Unable to update row in Table 'ipaddress_range': %v", err)
+		storeLog.Errorf("Unable to update row in Table 'ipaddress_range': %v", err)
+	}
+
+	_, err = store.db.Exec("DELETE FROM ipaddress_range WHERE ipaddress = ? AND retired = 1", ip)
+	if err != nil {
+		storeLog.Errorf("Unable to delete retired row from Table 'ipaddress_range': %v", err)
+	}
+
+	_, err = store.db.Exec("DELETE FROM retained_ips WHERE ipaddress = ?", ip)
+	if err != nil {
+		storeLog.Errorf("Unable to delete row from Table 'retained_ips': %v", err)
 	}
 }
 
-func (store *DBStore) CreateARecord(hostname, ipAddr string) bool {
-	insertARecordSQL := `INSERT INTO a_records(ipaddress, hostname) VALUES (?, ?)`
+// GetCIDRs returns the CIDRs that have IP Addresses in the store
+func (store *DBStore) GetCIDRs() []string {
+	var cidrs []string
+	rows, err := store.db.Query("SELECT DISTINCT cidr FROM ipaddress_range")
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'ipaddress_range': %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cidr string
+		if rows.Scan(&cidr) == nil {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// GetIPRecords returns the IP Addresses of the CIDR
+func (store *DBStore) GetIPRecords(cidr string) []IPRecord {
+	var records []IPRecord
+	rows, err := store.db.Query(
+		"SELECT ipaddress, status, released_at, retired FROM ipaddress_range WHERE cidr = ? ORDER BY id",
+		cidr,
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to query Table 'ipaddress_range': %v", err)
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record := IPRecord{CIDR: cidr}
+		var retired int
+		if rows.Scan(&record.IPAddr, &record.Status, &record.ReleasedAt, &retired) == nil {
+			record.Retired = retired == 1
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// RetireIP takes an allocated IP Address out of its pool, it is removed once released
+func (store *DBStore) RetireIP(ip string) bool {
+	_, err := store.db.Exec("UPDATE ipaddress_range SET retired = 1 WHERE ipaddress = ?", ip)
+	if err != nil {
+		storeLog.Errorf("Unable to update row in Table 'ipaddress_range': %v", err)
+		return false
+	}
+	return true
+}
+
+// RemoveIP removes an IP Address from the store
+func (store *DBStore) RemoveIP(ip string) bool {
+	_, err := store.db.Exec("DELETE FROM ipaddress_range WHERE ipaddress = ?", ip)
+	if err != nil {
+		storeLog.Errorf("Unable to delete row from Table 'ipaddress_range': %v", err)
+		return false
+	}
+	return true
+}
+
+// GetHostName returns the host of the A record of the IP Address
+func (store *DBStore) GetHostName(ipAddr string) string {
+	var hostname string
+	err := store.db.QueryRow(
+		"SELECT hostname FROM a_records WHERE ipaddress = ? ORDER BY hostname ASC LIMIT 1",
+		ipAddr,
+	).Scan(&hostname)
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+func (store *DBStore) CreateARecord(record ARecord) bool {
+	insertARecordSQL := `INSERT INTO a_records(ipaddress, hostname, cidr, namespace, name, shared_key, reserved_until)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
 
 	statement, _ := store.db.Prepare(insertARecordSQL)
 
-	_, err := statement.Exec(ipAddr, hostname)
+	_, err := statement.Exec(record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key,
+		record.ReservedUntil)
 	if err != nil {
-		log.Error("[STORE] Unable to Insert row in Table 'a_records'")
+		storeLog.Error("Unable to Insert row in Table 'a_records'")
 		return false
 	}
 	return true
 }
 
-func (store *DBStore) DeleteARecord(hostname, ipAddr string) bool {
-	deleteARecord := "DELETE FROM a_records WHERE ipaddress=? AND hostname=?"
+func (store *DBStore) DeleteARecord(record ARecord) bool {
+	deleteARecord := "DELETE FROM a_records WHERE ipaddress=? AND hostname=? AND cidr=? AND namespace=? AND name=? " +
+		"AND shared_key=?"
 
 	statement, _ := store.db.Prepare(deleteARecord)
 
-	_, err := statement.Exec(ipAddr, hostname)
+	_, err := statement.Exec(record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key)
 	if err != nil {
-		log.Error("[STORE] Unable to Delete row from Table 'a_records'")
+		storeLog.Error("Unable to Delete row from Table 'a_records'")
 		return false
 	}
 	return true
 }
+
+// CommitARecord makes the reserved A record permanent, it fails when the reservation expired
+func (store *DBStore) CommitARecord(record ARecord) bool {
+	result, err := store.db.Exec(
+		"UPDATE a_records SET reserved_until = 0 WHERE ipaddress=? AND hostname=? AND cidr=? AND namespace=? "+
+			"AND name=? AND shared_key=? AND (reserved_until = 0 OR reserved_until >= ?)",
+		record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to update row in Table 'a_records': %v", err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows != 0
+}
+
+// GetExpiredARecords returns the A records whose reservation expired
+func (store *DBStore) GetExpiredARecords() []ARecord {
+	return store.queryARecordsWhere("reserved_until > 0 AND reserved_until < ?", time.Now().Unix())
+}
+
+// DeleteExpiredARecord removes the A record if its reservation expired, and reports whether it did
+func (store *DBStore) DeleteExpiredARecord(record ARecord) bool {
+	result, err := store.db.Exec(
+		"DELETE FROM a_records WHERE ipaddress=? AND hostname=? AND cidr=? AND namespace=? AND name=? "+
+			"AND shared_key=? AND reserved_until > 0 AND reserved_until < ?",
+		record.IPAddr, record.HostName, record.CIDR, record.Namespace, record.Name, record.Key, time.Now().Unix(),
+	)
+	if err != nil {
+		storeLog.Errorf("Unable to delete row from Table 'a_records': %v", err)
+		return false
+	}
+	rows, err := result.RowsAffected()
+	return err == nil && rows != 0
+}
+
+// Close closes the database after the statements in progress are done
+func (store *DBStore) Close() {
+	if err := store.db.Close(); err != nil {
+		storeLog.Errorf("Unable to close DB, %v", err)
+	}
+}
+
+// cooldownCutoff returns the latest release time, in unix seconds, of an IP Address
+// that has completed the cooldown period
+func cooldownCutoff(cooldown time.Duration) int64 {
+	return time.Now().Add(-cooldown).Unix()
+}
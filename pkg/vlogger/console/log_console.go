@@ -24,13 +24,15 @@ import (
 	"log"
 	"log/syslog"
 	"os"
+	"sync/atomic"
 )
 
 type (
 	consoleLogger struct {
 		// slLogLevel uses syslog's definitions which have higher priority
-		// levels defined in descending order (0 is highest)
-		slLogLevel syslog.Priority
+		// levels defined in descending order (0 is highest), it is a
+		// syslog.Priority that can be changed while messages are logged
+		slLogLevel int32
 	}
 )
 
@@ -38,7 +40,7 @@ type (
 // to the console.
 func NewConsoleLogger() *consoleLogger {
 	return &consoleLogger{
-		slLogLevel: syslog.LOG_DEBUG,
+		slLogLevel: int32(syslog.LOG_DEBUG),
 	}
 }
 
@@ -51,26 +53,26 @@ func NewConsoleLoggerExt(prefix string, flags int) *consoleLogger {
 }
 
 func (cl *consoleLogger) Debug(msg string) {
-	if cl.slLogLevel >= syslog.LOG_DEBUG {
+	if cl.level() >= syslog.LOG_DEBUG {
 		log.Println("[DEBUG]", msg)
 	}
 }
 
 func (cl *consoleLogger) Debugf(format string, params ...interface{}) {
-	if cl.slLogLevel >= syslog.LOG_DEBUG {
+	if cl.level() >= syslog.LOG_DEBUG {
 		msg := fmt.Sprintf(format, params...)
 		log.Println("[DEBUG]", msg)
 	}
 }
 
 func (cl *consoleLogger) Info(msg string) {
-	if cl.slLogLevel >= syslog.LOG_INFO {
+	if cl.level() >= syslog.LOG_INFO {
 		toSTDOUT(msg)
 	}
 }
 
 func (cl *consoleLogger) Infof(format string, params ...interface{}) {
-	if cl.slLogLevel >= syslog.LOG_INFO {
+	if cl.level() >= syslog.LOG_INFO {
 		msg := fmt.Sprintf(format, params...)
 		toSTDOUT(msg)
 	}
@@ -83,50 +85,54 @@ func toSTDOUT(msg string) {
 }
 
 func (cl *consoleLogger) Warning(msg string) {
-	if cl.slLogLevel >= syslog.LOG_WARNING {
+	if cl.level() >= syslog.LOG_WARNING {
 		log.Println("[WARNING]", msg)
 	}
 }
 
 func (cl *consoleLogger) Warningf(format string, params ...interface{}) {
-	if cl.slLogLevel >= syslog.LOG_WARNING {
+	if cl.level() >= syslog.LOG_WARNING {
 		msg := fmt.Sprintf(format, params...)
 		log.Println("[WARNING]", msg)
 	}
 }
 
 func (cl *consoleLogger) Error(msg string) {
-	if cl.slLogLevel >= syslog.LOG_ERR {
+	if cl.level() >= syslog.LOG_ERR {
 		log.Println("[ERROR]", msg)
 	}
 }
 
 func (cl *consoleLogger) Errorf(format string, params ...interface{}) {
-	if cl.slLogLevel >= syslog.LOG_ERR {
+	if cl.level() >= syslog.LOG_ERR {
 		msg := fmt.Sprintf(format, params...)
 		log.Println("[ERROR]", msg)
 	}
 }
 
 func (cl *consoleLogger) Critical(msg string) {
-	if cl.slLogLevel >= syslog.LOG_CRIT {
+	if cl.level() >= syslog.LOG_CRIT {
 		log.Println("[CRITICAL]", msg)
 	}
 }
 
 func (cl *consoleLogger) Criticalf(format string, params ...interface{}) {
-	if cl.slLogLevel >= syslog.LOG_CRIT {
+	if cl.level() >= syslog.LOG_CRIT {
 		msg := fmt.Sprintf(format, params...)
 		log.Println("[CRITICAL]", msg)
 	}
 }
 
 func (cl *consoleLogger) SetLogLevel(slLogLevel syslog.Priority) {
-	cl.slLogLevel = slLogLevel
+	atomic.StoreInt32(&cl.slLogLevel, int32(slLogLevel))
 }
 
 func (cl *consoleLogger) GetLogLevel() syslog.Priority {
-	return cl.slLogLevel
+	return cl.level()
+}
+
+func (cl *consoleLogger) level() syslog.Priority {
+	return syslog.Priority(atomic.LoadInt32(&cl.slLogLevel))
 }
 
 func (cl *consoleLogger) Close() {
@@ -0,0 +1,207 @@
+// Copyright (c) 2019-2020, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//fields.go:
+//  This module provides structured logging, messages that carry key/value fields.
+//  Loggers that implement StructuredLogger receive the fields as they are, the
+//  others receive the message with the fields appended to it.
+//
+package vlogger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keys of the fields that are common to the subsystems
+const (
+	SubsystemKey = "subsystem"
+	NamespaceKey = "namespace"
+	ResourceKey  = "resource"
+	HostKey      = "host"
+	CIDRKey      = "cidr"
+	IPKey        = "ip"
+	SharedKeyKey = "key"
+	ErrorKey     = "error"
+)
+
+type (
+	// Field is a key/value pair carried by a log message
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+
+	// StructuredLogger is a Logger that records the fields of messages apart from their text.
+	StructuredLogger interface {
+		Logger
+		Log(level LogLevel, msg string, fields []Field)
+	}
+
+	// Entry logs messages with a set of fields. Entries are immutable, With returns a new one.
+	Entry struct {
+		fields []Field
+	}
+)
+
+// NewSubsystemLogger returns an Entry that marks its messages with the subsystem
+func NewSubsystemLogger(subsystem string) *Entry {
+	return &Entry{fields: []Field{{Key: SubsystemKey, Value: subsystem}}}
+}
+
+// With returns an Entry with the fields given as alternating keys and values
+func With(keysAndValues ...interface{}) *Entry {
+	return (&Entry{}).With(keysAndValues...)
+}
+
+// With returns a copy of the Entry with the fields added, replacing those with the same key
+func (e *Entry) With(keysAndValues ...interface{}) *Entry {
+	fields := make([]Field, len(e.fields), len(e.fields)+len(keysAndValues)/2)
+	copy(fields, e.fields)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		field := Field{Key: fmt.Sprint(keysAndValues[i])}
+		if i+1 < len(keysAndValues) {
+			field.Value = keysAndValues[i+1]
+		}
+		fields = setField(fields, field)
+	}
+	return &Entry{fields: fields}
+}
+
+// Fields returns the fields of the Entry
+func (e *Entry) Fields() []Field {
+	return append([]Field(nil), e.fields...)
+}
+
+func setField(fields []Field, field Field) []Field {
+	for i := range fields {
+		if fields[i].Key == field.Key {
+			fields[i] = field
+			return fields
+		}
+	}
+	return append(fields, field)
+}
+
+func (e *Entry) Debug(msg string) {
+	e.log(LL_DEBUG, msg)
+}
+
+func (e *Entry) Debugf(format string, params ...interface{}) {
+	e.logf(LL_DEBUG, format, params...)
+}
+
+func (e *Entry) Info(msg string) {
+	e.log(LL_INFO, msg)
+}
+
+func (e *Entry) Infof(format string, params ...interface{}) {
+	e.logf(LL_INFO, format, params...)
+}
+
+func (e *Entry) Warning(msg string) {
+	e.log(LL_WARNING, msg)
+}
+
+func (e *Entry) Warningf(format string, params ...interface{}) {
+	e.logf(LL_WARNING, format, params...)
+}
+
+func (e *Entry) Error(msg string) {
+	e.log(LL_ERROR, msg)
+}
+
+func (e *Entry) Errorf(format string, params ...interface{}) {
+	e.logf(LL_ERROR, format, params...)
+}
+
+func (e *Entry) Critical(msg string) {
+	e.log(LL_CRITICAL, msg)
+}
+
+func (e *Entry) Criticalf(format string, params ...interface{}) {
+	e.logf(LL_CRITICAL, format, params...)
+}
+
+// logf formats the message only when the level is not filtered
+func (e *Entry) logf(level LogLevel, format string, params ...interface{}) {
+	if level >= GetLogLevel() {
+		e.log(level, fmt.Sprintf(format, params...))
+	}
+}
+
+// log sends the message to the logger of the level, as text when it is not structured
+func (e *Entry) log(level LogLevel, msg string) {
+	if level < GetLogLevel() {
+		return
+	}
+	logger := vlog[level]
+	if sl, ok := logger.(StructuredLogger); ok {
+		sl.Log(level, msg, e.fields)
+		return
+	}
+	msg = FormatFields(msg, e.fields)
+	switch level {
+	case LL_DEBUG:
+		logger.Debug(msg)
+	case LL_INFO:
+		logger.Info(msg)
+	case LL_WARNING:
+		logger.Warning(msg)
+	case LL_ERROR:
+		logger.Error(msg)
+	default:
+		logger.Critical(msg)
+	}
+}
+
+// FormatFields renders the message with its fields as text, "[SUBSYSTEM] msg key=value ..."
+func FormatFields(msg string, fields []Field) string {
+	var b strings.Builder
+	for _, field := range fields {
+		if field.Key == SubsystemKey {
+			fmt.Fprintf(&b, "[%v] ", field.Value)
+		}
+	}
+	b.WriteString(msg)
+	for _, field := range fields {
+		if field.Key == SubsystemKey {
+			continue
+		}
+		value := fmt.Sprint(field.Value)
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %v=%v", field.Key, value)
+	}
+	return b.String()
+}
+
+// SplitSubsystem separates the "[SUBSYSTEM] " prefix of plain messages from their text
+func SplitSubsystem(msg string) (string, string) {
+	if !strings.HasPrefix(msg, "[") {
+		return "", msg
+	}
+	end := strings.Index(msg, "] ")
+	if end < 2 || end > 16 {
+		return "", msg
+	}
+	subsystem := msg[1:end]
+	for _, c := range subsystem {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' && c != '-' {
+			return "", msg
+		}
+	}
+	return subsystem, msg[end+2:]
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2019-2020, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//log_file.go:
+//  Provides logging to a file that is rotated by size through the common interface.
+//  To use, create the logger object with the following syntax:
+//    NewFileLogger(path, maxSize, maxBackups)
+//  The RotatingWriter of the file can also be given to other loggers, like
+//  jsonlog.NewJSONLoggerExt().
+//
+package file
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	fileLogger struct {
+		// slLogLevel uses syslog's definitions which have higher priority
+		// levels defined in descending order (0 is highest), it is a
+		// syslog.Priority that can be changed while messages are logged
+		slLogLevel int32
+
+		out    *RotatingWriter
+		logger *log.Logger
+	}
+
+	// RotatingWriter appends to a file, which is renamed to <path>.1 once it would grow beyond
+	// its maximum size. The previous backups are renamed to <path>.2 and so on, those beyond
+	// the maximum backups are removed.
+	RotatingWriter struct {
+		mutex      sync.Mutex
+		path       string
+		maxSize    int64
+		maxBackups int
+		file       *os.File
+		size       int64
+	}
+)
+
+// NewFileLogger creates a logger object that writes log messages to the file at path,
+// rotating it once it would grow beyond maxSize bytes and keeping maxBackups rotated files.
+func NewFileLogger(path string, maxSize int64, maxBackups int) (*fileLogger, error) {
+	out, err := NewRotatingWriter(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLogger{
+		slLogLevel: int32(syslog.LOG_DEBUG),
+		out:        out,
+		logger:     log.New(out, "", log.LstdFlags),
+	}, nil
+}
+
+// NewRotatingWriter opens the file at path for appending, it is never rotated when maxSize is 0
+func NewRotatingWriter(path string, maxSize int64, maxBackups int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, after rotating it when p does not fit
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and its backups, and opens a new file. The file is appended to
+// again when it can not be renamed.
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	err := w.shift()
+	if openErr := w.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift renames the file to the first backup, and every backup to the next one
+func (w *RotatingWriter) shift() error {
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	for i := w.maxBackups; i > 1; i-- {
+		err := os.Rename(w.backup(i-1), w.backup(i))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.path, w.backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (w *RotatingWriter) backup(i int) string {
+	return fmt.Sprintf("%v.%v", w.path, i)
+}
+
+// Close closes the file, it is not written to anymore
+func (w *RotatingWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (fl *fileLogger) Debug(msg string) {
+	if fl.level() >= syslog.LOG_DEBUG {
+		fl.logger.Println("[DEBUG]", msg)
+	}
+}
+
+func (fl *fileLogger) Debugf(format string, params ...interface{}) {
+	if fl.level() >= syslog.LOG_DEBUG {
+		fl.logger.Println("[DEBUG]", fmt.Sprintf(format, params...))
+	}
+}
+
+func (fl *fileLogger) Info(msg string) {
+	if fl.level() >= syslog.LOG_INFO {
+		fl.logger.Println("[INFO]", msg)
+	}
+}
+
+func (fl *fileLogger) Infof(format string, params ...interface{}) {
+	if fl.level() >= syslog.LOG_INFO {
+		fl.logger.Println("[INFO]", fmt.Sprintf(format, params...))
+	}
+}
+
+func (fl *fileLogger) Warning(msg string) {
+	if fl.level() >= syslog.LOG_WARNING {
+		fl.logger.Println("[WARNING]", msg)
+	}
+}
+
+func (fl *fileLogger) Warningf(format string, params ...interface{}) {
+	if fl.level() >= syslog.LOG_WARNING {
+		fl.logger.Println("[WARNING]", fmt.Sprintf(format, params...))
+	}
+}
+
+func (fl *fileLogger) Error(msg string) {
+	if fl.level() >= syslog.LOG_ERR {
+		fl.logger.Println("[ERROR]", msg)
+	}
+}
+
+func (fl *fileLogger) Errorf(format string, params ...interface{}) {
+	if fl.level() >= syslog.LOG_ERR {
+		fl.logger.Println("[ERROR]", fmt.Sprintf(format, params...))
+	}
+}
+
+func (fl *fileLogger) Critical(msg string) {
+	if fl.level() >= syslog.LOG_CRIT {
+		fl.logger.Println("[CRITICAL]", msg)
+	}
+}
+
+func (fl *fileLogger) Criticalf(format string, params ...interface{}) {
+	if fl.level() >= syslog.LOG_CRIT {
+		fl.logger.Println("[CRITICAL]", fmt.Sprintf(format, params...))
+	}
+}
+
+func (fl *fileLogger) SetLogLevel(slLogLevel syslog.Priority) {
+	atomic.StoreInt32(&fl.slLogLevel, int32(slLogLevel))
+}
+
+func (fl *fileLogger) GetLogLevel() syslog.Priority {
+	return fl.level()
+}
+
+func (fl *fileLogger) level() syslog.Priority {
+	return syslog.Priority(atomic.LoadInt32(&fl.slLogLevel))
+}
+
+func (fl *fileLogger) Close() {
+	_ = fl.out.Close()
+}
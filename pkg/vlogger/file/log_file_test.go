@@ -0,0 +1,62 @@
+package file
+
+import (
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controller.log")
+	w, err := NewRotatingWriter(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	w.Close()
+	if _, err := w.Write([]byte("closed\n")); err != os.ErrClosed {
+		t.Errorf("Expected the closed writer to fail, got %v", err)
+	}
+
+	expected := map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"}
+	for file, content := range expected {
+		data, err := ioutil.ReadFile(file)
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %q in %v, got %q, %v", content, file, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups, got %v", err)
+	}
+}
+
+func TestFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controller.log")
+	fl, err := NewFileLogger(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.SetLogLevel(syslog.LOG_INFO)
+	fl.Debug("Filtered")
+	fl.Infof("Allocated IP: %v", "10.1.1.1")
+	fl.Error("Unable to Insert row")
+	fl.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "[INFO] Allocated IP: 10.1.1.1") ||
+		!strings.HasSuffix(lines[1], "[ERROR] Unable to Insert row") {
+		t.Errorf("Unexpected log file: %q", data)
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2019-2020, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//log_json.go:
+//  Provides logging as lines of JSON through the common interface.
+//  To use, create the logger object with the following syntax:
+//    NewJSONLogger()
+//  Every line is an object with the time, level and msg of the message,
+//  followed by its fields.
+//
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+type (
+	jsonLogger struct {
+		// slLogLevel uses syslog's definitions which have higher priority
+		// levels defined in descending order (0 is highest), it is a
+		// syslog.Priority that can be changed while messages are logged
+		slLogLevel int32
+
+		mutex sync.Mutex
+		out   io.Writer
+	}
+)
+
+var slLogLevels = [log.LL_LOGLEVEL_SIZE]syslog.Priority{
+	syslog.LOG_DEBUG,
+	syslog.LOG_INFO,
+	syslog.LOG_WARNING,
+	syslog.LOG_ERR,
+	syslog.LOG_CRIT,
+}
+
+// NewJSONLogger creates a logger object that prints log messages as lines of JSON
+// to stderr.
+func NewJSONLogger() *jsonLogger {
+	return NewJSONLoggerExt(os.Stderr)
+}
+
+// NewJSONLoggerExt creates a logger object that writes log messages as lines of JSON
+// to out. out is closed along with the logger, unless it is stdout or stderr.
+func NewJSONLoggerExt(out io.Writer) *jsonLogger {
+	return &jsonLogger{
+		slLogLevel: int32(syslog.LOG_DEBUG),
+		out:        out,
+	}
+}
+
+// Log writes the message with its fields, the subsystem first
+func (jl *jsonLogger) Log(level log.LogLevel, msg string, fields []log.Field) {
+	if jl.level() < slLogLevels[level] {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeValue(&b, level.String())
+	for _, field := range fields {
+		if field.Key == log.SubsystemKey {
+			writeField(&b, field)
+		}
+	}
+	b.WriteString(`,"msg":`)
+	writeValue(&b, msg)
+	for _, field := range fields {
+		if field.Key != log.SubsystemKey {
+			writeField(&b, field)
+		}
+	}
+	b.WriteString("}\n")
+
+	jl.mutex.Lock()
+	defer jl.mutex.Unlock()
+	_, _ = jl.out.Write(b.Bytes())
+}
+
+func writeField(b *bytes.Buffer, field log.Field) {
+	b.WriteByte(',')
+	writeValue(b, field.Key)
+	b.WriteByte(':')
+	writeValue(b, field.Value)
+}
+
+func writeValue(b *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(data)
+}
+
+// logText writes a plain message, its "[SUBSYSTEM] " prefix becomes the subsystem field
+func (jl *jsonLogger) logText(level log.LogLevel, msg string) {
+	var fields []log.Field
+	if subsystem, text := log.SplitSubsystem(msg); subsystem != "" {
+		fields = []log.Field{{Key: log.SubsystemKey, Value: subsystem}}
+		msg = text
+	}
+	jl.Log(level, msg, fields)
+}
+
+func (jl *jsonLogger) Debug(msg string) {
+	jl.logText(log.LL_DEBUG, msg)
+}
+
+func (jl *jsonLogger) Debugf(format string, params ...interface{}) {
+	if jl.level() >= syslog.LOG_DEBUG {
+		jl.logText(log.LL_DEBUG, fmt.Sprintf(format, params...))
+	}
+}
+
+func (jl *jsonLogger) Info(msg string) {
+	jl.logText(log.LL_INFO, msg)
+}
+
+func (jl *jsonLogger) Infof(format string, params ...interface{}) {
+	if jl.level() >= syslog.LOG_INFO {
+		jl.logText(log.LL_INFO, fmt.Sprintf(format, params...))
+	}
+}
+
+func (jl *jsonLogger) Warning(msg string) {
+	jl.logText(log.LL_WARNING, msg)
+}
+
+func (jl *jsonLogger) Warningf(format string, params ...interface{}) {
+	if jl.level() >= syslog.LOG_WARNING {
+		jl.logText(log.LL_WARNING, fmt.Sprintf(format, params...))
+	}
+}
+
+func (jl *jsonLogger) Error(msg string) {
+	jl.logText(log.LL_ERROR, msg)
+}
+
+func (jl *jsonLogger) Errorf(format string, params ...interface{}) {
+	if jl.level() >= syslog.LOG_ERR {
+		jl.logText(log.LL_ERROR, fmt.Sprintf(format, params...))
+	}
+}
+
+func (jl *jsonLogger) Critical(msg string) {
+	jl.logText(log.LL_CRITICAL, msg)
+}
+
+func (jl *jsonLogger) Criticalf(format string, params ...interface{}) {
+	if jl.level() >= syslog.LOG_CRIT {
+		jl.logText(log.LL_CRITICAL, fmt.Sprintf(format, params...))
+	}
+}
+
+func (jl *jsonLogger) SetLogLevel(slLogLevel syslog.Priority) {
+	atomic.StoreInt32(&jl.slLogLevel, int32(slLogLevel))
+}
+
+func (jl *jsonLogger) GetLogLevel() syslog.Priority {
+	return jl.level()
+}
+
+func (jl *jsonLogger) level() syslog.Priority {
+	return syslog.Priority(atomic.LoadInt32(&jl.slLogLevel))
+}
+
+func (jl *jsonLogger) Close() {
+	if closer, ok := jl.out.(io.Closer); ok && jl.out != os.Stdout && jl.out != os.Stderr {
+		_ = closer.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var out bytes.Buffer
+	log.RegisterLogger(log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, NewJSONLoggerExt(&out))
+	log.SetLogLevel(log.LL_INFO)
+	defer log.SetLogLevel(log.LL_DEBUG)
+
+	entry := log.NewSubsystemLogger("CORE").With(log.NamespaceKey, "default", log.HostKey, "web.example.com")
+	entry.With(log.IPKey, "10.1.1.1").Infof("Allocated IP in %v", "10.1.1.0/24")
+	entry.Debug("Filtered")
+	log.Warningf("[STORE] Unable to Insert row: %v", "locked")
+	log.Error("[not a tag] plain")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %v: %v", len(lines), out.String())
+	}
+	var msgs []map[string]interface{}
+	for _, line := range lines {
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("Invalid JSON line %v: %v", line, err)
+		}
+		if msg["time"] == "" || msg["time"] == nil {
+			t.Errorf("Missing time in %v", line)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	expected := []map[string]interface{}{
+		{"level": "info", "subsystem": "CORE", "msg": "Allocated IP in 10.1.1.0/24",
+			"namespace": "default", "host": "web.example.com", "ip": "10.1.1.1"},
+		{"level": "warning", "subsystem": "STORE", "msg": "Unable to Insert row: locked"},
+		{"level": "error", "msg": "[not a tag] plain"},
+	}
+	for i, want := range expected {
+		for key, value := range want {
+			if msgs[i][key] != value {
+				t.Errorf("Line %v: expected %v=%v, got %v", i, key, value, msgs[i][key])
+			}
+		}
+		if len(msgs[i]) != len(want)+1 {
+			t.Errorf("Line %v: unexpected fields in %v", i, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[0], `{"time":`) || !strings.Contains(lines[0], `"level":"info","subsystem":"CORE","msg":`) {
+		t.Errorf("Unexpected order of the fields: %v", lines[0])
+	}
+}
+
+func TestFormatFields(t *testing.T) {
+	fields := log.NewSubsystemLogger("PROV").With(log.HostKey, "web", log.CIDRKey, "10.1.1.0/24",
+		"reason", "pool is exhausted", log.HostKey, "api").Fields()
+	got := log.FormatFields("Unable to allocate", fields)
+	want := `[PROV] Unable to allocate host=api cidr=10.1.1.0/24 reason="pool is exhausted"`
+	if got != want {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2019-2020, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//klogbridge.go:
+//  Sends the output of klog, which client-go logs through, and of logr to the
+//  loggers registered with vlogger. To use, install the bridge once the loggers
+//  are registered:
+//    Install()
+//  The verbosity of klog follows the vlogger level from then on.
+//
+package klogbridge
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+	"k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+)
+
+const (
+	// Subsystem of the messages of klog
+	Subsystem = "KLOG"
+	// CallerKey is the field of the file and line a message of klog was logged at
+	CallerKey = "caller"
+
+	// DebugVerbosity of klog while vlogger logs debug messages. client-go logs the details of its
+	// requests and caches at this verbosity, higher ones dump their content.
+	DebugVerbosity = 4
+)
+
+var (
+	klogLog = log.NewSubsystemLogger(Subsystem)
+
+	mutex    sync.Mutex
+	flags    *flag.FlagSet
+	flagsV2  *flag.FlagSet
+	severity = []string{"INFO", "WARNING", "ERROR", "FATAL"}
+)
+
+// Install sends the output of klog and klog/v2 to vlogger, klog no longer writes to stderr or files
+func Install() {
+	install()
+	SetLogLevel(log.GetLogLevel())
+}
+
+func install() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if flags == nil {
+		flags = flag.NewFlagSet("klog", flag.ContinueOnError)
+		klog.InitFlags(flags)
+		flagsV2 = flag.NewFlagSet("klog/v2", flag.ContinueOnError)
+		klogv2.InitFlags(flagsV2)
+		log.OnLogLevelChange(SetLogLevel)
+	}
+	for _, fs := range []*flag.FlagSet{flags, flagsV2} {
+		_ = fs.Set("logtostderr", "false")
+		_ = fs.Set("alsologtostderr", "false")
+		// Fatal messages are written to stderr as well, as klog exits right after them
+		_ = fs.Set("stderrthreshold", "FATAL")
+	}
+
+	// klog writes a message to the output of its severity and to those of the lower ones,
+	// every output only passes on the messages of its own severity
+	for _, name := range severity {
+		klog.SetOutputBySeverity(name, &writer{severity: name[0]})
+	}
+	klogv2.SetLogger(NewLogger(Subsystem))
+}
+
+// SetLogLevel sets the verbosity of klog to the vlogger level, messages of klog beyond the
+// default verbosity are only logged at the debug level. It is called on every change of the
+// vlogger level once the bridge is installed.
+func SetLogLevel(level log.LogLevel) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if flags == nil {
+		return
+	}
+	verbosity := 0
+	if level <= log.LL_DEBUG {
+		verbosity = DebugVerbosity
+	}
+	_ = flags.Set("v", strconv.Itoa(verbosity))
+	_ = flagsV2.Set("v", strconv.Itoa(verbosity))
+}
+
+// writer passes the messages of klog of a severity on to vlogger
+type writer struct {
+	severity byte
+}
+
+// Write takes a message of klog, "Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg"
+func (w *writer) Write(data []byte) (int, error) {
+	line := strings.TrimRight(string(data), "\n")
+	if len(line) == 0 || line[0] != w.severity {
+		return len(data), nil
+	}
+	entry := klogLog
+	if end := strings.Index(line, "] "); end > 30 {
+		entry = entry.With(CallerKey, line[30:end])
+		line = line[end+2:]
+	}
+
+	switch w.severity {
+	case 'I':
+		entry.Info(line)
+	case 'W':
+		entry.Warning(line)
+	case 'E':
+		entry.Error(line)
+	default:
+		entry.Critical(line)
+	}
+	return len(data), nil
+}
+
+// logger is a logr.Logger that logs to vlogger, at the debug level beyond verbosity 0
+type logger struct {
+	entry     *log.Entry
+	verbosity int
+}
+
+// NewLogger returns a logr.Logger that logs to vlogger as the subsystem
+func NewLogger(subsystem string) logr.Logger {
+	return &logger{entry: log.NewSubsystemLogger(subsystem)}
+}
+
+func (l *logger) Info(msg string, keysAndValues ...interface{}) {
+	entry := l.entry.With(keysAndValues...)
+	msg = strings.TrimRight(msg, "\n")
+	if l.verbosity > 0 {
+		entry.Debug(msg)
+		return
+	}
+	entry.Info(msg)
+}
+
+func (l *logger) Enabled() bool {
+	if l.verbosity > 0 {
+		return log.GetLogLevel() <= log.LL_DEBUG
+	}
+	return log.GetLogLevel() <= log.LL_INFO
+}
+
+func (l *logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	entry := l.entry.With(keysAndValues...)
+	if err != nil {
+		entry = entry.With(log.ErrorKey, err)
+	}
+	entry.Error(strings.TrimRight(msg, "\n"))
+}
+
+func (l *logger) V(level int) logr.InfoLogger {
+	return &logger{entry: l.entry, verbosity: l.verbosity + level}
+}
+
+func (l *logger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return &logger{entry: l.entry.With(keysAndValues...), verbosity: l.verbosity}
+}
+
+// WithName appends the name to the subsystem, "KLOG.name"
+func (l *logger) WithName(name string) logr.Logger {
+	subsystem := name
+	for _, field := range l.entry.Fields() {
+		if field.Key == log.SubsystemKey {
+			subsystem = fmt.Sprint(field.Value) + "." + name
+		}
+	}
+	return &logger{entry: l.entry.With(log.SubsystemKey, subsystem), verbosity: l.verbosity}
+}
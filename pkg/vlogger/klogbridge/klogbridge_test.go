@@ -0,0 +1,85 @@
+package klogbridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/subbuv26/f5-ipam-controller/pkg/vlogger"
+	"github.com/subbuv26/f5-ipam-controller/pkg/vlogger/jsonlog"
+	"k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+)
+
+func TestBridge(t *testing.T) {
+	var out bytes.Buffer
+	log.RegisterLogger(log.LL_MIN_LEVEL, log.LL_MAX_LEVEL, jsonlog.NewJSONLoggerExt(&out))
+	log.SetLogLevel(log.LL_INFO)
+	defer log.SetLogLevel(log.LL_DEBUG)
+	Install()
+	SetLogLevel(log.LL_INFO)
+
+	messages := func() []map[string]interface{} {
+		defer out.Reset()
+		var msgs []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			var msg map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("Invalid JSON line %v: %v", line, err)
+			}
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	klog.Info("Starting reflector")
+	klog.Warningf("Watch of %v ended", "F5IPAM")
+	klog.Error("Unable to list")
+	klog.V(4).Info("Listing F5IPAMs")
+	msgs := messages()
+	expected := []struct{ level, msg string }{
+		{"info", "Starting reflector"},
+		{"warning", "Watch of F5IPAM ended"},
+		{"error", "Unable to list"},
+	}
+	if len(msgs) != len(expected) {
+		t.Fatalf("Expected %v messages, got %v", len(expected), msgs)
+	}
+	for i, want := range expected {
+		if msgs[i]["level"] != want.level || msgs[i]["msg"] != want.msg || msgs[i]["subsystem"] != Subsystem {
+			t.Errorf("Expected %v message %v, got %v", want.level, want.msg, msgs[i])
+		}
+		if caller, _ := msgs[i][CallerKey].(string); !strings.HasPrefix(caller, "klogbridge_test.go:") {
+			t.Errorf("Expected the caller of the message, got %v", msgs[i])
+		}
+	}
+
+	log.SetLogLevel(log.LL_DEBUG)
+	SetLogLevel(log.LL_DEBUG)
+	klog.V(4).Info("Listing F5IPAMs")
+	klog.V(5).Info("Response body")
+	if msgs = messages(); len(msgs) != 1 || msgs[0]["msg"] != "Listing F5IPAMs" {
+		t.Errorf("Expected the verbose message at the debug level, got %v", msgs)
+	}
+
+	klogv2.Info("Trace of the request")
+	klogv2.ErrorS(errors.New("timeout"), "Unable to watch")
+	msgs = messages()
+	if len(msgs) != 2 || msgs[0]["level"] != "info" || msgs[1]["level"] != "error" {
+		t.Errorf("Expected the messages of klog/v2, got %v", msgs)
+	}
+
+	logger := NewLogger("CORE").WithName("informer").WithValues(log.CIDRKey, "10.1.1.0/24")
+	logger.V(2).Info("Synced")
+	logger.Error(errors.New("closed"), "Watch ended")
+	msgs = messages()
+	if len(msgs) != 2 || msgs[0]["level"] != "debug" || msgs[0]["subsystem"] != "CORE.informer" ||
+		msgs[0][log.CIDRKey] != "10.1.1.0/24" || msgs[1][log.ErrorKey] != "closed" {
+		t.Errorf("Unexpected messages of the logr.Logger: %v", msgs)
+	}
+}
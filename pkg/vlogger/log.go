@@ -22,6 +22,8 @@ import (
 	"log/syslog" // For LOG level definitions
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // LogLevel is used for global (package-level) filtering of log messages based on their priority
@@ -125,8 +127,13 @@ var (
 	vlog [LL_LOGLEVEL_SIZE]Logger
 
 	// logLevel indicates the current package-level filtering being applied
-	// (may be further restricted by specific concrete loggers).
-	logLevel LogLevel = LL_DEBUG
+	// (may be further restricted by specific concrete loggers). It is a LogLevel
+	// that can be changed while messages are logged.
+	logLevel int32 = LL_DEBUG
+
+	// levelListeners are told about the changes of the package-level filtering
+	levelListeners []func(LogLevel)
+	listenerMutex  sync.Mutex
 
 	// logLevelToSyslogLevel maps vlogger log levels to the internal representation used
 	// by the implementations (which use syslog's definitions).
@@ -249,22 +256,37 @@ func Panicf(format string, params ...interface{}) {
 	panic(msg)
 }
 
-// SetLogLevel sets the current package-level filtering
+// SetLogLevel sets the current package-level filtering, it can be changed at any time
 func SetLogLevel(level LogLevel) {
-	logLevel = level
+	atomic.StoreInt32(&logLevel, int32(level))
 
 	// Update all loggers to the new level
-	slLogLevel := logLevelToSyslogLevel[logLevel]
+	slLogLevel := logLevelToSyslogLevel[level]
 	for i, _ := range vlog {
 		if vlog[i] != nil {
 			vlog[i].SetLogLevel(slLogLevel)
 		}
 	}
+
+	listenerMutex.Lock()
+	listeners := levelListeners
+	listenerMutex.Unlock()
+	for _, listener := range listeners {
+		listener(level)
+	}
 }
 
 // GetLogLevel returns the current package-level filtering
 func GetLogLevel() LogLevel {
-	return logLevel
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+// OnLogLevelChange calls the listener with the new level whenever SetLogLevel is called,
+// for the logging of libraries that has a level of its own
+func OnLogLevelChange(listener func(LogLevel)) {
+	listenerMutex.Lock()
+	defer listenerMutex.Unlock()
+	levelListeners = append(levelListeners, listener)
 }
 
 // Close informs the configured loggers that they are being closed and
@@ -0,0 +1,199 @@
+// Copyright (c) 2019-2020, F5 Networks, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//log_syslog.go:
+//  Provides logging to a syslog daemon through the common interface.
+//  To use, create the logger object with the following syntax:
+//    NewSyslogLogger(facility, tag)
+//  or, for a remote daemon over UDP or TCP:
+//    NewSyslogLoggerExt("udp", "syslog.example.com:514", facility, tag)
+//  The connection to the daemon is reopened when a message can not be sent.
+//
+package syslog
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LocalAddress selects the syslog daemon of the host in ParseAddress
+const LocalAddress = "local"
+
+type (
+	syslogLogger struct {
+		// slLogLevel uses syslog's definitions which have higher priority
+		// levels defined in descending order (0 is highest), it is a
+		// syslog.Priority that can be changed while messages are logged
+		slLogLevel int32
+
+		writer    *syslog.Writer
+		closeOnce sync.Once
+	}
+)
+
+var facilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// NewSyslogLogger creates a logger object that sends log messages to the syslog daemon
+// of the host, through its local socket.
+func NewSyslogLogger(facility syslog.Priority, tag string) (*syslogLogger, error) {
+	return NewSyslogLoggerExt("", "", facility, tag)
+}
+
+// NewSyslogLoggerExt creates a logger object that sends log messages to the syslog daemon
+// at raddr over network, "udp" or "tcp". The local daemon is used when network is empty.
+func NewSyslogLoggerExt(network, raddr string, facility syslog.Priority, tag string) (*syslogLogger, error) {
+	writer, err := syslog.Dial(network, raddr, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogLogger{
+		slLogLevel: int32(syslog.LOG_DEBUG),
+		writer:     writer,
+	}, nil
+}
+
+// ParseFacility returns the facility of the name, like daemon or local0
+func ParseFacility(name string) (syslog.Priority, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %v", name)
+	}
+	return facility, nil
+}
+
+// ParseAddress returns the network and address of the syslog daemon, which is given as
+// udp://host:port or tcp://host:port. LocalAddress is the local daemon, with an empty network.
+func ParseAddress(address string) (string, string, error) {
+	if address == LocalAddress {
+		return "", "", nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %v: %v", address, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+	default:
+		return "", "", fmt.Errorf("invalid syslog address %v, expected %v, udp://host:port or tcp://host:port",
+			address, LocalAddress)
+	}
+	if u.Host == "" || u.Port() == "" {
+		return "", "", fmt.Errorf("invalid syslog address %v, the host and port are required", address)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+func (sl *syslogLogger) Debug(msg string) {
+	if sl.level() >= syslog.LOG_DEBUG {
+		_ = sl.writer.Debug(msg)
+	}
+}
+
+func (sl *syslogLogger) Debugf(format string, params ...interface{}) {
+	if sl.level() >= syslog.LOG_DEBUG {
+		_ = sl.writer.Debug(fmt.Sprintf(format, params...))
+	}
+}
+
+func (sl *syslogLogger) Info(msg string) {
+	if sl.level() >= syslog.LOG_INFO {
+		_ = sl.writer.Info(msg)
+	}
+}
+
+func (sl *syslogLogger) Infof(format string, params ...interface{}) {
+	if sl.level() >= syslog.LOG_INFO {
+		_ = sl.writer.Info(fmt.Sprintf(format, params...))
+	}
+}
+
+func (sl *syslogLogger) Warning(msg string) {
+	if sl.level() >= syslog.LOG_WARNING {
+		_ = sl.writer.Warning(msg)
+	}
+}
+
+func (sl *syslogLogger) Warningf(format string, params ...interface{}) {
+	if sl.level() >= syslog.LOG_WARNING {
+		_ = sl.writer.Warning(fmt.Sprintf(format, params...))
+	}
+}
+
+func (sl *syslogLogger) Error(msg string) {
+	if sl.level() >= syslog.LOG_ERR {
+		_ = sl.writer.Err(msg)
+	}
+}
+
+func (sl *syslogLogger) Errorf(format string, params ...interface{}) {
+	if sl.level() >= syslog.LOG_ERR {
+		_ = sl.writer.Err(fmt.Sprintf(format, params...))
+	}
+}
+
+func (sl *syslogLogger) Critical(msg string) {
+	if sl.level() >= syslog.LOG_CRIT {
+		_ = sl.writer.Crit(msg)
+	}
+}
+
+func (sl *syslogLogger) Criticalf(format string, params ...interface{}) {
+	if sl.level() >= syslog.LOG_CRIT {
+		_ = sl.writer.Crit(fmt.Sprintf(format, params...))
+	}
+}
+
+func (sl *syslogLogger) SetLogLevel(slLogLevel syslog.Priority) {
+	atomic.StoreInt32(&sl.slLogLevel, int32(slLogLevel))
+}
+
+func (sl *syslogLogger) GetLogLevel() syslog.Priority {
+	return sl.level()
+}
+
+func (sl *syslogLogger) level() syslog.Priority {
+	return syslog.Priority(atomic.LoadInt32(&sl.slLogLevel))
+}
+
+// Close closes the connection to the daemon, the logger may be registered for several levels
+func (sl *syslogLogger) Close() {
+	sl.closeOnce.Do(func() {
+		_ = sl.writer.Close()
+	})
+}
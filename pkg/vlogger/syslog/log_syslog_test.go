@@ -0,0 +1,62 @@
+package syslog
+
+import (
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogLogger(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	network, raddr, err := ParseAddress("udp://" + conn.LocalAddr().String())
+	if err != nil || network != "udp" || raddr != conn.LocalAddr().String() {
+		t.Fatalf("ParseAddress got: %v, %v, %v", network, raddr, err)
+	}
+	facility, err := ParseFacility("LOCAL3")
+	if err != nil || facility != syslog.LOG_LOCAL3 {
+		t.Fatalf("ParseFacility got: %v, %v", facility, err)
+	}
+	sl, err := NewSyslogLoggerExt(network, raddr, facility, "f5-ipam-controller")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.SetLogLevel(syslog.LOG_INFO)
+	sl.Debug("Filtered")
+	sl.Warningf("Unable to Insert row: %v", "locked")
+	sl.Close()
+	sl.Close()
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// <priority> of local3.warning is 19*8+4
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<156>") || !strings.Contains(msg, "f5-ipam-controller") ||
+		!strings.HasSuffix(strings.TrimSpace(msg), "Unable to Insert row: locked") {
+		t.Errorf("Unexpected message: %q", msg)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	if network, raddr, err := ParseAddress(LocalAddress); err != nil || network != "" || raddr != "" {
+		t.Errorf("ParseAddress of the local daemon got: %v, %v, %v", network, raddr, err)
+	}
+	for _, address := range []string{"syslog.example.com:514", "http://syslog.example.com:514", "udp://syslog.example.com"} {
+		if _, _, err := ParseAddress(address); err == nil {
+			t.Errorf("Invalid address %v is accepted", address)
+		}
+	}
+	if _, err := ParseFacility("console"); err == nil {
+		t.Error("Unknown facility is accepted")
+	}
+}